@@ -0,0 +1,342 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+)
+
+//
+// Access of other machines than this one, which must be in the allowed
+// networks, give a token or the username and password, none getting in
+// until one is set, and POST their changes with the CSRF token
+//
+
+const (
+	TokenHeader = "X-Quasar-Token"
+	CSRFHeader  = "X-Quasar-CSRF"
+	csrfCookie  = "quasar_csrf"
+	csrfField   = "csrf_token"
+	streamField = "stream_token"
+)
+
+var accessLog = logging.MustGetLogger("access")
+
+// csrfToken is handed out to remote clients in a cookie, which neither
+// scripts nor pages of other sites get, and in the web UI's page, and sent
+// back with their changes.
+var csrfToken = newCSRFToken()
+
+func newCSRFToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// splitList splits comma separated settings, leaving out empty items.
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ListenAddresses returns the addresses to serve the API on, all
+// interfaces unless remote_bind says otherwise, loopback always being one
+// for Kodi.
+func ListenAddresses() []string {
+	port := strconv.Itoa(config.ListenPort)
+	binds := splitList(config.Get().RemoteBind)
+	if len(binds) == 0 {
+		return []string{":" + port}
+	}
+	addresses := make([]string, 0, len(binds)+1)
+	loopback := false
+	for _, bind := range binds {
+		if ip := net.ParseIP(bind); ip != nil && ip.IsLoopback() {
+			loopback = true
+		}
+		addresses = append(addresses, net.JoinHostPort(bind, port))
+	}
+	if !loopback {
+		addresses = append(addresses, net.JoinHostPort("127.0.0.1", port))
+	}
+	return addresses
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// loopbackRequest tells whether a request comes from this machine.
+func loopbackRequest(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localHost tells whether a request was made to this machine's API by its
+// name or address, and not to a site whose name resolves to loopback, as
+// in DNS rebinding.
+func localHost(r *http.Request) bool {
+	port := strconv.Itoa(config.ListenPort)
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, splitList(config.Get().RemoteBind)...)
+	for _, host := range hosts {
+		if strings.EqualFold(r.Host, net.JoinHostPort(host, port)) {
+			return true
+		}
+	}
+	return false
+}
+
+// localRequest tells whether a request comes from this machine, which
+// Kodi's calls do, and not from a page of another site in its browser.
+func localRequest(r *http.Request) bool {
+	if !loopbackRequest(r) || !localHost(r) {
+		return false
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "cross-site", "same-site":
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if i := strings.Index(origin, "://"); i < 0 || origin[i+3:] != r.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedNetwork tells whether an address is in remote_allowed_networks,
+// which takes networks in CIDR notation and single addresses.
+func allowedNetwork(conf *config.Configuration, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range splitList(conf.RemoteAllowedNetworks) {
+		if !strings.Contains(network, "/") {
+			if allowed := net.ParseIP(network); allowed != nil && allowed.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			accessLog.Warningf("Invalid allowed network %s: %s", network, err)
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSecret(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// requestToken returns the API token a request comes with, if any, only
+// taken from headers for it not to be logged with URLs.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get(TokenHeader)
+}
+
+// tokenAuthorized tells whether a request comes with one of the API tokens,
+// which browsers don't send on their own, unlike basic auth.
+func tokenAuthorized(conf *config.Configuration, r *http.Request) bool {
+	token := requestToken(r)
+	if token == "" {
+		return false
+	}
+	for _, expected := range splitList(conf.RemoteTokens) {
+		if sameSecret(token, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamRequest tells whether a request only gets a stream, as media
+// servers playing standalone libraries' .strm files do.
+func streamRequest(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	for _, prefix := range []string{"/library/movie/stream/", "/library/show/stream/", "/files/"} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamAuthorized tells whether a stream request comes with the stream
+// token, which gets streams only, it being written in .strm files.
+func streamAuthorized(conf *config.Configuration, r *http.Request) bool {
+	token := r.URL.Query().Get(streamField)
+	return token != "" && conf.LibraryStreamToken != "" && streamRequest(r) && sameSecret(token, conf.LibraryStreamToken)
+}
+
+// credentialsSet tells whether remote clients have credentials to give,
+// without which they're all refused.
+func credentialsSet(conf *config.Configuration) bool {
+	return len(splitList(conf.RemoteTokens)) > 0 || conf.RemoteUsername != "" || conf.LibraryStreamToken != ""
+}
+
+// authorized tells whether a remote request gives the credentials asked
+// for.
+func authorized(conf *config.Configuration, r *http.Request) bool {
+	if streamAuthorized(conf, r) || tokenAuthorized(conf, r) {
+		return true
+	}
+	if conf.RemoteUsername != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			return sameSecret(username, conf.RemoteUsername) && sameSecret(password, conf.RemotePassword)
+		}
+	}
+	return false
+}
+
+// validCSRF tells whether a remote request changing state can't have been
+// made by a page of another site.
+func validCSRF(r *http.Request) bool {
+	if tokenAuthorized(config.Get(), r) {
+		return true
+	}
+	token := r.Header.Get(CSRFHeader)
+	if token == "" {
+		token = r.FormValue(csrfField)
+	}
+	return token != "" && sameSecret(token, csrfToken)
+}
+
+// AccessHandler turns away other machines than this one when they're not
+// in the allowed networks or don't give the credentials asked for.
+func AccessHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if localRequest(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if loopbackRequest(r) && !localHost(r) {
+			accessLog.Warningf("Refused %s %s from %s, made to host %q", r.Method, r.URL.Path, r.RemoteAddr, r.Host)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		conf := config.Get()
+		if !allowedNetwork(conf, remoteIP(r)) {
+			accessLog.Warningf("Refused %s %s from %s, not in the allowed networks", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !credentialsSet(conf) {
+			accessLog.Warningf("Refused %s %s from %s, no remote credentials set", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !authorized(conf, r) {
+			accessLog.Warningf("Refused %s %s from %s, wrong credentials", r.Method, r.URL.Path, r.RemoteAddr)
+			if conf.RemoteUsername != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="Quasar"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if cookie, err := r.Cookie(csrfCookie); err != nil || cookie.Value != csrfToken {
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    csrfToken,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// changeRefused returns the status and reason a request changing state is
+// refused with, or 0. Remote clients must POST changes with the CSRF token.
+func changeRefused(r *http.Request) (int, string) {
+	if localRequest(r) {
+		return 0, ""
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		return http.StatusMethodNotAllowed, "Changes must be POSTed"
+	case "OPTIONS":
+		return 0, ""
+	}
+	if !validCSRF(r) {
+		accessLog.Warningf("Refused %s %s from %s, missing or wrong CSRF token", r.Method, r.URL.Path, r.RemoteAddr)
+		return http.StatusForbidden, "Missing or wrong CSRF token"
+	}
+	return 0, ""
+}
+
+// checkCSRF checks the CSRF token of all requests but GETs.
+func checkCSRF(ctx *gin.Context) {
+	switch ctx.Request.Method {
+	case "GET", "HEAD":
+		return
+	}
+	if status, reason := changeRefused(ctx.Request); status != 0 {
+		ctx.String(status, reason)
+		ctx.Abort()
+	}
+}
+
+// changesState marks routes changing state, which Kodi GETs while remote
+// clients POST them.
+func changesState(ctx *gin.Context) {
+	if status, reason := changeRefused(ctx.Request); status != 0 {
+		if status == http.StatusMethodNotAllowed {
+			ctx.Writer.Header().Set("Allow", "POST")
+		}
+		ctx.String(status, reason)
+		ctx.Abort()
+	}
+}
+
+// ChangesState is changesState for handlers outside of the routes.
+func ChangesState(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, reason := changeRefused(r); status != 0 {
+			if status == http.StatusMethodNotAllowed {
+				w.Header().Set("Allow", "POST")
+			}
+			http.Error(w, reason, status)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// action registers a route changing state, for both GET and POST, GET
+// being for Kodi only: changesState refuses it from anyone but loopback
+// requests of this machine's own pages.
+func action(group *gin.RouterGroup, relativePath string, handlers ...gin.HandlerFunc) {
+	handlers = append([]gin.HandlerFunc{changesState}, handlers...)
+	group.GET(relativePath, handlers...)
+	group.POST(relativePath, handlers...)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+)
+
+//
+// Automatic download of newly aired library episodes
+//
+
+var grabLog = logging.MustGetLogger("autograb")
+
+type grabRequest struct {
+	ShowID    int
+	Season    int
+	Episode   int
+	EpisodeID int
+}
+
+var grabQueue = make(chan *grabRequest, 100)
+
+// Older episodes showing up are from a back catalog, not newly aired
+const grabMaxAge = 7 * 24 * time.Hour
+
+func newlyAired(airDate string) bool {
+	aired, err := time.Parse("2006-01-02", airDate)
+	if err != nil {
+		return false
+	}
+	return time.Since(aired) < grabMaxAge
+}
+
+// queueGrab schedules the background download of an episode, when
+// enabled.
+func queueGrab(showId int, seasonNumber int, episodeNumber int, episodeId int) {
+	if config.Get().AutoGrabEpisodes == false {
+		return
+	}
+	request := &grabRequest{
+		ShowID:    showId,
+		Season:    seasonNumber,
+		Episode:   episodeNumber,
+		EpisodeID: episodeId,
+	}
+	select {
+	case grabQueue <- request:
+	default:
+		grabLog.Warningf("Too many episodes waiting, not grabbing S%02dE%02d of show %d", seasonNumber, episodeNumber, showId)
+	}
+}
+
+// AutoGrab downloads the queued episodes, one at a time.
+func AutoGrab(btService *bittorrent.BTService) {
+	for request := range grabQueue {
+		if err := grabEpisode(btService, request); err != nil {
+			grabLog.Warningf("Unable to grab S%02dE%02d of show %d: %s", request.Season, request.Episode, request.ShowID, err)
+		}
+	}
+}
+
+func grabEpisode(btService *bittorrent.BTService, request *grabRequest) error {
+	if config.Get().DownloadPath == "." {
+		return errors.New("Download path empty")
+	}
+	if infoHash := grabbedTorrent(request.ShowID, request.Season, request.Episode); infoHash != "" {
+		grabLog.Infof("S%02dE%02d of show %d already grabbed as %s", request.Season, request.Episode, request.ShowID, infoHash)
+		return nil
+	}
+
+	var searchErr error
+	episodeId := strconv.Itoa(request.EpisodeID)
+	torrents, _ := searchLinks(linksEpisode, episodeId, false, func() []*bittorrent.Torrent {
+		torrents, err := showEpisodeLinks(request.ShowID, request.Season, request.Episode)
+		searchErr = err
+		return torrents
+	})
+	if searchErr != nil {
+		return searchErr
+	}
+
+	for _, torrent := range preferredEpisodeLinks(strconv.Itoa(request.ShowID), torrents) {
+		if isDeadLink(torrent.InfoHash) {
+			continue
+		}
+		infoHash, err := addTorrent(btService, torrent.URI)
+		if err != nil {
+			grabLog.Warningf("Unable to add %s: %s", torrent.Name, err)
+			continue
+		}
+		// The file is only known once playback picks it
+		err = btService.UpdateDB(bittorrent.Update, infoHash, request.EpisodeID, "episode", -1, request.ShowID, request.Season, request.Episode)
+		if err != nil {
+			return err
+		}
+		grabLog.Noticef("Grabbing S%02dE%02d of show %d from %s", request.Season, request.Episode, request.ShowID, torrent.Name)
+		return nil
+	}
+	return fmt.Errorf("None of %d links could be added", len(torrents))
+}
+
+// grabbedTorrent returns the info hash of the torrent tracked for an
+// episode, if any.
+func grabbedTorrent(showId int, seasonNumber int, episodeNumber int) string {
+	return activeTorrent(func(item *bittorrent.DBItem) bool {
+		return item.Type == "episode" && item.ShowID == showId && item.Season == seasonNumber && item.Episode == episodeNumber
+	})
+}
+
+// activeTorrent returns the info hash of the last active torrent matching,
+// if any.
+func activeTorrent(match func(item *bittorrent.DBItem) bool) (infoHash string) {
+	DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bittorrent.Bucket)).ForEach(func(k, v []byte) error {
+			var item *bittorrent.DBItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return nil
+			}
+			if item.State == bittorrent.Active && match(item) {
+				infoHash = string(k)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// grabbedTorrentIndex returns the index in the session of the torrent
+// tracked for an episode, -1 if it isn't there.
+func grabbedTorrentIndex(btService *bittorrent.BTService, showId int, seasonNumber int, episodeNumber int) int {
+	return sessionIndex(btService, grabbedTorrent(showId, seasonNumber, episodeNumber))
+}
+
+// sessionIndex returns the index in the session of a torrent, -1 if it
+// isn't there.
+func sessionIndex(btService *bittorrent.BTService, infoHash string) int {
+	if infoHash == "" {
+		return -1
+	}
+	torrentsVector := btService.Session.GetHandle().GetTorrents()
+	torrentsVectorSize := int(torrentsVector.Size())
+	for i := 0; i < torrentsVectorSize; i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.IsValid() == false {
+			continue
+		}
+		shaHash := torrentHandle.Status().GetInfoHash().ToString()
+		if hex.EncodeToString([]byte(shaHash)) == infoHash {
+			return i
+		}
+	}
+	return -1
+}
+
+// grabbedPlayURL returns the URL resuming the torrent grabbed for an
+// episode, or an empty string if there's none.
+func grabbedPlayURL(btService *bittorrent.BTService, showId int, seasonNumber int, episodeNumber int, library string) string {
+	index := grabbedTorrentIndex(btService, showId, seasonNumber, episodeNumber)
+	if index < 0 {
+		return ""
+	}
+	grabLog.Infof("Playing grabbed torrent of S%02dE%02d of show %d", seasonNumber, episodeNumber, showId)
+	return UrlQuery(
+		UrlForXBMC("/play"), "resume", strconv.Itoa(index),
+		"show", strconv.Itoa(showId),
+		"season", strconv.Itoa(seasonNumber),
+		"episode", strconv.Itoa(episodeNumber),
+		"library", library,
+		"type", "episode")
+}
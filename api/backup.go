@@ -0,0 +1,502 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+)
+
+const (
+	// Bump when the format changes, older versions must stay importable
+	libraryExportVersion = 4
+	backupsFolder        = "backups"
+	backupPrefix         = "library-"
+)
+
+const (
+	importMerge = iota
+	importReplace
+)
+
+// libraryExport is a portable copy of what Quasar tracks in its database:
+// library items, deliberately removed items, torrents being handled,
+// per-show rules since version 2, where items were written since 3 and
+// the movie lists imported for re-sync since 4. Items and lists are the
+// default profile's.
+type libraryExport struct {
+	Version   int                           `json:"version"`
+	Created   time.Time                     `json:"created"`
+	Movies    []string                      `json:"movies"`
+	Shows     []string                      `json:"shows"`
+	Removed   []*DBItem                     `json:"removed"`
+	Torrents  map[string]*bittorrent.DBItem `json:"torrents"`
+	ShowRules map[string]*showRules         `json:"show_rules,omitempty"`
+	Locations map[string]*libraryLocation   `json:"locations,omitempty"`
+	Lists     []*importedList               `json:"imported_lists,omitempty"`
+}
+
+func exportLibrary() (*libraryExport, error) {
+	export := &libraryExport{
+		Version:   libraryExportVersion,
+		Created:   time.Now(),
+		Movies:    make([]string, 0),
+		Shows:     make([]string, 0),
+		Removed:   make([]*DBItem, 0),
+		Torrents:  make(map[string]*bittorrent.DBItem),
+		ShowRules: make(map[string]*showRules),
+		Locations: make(map[string]*libraryLocation),
+		Lists:     make([]*importedList, 0),
+	}
+	err := DB.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			var item *DBItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				libraryLog.Warningf("Skipping invalid library item %s: %s", k, err)
+				return nil
+			}
+			switch item.Type {
+			case Movie:
+				export.Movies = append(export.Movies, item.ID)
+			case Show:
+				export.Shows = append(export.Shows, item.ID)
+			case RemovedMovie, RemovedShow, RemovedSeason, RemovedEpisode:
+				export.Removed = append(export.Removed, item)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(showRulesBucket)).ForEach(func(k, v []byte) error {
+			var rules *showRules
+			if err := json.Unmarshal(v, &rules); err != nil {
+				libraryLog.Warningf("Skipping invalid show rules %s: %s", k, err)
+				return nil
+			}
+			export.ShowRules[string(k)] = rules
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(locationsBucket)).ForEach(func(k, v []byte) error {
+			var location *libraryLocation
+			if err := json.Unmarshal(v, &location); err != nil {
+				libraryLog.Warningf("Skipping invalid library location %s: %s", k, err)
+				return nil
+			}
+			export.Locations[string(k)] = location
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket([]byte(importedListsBucket)).ForEach(func(k, v []byte) error {
+			var list *importedList
+			if err := json.Unmarshal(v, &list); err != nil {
+				libraryLog.Warningf("Skipping invalid imported list %s: %s", k, err)
+				return nil
+			}
+			export.Lists = append(export.Lists, list)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(bittorrent.Bucket)).ForEach(func(k, v []byte) error {
+			var item *bittorrent.DBItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				libraryLog.Warningf("Skipping invalid torrent item %s: %s", k, err)
+				return nil
+			}
+			export.Torrents[string(k)] = item
+			return nil
+		})
+	})
+	return export, err
+}
+
+func putDBItem(b *bolt.Bucket, item *DBItem) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(fmt.Sprintf("%d_%s", item.Type, item.ID)), buf)
+}
+
+// importLibrary loads an export into the database, either on top of
+// what's already there, or in place of it, then writes the .strm files of
+// the imported items.
+func importLibrary(export *libraryExport, mode int) error {
+	if export == nil {
+		return errors.New("Empty library export")
+	}
+	if export.Version < 1 || export.Version > libraryExportVersion {
+		return fmt.Errorf("Unsupported library export version %d", export.Version)
+	}
+
+	if mode == importReplace {
+		if err := removeStaleStrms(export); err != nil {
+			return err
+		}
+	}
+
+	err := DB.Update(func(tx *bolt.Tx) error {
+		if mode == importReplace {
+			for _, name := range replacedBuckets(export.Version) {
+				if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+				if _, err := tx.CreateBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+
+		b := tx.Bucket([]byte(bucket))
+		for _, id := range export.Movies {
+			if err := putDBItem(b, &DBItem{ID: id, Type: Movie}); err != nil {
+				return err
+			}
+		}
+		for _, id := range export.Shows {
+			if err := putDBItem(b, &DBItem{ID: id, Type: Show}); err != nil {
+				return err
+			}
+		}
+		for _, item := range export.Removed {
+			if err := putDBItem(b, item); err != nil {
+				return err
+			}
+		}
+
+		tb := tx.Bucket([]byte(bittorrent.Bucket))
+		for infoHash, item := range export.Torrents {
+			buf, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := tb.Put([]byte(infoHash), buf); err != nil {
+				return err
+			}
+		}
+
+		rb := tx.Bucket([]byte(showRulesBucket))
+		for showId, rules := range export.ShowRules {
+			buf, err := json.Marshal(rules)
+			if err != nil {
+				return err
+			}
+			if err := rb.Put([]byte(showId), buf); err != nil {
+				return err
+			}
+		}
+
+		lb := tx.Bucket([]byte(locationsBucket))
+		for key, location := range export.Locations {
+			buf, err := json.Marshal(location)
+			if err != nil {
+				return err
+			}
+			if err := lb.Put([]byte(key), buf); err != nil {
+				return err
+			}
+		}
+
+		ib := tx.Bucket([]byte(importedListsBucket))
+		for _, list := range export.Lists {
+			buf, err := json.Marshal(list)
+			if err != nil {
+				return err
+			}
+			if err := ib.Put([]byte(list.URL), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	libraryLog.Noticef("Imported %d movies, %d shows, %d removed items, %d torrents, %d show rules, %d locations and %d imported lists",
+		len(export.Movies), len(export.Shows), len(export.Removed), len(export.Torrents), len(export.ShowRules), len(export.Locations), len(export.Lists))
+
+	return writeImportedStrms(export)
+}
+
+// replacedBuckets returns the buckets an export of a version carries, the
+// ones a replacing import starts over. Torrents are only merged, the
+// session still has the ones running.
+func replacedBuckets(version int) []string {
+	buckets := []string{bucket}
+	if version >= 2 {
+		buckets = append(buckets, showRulesBucket)
+	}
+	if version >= 3 {
+		buckets = append(buckets, locationsBucket)
+	}
+	if version >= 4 {
+		buckets = append(buckets, importedListsBucket)
+	}
+	return buckets
+}
+
+// removeStaleStrms removes the folders of the movies and shows a replacing
+// import leaves out, while their locations are still known, but for the
+// ones other profiles have.
+func removeStaleStrms(export *libraryExport) error {
+	current, err := exportLibrary()
+	if err != nil {
+		return err
+	}
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return err
+	}
+
+	conf := config.Get()
+	for _, id := range missingIDs(current.Movies, export.Movies) {
+		if addedElsewhere(conf, id, Movie) {
+			continue
+		}
+		movie := tmdb.GetMovieById(id, "en")
+		if movie == nil {
+			libraryLog.Warningf("Unable to find movie %s to remove", id)
+			continue
+		}
+		location, err := movieLocation(layout, movie)
+		if err != nil {
+			libraryLog.Error(err)
+			continue
+		}
+		removeStaleFolder(location.Folder)
+		deleteLocation(Movie, id)
+	}
+	for _, id := range missingIDs(current.Shows, export.Shows) {
+		if addedElsewhere(conf, id, Show) {
+			continue
+		}
+		showId, _ := strconv.Atoi(id)
+		show := tmdb.GetShow(showId, "en")
+		if show == nil {
+			libraryLog.Warningf("Unable to find show %s to remove", id)
+			continue
+		}
+		location, err := showLocation(layout, show)
+		if err != nil {
+			libraryLog.Error(err)
+			continue
+		}
+		removeStaleFolder(location.Folder)
+		deleteLocation(Show, id)
+	}
+	return nil
+}
+
+func removeStaleFolder(folder string) {
+	if _, err := os.Stat(folder); err != nil {
+		return
+	}
+	libraryLog.Infof("Removing %s, not in the imported library", folder)
+	if err := os.RemoveAll(folder); err != nil {
+		libraryLog.Error(err)
+	}
+}
+
+// missingIDs returns the IDs of current which aren't in kept.
+func missingIDs(current []string, kept []string) []string {
+	keep := make(map[string]bool, len(kept))
+	for _, id := range kept {
+		keep[id] = true
+	}
+	missing := make([]string, 0)
+	for _, id := range current {
+		if !keep[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func writeImportedStrms(export *libraryExport) error {
+	if err := checkMoviesPath(); err != nil {
+		return err
+	}
+	if err := checkShowsPath(); err != nil {
+		return err
+	}
+	for _, id := range export.Movies {
+		if _, err := writeMovieStrm(id); err != nil && !strings.HasPrefix(err.Error(), "LOCALIZE[30287]") {
+			libraryLog.Error(err)
+		}
+	}
+	for _, id := range export.Shows {
+		if _, err := writeShowStrm(config.Get(), id, false); err != nil {
+			libraryLog.Error(err)
+		}
+	}
+	return nil
+}
+
+//
+// Automatic backups
+//
+func backupsPath() string {
+	return filepath.Join(config.Get().ProfilePath, backupsFolder)
+}
+
+// backupLibrary writes an export in the profile folder, only keeping the
+// most recent ones.
+func backupLibrary() error {
+	export, err := exportLibrary()
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := backupsPath()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	backupFile := filepath.Join(path, fmt.Sprintf("%s%s.json", backupPrefix, export.Created.Format("20060102-150405")))
+	if err := ioutil.WriteFile(backupFile, buf, 0644); err != nil {
+		return err
+	}
+	libraryLog.Noticef("Library backed up to %s", backupFile)
+
+	backups := libraryBackups()
+	for i := config.Get().LibraryBackupsKept; i < len(backups); i++ {
+		libraryLog.Infof("Removing old backup %s", backups[i])
+		os.Remove(backups[i])
+	}
+	return nil
+}
+
+// libraryBackups returns the backup files, most recent first.
+func libraryBackups() []string {
+	backups, _ := filepath.Glob(filepath.Join(backupsPath(), backupPrefix+"*.json"))
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups
+}
+
+//
+// Export and import externals
+//
+func ExportLibrary(ctx *gin.Context) {
+	export, err := exportLibrary()
+	if err != nil {
+		ctx.String(500, err.Error())
+		return
+	}
+	ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=quasar-%s.json", export.Created.Format("20060102-150405")))
+	ctx.JSON(200, export)
+}
+
+func ImportLibrary(ctx *gin.Context) {
+	mode := importMerge
+	switch ctx.Query("mode") {
+	case "", "merge":
+	case "replace":
+		mode = importReplace
+	default:
+		ctx.String(400, "Invalid import mode, use merge or replace")
+		return
+	}
+
+	var export libraryExport
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&export); err != nil {
+		ctx.String(400, fmt.Sprintf("Invalid library export: %s", err))
+		return
+	}
+	if err := importLibrary(&export, mode); err != nil {
+		ctx.String(500, err.Error())
+		return
+	}
+	ctx.String(200, "")
+}
+
+// RestoreLibrary lets the user pick one of the automatic backups to
+// restore from Kodi.
+func RestoreLibrary(ctx *gin.Context) {
+	dialogs := uiFor(ctx)
+	backups := libraryBackups()
+	if len(backups) == 0 {
+		dialogs.Notify("Quasar", "LOCALIZE[30309]")
+		return
+	}
+	choices := make([]string, 0, len(backups))
+	for _, backup := range backups {
+		choices = append(choices, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(backup), backupPrefix), ".json"))
+	}
+	choice := dialogs.Choose("LOCALIZE[30310]", "", choices...)
+	if choice < 0 {
+		return
+	}
+
+	mode := importMerge
+	if dialogs.Confirm("Quasar", "LOCALIZE[30311]") {
+		mode = importReplace
+	}
+
+	if err := restoreBackup(backups[choice], mode); err != nil {
+		libraryLog.Error(err)
+		dialogs.Notify("Quasar", err.Error())
+		return
+	}
+	dialogs.Notify("Quasar", "LOCALIZE[30312]")
+	if dialogs.Confirm("Quasar", "LOCALIZE[30288]") {
+		libraryScan()
+	}
+}
+
+func restoreBackup(backupFile string, mode int) error {
+	buf, err := ioutil.ReadFile(backupFile)
+	if err != nil {
+		return err
+	}
+	var export libraryExport
+	if err := json.Unmarshal(buf, &export); err != nil {
+		return errors.New(fmt.Sprintf("Invalid backup %s: %s", filepath.Base(backupFile), err))
+	}
+	return importLibrary(&export, mode)
+}
+
+// lastBackup returns when the library was last backed up.
+func lastBackup() (last time.Time) {
+	backups := libraryBackups()
+	if len(backups) == 0 {
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(backups[0]), backupPrefix), ".json")
+	last, _ = time.ParseInLocation("20060102-150405", name, time.Local)
+	return
+}
+
+func backupDue() bool {
+	frequency := config.Get().LibraryBackupFrequency
+	if frequency <= 0 {
+		return false
+	}
+	return time.Since(lastBackup()) > time.Duration(frequency)*24*time.Hour
+}
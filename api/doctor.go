@@ -0,0 +1,443 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Library reconciliation between the .strm tree, Quasar's database and
+// Kodi's video library, which drift apart over time.
+//
+
+const (
+	issueOrphanStrm    = "orphan_strm"
+	issueMissingStrm   = "missing_strm"
+	issueDuplicateStrm = "duplicate_strm"
+	issueStalePlayURL  = "stale_play_url"
+	issueKodiOrphan    = "kodi_orphan"
+	issueNotInKodi     = "not_in_kodi"
+)
+
+var (
+	// Current and past forms of the play URLs, whatever the plugin ID
+	moviePlayURL   = regexp.MustCompile(`^plugin://[^/]+/(?:library/movie/play|library/play/movie)/(\d+)$`)
+	episodePlayURL = regexp.MustCompile(`^plugin://[^/]+/(?:library/show/play/(\d+)/(\d+)/(\d+)|library/play/show/(\d+)/season/(\d+)/episode/(\d+))$`)
+)
+
+type strmFile struct {
+	Path    string
+	Content string
+	Type    int
+	ID      string
+	Season  int
+	Episode int
+}
+
+type byPath []*strmFile
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+func (s *strmFile) playURL() string {
+	if s.Type == Movie {
+		return movieLibraryURL(s.ID)
+	}
+	return episodeLibraryURL(s.ID, s.Season, s.Episode)
+}
+
+type libraryIssue struct {
+	Type   string `json:"type"`
+	Kind   string `json:"kind"`
+	ID     string `json:"id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail"`
+	Fixed  bool   `json:"fixed"`
+	Error  string `json:"error,omitempty"`
+
+	strm *strmFile
+}
+
+type byIssueType []*libraryIssue
+
+func (a byIssueType) Len() int           { return len(a) }
+func (a byIssueType) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byIssueType) Less(i, j int) bool { return a[i].Type < a[j].Type }
+
+type libraryDiff struct {
+	Issues []*libraryIssue `json:"issues"`
+	DryRun bool            `json:"dry_run"`
+
+	strms    []*strmFile
+	tracked  map[int]map[string]bool
+	kodiSeen bool
+}
+
+func (d *libraryDiff) add(issueType string, kind string, id string, path string, detail string, strm *strmFile) {
+	d.Issues = append(d.Issues, &libraryIssue{
+		Type:   issueType,
+		Kind:   kind,
+		ID:     id,
+		Path:   path,
+		Detail: detail,
+		strm:   strm,
+	})
+}
+
+func kindOf(strm *strmFile) string {
+	if strm.Type == Movie {
+		return "movie"
+	}
+	return "episode"
+}
+
+// readStrms lists the .strm files pointing to Quasar under a folder.
+func readStrms(root string) []*strmFile {
+	strms := make([]*strmFile, 0)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".strm" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			libraryLog.Warning(err)
+			return nil
+		}
+		strm := &strmFile{Path: path, Content: strings.TrimSpace(string(content))}
+		if matches := moviePlayURL.FindStringSubmatch(strm.Content); matches != nil {
+			strm.Type = Movie
+			strm.ID = matches[1]
+		} else if matches := episodePlayURL.FindStringSubmatch(strm.Content); matches != nil {
+			if matches[1] == "" {
+				matches = append(matches[:1], matches[4:]...)
+			}
+			strm.Type = Episode
+			strm.ID = matches[1]
+			strm.Season, _ = strconv.Atoi(matches[2])
+			strm.Episode, _ = strconv.Atoi(matches[3])
+		} else {
+			// Not ours
+			return nil
+		}
+		strms = append(strms, strm)
+		return nil
+	})
+	sort.Sort(byPath(strms))
+	return strms
+}
+
+// trackedItems returns the items of the library of conf, and the ones
+// removed from it, by type.
+func trackedItems(conf *config.Configuration) map[int]map[string]bool {
+	tracked := make(map[int]map[string]bool)
+	DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(profileBucket(conf, bucket)).ForEach(func(k, v []byte) error {
+			var item *DBItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return nil
+			}
+			if tracked[item.Type] == nil {
+				tracked[item.Type] = make(map[string]bool)
+			}
+			tracked[item.Type][item.ID] = true
+			return nil
+		})
+	})
+	return tracked
+}
+
+// allTrackedItems returns the items of the libraries of all profiles, whose
+// .strm files are shared, an item being removed only if no profile has it.
+func allTrackedItems() map[int]map[string]bool {
+	tracked := make(map[int]map[string]bool)
+	for _, conf := range allProfiles() {
+		for itemType, ids := range trackedItems(conf) {
+			if tracked[itemType] == nil {
+				tracked[itemType] = make(map[string]bool)
+			}
+			for id := range ids {
+				tracked[itemType][id] = true
+			}
+		}
+	}
+	for _, pair := range [][2]int{{Movie, RemovedMovie}, {Show, RemovedShow}} {
+		for id := range tracked[pair[0]] {
+			delete(tracked[pair[1]], id)
+		}
+	}
+	return tracked
+}
+
+// kodiLibraryFiles returns the files Kodi's library knows of under our
+// library folders.
+func kodiLibraryFiles() map[string]string {
+	files := make(map[string]string)
+	folders := libraryFolders()
+	inLibrary := func(file string) bool {
+		return inLibraryFolders(file, folders)
+	}
+	if libraryMovies != nil {
+		for _, movie := range libraryMovies.Movies {
+			if file := filepath.Clean(movie.File); inLibrary(file) {
+				files[file] = movie.Title
+			}
+		}
+	}
+	for _, episodes := range libraryEpisodes {
+		if episodes == nil {
+			continue
+		}
+		for _, episode := range episodes.Episodes {
+			if file := filepath.Clean(episode.File); inLibrary(file) {
+				files[file] = episode.Title
+			}
+		}
+	}
+	return files
+}
+
+// diffLibrary compares the three sources, refreshing Kodi's side first if
+// asked to.
+func diffLibrary(refreshKodi bool) (*libraryDiff, error) {
+	if err := checkMoviesPath(); err != nil {
+		return nil, err
+	}
+	if err := checkShowsPath(); err != nil {
+		return nil, err
+	}
+	if refreshKodi {
+		updateLibraryMovies()
+		updateLibraryShows()
+	}
+
+	diff := &libraryDiff{
+		Issues:   make([]*libraryIssue, 0),
+		DryRun:   true,
+		strms:    make([]*strmFile, 0),
+		tracked:  allTrackedItems(),
+		kodiSeen: kodiLibrary() && (libraryMovies != nil || libraryShows != nil),
+	}
+	for _, folder := range libraryFolders() {
+		diff.strms = append(diff.strms, readStrms(folder)...)
+	}
+	kodiFiles := kodiLibraryFiles()
+
+	movies := make(map[string][]*strmFile)
+	episodes := make(map[string][]*strmFile)
+	shows := make(map[string]bool)
+	for _, strm := range diff.strms {
+		if strm.Type == Movie {
+			movies[strm.ID] = append(movies[strm.ID], strm)
+		} else {
+			key := fmt.Sprintf("%s_%d_%d", strm.ID, strm.Season, strm.Episode)
+			episodes[key] = append(episodes[key], strm)
+			shows[strm.ID] = true
+		}
+
+		if strm.Content != strm.playURL() {
+			diff.add(issueStalePlayURL, kindOf(strm), strm.ID, strm.Path, fmt.Sprintf("Points to %s", strm.Content), strm)
+		}
+		if diff.kodiSeen {
+			if _, exists := kodiFiles[strm.Path]; !exists {
+				diff.add(issueNotInKodi, kindOf(strm), strm.ID, strm.Path, "Not in Kodi's library", strm)
+			}
+		}
+	}
+
+	duplicates := func(strms []*strmFile) {
+		// Keep the one Kodi knows of, or else the first one
+		kept := 0
+		for i, strm := range strms {
+			if _, exists := kodiFiles[strm.Path]; exists {
+				kept = i
+				break
+			}
+		}
+		for i, strm := range strms {
+			if i != kept {
+				diff.add(issueDuplicateStrm, kindOf(strm), strm.ID, strm.Path, fmt.Sprintf("Duplicate of %s", strms[kept].Path), strm)
+			}
+		}
+	}
+
+	for id, strms := range movies {
+		if len(strms) > 1 {
+			duplicates(strms)
+		}
+		if !diff.tracked[Movie][id] {
+			detail := "Not in Quasar's library"
+			if diff.tracked[RemovedMovie][id] {
+				detail = "Removed from Quasar's library"
+			}
+			for _, strm := range strms {
+				diff.add(issueOrphanStrm, "movie", id, strm.Path, detail, strm)
+			}
+		}
+	}
+	for _, strms := range episodes {
+		if len(strms) > 1 {
+			duplicates(strms)
+		}
+		id := strms[0].ID
+		if !diff.tracked[Show][id] {
+			detail := "Show not in Quasar's library"
+			if diff.tracked[RemovedShow][id] {
+				detail = "Show removed from Quasar's library"
+			}
+			for _, strm := range strms {
+				diff.add(issueOrphanStrm, "episode", id, strm.Path, detail, strm)
+			}
+		}
+	}
+
+	for id := range diff.tracked[Movie] {
+		if len(movies[id]) == 0 {
+			diff.add(issueMissingStrm, "movie", id, "", "In Quasar's library without a .strm file", nil)
+		}
+	}
+	for id := range diff.tracked[Show] {
+		if !shows[id] {
+			diff.add(issueMissingStrm, "show", id, "", "In Quasar's library without any .strm file", nil)
+		}
+	}
+
+	for file, title := range kodiFiles {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			diff.add(issueKodiOrphan, "", "", file, fmt.Sprintf("%s is in Kodi's library without its file", title), nil)
+		}
+	}
+
+	sort.Stable(byIssueType(diff.Issues))
+	return diff, nil
+}
+
+func removeStrm(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(strings.TrimSuffix(path, ".strm") + ".nfo")
+	return nil
+}
+
+// fix repairs the issues of the given types, or all of them if none is
+// given.
+func (d *libraryDiff) fix(issueTypes ...string) {
+	d.DryRun = false
+	needClean := false
+	needScan := false
+
+	for _, issue := range d.Issues {
+		if len(issueTypes) > 0 && !stringInSlice(issue.Type, issueTypes) {
+			continue
+		}
+
+		var err error
+		switch issue.Type {
+		case issueOrphanStrm:
+			removedType := RemovedMovie
+			trackedType := Movie
+			if issue.Kind == "episode" {
+				removedType = RemovedShow
+				trackedType = Show
+			}
+			if d.tracked[removedType][issue.ID] {
+				err = removeStrm(issue.Path)
+				needClean = true
+			} else if !d.tracked[trackedType][issue.ID] {
+				err = updateDB(config.Get(), Update, trackedType, []string{issue.ID}, 0)
+				if err == nil {
+					if d.tracked[trackedType] == nil {
+						d.tracked[trackedType] = make(map[string]bool)
+					}
+					d.tracked[trackedType][issue.ID] = true
+				}
+			}
+		case issueMissingStrm:
+			if issue.Kind == "movie" {
+				_, err = writeMovieStrm(issue.ID)
+			} else {
+				_, err = writeShowStrm(config.Get(), issue.ID, false)
+			}
+			needScan = true
+		case issueDuplicateStrm:
+			err = removeStrm(issue.Path)
+			needClean = true
+		case issueStalePlayURL:
+			err = ioutil.WriteFile(issue.Path, []byte(issue.strm.playURL()), 0644)
+			issue.strm.Content = issue.strm.playURL()
+		case issueKodiOrphan:
+			needClean = true
+		case issueNotInKodi:
+			needScan = true
+		}
+
+		if err != nil {
+			libraryLog.Warningf("Unable to fix %s of %s: %s", issue.Type, issue.Path, err)
+			issue.Error = err.Error()
+		} else {
+			issue.Fixed = true
+		}
+	}
+
+	if needClean {
+		libraryClean()
+	}
+	if needScan && scanning == false {
+		scanning = true
+		libraryScan()
+	}
+}
+
+func (d *libraryDiff) summary() string {
+	counts := make(map[string]int)
+	for _, issue := range d.Issues {
+		counts[issue.Type]++
+	}
+	if len(counts) == 0 {
+		return "No library issue found"
+	}
+	labels := make([]string, 0, len(counts))
+	for issueType, count := range counts {
+		labels = append(labels, fmt.Sprintf("%d %s", count, strings.Replace(issueType, "_", " ", -1)))
+	}
+	sort.Strings(labels)
+	if d.DryRun {
+		return "Found " + strings.Join(labels, ", ")
+	}
+	return "Fixed " + strings.Join(labels, ", ")
+}
+
+// LibraryDoctor reports the differences between the .strm files, Quasar's
+// database and Kodi's library, and fixes them unless it's a dry run.
+func LibraryDoctor(ctx *gin.Context) {
+	diff, err := diffLibrary(true)
+	if err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+
+	fix := ctx.Query("fix") != ""
+	if !fix && ctx.Query("dialog") != "" && len(diff.Issues) > 0 {
+		fix = xbmc.DialogConfirm("Quasar", diff.summary()+", fix them?")
+	}
+	if fix {
+		diff.fix()
+	}
+
+	libraryLog.Notice(diff.summary())
+	xbmc.Notify("Quasar", diff.summary(), config.AddonIcon())
+	ctx.JSON(200, diff)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+// How long ?wait=1 holds a request open for the next event
+const eventsMaxWait = 30 * time.Second
+
+// Events lists the dialogs and notifications of headless mode after the
+// one given by ?since=, waiting for the next one with ?wait=1.
+func Events(ctx *gin.Context) {
+	since, _ := strconv.Atoi(ctx.DefaultQuery("since", "0"))
+	if ctx.Query("wait") == "" {
+		ctx.JSON(200, xbmc.RecentEvents(since))
+		return
+	}
+
+	// Listening first so nothing is missed in between
+	published, done := xbmc.Events.Listen()
+	defer close(done)
+	events := xbmc.RecentEvents(since)
+	if len(events) == 0 {
+		select {
+		case <-published:
+			events = xbmc.RecentEvents(since)
+		case <-time.After(eventsMaxWait):
+		}
+	}
+	ctx.JSON(200, events)
+}
@@ -0,0 +1,666 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/util"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Library layout: which root folder items go to, and how their folders and
+// files are named.
+//
+
+const (
+	LibraryLayoutFile = "library.json"
+	locationsBucket   = "LibraryLocations"
+)
+
+// libraryRoot takes the items matching all of its conditions instead of the
+// default Movies and Shows folders, i.e.
+// {"name": "Kids", "path": "/media/kids", "type": "show",
+//  "genres": ["Animation", "Family"], "certifications": ["TV-Y", "TV-G"]}
+//
+// Genres and certifications match if any of the item's do, and lists are
+// the Trakt lists the item was added from, i.e. watchlist, collection or a
+// list ID. Relative paths are relative to the library path.
+type libraryRoot struct {
+	Name           string   `json:"name"`
+	Path           string   `json:"path"`
+	Type           string   `json:"type"`
+	Genres         []string `json:"genres"`
+	Certifications []string `json:"certifications"`
+	Lists          []string `json:"lists"`
+}
+
+// libraryLayout is read from library.json in the profile folder. Templates
+// use {field} or {field:02} for zero padded numbers, and / for subfolders.
+type libraryLayout struct {
+	MovieFolder string         `json:"movie_folder"`
+	MovieFile   string         `json:"movie_file"`
+	ShowFolder  string         `json:"show_folder"`
+	EpisodeFile string         `json:"episode_file"`
+	Roots       []*libraryRoot `json:"roots"`
+}
+
+// libraryLocation is where an item was written, so later updates and
+// removals find it whatever the layout became since.
+type libraryLocation struct {
+	Folder string `json:"folder"`
+	Root   string `json:"root,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+var (
+	templateField = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+	movieFields   = []string{"title", "original_title", "year", "tmdb", "imdb"}
+	showFields    = []string{"title", "original_title", "year", "tmdb", "tvdb", "imdb"}
+	episodeFields = append([]string{"season", "episode", "episode_title"}, showFields...)
+)
+
+func defaultLibraryLayout() *libraryLayout {
+	return &libraryLayout{
+		MovieFolder: "{original_title} ({year})",
+		MovieFile:   "{original_title} ({year})",
+		ShowFolder:  "{title} ({year})",
+		EpisodeFile: "{title} ({year}) S{season:02}E{episode:02}",
+	}
+}
+
+func LibraryLayoutPath() string {
+	return filepath.Join(config.Get().ProfilePath, LibraryLayoutFile)
+}
+
+// loadLibraryLayout reads the user's layout, a missing file or field
+// meaning the default one.
+func loadLibraryLayout() (*libraryLayout, error) {
+	layout := defaultLibraryLayout()
+	data, err := ioutil.ReadFile(LibraryLayoutPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return layout, nil
+		}
+		return layout, err
+	}
+	if err := json.Unmarshal(data, layout); err != nil {
+		return defaultLibraryLayout(), fmt.Errorf("Invalid %s: %s", LibraryLayoutFile, err)
+	}
+	if err := layout.validate(); err != nil {
+		return defaultLibraryLayout(), fmt.Errorf("Invalid %s: %s", LibraryLayoutFile, err)
+	}
+	return layout, nil
+}
+
+func (l *libraryLayout) validate() error {
+	templates := []struct {
+		name     string
+		template string
+		fields   []string
+	}{
+		{"movie_folder", l.MovieFolder, movieFields},
+		{"movie_file", l.MovieFile, movieFields},
+		{"show_folder", l.ShowFolder, showFields},
+		{"episode_file", l.EpisodeFile, episodeFields},
+	}
+	for _, t := range templates {
+		if strings.TrimSpace(t.template) == "" {
+			return fmt.Errorf("%s is empty", t.name)
+		}
+		for _, match := range templateField.FindAllStringSubmatch(t.template, -1) {
+			if !stringInSlice(match[1], t.fields) {
+				return fmt.Errorf("unknown field {%s} in %s, use one of %s", match[1], t.name, strings.Join(t.fields, ", "))
+			}
+		}
+	}
+	for i, root := range l.Roots {
+		if root.Path == "" {
+			return fmt.Errorf("root #%d has no path", i+1)
+		}
+		if root.Type != "" && root.Type != "movie" && root.Type != "show" {
+			return fmt.Errorf("root #%d has invalid type %s, use movie or show", i+1, root.Type)
+		}
+	}
+	return nil
+}
+
+// renderTemplate fills a template, keeping each path segment a valid file
+// name.
+func renderTemplate(template string, values map[string]interface{}) (string, error) {
+	rendered := templateField.ReplaceAllStringFunc(template, func(field string) string {
+		match := templateField.FindStringSubmatch(field)
+		switch value := values[match[1]].(type) {
+		case int:
+			if match[2] != "" {
+				width, _ := strconv.Atoi(match[2])
+				return fmt.Sprintf("%0*d", width, value)
+			}
+			return strconv.Itoa(value)
+		case string:
+			return strings.Replace(value, "/", "", -1)
+		}
+		return ""
+	})
+
+	segments := strings.Split(rendered, "/")
+	for i, segment := range segments {
+		segment = strings.TrimSpace(util.ToFileName(segment))
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("Template %q gives an invalid path %q", template, rendered)
+		}
+		segments[i] = segment
+	}
+	return filepath.Join(segments...), nil
+}
+
+func yearOf(date string) string {
+	return strings.Split(date, "-")[0]
+}
+
+func movieValues(movie *tmdb.Movie) map[string]interface{} {
+	return map[string]interface{}{
+		"title":          movie.Title,
+		"original_title": movie.OriginalTitle,
+		"year":           yearOf(movie.ReleaseDate),
+		"tmdb":           movie.Id,
+		"imdb":           movie.IMDBId,
+	}
+}
+
+func showValues(show *tmdb.Show) map[string]interface{} {
+	values := map[string]interface{}{
+		"title":          show.Name,
+		"original_title": show.OriginalName,
+		"year":           yearOf(show.FirstAirDate),
+		"tmdb":           show.Id,
+		"tvdb":           "",
+		"imdb":           "",
+	}
+	if show.ExternalIDs != nil {
+		values["imdb"] = show.ExternalIDs.IMDBId
+		if show.ExternalIDs.TVDBID != nil {
+			values["tvdb"] = fmt.Sprintf("%v", show.ExternalIDs.TVDBID)
+		}
+	}
+	return values
+}
+
+func episodeValues(show *tmdb.Show, seasonNumber int, episodeNumber int, episodeTitle string) map[string]interface{} {
+	values := showValues(show)
+	values["season"] = seasonNumber
+	values["episode"] = episodeNumber
+	values["episode_title"] = episodeTitle
+	return values
+}
+
+func movieCertifications(movie *tmdb.Movie) []string {
+	certifications := make([]string, 0)
+	if movie.ReleaseDates == nil {
+		return certifications
+	}
+	for _, country := range movie.ReleaseDates.Results {
+		for _, release := range country.ReleaseDates {
+			if release.Certification != "" {
+				certifications = append(certifications, release.Certification)
+			}
+		}
+	}
+	return certifications
+}
+
+func showCertifications(show *tmdb.Show) []string {
+	certifications := make([]string, 0)
+	if show.ContentRatings == nil {
+		return certifications
+	}
+	for _, rating := range show.ContentRatings.Ratings {
+		if rating.Rating != "" {
+			certifications = append(certifications, rating.Rating)
+		}
+	}
+	return certifications
+}
+
+func anyInSlice(values []string, list []string) bool {
+	for _, value := range values {
+		for _, item := range list {
+			if strings.EqualFold(value, item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *libraryRoot) matches(itemType string, genres []string, certifications []string, source string) bool {
+	if r.Type != "" && r.Type != itemType {
+		return false
+	}
+	if len(r.Genres) > 0 && !anyInSlice(genres, r.Genres) {
+		return false
+	}
+	if len(r.Certifications) > 0 && !anyInSlice(certifications, r.Certifications) {
+		return false
+	}
+	if len(r.Lists) > 0 && !anyInSlice([]string{source}, r.Lists) {
+		return false
+	}
+	return true
+}
+
+func (r *libraryRoot) path() string {
+	if filepath.IsAbs(r.Path) {
+		return r.Path
+	}
+	return filepath.Join(libraryPath, r.Path)
+}
+
+// route returns the first root taking an item, nil for the default one.
+func (l *libraryLayout) route(itemType string, genres []string, certifications []string, source string) *libraryRoot {
+	for _, root := range l.Roots {
+		if root.matches(itemType, genres, certifications, source) {
+			return root
+		}
+	}
+	return nil
+}
+
+// movieTarget is where a movie goes with the current layout.
+func (l *libraryLayout) movieTarget(movie *tmdb.Movie, source string) (*libraryLocation, error) {
+	genres := make([]string, 0, len(movie.Genres))
+	for _, genre := range movie.Genres {
+		genres = append(genres, genre.Name)
+	}
+	folder, err := renderTemplate(l.MovieFolder, movieValues(movie))
+	if err != nil {
+		return nil, err
+	}
+	location := &libraryLocation{Folder: filepath.Join(moviesLibraryPath, folder), Source: source}
+	if root := l.route("movie", genres, movieCertifications(movie), source); root != nil {
+		location.Folder = filepath.Join(root.path(), folder)
+		location.Root = root.Name
+	}
+	return location, nil
+}
+
+// showTarget is where a show goes with the current layout.
+func (l *libraryLayout) showTarget(show *tmdb.Show, source string) (*libraryLocation, error) {
+	genres := make([]string, 0, len(show.Genres))
+	for _, genre := range show.Genres {
+		genres = append(genres, genre.Name)
+	}
+	folder, err := renderTemplate(l.ShowFolder, showValues(show))
+	if err != nil {
+		return nil, err
+	}
+	location := &libraryLocation{Folder: filepath.Join(showsLibraryPath, folder), Source: source}
+	if root := l.route("show", genres, showCertifications(show), source); root != nil {
+		location.Folder = filepath.Join(root.path(), folder)
+		location.Root = root.Name
+	}
+	return location, nil
+}
+
+func (l *libraryLayout) movieFile(movie *tmdb.Movie) (string, error) {
+	file, err := renderTemplate(l.MovieFile, movieValues(movie))
+	if err != nil {
+		return "", err
+	}
+	return file + ".strm", nil
+}
+
+// episodeFile is the path of an episode's .strm file within its show's
+// folder.
+func (l *libraryLayout) episodeFile(show *tmdb.Show, seasonNumber int, episodeNumber int, episodeTitle string) (string, error) {
+	file, err := renderTemplate(l.EpisodeFile, episodeValues(show, seasonNumber, episodeNumber, episodeTitle))
+	if err != nil {
+		return "", err
+	}
+	return file + ".strm", nil
+}
+
+//
+// Item locations
+//
+func locationKey(itemType int, id string) []byte {
+	return []byte(fmt.Sprintf("%d_%s", itemType, id))
+}
+
+// getLocation returns where an item was written, with an empty folder if
+// it wasn't yet.
+func getLocation(itemType int, id string) *libraryLocation {
+	location := &libraryLocation{}
+	DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(locationsBucket)).Get(locationKey(itemType, id)); v != nil {
+			json.Unmarshal(v, location)
+		}
+		return nil
+	})
+	return location
+}
+
+func setLocation(itemType int, id string, location *libraryLocation) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(location)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(locationsBucket)).Put(locationKey(itemType, id), buf)
+	})
+}
+
+func deleteLocation(itemType int, id string) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(locationsBucket)).Delete(locationKey(itemType, id))
+	})
+}
+
+// setSource remembers which list an item is added from, for routing it
+// when it gets written.
+func setSource(itemType int, id string, source string) {
+	location := getLocation(itemType, id)
+	if location.Folder != "" {
+		return
+	}
+	location.Source = source
+	if err := setLocation(itemType, id, location); err != nil {
+		libraryLog.Error(err)
+	}
+}
+
+// movieLocation returns where a movie is, or goes if it's not there yet.
+func movieLocation(layout *libraryLayout, movie *tmdb.Movie) (*libraryLocation, error) {
+	location := getLocation(Movie, strconv.Itoa(movie.Id))
+	if location.Folder != "" {
+		return location, nil
+	}
+	// Written before locations were remembered, where it always went
+	if legacy, err := defaultLibraryLayout().movieTarget(movie, location.Source); err == nil {
+		if _, err := os.Stat(legacy.Folder); err == nil {
+			return legacy, nil
+		}
+	}
+	return layout.movieTarget(movie, location.Source)
+}
+
+// showLocation returns where a show is, or goes if it's not there yet.
+func showLocation(layout *libraryLayout, show *tmdb.Show) (*libraryLocation, error) {
+	location := getLocation(Show, strconv.Itoa(show.Id))
+	if location.Folder != "" {
+		return location, nil
+	}
+	if legacy, err := defaultLibraryLayout().showTarget(show, location.Source); err == nil {
+		if _, err := os.Stat(legacy.Folder); err == nil {
+			return legacy, nil
+		}
+	}
+	return layout.showTarget(show, location.Source)
+}
+
+// libraryFolders returns the default folders and the ones of all roots,
+// leaving out the ones within others.
+func libraryFolders() []string {
+	candidates := []string{moviesLibraryPath, showsLibraryPath}
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		libraryLog.Warning(err)
+	}
+	for _, root := range layout.Roots {
+		candidates = append(candidates, filepath.Clean(root.path()))
+	}
+	folders := make([]string, 0, len(candidates))
+	for _, folder := range candidates {
+		if !stringInSlice(folder, folders) && !inLibraryFolders(folder, candidates) {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+func inLibraryFolders(file string, folders []string) bool {
+	for _, folder := range folders {
+		if strings.HasPrefix(file, folder+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Migration to the current layout
+//
+type libraryMove struct {
+	Kind  string `json:"kind"`
+	ID    string `json:"id"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+
+	location *libraryLocation
+}
+
+type libraryMigration struct {
+	Moves  []*libraryMove `json:"moves"`
+	DryRun bool           `json:"dry_run"`
+}
+
+// moveFile renames a file, creating the folders it goes to and taking its
+// .nfo along.
+func moveFile(from string, to string) error {
+	if from == to {
+		return nil
+	}
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	fromNFO := strings.TrimSuffix(from, ".strm") + ".nfo"
+	if _, err := os.Stat(fromNFO); err == nil {
+		os.Rename(fromNFO, strings.TrimSuffix(to, ".strm")+".nfo")
+	}
+	return nil
+}
+
+// removeEmptyFolders removes the folders left empty under a folder.
+func removeEmptyFolders(folder string) {
+	entries, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			removeEmptyFolders(filepath.Join(folder, entry.Name()))
+		}
+	}
+	if entries, err := ioutil.ReadDir(folder); err == nil && len(entries) == 0 {
+		os.Remove(folder)
+	}
+}
+
+// planMigration lists the files of every tracked item not where the
+// current layout puts them.
+func planMigration(layout *libraryLayout) *libraryMigration {
+	migration := &libraryMigration{Moves: make([]*libraryMove, 0), DryRun: true}
+	tracked := allTrackedItems()
+
+	for id := range tracked[Movie] {
+		movie := tmdb.GetMovieById(id, "en")
+		if movie == nil {
+			continue
+		}
+		current, err := movieLocation(layout, movie)
+		if err != nil {
+			libraryLog.Warning(err)
+			continue
+		}
+		target, err := layout.movieTarget(movie, current.Source)
+		if err != nil {
+			libraryLog.Warning(err)
+			continue
+		}
+		file, err := layout.movieFile(movie)
+		if err != nil {
+			libraryLog.Warning(err)
+			continue
+		}
+		for _, strm := range readStrms(current.Folder) {
+			if strm.Type != Movie || strm.ID != id {
+				continue
+			}
+			to := filepath.Join(target.Folder, file)
+			if strm.Path != to {
+				migration.Moves = append(migration.Moves, &libraryMove{Kind: "movie", ID: id, From: strm.Path, To: to, location: target})
+			}
+		}
+	}
+
+	for id := range tracked[Show] {
+		Id, _ := strconv.Atoi(id)
+		show := tmdb.GetShow(Id, "en")
+		if show == nil {
+			continue
+		}
+		current, err := showLocation(layout, show)
+		if err != nil {
+			libraryLog.Warning(err)
+			continue
+		}
+		target, err := layout.showTarget(show, current.Source)
+		if err != nil {
+			libraryLog.Warning(err)
+			continue
+		}
+		for _, strm := range readStrms(current.Folder) {
+			if strm.Type != Episode || strm.ID != id {
+				continue
+			}
+			episodeTitle := ""
+			if episode := tmdb.GetEpisode(Id, strm.Season, strm.Episode, "en"); episode != nil {
+				episodeTitle = episode.Name
+			}
+			file, err := layout.episodeFile(show, strm.Season, strm.Episode, episodeTitle)
+			if err != nil {
+				libraryLog.Warning(err)
+				break
+			}
+			to := filepath.Join(target.Folder, file)
+			if strm.Path != to {
+				migration.Moves = append(migration.Moves, &libraryMove{Kind: "episode", ID: id, From: strm.Path, To: to, location: target})
+			}
+		}
+		// tvshow.nfo follows the show's folder
+		if _, err := os.Stat(filepath.Join(current.Folder, "tvshow.nfo")); err == nil && current.Folder != target.Folder {
+			migration.Moves = append(migration.Moves, &libraryMove{
+				Kind:     "show",
+				ID:       id,
+				From:     filepath.Join(current.Folder, "tvshow.nfo"),
+				To:       filepath.Join(target.Folder, "tvshow.nfo"),
+				location: target,
+			})
+		}
+	}
+	return migration
+}
+
+func (m *libraryMigration) apply() {
+	m.DryRun = false
+	folders := make(map[string]bool)
+	for _, move := range m.Moves {
+		if err := moveFile(move.From, move.To); err != nil {
+			libraryLog.Warningf("Unable to move %s to %s: %s", move.From, move.To, err)
+			move.Error = err.Error()
+			continue
+		}
+		move.Done = true
+		folders[filepath.Dir(move.From)] = true
+
+		itemType := Movie
+		if move.Kind != "movie" {
+			itemType = Show
+		}
+		if err := setLocation(itemType, move.ID, move.location); err != nil {
+			libraryLog.Error(err)
+		}
+	}
+	for folder := range folders {
+		for _, root := range libraryFolders() {
+			if strings.HasPrefix(folder, root+string(os.PathSeparator)) {
+				// Up to the item's own folder, leaving the roots alone
+				for parent := folder; parent != root; parent = filepath.Dir(parent) {
+					removeEmptyFolders(parent)
+				}
+			}
+		}
+	}
+}
+
+func (m *libraryMigration) summary() string {
+	if len(m.Moves) == 0 {
+		return "Library already matches its layout"
+	}
+	if m.DryRun {
+		return fmt.Sprintf("%d files to move", len(m.Moves))
+	}
+	done := 0
+	for _, move := range m.Moves {
+		if move.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("Moved %d of %d files", done, len(m.Moves))
+}
+
+// LibraryMigrate moves the library's files to where the current layout
+// puts them, only reporting what it would do unless asked to apply it.
+func LibraryMigrate(ctx *gin.Context) {
+	if err := checkMoviesPath(); err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+	if err := checkShowsPath(); err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+
+	migration := planMigration(layout)
+	apply := ctx.Query("apply") != ""
+	if !apply && ctx.Query("dialog") != "" && len(migration.Moves) > 0 {
+		apply = xbmc.DialogConfirm("Quasar", migration.summary()+", move them?")
+	}
+	if apply && len(migration.Moves) > 0 {
+		migration.apply()
+		// Kodi knows the files by path, so it needs to forget the old ones
+		libraryClean()
+		if scanning == false {
+			scanning = true
+			libraryScan()
+		}
+	}
+
+	libraryLog.Notice(migration.summary())
+	xbmc.Notify("Quasar", migration.summary(), config.AddonIcon())
+	ctx.JSON(200, migration)
+}
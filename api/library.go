@@ -1,27 +1,28 @@
 package api
 
 import (
-	"os"
-	"fmt"
-	"time"
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"strconv"
-	"strings"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
-	"encoding/json"
-	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
-	"github.com/scakemyer/quasar/util"
-	"github.com/scakemyer/quasar/xbmc"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/tmdb"
 	"github.com/scakemyer/quasar/trakt"
-	"github.com/scakemyer/quasar/config"
-	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/ui"
+	"github.com/scakemyer/quasar/util"
+	"github.com/scakemyer/quasar/xbmc"
 )
 
 const (
@@ -148,15 +149,27 @@ func checkShowsPath() error {
 // Updates from Kodi library
 //
 func updateLibraryMovies() {
+	if !kodiLibrary() {
+		return
+	}
+	defer librarySyncSeconds.Since(time.Now(), "kodi_movies", "ok")
 	libraryMovies = xbmc.VideoLibraryGetMovies()
 }
 func updateLibraryShows() {
+	if !kodiLibrary() {
+		return
+	}
+	defer librarySyncSeconds.Since(time.Now(), "kodi_shows", "ok")
 	libraryShows = xbmc.VideoLibraryGetShows()
 	if libraryShows == nil {
 		return
 	}
+	showIds := make([]int, 0, len(libraryShows.Shows))
 	for _, tvshow := range libraryShows.Shows {
-		updateLibraryEpisodes(tvshow.ID)
+		showIds = append(showIds, tvshow.ID)
+	}
+	for showId, episodes := range xbmc.VideoLibraryGetShowsEpisodes(showIds) {
+		libraryEpisodes[showId] = episodes
 	}
 }
 func updateLibraryEpisodes(showId int) {
@@ -334,9 +347,9 @@ func isDuplicateEpisode(tmdbShowId int, seasonNumber int, episodeNumber int) (ep
 	return
 }
 
-func isAddedToLibrary(id string, addedType int) (isAdded bool) {
+func isAddedToLibrary(conf *config.Configuration, id string, addedType int) (isAdded bool) {
 	DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(bucket)).Cursor()
+		c := tx.Bucket(profileBucket(conf, bucket)).Cursor()
 		prefix := []byte(fmt.Sprintf("%d_", addedType))
 		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
 			itemID := strings.Split(string(k), "_")[1]
@@ -354,11 +367,11 @@ func isAddedToLibrary(id string, addedType int) (isAdded bool) {
 //
 // Database updates
 //
-func updateDB(Operation int, Type int, IDs []string, TVShowID int) (err error) {
+func updateDB(conf *config.Configuration, Operation int, Type int, IDs []string, TVShowID int) (err error) {
 	switch Operation {
 	case Delete:
 		err = DB.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
+			b := tx.Bucket(profileBucket(conf, bucket))
 			if err := b.Delete([]byte(fmt.Sprintf("%d_%s", Type, IDs[0]))); err != nil {
 				return err
 			}
@@ -366,7 +379,7 @@ func updateDB(Operation int, Type int, IDs []string, TVShowID int) (err error) {
 		})
 	case Update:
 		err = DB.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
+			b := tx.Bucket(profileBucket(conf, bucket))
 			item := DBItem{
 				ID: IDs[0],
 				Type: Type,
@@ -381,7 +394,7 @@ func updateDB(Operation int, Type int, IDs []string, TVShowID int) (err error) {
 		})
 	case Batch:
 		err = DB.Batch(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
+			b := tx.Bucket(profileBucket(conf, bucket))
 			for _, id := range IDs {
 				item := DBItem{
 					ID: id,
@@ -400,7 +413,7 @@ func updateDB(Operation int, Type int, IDs []string, TVShowID int) (err error) {
 		})
 	case BatchDelete:
 		err = DB.Batch(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
+			b := tx.Bucket(profileBucket(conf, bucket))
 			for _, id := range IDs {
 				if err := b.Delete([]byte(fmt.Sprintf("%d_%s", Type, id))); err != nil {
 					return err
@@ -420,9 +433,9 @@ func updateDB(Operation int, Type int, IDs []string, TVShowID int) (err error) {
 	return err
 }
 
-func wasRemoved(id string, removedType int) (wasRemoved bool) {
+func wasRemoved(conf *config.Configuration, id string, removedType int) (wasRemoved bool) {
 	DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(bucket)).Cursor()
+		c := tx.Bucket(profileBucket(conf, bucket)).Cursor()
 		prefix := []byte(fmt.Sprintf("%d_", removedType))
 		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
 			itemID := strings.Split(string(k), "_")[1]
@@ -439,31 +452,39 @@ func wasRemoved(id string, removedType int) (wasRemoved bool) {
 //
 // Library updates
 //
-func doUpdateLibrary() error {
-	if err := checkShowsPath(); err != nil {
+func doUpdateLibrary() (err error) {
+	defer observeSync("library_update", time.Now(), &err)
+
+	// Kodi's side is kept up to date by the library listener
+	diff, err := diffLibrary(false)
+	if err != nil {
 		return err
 	}
 
-	DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(bucket)).Cursor()
-		prefix := []byte(fmt.Sprintf("%d_", Show))
-		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			var item *DBItem
-			if err := json.Unmarshal(v, &item); err != nil {
-				return err
-			}
-			if _, err := writeShowStrm(item.ID, false); err != nil {
-				libraryLog.Error(err)
+	// Rewrite what's missing or outdated, then look for new episodes
+	diff.fix(issueMissingStrm, issueStalePlayURL)
+	shows := make(map[string]*config.Configuration)
+	for _, conf := range allProfiles() {
+		for showId := range trackedItems(conf)[Show] {
+			if _, exists := shows[showId]; !exists {
+				shows[showId] = conf
 			}
 		}
-		return nil
-	})
+	}
+	updateShows(shows)
+	for _, conf := range allProfiles() {
+		syncImportedLists(conf)
+	}
 
 	libraryLog.Notice("Library updated")
 	return nil
 }
 
-func doSyncTrakt() error {
+// doSyncTrakt adds the movies and shows of the Trakt lists of conf to its
+// library.
+func doSyncTrakt(conf *config.Configuration) (err error) {
+	defer observeSync("trakt_lists", time.Now(), &err)
+
 	if err := checkMoviesPath(); err != nil {
 		return err
 	}
@@ -471,25 +492,25 @@ func doSyncTrakt() error {
 		return err
 	}
 
-	if err := syncMoviesList("watchlist", true); err != nil {
+	if err := syncMoviesList(conf, ui.Get(), "watchlist", true); err != nil {
 		return err
 	}
-	if err := syncMoviesList("collection", true); err != nil {
+	if err := syncMoviesList(conf, ui.Get(), "collection", true); err != nil {
 		return err
 	}
-	if err := syncShowsList("watchlist", true); err != nil {
+	if err := syncShowsList(conf, ui.Get(), "watchlist", true); err != nil {
 		return err
 	}
-	if err := syncShowsList("collection", true); err != nil {
+	if err := syncShowsList(conf, ui.Get(), "collection", true); err != nil {
 		return err
 	}
 
-	lists := trakt.Userlists()
+	lists := trakt.Userlists(conf)
 	for _, list := range lists {
-		if err := syncMoviesList(strconv.Itoa(list.IDs.Trakt), true); err != nil {
+		if err := syncMoviesList(conf, ui.Get(), strconv.Itoa(list.IDs.Trakt), true); err != nil {
 			continue
 		}
-		if err := syncShowsList(strconv.Itoa(list.IDs.Trakt), true); err != nil {
+		if err := syncShowsList(conf, ui.Get(), strconv.Itoa(list.IDs.Trakt), true); err != nil {
 			continue
 		}
 	}
@@ -501,7 +522,7 @@ func doSyncTrakt() error {
 //
 // Movie internals
 //
-func syncMoviesList(listId string, updating bool) (err error) {
+func syncMoviesList(conf *config.Configuration, dialogs ui.UI, listId string, updating bool) (err error) {
 	if err := checkMoviesPath(); err != nil {
 		return err
 	}
@@ -511,13 +532,13 @@ func syncMoviesList(listId string, updating bool) (err error) {
 
 	switch listId {
 	case "watchlist":
-		movies, err = trakt.WatchlistMovies()
+		movies, err = trakt.WatchlistMovies(conf)
 		label = "LOCALIZE[30254]"
 	case "collection":
-		movies, err = trakt.CollectionMovies()
+		movies, err = trakt.CollectionMovies(conf)
 		label = "LOCALIZE[30257]"
 	default:
-		movies, err = trakt.ListItemsMovies(listId, false)
+		movies, err = trakt.ListItemsMovies(conf, listId, false)
 		label = "LOCALIZE[30263]"
 	}
 
@@ -536,7 +557,13 @@ func syncMoviesList(listId string, updating bool) (err error) {
 
 		tmdbId := strconv.Itoa(movie.Movie.IDs.TMDB)
 
-		if updating && wasRemoved(tmdbId, RemovedMovie) {
+		if updating && wasRemoved(conf, tmdbId, RemovedMovie) {
+			continue
+		}
+
+		// Already written for another profile
+		if addedElsewhere(conf, tmdbId, Movie) {
+			movieIDs = append(movieIDs, tmdbId)
 			continue
 		}
 
@@ -547,6 +574,7 @@ func syncMoviesList(listId string, updating bool) (err error) {
 			continue
 		}
 
+		setSource(Movie, tmdbId, listId)
 		if _, err := writeMovieStrm(tmdbId); err != nil {
 			libraryLog.Error(err)
 			continue
@@ -555,14 +583,14 @@ func syncMoviesList(listId string, updating bool) (err error) {
 		movieIDs = append(movieIDs, tmdbId)
 	}
 
-	if err := updateDB(Batch, Movie, movieIDs, 0); err != nil {
+	if err := updateDB(conf, Batch, Movie, movieIDs, 0); err != nil {
 		return err
 	}
 
 	if !updating {
 		libraryLog.Noticef("Movies list (%s) added", listId)
-		if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", label)) {
-			xbmc.VideoLibraryScan()
+		if dialogs.Confirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", label)) {
+			libraryScan()
 		}
 	}
 	return nil
@@ -574,19 +602,29 @@ func writeMovieStrm(tmdbId string) (*tmdb.Movie, error) {
 		return movie, errors.New(fmt.Sprintf("Unable to get movie (%s)", tmdbId))
 	}
 
-	movieStrm := util.ToFileName(fmt.Sprintf("%s (%s)", movie.OriginalTitle, strings.Split(movie.ReleaseDate, "-")[0]))
-	moviePath := filepath.Join(moviesLibraryPath, movieStrm)
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return movie, err
+	}
+	location, err := movieLocation(layout, movie)
+	if err != nil {
+		return movie, err
+	}
+	movieFile, err := layout.movieFile(movie)
+	if err != nil {
+		return movie, err
+	}
+	moviePath := location.Folder
+	movieStrmPath := filepath.Join(moviePath, movieFile)
 
-	if _, err := os.Stat(moviePath); os.IsNotExist(err) {
-		if err := os.Mkdir(moviePath, 0755); err != nil {
+	if _, err := os.Stat(filepath.Dir(movieStrmPath)); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(movieStrmPath), 0755); err != nil {
 			libraryLog.Error(err)
 			return movie, err
 		}
 	}
 
-	movieStrmPath := filepath.Join(moviePath, fmt.Sprintf("%s.strm", movieStrm))
-
-	playLink := UrlForXBMC("/library/movie/play/%s", tmdbId)
+	playLink := movieLibraryURL(tmdbId)
 	if _, err := os.Stat(movieStrmPath); err == nil {
 		return movie, errors.New(fmt.Sprintf("LOCALIZE[30287];;%s", movie.Title))
 	}
@@ -594,6 +632,12 @@ func writeMovieStrm(tmdbId string) (*tmdb.Movie, error) {
 		libraryLog.Error(err)
 		return movie, err
 	}
+	if err := writeMovieNFO(movie, strings.TrimSuffix(movieStrmPath, ".strm")+".nfo"); err != nil {
+		libraryLog.Error(err)
+	}
+	if err := setLocation(Movie, tmdbId, location); err != nil {
+		libraryLog.Error(err)
+	}
 
 	return movie, nil
 }
@@ -604,27 +648,47 @@ func removeMovie(ctx *gin.Context, tmdbId string) error {
 	}
 	movie := tmdb.GetMovieById(tmdbId, "en")
 	movieName := fmt.Sprintf("%s (%s)", movie.OriginalTitle, strings.Split(movie.ReleaseDate, "-")[0])
-	movieStrm := util.ToFileName(movieName)
-	moviePath := filepath.Join(moviesLibraryPath, movieStrm)
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return err
+	}
+	location, err := movieLocation(layout, movie)
+	if err != nil {
+		return err
+	}
+	moviePath := location.Folder
 
 	if _, err := os.Stat(moviePath); err != nil {
 		return errors.New("LOCALIZE[30282]")
 	}
-	if err := os.RemoveAll(moviePath); err != nil {
-		return err
+	for _, conf := range removingProfiles(ctx) {
+		if err := updateDB(conf, Delete, Movie, []string{tmdbId}, 0); err != nil {
+			return err
+		}
+		if err := updateDB(conf, Update, RemovedMovie, []string{tmdbId}, 0); err != nil {
+			return err
+		}
+	}
+	// Its files stay while another profile has it
+	if len(profilesWith(tmdbId, Movie)) > 0 {
+		libraryLog.Noticef("%s removed from the profile's library", movieName)
+		if ctx != nil {
+			clearPageCache(ctx)
+		}
+		return nil
 	}
 
-	if err := updateDB(Delete, Movie, []string{tmdbId}, 0); err != nil {
+	if err := os.RemoveAll(moviePath); err != nil {
 		return err
 	}
-	if err := updateDB(Update, RemovedMovie, []string{tmdbId}, 0); err != nil {
-		return err
+	if err := deleteLocation(Movie, tmdbId); err != nil {
+		libraryLog.Error(err)
 	}
 	libraryLog.Warningf("%s removed from library", movieName)
 
 	if ctx != nil {
-		if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", movieName)) {
-			xbmc.VideoLibraryClean()
+		if uiFor(ctx).Confirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", movieName)) {
+			libraryClean()
 		} else {
 			clearPageCache(ctx)
 		}
@@ -636,7 +700,7 @@ func removeMovie(ctx *gin.Context, tmdbId string) error {
 //
 // Shows internals
 //
-func syncShowsList(listId string, updating bool) (err error) {
+func syncShowsList(conf *config.Configuration, dialogs ui.UI, listId string, updating bool) (err error) {
 	if err := checkShowsPath(); err != nil {
 		return err
 	}
@@ -646,13 +710,13 @@ func syncShowsList(listId string, updating bool) (err error) {
 
 	switch listId {
 	case "watchlist":
-		shows, err = trakt.WatchlistShows()
+		shows, err = trakt.WatchlistShows(conf)
 		label = "LOCALIZE[30254]"
 	case "collection":
-		shows, err = trakt.CollectionShows()
+		shows, err = trakt.CollectionShows(conf)
 		label = "LOCALIZE[30257]"
 	default:
-		shows, err = trakt.ListItemsShows(listId, false)
+		shows, err = trakt.ListItemsShows(conf, listId, false)
 		label = "LOCALIZE[30263]"
 	}
 
@@ -671,7 +735,13 @@ func syncShowsList(listId string, updating bool) (err error) {
 
 		tmdbId := strconv.Itoa(show.Show.IDs.TMDB)
 
-		if updating && wasRemoved(tmdbId, RemovedShow) {
+		if updating && wasRemoved(conf, tmdbId, RemovedShow) {
+			continue
+		}
+
+		// Already written, and kept up to date, for another profile
+		if addedElsewhere(conf, tmdbId, Show) {
+			showIDs = append(showIDs, tmdbId)
 			continue
 		}
 
@@ -682,7 +752,8 @@ func syncShowsList(listId string, updating bool) (err error) {
 			}
 		}
 
-		if _, err := writeShowStrm(tmdbId, false); err != nil {
+		setSource(Show, tmdbId, listId)
+		if _, err := writeShowStrm(conf, tmdbId, false); err != nil {
 			libraryLog.Error(err)
 			continue
 		}
@@ -690,37 +761,59 @@ func syncShowsList(listId string, updating bool) (err error) {
 		showIDs = append(showIDs, tmdbId)
 	}
 
-	if err := updateDB(Batch, Show, showIDs, 0); err != nil {
+	if err := updateDB(conf, Batch, Show, showIDs, 0); err != nil {
 		return err
 	}
 
 	if !updating {
 		libraryLog.Noticef("Shows list (%s) added", listId)
-		if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", label)) {
-			xbmc.VideoLibraryScan()
+		if dialogs.Confirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", label)) {
+			libraryScan()
 		}
 	}
 	return nil
 }
 
-func writeShowStrm(showId string, adding bool) (*tmdb.Show, error) {
+func writeShowStrm(conf *config.Configuration, showId string, adding bool) (*tmdb.Show, error) {
+	return writeShowSeasonsStrm(conf, showId, adding, nil)
+}
+
+// writeShowSeasonsStrm only goes through the given seasons, or all of
+// them if there are none, leaving out the episodes removed from the
+// library of conf.
+func writeShowSeasonsStrm(conf *config.Configuration, showId string, adding bool, seasons []int) (*tmdb.Show, error) {
 	Id, _ := strconv.Atoi(showId)
 	show := tmdb.GetShow(Id, "en")
 	if show == nil {
 		return nil, errors.New(fmt.Sprintf("Unable to get show (%s)", showId))
 	}
-	showStrm := util.ToFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
-	showPath := filepath.Join(showsLibraryPath, showStrm)
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return show, err
+	}
+	location, err := showLocation(layout, show)
+	if err != nil {
+		return show, err
+	}
+	showPath := location.Folder
 
 	if _, err := os.Stat(showPath); os.IsNotExist(err) {
-		if err := os.Mkdir(showPath, 0755); err != nil {
+		if err := os.MkdirAll(showPath, 0755); err != nil {
 			libraryLog.Error(err)
 			return show, err
 		}
 	}
 
+	// Always rewritten as the show's status and artwork change over time
+	if err := writeShowNFO(show, filepath.Join(showPath, "tvshow.nfo")); err != nil {
+		libraryLog.Error(err)
+	}
+	if err := setLocation(Show, showId, location); err != nil {
+		libraryLog.Error(err)
+	}
+
 	now := time.Now().UTC()
-	addSpecials := config.Get().AddSpecials
+	rules := getShowRules(showId)
 
 	for _, season := range show.Seasons {
 		if season.EpisodeCount == 0 {
@@ -732,7 +825,10 @@ func writeShowStrm(showId string, adding bool) (*tmdb.Show, error) {
 				continue
 			}
 		}
-		if addSpecials == false && season.Season == 0 {
+		if !rules.monitors(show, season.Season) {
+			continue
+		}
+		if len(seasons) > 0 && !intInSlice(season.Season, seasons) {
 			continue
 		}
 
@@ -757,7 +853,7 @@ func writeShowStrm(showId string, adding bool) (*tmdb.Show, error) {
 				reAddIDs = append(reAddIDs, strconv.Itoa(episode.Id))
 			} else {
 				// Check if single episode was previously removed
-				if wasRemoved(strconv.Itoa(episode.Id), RemovedEpisode) {
+				if wasRemoved(conf, strconv.Itoa(episode.Id), RemovedEpisode) {
 					continue
 				}
 			}
@@ -766,19 +862,34 @@ func writeShowStrm(showId string, adding bool) (*tmdb.Show, error) {
 				continue
 			}
 
-			episodeStrmPath := filepath.Join(showPath, fmt.Sprintf("%s S%02dE%02d.strm", showStrm, season.Season, episode.EpisodeNumber))
-			playLink := UrlForXBMC("/library/show/play/%d/%d/%d", Id, season.Season, episode.EpisodeNumber)
+			episodeFile, err := layout.episodeFile(show, season.Season, episode.EpisodeNumber, episode.Name)
+			if err != nil {
+				return show, err
+			}
+			episodeStrmPath := filepath.Join(showPath, episodeFile)
+			playLink := episodeLibraryURL(showId, season.Season, episode.EpisodeNumber)
 			if _, err := os.Stat(episodeStrmPath); err == nil {
 				libraryLog.Warningf("%s already exists, skipping", episodeStrmPath)
 				continue
 			}
+			if err := os.MkdirAll(filepath.Dir(episodeStrmPath), 0755); err != nil {
+				libraryLog.Error(err)
+				return show, err
+			}
 			if err := ioutil.WriteFile(episodeStrmPath, []byte(playLink), 0644); err != nil {
 				libraryLog.Error(err)
 				return show, err
 			}
+			episodeNFOPath := strings.TrimSuffix(episodeStrmPath, ".strm") + ".nfo"
+			if err := writeEpisodeNFO(show, episode, episodeNFOPath); err != nil {
+				libraryLog.Error(err)
+			}
+			if !adding && newlyAired(episode.AirDate) {
+				queueGrab(Id, season.Season, episode.EpisodeNumber, episode.Id)
+			}
 		}
 		if len(reAddIDs) > 0 {
-			if err := updateDB(BatchDelete, RemovedEpisode, reAddIDs, Id); err != nil {
+			if err := updateDB(conf, BatchDelete, RemovedEpisode, reAddIDs, Id); err != nil {
 				libraryLog.Error(err)
 			}
 		}
@@ -798,29 +909,49 @@ func removeShow(ctx *gin.Context, tmdbId string) error {
 		return errors.New("Unable to find show to remove")
 	}
 
-	showStrm := util.ToFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
-	showPath := filepath.Join(showsLibraryPath, showStrm)
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return err
+	}
+	location, err := showLocation(layout, show)
+	if err != nil {
+		return err
+	}
+	showPath := location.Folder
 
 	if _, err := os.Stat(showPath); err != nil {
 		libraryLog.Warning(err)
 		return errors.New("LOCALIZE[30282]")
 	}
-	if err := os.RemoveAll(showPath); err != nil {
-		libraryLog.Error(err)
-		return err
+	for _, conf := range removingProfiles(ctx) {
+		if err := updateDB(conf, Delete, Show, []string{tmdbId}, 0); err != nil {
+			return err
+		}
+		if err := updateDB(conf, Update, RemovedShow, []string{tmdbId}, 0); err != nil {
+			return err
+		}
+	}
+	// Its files stay while another profile has it
+	if len(profilesWith(tmdbId, Show)) > 0 {
+		libraryLog.Noticef("%s removed from the profile's library", show.Name)
+		if ctx != nil {
+			clearPageCache(ctx)
+		}
+		return nil
 	}
 
-	if err := updateDB(Delete, Show, []string{tmdbId}, 0); err != nil {
+	if err := os.RemoveAll(showPath); err != nil {
+		libraryLog.Error(err)
 		return err
 	}
-	if err := updateDB(Update, RemovedShow, []string{tmdbId}, 0); err != nil {
-		return err
+	if err := deleteLocation(Show, tmdbId); err != nil {
+		libraryLog.Error(err)
 	}
 	libraryLog.Warningf("%s removed from library", show.Name)
 
 	if ctx != nil {
-		if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", show.Name)) {
-			xbmc.VideoLibraryClean()
+		if uiFor(ctx).Confirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", show.Name)) {
+			libraryClean()
 		} else {
 			clearPageCache(ctx)
 		}
@@ -840,9 +971,23 @@ func removeEpisode(tmdbId string, showId string, scraperId string, seasonNumber
 		return errors.New("Unable to find show to remove episode")
 	}
 
-	showPath := util.ToFileName(fmt.Sprintf("%s (%s)", show.Name, strings.Split(show.FirstAirDate, "-")[0]))
-	episodeStrm := fmt.Sprintf("%s S%02dE%02d.strm", showPath, seasonNumber, episodeNumber)
-	episodePath := filepath.Join(showsLibraryPath, showPath, episodeStrm)
+	layout, err := loadLibraryLayout()
+	if err != nil {
+		return err
+	}
+	location, err := showLocation(layout, show)
+	if err != nil {
+		return err
+	}
+	episodeTitle := ""
+	if episode := tmdb.GetEpisode(Id, seasonNumber, episodeNumber, "en"); episode != nil {
+		episodeTitle = episode.Name
+	}
+	episodeStrm, err := layout.episodeFile(show, seasonNumber, episodeNumber, episodeTitle)
+	if err != nil {
+		return err
+	}
+	episodePath := filepath.Join(location.Folder, episodeStrm)
 
 	alreadyRemoved := false
 	if _, err := os.Stat(episodePath); err != nil {
@@ -852,6 +997,7 @@ func removeEpisode(tmdbId string, showId string, scraperId string, seasonNumber
 		if err := os.Remove(episodePath); err != nil {
 			return err
 		}
+		os.Remove(strings.TrimSuffix(episodePath, ".strm") + ".nfo")
 	}
 
 	removedEpisodes <- &removedEpisode{
@@ -880,33 +1026,41 @@ func AddMovie(ctx *gin.Context) {
 		ctx.String(200, err.Error())
 		return
 	}
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
 
-	if movie, err := isDuplicateMovie(tmdbId); err != nil {
-		libraryLog.Warningf(err.Error())
-		xbmc.Notify("Quasar", fmt.Sprintf("LOCALIZE[30287];;%s", movie.Title), config.AddonIcon())
-		return
-	}
-
 	var err error
 	var movie *tmdb.Movie
-	if movie, err = writeMovieStrm(tmdbId); err != nil {
-		ctx.String(200, err.Error())
-		return
+	if addedElsewhere(conf, tmdbId, Movie) {
+		// Written for another profile already
+		if movie = tmdb.GetMovieById(tmdbId, "en"); movie == nil {
+			ctx.String(200, fmt.Sprintf("Unable to get movie (%s)", tmdbId))
+			return
+		}
+	} else {
+		if movie, err := isDuplicateMovie(tmdbId); err != nil {
+			libraryLog.Warningf(err.Error())
+			uiFor(ctx).Notify("Quasar", fmt.Sprintf("LOCALIZE[30287];;%s", movie.Title))
+			return
+		}
+		if movie, err = writeMovieStrm(tmdbId); err != nil {
+			ctx.String(200, err.Error())
+			return
+		}
 	}
 
-	if err := updateDB(Update, Movie, []string{tmdbId}, 0); err != nil {
+	if err := updateDB(conf, Update, Movie, []string{tmdbId}, 0); err != nil {
 		ctx.String(200, err.Error())
 		return
 	}
-	if err := updateDB(Delete, RemovedMovie, []string{tmdbId}, 0); err != nil {
+	if err := updateDB(conf, Delete, RemovedMovie, []string{tmdbId}, 0); err != nil {
 		ctx.String(200, err.Error())
 		return
 	}
 
 	libraryLog.Noticef("%s added to library", movie.Title)
-	if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", movie.Title)) {
-		xbmc.VideoLibraryScan()
+	if uiFor(ctx).Confirm("Quasar", fmt.Sprintf("LOCALIZE[30277];;%s", movie.Title)) {
+		libraryScan()
 	} else {
 		clearPageCache(ctx)
 	}
@@ -921,7 +1075,7 @@ func AddMoviesList(ctx *gin.Context) {
 		updating = true
 	}
 
-	syncMoviesList(listId, updating)
+	syncMoviesList(profileFor(ctx), uiFor(ctx), listId, updating)
 }
 
 func RemoveMovie(ctx *gin.Context) {
@@ -939,15 +1093,17 @@ func AddShow(ctx *gin.Context) {
 		ctx.String(200, err.Error())
 		return
 	}
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
 	merge := ctx.DefaultQuery("merge", "false")
 
 	label := "LOCALIZE[30277]"
 	logMsg := "%s (%s) added to library"
-	if merge == "false" {
+	// Shows written for another profile already are only merged
+	if merge == "false" && !addedElsewhere(conf, tmdbId, Show) {
 		if show, err := isDuplicateShow(tmdbId); err != nil {
 			libraryLog.Warning(err)
-			xbmc.Notify("Quasar", fmt.Sprintf("LOCALIZE[30287];;%s", show.Name), config.AddonIcon())
+			uiFor(ctx).Notify("Quasar", fmt.Sprintf("LOCALIZE[30287];;%s", show.Name))
 			return
 		}
 	} else {
@@ -957,24 +1113,24 @@ func AddShow(ctx *gin.Context) {
 
 	var err error
 	var show *tmdb.Show
-	if show, err = writeShowStrm(tmdbId, true); err != nil {
+	if show, err = writeShowStrm(conf, tmdbId, true); err != nil {
 		libraryLog.Error(err)
 		ctx.String(200, err.Error())
 		return
 	}
 
-	if err := updateDB(Update, Show, []string{tmdbId}, 0); err != nil {
+	if err := updateDB(conf, Update, Show, []string{tmdbId}, 0); err != nil {
 		ctx.String(200, err.Error())
 		return
 	}
-	if err := updateDB(Delete, RemovedShow, []string{tmdbId}, 0); err != nil {
+	if err := updateDB(conf, Delete, RemovedShow, []string{tmdbId}, 0); err != nil {
 		ctx.String(200, err.Error())
 		return
 	}
 
 	libraryLog.Noticef(logMsg, show.Name, tmdbId)
-	if xbmc.DialogConfirm("Quasar", fmt.Sprintf("%s;;%s", label, show.Name)) {
-		xbmc.VideoLibraryScan()
+	if uiFor(ctx).Confirm("Quasar", fmt.Sprintf("%s;;%s", label, show.Name)) {
+		libraryScan()
 	} else {
 		clearPageCache(ctx)
 	}
@@ -989,7 +1145,7 @@ func AddShowsList(ctx *gin.Context) {
 		updating = true
 	}
 
-	syncShowsList(listId, updating)
+	syncShowsList(profileFor(ctx), uiFor(ctx), listId, updating)
 }
 
 func RemoveShow(ctx *gin.Context) {
@@ -1004,11 +1160,11 @@ func RemoveShow(ctx *gin.Context) {
 //
 func LibraryUpdate(db *bolt.DB) {
 	if err := checkMoviesPath(); err != nil {
-		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+		ui.Get().Notify("Quasar", err.Error())
 		return
 	}
 	if err := checkShowsPath(); err != nil {
-		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+		ui.Get().Notify("Quasar", err.Error())
 		return
 	}
 
@@ -1016,7 +1172,7 @@ func LibraryUpdate(db *bolt.DB) {
 		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
 		if err != nil {
 			libraryLog.Error(err)
-			xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+			ui.Get().Notify("Quasar", err.Error())
 			return err
 		}
 		return nil
@@ -1033,14 +1189,14 @@ func LibraryUpdate(db *bolt.DB) {
 		oldFile := filepath.Join(libraryPath, "QuasarDB.json")
 		file, err := ioutil.ReadFile(oldFile)
 		if err != nil {
-			xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+			ui.Get().Notify("Quasar", err.Error())
 		} else {
 			if err := json.Unmarshal(file, &oldDB); err != nil {
-				xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
-			} else if err := updateDB(Batch, Movie, oldDB.Movies, 0); err != nil {
-				xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
-			} else if err := updateDB(Batch, Show, oldDB.Shows, 0); err != nil {
-				xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+				ui.Get().Notify("Quasar", err.Error())
+			} else if err := updateDB(config.Get(), Batch, Movie, oldDB.Movies, 0); err != nil {
+				ui.Get().Notify("Quasar", err.Error())
+			} else if err := updateDB(config.Get(), Batch, Show, oldDB.Shows, 0); err != nil {
+				ui.Get().Notify("Quasar", err.Error())
 			} else {
 				os.Remove(oldFile)
 				libraryLog.Notice("Successfully imported and removed QuasarDB.json")
@@ -1062,7 +1218,7 @@ func LibraryUpdate(db *bolt.DB) {
 		go func() {
 			time.Sleep(30 * time.Second)
 			if tmdb.WarmingUp == true {
-				xbmc.Notify("Quasar", "LOCALIZE[30147]", config.AddonIcon())
+				ui.Get().Notify("Quasar", "LOCALIZE[30147]")
 			}
 		}()
 		started := time.Now()
@@ -1078,7 +1234,7 @@ func LibraryUpdate(db *bolt.DB) {
 		tmdb.WarmingUp = false
 		took := time.Since(started)
 		if took.Seconds() > 30 {
-			xbmc.Notify("Quasar", "LOCALIZE[30148]", config.AddonIcon())
+			ui.Get().Notify("Quasar", "LOCALIZE[30148]")
 		}
 		libraryLog.Noticef("Caches warmed up in %s", took)
 	}()
@@ -1129,27 +1285,32 @@ func LibraryUpdate(db *bolt.DB) {
 						for _, showEpisode := range showEpisodes {
 							tmdbIDs = append(tmdbIDs, showEpisode.ID)
 						}
+						// Gone from everyone's library, as its files are
 						Id, _ := strconv.Atoi(showEpisodes[0].ShowID)
-						if err := updateDB(Batch, RemovedEpisode, tmdbIDs, Id); err != nil {
-							libraryLog.Error(err)
+						for _, conf := range allProfiles() {
+							if err := updateDB(conf, Batch, RemovedEpisode, tmdbIDs, Id); err != nil {
+								libraryLog.Error(err)
+							}
 						}
 					}
 					if len(labels) > 0 {
 						label = strings.Join(labels, ", ")
-						if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", label)) {
-							xbmc.VideoLibraryClean()
+						if ui.Get().Confirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", label)) {
+							libraryClean()
 						}
 					}
 				} else {
 					for showName, episode := range shows {
 						label = fmt.Sprintf("%s S%02dE%02d", showName, episode[0].Season, episode[0].Episode)
 						Id, _ := strconv.Atoi(episode[0].ShowID)
-						if err := updateDB(Update, RemovedEpisode, []string{episode[0].ID}, Id); err != nil {
-							libraryLog.Error(err)
+						for _, conf := range allProfiles() {
+							if err := updateDB(conf, Update, RemovedEpisode, []string{episode[0].ID}, Id); err != nil {
+								libraryLog.Error(err)
+							}
 						}
 					}
-					if xbmc.DialogConfirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", label)) {
-						xbmc.VideoLibraryClean()
+					if ui.Get().Confirm("Quasar", fmt.Sprintf("LOCALIZE[30278];;%s", label)) {
+						libraryClean()
 					}
 				}
 
@@ -1181,7 +1342,7 @@ func LibraryUpdate(db *bolt.DB) {
 				}
 				if config.Get().UpdateAutoScan && scanning == false {
 					scanning = true
-					xbmc.VideoLibraryScan()
+					libraryScan()
 				}
 			}
 		}()
@@ -1206,6 +1367,10 @@ func LibraryUpdate(db *bolt.DB) {
 	markedForRemovalTicker := time.NewTicker(30 * time.Second)
 	defer markedForRemovalTicker.Stop()
 
+	// Checked hourly so restarts don't postpone backups forever
+	backupTicker := time.NewTicker(1 * time.Hour)
+	defer backupTicker.Stop()
+
 	for {
 		select {
 		case <- updateTicker.C:
@@ -1215,17 +1380,27 @@ func LibraryUpdate(db *bolt.DB) {
 				}
 				if config.Get().UpdateAutoScan && scanning == false && updateFrequency != traktFrequency {
 					scanning = true
-					xbmc.VideoLibraryScan()
+					libraryScan()
 				}
 			}
 		case <- traktSyncTicker.C:
 			if config.Get().TraktSyncFrequency > 0 {
-				if err := doSyncTrakt(); err != nil {
-					libraryLog.Warning(err)
+				for _, conf := range allProfiles() {
+					if conf.TraktToken == "" {
+						continue
+					}
+					if err := doSyncTrakt(conf); err != nil {
+						libraryLog.Warning(err)
+					}
+					if conf.TraktSyncWatched {
+						if _, err := watchedSync(conf, false); err != nil {
+							libraryLog.Warning(err)
+						}
+					}
 				}
 				if config.Get().UpdateAutoScan && scanning == false {
 					scanning = true
-					xbmc.VideoLibraryScan()
+					libraryScan()
 				}
 			}
 		case <- markedForRemovalTicker.C:
@@ -1256,12 +1431,18 @@ func LibraryUpdate(db *bolt.DB) {
 							}
 						}
 					}
-					updateDB(DeleteTorrent, 0, []string{string(k)}, 0)
+					updateDB(config.Get(), DeleteTorrent, 0, []string{string(k)}, 0)
 					libraryLog.Infof("Removed %s from database", k)
 					return nil
 				})
 				return nil
 			})
+		case <-backupTicker.C:
+			if backupDue() {
+				if err := backupLibrary(); err != nil {
+					libraryLog.Error(err)
+				}
+			}
 		case <- closing:
 			close(removedEpisodes)
 			return
@@ -1273,8 +1454,8 @@ func UpdateLibrary(ctx *gin.Context) {
 	if err := doUpdateLibrary(); err != nil {
 		ctx.String(200, err.Error())
 	}
-	if xbmc.DialogConfirm("Quasar", "LOCALIZE[30288]") {
-		xbmc.VideoLibraryScan()
+	if uiFor(ctx).Confirm("Quasar", "LOCALIZE[30288]") {
+		libraryScan()
 	}
 }
 
@@ -1365,295 +1546,287 @@ func FindEpisodeInLibrary(show *tmdb.Show, episode *tmdb.Episode) *xbmc.VideoLib
 //
 // Kodi notifications
 //
+
+// Notification takes the notifications forwarded by the add-on, of use only
+// while Kodi's JSON-RPC connection is down.
 func Notification(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if xbmc.ListeningNotifications() {
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(ctx.Query("data"))
+		if err != nil {
+			libraryLog.Error(err)
+			return
+		}
+		xbmc.PublishNotification(&xbmc.Notification{
+			Sender: ctx.Query("sender"),
+			Method: ctx.Query("method"),
+			Data:   data,
+		})
+	}
+}
 
-	sender := ctx.Query("sender")
-	method := ctx.Query("method")
-	data := ctx.Query("data")
+// NotificationListener acts on Kodi's player and library events.
+func NotificationListener(btService *bittorrent.BTService) {
+	events, done := xbmc.KodiEvents.Listen()
+	defer close(done)
+	for event := range events {
+		switch event := event.(type) {
+		case *xbmc.PlayerEvent:
+			go onPlayerEvent(btService, event)
+		case *xbmc.LibraryEvent:
+			go onLibraryEvent(btService, event)
+		}
+	}
+}
 
-	// jsData, _ := base64.StdEncoding.DecodeString(data)
-	// libraryLog.Debugf("Notification: sender=%s method=%s data=%s", sender, method, jsData)
+func onPlayerEvent(btService *bittorrent.BTService, event *xbmc.PlayerEvent) {
+	state := btService.PlayerState()
+	if state == nil {
+		return
+	}
 
-	if sender == "xbmc" {
-		switch method {
-		case "Player.OnSeek":
-			if bittorrent.VideoDuration > 0 {
-				bittorrent.Seeked = true
-			}
+	switch event.Method {
+	case xbmc.PlayerOnPause:
+		if !state.Stopped() {
+			state.SetPaused(true)
+		}
 
-		case "Player.OnPause":
-			if bittorrent.VideoDuration == 0 {
-				return
-			}
-			if !bittorrent.Paused {
-				bittorrent.Paused = true
-			}
+	case xbmc.PlayerOnResume:
+		state.SetPaused(false)
 
-		case "Player.OnPlay":
-			time.Sleep(1 * time.Second) // Let player get its WatchedTime and VideoDuration
-			if bittorrent.VideoDuration == 0 {
-				return
-			}
-			if bittorrent.Paused { // Prevent seeking when simply unpausing
-				bittorrent.Paused = false
-				return
-			}
-			if !bittorrent.FromLibrary {
-				return
-			}
-			libraryResume := config.Get().LibraryResume
-			if libraryResume == 0 {
-				return
-			}
-			var started struct {
-				Item  struct {
-					ID   int    `json:"id"`
-					Type string `json:"type"`
-				} `json:"item"`
-			}
-			jsonData, _ := base64.StdEncoding.DecodeString(data)
-			if err := json.Unmarshal(jsonData, &started); err != nil {
-				libraryLog.Error(err)
-				return
-			}
-			var position float64
-			if started.Item.Type == "movie" {
-				var movie *xbmc.VideoLibraryMovieItem
-				if libraryMovies == nil {
-					return
-				}
-				for _, libraryMovie := range libraryMovies.Movies {
-					if libraryMovie.ID == started.Item.ID {
-						movie = libraryMovie
-						break
-					}
-				}
-				if movie == nil || movie.ID == 0 {
-					libraryLog.Warningf("No movie found with ID: %d", started.Item.ID)
-					return
-				}
-				if libraryResume == 2 && ExistingTorrent(btService, movie.Title) == "" {
-					return
-				}
-				position = movie.Resume.Position
-			} else {
-				if libraryEpisodes == nil {
-					return
-				}
-				var episode *xbmc.VideoLibraryEpisodeItem
-				for _, episodes := range libraryEpisodes {
-					for _, existingEpisode := range episodes.Episodes {
-						if existingEpisode.ID == started.Item.ID {
-							episode = existingEpisode
-							break
-						}
-					}
-					if episode != nil {
-						break
-					}
-				}
-				if episode == nil || episode.ID == 0 {
-					libraryLog.Warningf("No episode found with ID: %d", started.Item.ID)
-					return
-				}
-				if libraryShows == nil {
-					return
-				}
-				showTitle := ""
-				for _, show := range libraryShows.Shows {
-					if show.ScraperID == strconv.Itoa(episode.TVShowID) {
-						showTitle = show.Title
-					}
-				}
-				longName := fmt.Sprintf("%s S%02dE%02d", showTitle, episode.Season, episode.Episode)
-				if libraryResume == 2 && ExistingTorrent(btService, longName) == "" {
-					return
-				}
-				position = episode.Resume.Position
-			}
-			xbmc.PlayerSeek(position)
+	case xbmc.PlayerOnPlay:
+		// Kodi before 18 also sends it when unpausing, and has no OnAVStart
+		if state.SetPaused(false) {
+			return
+		}
+		item := event.Item
+		time.AfterFunc(1*time.Second, func() {
+			resumePlayback(btService, state, item)
+		})
 
-		case "Player.OnStop":
-			if bittorrent.VideoDuration <= 1 {
-				return
-			}
-			var stopped struct {
-				Ended bool `json:"end"`
-				Item  struct {
-					ID   int    `json:"id"`
-					Type string `json:"type"`
-				} `json:"item"`
-			}
-			jsonData, _ := base64.StdEncoding.DecodeString(data)
-			if err := json.Unmarshal(jsonData, &stopped); err != nil {
-				libraryLog.Error(err)
-				return
-			}
+	case xbmc.PlayerOnAVStart:
+		resumePlayback(btService, state, event.Item)
 
-			progress := bittorrent.WatchedTime / bittorrent.VideoDuration * 100
+	case xbmc.PlayerOnStop:
+		if event.Item.ID == 0 || !state.TakeStop() {
+			return
+		}
+		watchedTime, videoDuration := state.Times()
+		if videoDuration <= 1 {
+			return
+		}
 
-			libraryLog.Infof("Stopped at %f%%", progress)
+		progress := watchedTime / videoDuration * 100
 
-			if stopped.Ended || progress > 90 {
-				if stopped.Item.Type == "movie" {
-					xbmc.SetMovieWatched(stopped.Item.ID, 1, 0, 0)
-				} else {
-					xbmc.SetEpisodeWatched(stopped.Item.ID, 1, 0, 0)
-				}
-			} else if bittorrent.WatchedTime > 180 {
-				if stopped.Item.Type == "movie" {
-					xbmc.SetMovieWatched(stopped.Item.ID, 0, int(bittorrent.WatchedTime), int(bittorrent.VideoDuration))
-				} else {
-					xbmc.SetEpisodeWatched(stopped.Item.ID, 0, int(bittorrent.WatchedTime), int(bittorrent.VideoDuration))
-				}
+		libraryLog.Infof("Stopped at %f%%", progress)
+
+		if event.Ended || progress > 90 {
+			if event.Item.Type == "movie" {
+				xbmc.SetMovieWatched(event.Item.ID, 1, 0, 0)
+			} else {
+				xbmc.SetEpisodeWatched(event.Item.ID, 1, 0, 0)
+			}
+		} else if watchedTime > 180 {
+			if event.Item.Type == "movie" {
+				xbmc.SetMovieWatched(event.Item.ID, 0, int(watchedTime), int(videoDuration))
 			} else {
-				time.Sleep(200 * time.Millisecond)
-				xbmc.Refresh()
+				xbmc.SetEpisodeWatched(event.Item.ID, 0, int(watchedTime), int(videoDuration))
 			}
+		} else {
+			time.Sleep(200 * time.Millisecond)
+			xbmc.Refresh()
+		}
+	}
+}
 
-		case "VideoLibrary.OnUpdate":
-			time.Sleep(200 * time.Millisecond) // Because Kodi...
-			if !bittorrent.WasPlaying {
-				return
+// resumePlayback seeks to where library items were left, once per player.
+func resumePlayback(btService *bittorrent.BTService, state *bittorrent.PlayerState, item xbmc.NotificationItem) {
+	if !state.FromLibrary() || item.ID == 0 {
+		return
+	}
+	libraryResume := config.Get().LibraryResume
+	if libraryResume == 0 || !state.TakeResume() {
+		return
+	}
+	var position float64
+	if item.Type == "movie" {
+		var movie *xbmc.VideoLibraryMovieItem
+		if libraryMovies == nil {
+			return
+		}
+		for _, libraryMovie := range libraryMovies.Movies {
+			if libraryMovie.ID == item.ID {
+				movie = libraryMovie
+				break
 			}
-			var item struct {
-				ID   int    `json:"id"`
-				Type string `json:"type"`
+		}
+		if movie == nil || movie.ID == 0 {
+			libraryLog.Warningf("No movie found with ID: %d", item.ID)
+			return
+		}
+		if libraryResume == 2 && ExistingTorrent(btService, movie.Title) == "" {
+			return
+		}
+		position = movie.Resume.Position
+	} else {
+		if libraryEpisodes == nil {
+			return
+		}
+		var episode *xbmc.VideoLibraryEpisodeItem
+		for _, episodes := range libraryEpisodes {
+			for _, existingEpisode := range episodes.Episodes {
+				if existingEpisode.ID == item.ID {
+					episode = existingEpisode
+					break
+				}
 			}
-			jsonData, _ := base64.StdEncoding.DecodeString(data)
-			if err := json.Unmarshal(jsonData, &item); err != nil {
-				libraryLog.Error(err)
-				return
+			if episode != nil {
+				break
 			}
-			if item.ID != 0 {
-				bittorrent.WasPlaying = false
-				if item.Type == "movie" {
-					updateLibraryMovies()
-				} else {
-					updateLibraryShows()
-				}
-				xbmc.Refresh()
+		}
+		if episode == nil || episode.ID == 0 {
+			libraryLog.Warningf("No episode found with ID: %d", item.ID)
+			return
+		}
+		if libraryShows == nil {
+			return
+		}
+		showTitle := ""
+		for _, show := range libraryShows.Shows {
+			if show.ScraperID == strconv.Itoa(episode.TVShowID) {
+				showTitle = show.Title
 			}
+		}
+		longName := fmt.Sprintf("%s S%02dE%02d", showTitle, episode.Season, episode.Episode)
+		if libraryResume == 2 && ExistingTorrent(btService, longName) == "" {
+			return
+		}
+		position = episode.Resume.Position
+	}
+	xbmc.PlayerSeek(position)
+}
 
-		case "VideoLibrary.OnRemove":
-			jsonData, err := base64.StdEncoding.DecodeString(data)
-			if err != nil {
-				libraryLog.Error(err)
-				return
-			}
+func onLibraryEvent(btService *bittorrent.BTService, event *xbmc.LibraryEvent) {
+	switch event.Method {
+	case xbmc.LibraryOnUpdate:
+		if event.Item.ID == 0 {
+			return
+		}
+		time.Sleep(200 * time.Millisecond) // Because Kodi...
+		if state := btService.PlayerState(); state == nil || !state.TakeLibraryUpdate() {
+			return
+		}
+		if event.Item.Type == "movie" {
+			updateLibraryMovies()
+		} else {
+			updateLibraryShows()
+		}
+		xbmc.Refresh()
 
-			var item struct {
-				ID   int    `json:"id"`
-				Type string `json:"type"`
+	case xbmc.LibraryOnRemove:
+		switch event.Item.Type {
+		case "episode":
+			var episode *xbmc.VideoLibraryEpisodeItem
+			if libraryEpisodes == nil {
+				break
 			}
-			if err := json.Unmarshal(jsonData, &item); err != nil {
-				libraryLog.Error(err)
+			for _, episodes := range libraryEpisodes {
+				for _, existingEpisode := range episodes.Episodes {
+					if existingEpisode.ID == event.Item.ID {
+						episode = existingEpisode
+						break
+					}
+				}
+			}
+			if episode == nil || episode.ID == 0 {
+				libraryLog.Warningf("No episode found with ID: %d", event.Item.ID)
 				return
 			}
 
-			switch item.Type {
-			case "episode":
-				var episode *xbmc.VideoLibraryEpisodeItem
-				if libraryEpisodes == nil {
+			var scraperId string
+			if libraryShows == nil {
+				break
+			}
+			for _, tvshow := range libraryShows.Shows {
+				if tvshow.ID == episode.TVShowID {
+					scraperId = tvshow.ScraperID
 					break
 				}
-				for _, episodes := range libraryEpisodes {
-					for _, existingEpisode := range episodes.Episodes {
-						if existingEpisode.ID == item.ID {
-							episode = existingEpisode
-							break
-						}
-					}
-				}
-				if episode == nil || episode.ID == 0 {
-					libraryLog.Warningf("No episode found with ID: %d", item.ID)
-					return
-				}
+			}
 
-				var scraperId string
-				if libraryShows == nil {
-					break
-				}
-				for _, tvshow := range libraryShows.Shows {
-					if tvshow.ID == episode.TVShowID {
-						scraperId = tvshow.ScraperID
-						break
+			if scraperId != "" && episode.UniqueIDs.ID != "" {
+				var tmdbId string
+				var showId string
+
+				switch config.Get().TvScraper {
+				case TMDBScraper:
+					tmdbId = episode.UniqueIDs.ID
+					showId = scraperId
+				case TVDBScraper:
+					traktShow := trakt.GetShowByTVDB(scraperId)
+					if traktShow == nil {
+						libraryLog.Warning("No matching TVDB show to remove (%s)", scraperId)
+						return
 					}
-				}
-
-				if scraperId != "" && episode.UniqueIDs.ID != "" {
-					var tmdbId string
-					var showId string
-
-					switch config.Get().TvScraper {
-					case TMDBScraper:
-						tmdbId = episode.UniqueIDs.ID
-						showId = scraperId
-					case TVDBScraper:
-						traktShow := trakt.GetShowByTVDB(scraperId)
-						if traktShow == nil {
-							libraryLog.Warning("No matching TVDB show to remove (%s)", scraperId)
-							return
-						}
-						showId = strconv.Itoa(traktShow.IDs.TVDB)
-						TVDBEpisode := trakt.GetEpisodeByTVDB(episode.UniqueIDs.ID)
-						if TVDBEpisode == nil {
-							libraryLog.Warning("No matching TVDB episode to remove (%s)", scraperId)
-							return
-						}
-						tmdbId = strconv.Itoa(TVDBEpisode.IDs.TMDB)
-					case TraktScraper:
-						traktShow := trakt.GetShow(scraperId)
-						if traktShow == nil {
-							libraryLog.Warning("No matching show to remove (%s)", scraperId)
-							return
-						}
-						showId = strconv.Itoa(traktShow.IDs.Trakt)
-						traktEpisode := trakt.GetEpisode(episode.UniqueIDs.ID)
-						if traktEpisode == nil {
-							libraryLog.Warning("No matching episode to remove (%s)", scraperId)
-							return
-						}
-						libraryLog.Warning("No matching episode to remove (%s)", episode.UniqueIDs.ID)
+					showId = strconv.Itoa(traktShow.IDs.TVDB)
+					TVDBEpisode := trakt.GetEpisodeByTVDB(episode.UniqueIDs.ID)
+					if TVDBEpisode == nil {
+						libraryLog.Warning("No matching TVDB episode to remove (%s)", scraperId)
 						return
 					}
-
-					if err := removeEpisode(tmdbId, showId, scraperId, episode.Season, episode.Episode); err != nil {
-						libraryLog.Warning(err)
+					tmdbId = strconv.Itoa(TVDBEpisode.IDs.TMDB)
+				case TraktScraper:
+					traktShow := trakt.GetShow(scraperId)
+					if traktShow == nil {
+						libraryLog.Warning("No matching show to remove (%s)", scraperId)
+						return
 					}
-				} else {
-					libraryLog.Warning("Missing episodeid or tvshowid, nothing to remove")
+					showId = strconv.Itoa(traktShow.IDs.Trakt)
+					traktEpisode := trakt.GetEpisode(episode.UniqueIDs.ID)
+					if traktEpisode == nil {
+						libraryLog.Warning("No matching episode to remove (%s)", scraperId)
+						return
+					}
+					libraryLog.Warning("No matching episode to remove (%s)", episode.UniqueIDs.ID)
+					return
 				}
-			case "movie":
-				if libraryMovies == nil {
-					break
+
+				if err := removeEpisode(tmdbId, showId, scraperId, episode.Season, episode.Episode); err != nil {
+					libraryLog.Warning(err)
 				}
-				for _, movie := range libraryMovies.Movies {
-					if movie.ID == item.ID {
-						tmdbMovie := tmdb.GetMovieById(movie.IMDBNumber, "en")
-						if tmdbMovie == nil || tmdbMovie.Id == 0 {
-							break
-						}
-						if err := removeMovie(nil, strconv.Itoa(tmdbMovie.Id)); err != nil {
-							libraryLog.Warning("Nothing left to remove from Quasar")
-						}
+			} else {
+				libraryLog.Warning("Missing episodeid or tvshowid, nothing to remove")
+			}
+		case "movie":
+			if libraryMovies == nil {
+				break
+			}
+			for _, movie := range libraryMovies.Movies {
+				if movie.ID == event.Item.ID {
+					tmdbMovie := tmdb.GetMovieById(movie.IMDBNumber, "en")
+					if tmdbMovie == nil || tmdbMovie.Id == 0 {
 						break
 					}
+					if err := removeMovie(nil, strconv.Itoa(tmdbMovie.Id)); err != nil {
+						libraryLog.Warning("Nothing left to remove from Quasar")
+					}
+					break
 				}
 			}
+		}
 
-		case "VideoLibrary.OnScanFinished":
-			scanning = false
-			fallthrough
+	case xbmc.LibraryOnScanFinished:
+		scanning = false
+		fallthrough
 
-		case "VideoLibrary.OnCleanFinished":
-			updateLibraryMovies()
-			updateLibraryShows()
-			clearPageCache(ctx)
-		}
+	case xbmc.LibraryOnCleanFinished:
+		updateLibraryMovies()
+		updateLibraryShows()
+		clearPageCache(nil)
 	}
-}}
+}
 
 func PlayMovie(btService *bittorrent.BTService) gin.HandlerFunc {
 	if config.Get().ChooseStreamAuto == true {
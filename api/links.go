@@ -0,0 +1,385 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/dustin/go-humanize"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/providers"
+	"github.com/scakemyer/quasar/release"
+)
+
+const (
+	linksBucket     = "Links"
+	historyBucket   = "SearchHistory"
+	deadLinksBucket = "DeadLinks"
+)
+
+const (
+	// How long a link that stalled stays demoted
+	deadLinksExpiration = 30 * 24 * time.Hour
+	deadLinkReason      = "stalled before"
+)
+
+const (
+	linksMovie = iota
+	linksSeason
+	linksEpisode
+	linksQuery
+	linksChosen
+)
+
+var (
+	linksLog = logging.MustGetLogger("links")
+
+	// How long provider results stay fresh, per type of media
+	linksExpiration = map[int]time.Duration{
+		linksMovie:   72 * time.Hour,
+		linksSeason:  24 * time.Hour,
+		linksEpisode: 24 * time.Hour,
+		linksQuery:   12 * time.Hour,
+		linksChosen:  30 * 24 * time.Hour,
+	}
+)
+
+type deadLinkItem struct {
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+type linksCacheItem struct {
+	Torrents []*bittorrent.Torrent `json:"torrents"`
+	Created  time.Time             `json:"created"`
+}
+
+func linksKey(linksType int, id string) []byte {
+	return []byte(fmt.Sprintf("%d_%s", linksType, id))
+}
+
+// InitDB sets up the buckets used outside of the library and prunes
+// expired links, so they survive restarts without growing forever.
+func InitDB(db *bolt.DB) {
+	DB = db
+	err := DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{linksBucket, historyBucket, deadLinksBucket, showUpdatesBucket, showRulesBucket, locationsBucket, importedListsBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		c := tx.Bucket([]byte(linksBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item *linksCacheItem
+			var linksType int
+			fmt.Sscanf(string(k), "%d_", &linksType)
+			if err := json.Unmarshal(v, &item); err != nil || time.Since(item.Created) > linksExpiration[linksType] {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+
+		c = tx.Bucket([]byte(deadLinksBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item *deadLinkItem
+			if err := json.Unmarshal(v, &item); err != nil || time.Since(item.Created) > deadLinksExpiration {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		linksLog.Error(err)
+	}
+	for _, conf := range allProfiles() {
+		if err := initProfileBuckets(conf); err != nil {
+			linksLog.Error(err)
+		}
+	}
+
+	// Torrent files are only kept as long as the longest lived links
+	linksPath := filepath.Join(config.Get().ProfilePath, "links")
+	files, _ := filepath.Glob(filepath.Join(linksPath, "*.torrent"))
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && time.Since(fi.ModTime()) > linksExpiration[linksChosen] {
+			os.Remove(file)
+		}
+	}
+}
+
+// getCachedLinks returns the cached links of a media, if any, and when
+// they were cached.
+func getCachedLinks(linksType int, id string) (torrents []*bittorrent.Torrent, created time.Time) {
+	if DB == nil {
+		return
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(linksBucket)).Get(linksKey(linksType, id))
+		if v == nil {
+			return nil
+		}
+		var item *linksCacheItem
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+		if time.Since(item.Created) > linksExpiration[linksType] {
+			return nil
+		}
+		// Releases aren't stored, parse them again for rules to see the
+		// same fields as on fresh results
+		for _, torrent := range item.Torrents {
+			if torrent.Release == nil {
+				torrent.Release = release.Parse(torrent.Name)
+			}
+		}
+		torrents = item.Torrents
+		created = item.Created
+		return nil
+	})
+	if len(torrents) > 0 {
+		// Scores aren't trusted from the cache, the rules may have changed
+		torrents = providers.ApplyRules(torrents, &providers.ScoreContext{Runtime: torrents[0].Runtime})
+	}
+	return
+}
+
+func setCachedLinks(linksType int, id string, torrents []*bittorrent.Torrent) {
+	if DB == nil {
+		return
+	}
+	item := linksCacheItem{
+		Torrents: make([]*bittorrent.Torrent, 0, len(torrents)),
+		Created:  time.Now(),
+	}
+	for _, torrent := range torrents {
+		item.Torrents = append(item.Torrents, persistentTorrent(torrent))
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(linksBucket)).Put(linksKey(linksType, id), buf)
+	})
+	if err != nil {
+		linksLog.Error(err)
+	}
+}
+
+func deleteCachedLinks(linksType int, id string) {
+	if DB == nil {
+		return
+	}
+	DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(linksBucket)).Delete(linksKey(linksType, id))
+	})
+}
+
+// persistentTorrent copies resolved torrent files out of the temp folder,
+// which is emptied on every start, so cached links keep working.
+func persistentTorrent(torrent *bittorrent.Torrent) *bittorrent.Torrent {
+	if torrent.IsMagnet() || !strings.HasPrefix(torrent.URI, config.Get().Info.TempPath) {
+		return torrent
+	}
+
+	linksPath := filepath.Join(config.Get().ProfilePath, "links")
+	if err := os.MkdirAll(linksPath, 0755); err != nil {
+		linksLog.Error(err)
+		return torrent
+	}
+	torrentFile := filepath.Join(linksPath, filepath.Base(torrent.URI))
+	if err := copyFile(torrent.URI, torrentFile); err != nil {
+		linksLog.Error(err)
+		return torrent
+	}
+
+	persistent := *torrent
+	persistent.URI = torrentFile
+	return &persistent
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// searchLinks returns the cached links of a media when still fresh,
+// otherwise it runs search and caches its results. A zero time means the
+// links are fresh from the providers.
+func searchLinks(linksType int, id string, refresh bool, search func() []*bittorrent.Torrent) ([]*bittorrent.Torrent, time.Time) {
+	if !refresh {
+		if torrents, created := getCachedLinks(linksType, id); len(torrents) > 0 {
+			linksLog.Infof("Using %d cached links from %s", len(torrents), humanize.Time(created))
+			return demoteDeadLinks(torrents), created
+		}
+	}
+	torrents := demoteDeadLinks(search())
+	if len(torrents) > 0 {
+		setCachedLinks(linksType, id, torrents)
+	} else {
+		deleteCachedLinks(linksType, id)
+	}
+	return torrents, time.Time{}
+}
+
+// linksParam identifies cached links in play URLs, so playback can fall
+// back to the next link when the chosen one stalls.
+func linksParam(linksType int, id string) string {
+	return string(linksKey(linksType, id))
+}
+
+// fallbackLinks returns the cached links identified by a play URL's
+// links parameter, in ranking order, without the one being played and the
+// ones known to be dead.
+func fallbackLinks(links string, uri string) []*bittorrent.Torrent {
+	candidates := make([]*bittorrent.Torrent, 0)
+	parts := strings.SplitN(links, "_", 2)
+	if len(parts) != 2 {
+		return candidates
+	}
+	linksType, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return candidates
+	}
+	torrents, _ := getCachedLinks(linksType, parts[1])
+	for _, torrent := range torrents {
+		if torrent.URI == uri || isDeadLink(torrent.InfoHash) {
+			continue
+		}
+		candidates = append(candidates, torrent)
+	}
+	return candidates
+}
+
+func markDeadLink(infoHash string, reason string) {
+	if DB == nil || infoHash == "" {
+		return
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(deadLinkItem{Reason: reason, Created: time.Now()})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(deadLinksBucket)).Put([]byte(strings.ToLower(infoHash)), buf)
+	})
+	if err != nil {
+		linksLog.Error(err)
+	}
+}
+
+func isDeadLink(infoHash string) (dead bool) {
+	if DB == nil || infoHash == "" {
+		return
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		dead = tx.Bucket([]byte(deadLinksBucket)).Get([]byte(strings.ToLower(infoHash))) != nil
+		return nil
+	})
+	return
+}
+
+// demoteDeadLinks moves links that stalled before at the end of the list,
+// keeping them around in case they came back to life.
+func demoteDeadLinks(torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	alive := make([]*bittorrent.Torrent, 0, len(torrents))
+	dead := make([]*bittorrent.Torrent, 0)
+	for _, torrent := range torrents {
+		if isDeadLink(torrent.InfoHash) {
+			if !stringInSlice(deadLinkReason, torrent.ScoreReasons) {
+				torrent.ScoreReasons = append(torrent.ScoreReasons, deadLinkReason)
+			}
+			dead = append(dead, torrent)
+		} else {
+			alive = append(alive, torrent)
+		}
+	}
+	return append(alive, dead...)
+}
+
+func refreshChoice(created time.Time) string {
+	return fmt.Sprintf("[B]Refresh results[/B]\nCached %s", humanize.Time(created))
+}
+
+// linkChoices builds the labels of the links dialog.
+func linkChoices(torrents []*bittorrent.Torrent) []string {
+	choices := make([]string, 0, len(torrents))
+	for _, torrent := range torrents {
+		resolution := ""
+		if torrent.Resolution > 0 {
+			resolution = fmt.Sprintf("[B][COLOR %s]%s[/COLOR][/B] ", bittorrent.Colors[torrent.Resolution], bittorrent.Resolutions[torrent.Resolution])
+		}
+
+		info := make([]string, 0)
+		if torrent.Size != "" {
+			info = append(info, fmt.Sprintf("[B][%s][/B]", torrent.Size))
+		}
+		if torrent.RipType > 0 {
+			info = append(info, bittorrent.Rips[torrent.RipType])
+		}
+		if torrent.VideoCodec > 0 {
+			info = append(info, bittorrent.Codecs[torrent.VideoCodec])
+		}
+		if torrent.AudioCodec > 0 {
+			info = append(info, bittorrent.Codecs[torrent.AudioCodec])
+		}
+		if torrent.Release != nil {
+			info = append(info, torrent.Release.Tags()...)
+		}
+		if len(torrent.ScoreReasons) > 0 {
+			info = append(info, fmt.Sprintf("[COLOR FF999999](%s)[/COLOR]", strings.Join(torrent.ScoreReasons, ", ")))
+		}
+		if torrent.Provider != "" {
+			info = append(info, fmt.Sprintf(" - [B]%s[/B]", torrent.Provider))
+		}
+
+		multi := ""
+		if torrent.Multi {
+			multi = "\nmulti"
+		}
+
+		label := fmt.Sprintf("%s(%d / %d) %s\n%s\n%s%s",
+			resolution,
+			torrent.Seeds,
+			torrent.Peers,
+			strings.Join(info, " "),
+			torrent.Name,
+			torrent.Icon,
+			multi,
+		)
+		choices = append(choices, label)
+	}
+	return choices
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,411 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+)
+
+//
+// Movie list imports from IMDb and Letterboxd exports
+//
+
+const (
+	importedListsBucket = "ImportedLists"
+	// What a Letterboxd account export is imported from by default
+	letterboxdDefaultFile = "watchlist.csv"
+)
+
+var (
+	imdbIdPattern   = regexp.MustCompile(`^tt\d+$`)
+	imdbListPattern = regexp.MustCompile(`^https?://(?:www\.)?imdb\.com/list/(ls\d+)`)
+)
+
+type importEntry struct {
+	IMDBId string `json:"imdb,omitempty"`
+	Title  string `json:"title"`
+	Year   string `json:"year,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type importReport struct {
+	Source    string         `json:"source"`
+	Added     []string       `json:"added"`
+	Existing  int            `json:"existing"`
+	Skipped   []*importEntry `json:"skipped"`
+	Unmatched []*importEntry `json:"unmatched"`
+}
+
+// importedList is a list URL registered for periodic re-sync.
+type importedList struct {
+	URL        string    `json:"url"`
+	File       string    `json:"file,omitempty"`
+	Source     string    `json:"source"`
+	LastSynced time.Time `json:"last_synced"`
+	Unmatched  int       `json:"unmatched"`
+}
+
+func (r *importReport) summary() string {
+	return fmt.Sprintf("%d movies added, %d already in library, %d unmatched", len(r.Added), r.Existing, len(r.Unmatched))
+}
+
+// readCSVRecords finds the header of the movies in an export, skipping the
+// preamble of Letterboxd list exports, and returns the rows as maps.
+func readCSVRecords(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var header []string
+	records := make([]map[string]string, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			// The preamble has a Name column too, but no Year
+			if stringInSlice("Const", row) || (stringInSlice("Name", row) && stringInSlice("Year", row)) {
+				header = row
+			}
+			continue
+		}
+		record := make(map[string]string)
+		for i, column := range header {
+			if i < len(row) {
+				record[column] = strings.TrimSpace(row[i])
+			}
+		}
+		records = append(records, record)
+	}
+	if header == nil {
+		return nil, errors.New("Not an IMDb or Letterboxd export, missing Const or Name and Year columns")
+	}
+	return records, nil
+}
+
+// readExport returns the entries of an IMDb CSV, or of a Letterboxd CSV or
+// account export ZIP, in which case file selects the CSV to read.
+func readExport(data []byte, file string) ([]*importEntry, error) {
+	if bytes.HasPrefix(data, []byte("PK")) {
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		if file == "" {
+			file = letterboxdDefaultFile
+		}
+		found := false
+		for _, f := range archive.File {
+			if filepath.ToSlash(f.Name) != file {
+				continue
+			}
+			reader, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err = ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				return nil, err
+			}
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("No %s in archive", file)
+		}
+	}
+
+	records, err := readCSVRecords(data)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*importEntry, 0, len(records))
+	for _, record := range records {
+		entry := &importEntry{
+			IMDBId: record["Const"],
+			Title:  record["Title"],
+			Year:   record["Year"],
+		}
+		if entry.Title == "" {
+			entry.Title = record["Name"]
+		}
+		if titleType := record["Title Type"]; titleType != "" && titleType != "movie" && titleType != "tvMovie" && titleType != "video" {
+			entry.Reason = fmt.Sprintf("%s is not a movie", titleType)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// resolveEntry finds the TMDB movie of an entry, by its IMDb ID if it has
+// one, by its title and year otherwise.
+func resolveEntry(entry *importEntry) int {
+	if imdbIdPattern.MatchString(entry.IMDBId) {
+		if result := tmdb.Find(entry.IMDBId, "imdb_id"); result != nil && len(result.MovieResults) > 0 {
+			return result.MovieResults[0].Id
+		}
+		return 0
+	}
+	if entry.Title == "" {
+		return 0
+	}
+	movies, _ := tmdb.SearchMovies(entry.Title, "en", 1)
+	for _, movie := range movies {
+		if movie != nil && (entry.Year == "" || yearOf(movie.ReleaseDate) == entry.Year) {
+			return movie.Id
+		}
+	}
+	return 0
+}
+
+// importMovies adds the movies of an export to the library of conf,
+// skipping the ones removed before when syncing.
+func importMovies(conf *config.Configuration, entries []*importEntry, source string, syncing bool) (*importReport, error) {
+	if err := checkMoviesPath(); err != nil {
+		return nil, err
+	}
+
+	report := &importReport{
+		Source:    source,
+		Added:     make([]string, 0),
+		Skipped:   make([]*importEntry, 0),
+		Unmatched: make([]*importEntry, 0),
+	}
+	for _, entry := range entries {
+		if entry.Reason != "" {
+			report.Skipped = append(report.Skipped, entry)
+			continue
+		}
+		Id := resolveEntry(entry)
+		if Id == 0 {
+			entry.Reason = "Not found on TMDB"
+			report.Unmatched = append(report.Unmatched, entry)
+			continue
+		}
+
+		tmdbId := fmt.Sprintf("%d", Id)
+		if syncing && wasRemoved(conf, tmdbId, RemovedMovie) {
+			continue
+		}
+		if isAddedToLibrary(conf, tmdbId, Movie) {
+			report.Existing++
+			continue
+		}
+		// Written for another profile already
+		if addedElsewhere(conf, tmdbId, Movie) {
+			report.Added = append(report.Added, tmdbId)
+			continue
+		}
+		if _, err := isDuplicateMovie(tmdbId); err != nil {
+			report.Existing++
+			continue
+		}
+
+		setSource(Movie, tmdbId, source)
+		if _, err := writeMovieStrm(tmdbId); err != nil {
+			if !strings.HasPrefix(err.Error(), "LOCALIZE[30287]") {
+				libraryLog.Error(err)
+				entry.Reason = err.Error()
+				report.Unmatched = append(report.Unmatched, entry)
+			} else {
+				report.Existing++
+			}
+			continue
+		}
+		report.Added = append(report.Added, tmdbId)
+	}
+
+	if err := updateDB(conf, Batch, Movie, report.Added, 0); err != nil {
+		return report, err
+	}
+	if !syncing {
+		if err := updateDB(conf, BatchDelete, RemovedMovie, report.Added, 0); err != nil {
+			return report, err
+		}
+	}
+
+	libraryLog.Noticef("Imported %s: %s", source, report.summary())
+	for _, entry := range report.Unmatched {
+		libraryLog.Warningf("Unmatched %s (%s) %s: %s", entry.Title, entry.Year, entry.IMDBId, entry.Reason)
+	}
+	return report, nil
+}
+
+// exportURL turns IMDb list pages into their CSV export.
+func exportURL(url string) string {
+	if matches := imdbListPattern.FindStringSubmatch(url); matches != nil {
+		return fmt.Sprintf("https://www.imdb.com/list/%s/export", matches[1])
+	}
+	return url
+}
+
+func fetchExport(url string) ([]byte, error) {
+	resp, err := http.Get(exportURL(url))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Bad status getting %s: %d", url, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func importSource(url string, data []byte) string {
+	if matches := imdbListPattern.FindStringSubmatch(url); matches != nil {
+		return "imdb:" + matches[1]
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if bytes.Contains(head, []byte("Letterboxd")) || bytes.HasPrefix(data, []byte("PK")) {
+		return "letterboxd"
+	}
+	return "imdb"
+}
+
+//
+// Registered lists
+//
+func registerImportedList(conf *config.Configuration, list *importedList) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(profileBucket(conf, importedListsBucket)).Put([]byte(list.URL), buf)
+	})
+}
+
+func importedLists(conf *config.Configuration) []*importedList {
+	lists := make([]*importedList, 0)
+	DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(profileBucket(conf, importedListsBucket)).ForEach(func(k, v []byte) error {
+			var list *importedList
+			if err := json.Unmarshal(v, &list); err == nil {
+				lists = append(lists, list)
+			}
+			return nil
+		})
+	})
+	return lists
+}
+
+// syncImportedLists imports the lists registered by a profile again, for
+// the movies added to them since.
+func syncImportedLists(conf *config.Configuration) {
+	for _, list := range importedLists(conf) {
+		data, err := fetchExport(list.URL)
+		if err != nil {
+			libraryLog.Warningf("Unable to sync %s: %s", list.URL, err)
+			continue
+		}
+		entries, err := readExport(data, list.File)
+		if err != nil {
+			libraryLog.Warningf("Unable to sync %s: %s", list.URL, err)
+			continue
+		}
+		report, err := importMovies(conf, entries, list.Source, true)
+		if err != nil {
+			libraryLog.Warningf("Unable to sync %s: %s", list.URL, err)
+			continue
+		}
+		list.LastSynced = time.Now()
+		list.Unmatched = len(report.Unmatched)
+		if err := registerImportedList(conf, list); err != nil {
+			libraryLog.Error(err)
+		}
+	}
+}
+
+// ImportMoviesList imports an IMDb or Letterboxd export, either posted or
+// fetched from ?url=, which is then synced periodically with ?sync=1.
+// ?file= selects the list to import from a Letterboxd account export.
+func ImportMoviesList(ctx *gin.Context) {
+	url := ctx.Query("url")
+	file := ctx.Query("file")
+
+	var data []byte
+	var err error
+	if url != "" {
+		data, err = fetchExport(url)
+	} else {
+		data, err = ioutil.ReadAll(ctx.Request.Body)
+	}
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	if len(data) == 0 {
+		ctx.String(400, "Missing export, post it or give its url")
+		return
+	}
+
+	entries, err := readExport(data, file)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	source := ctx.DefaultQuery("source", importSource(url, data))
+	conf := profileFor(ctx)
+	report, err := importMovies(conf, entries, source, false)
+	if err != nil {
+		ctx.String(500, err.Error())
+		return
+	}
+
+	if url != "" && ctx.Query("sync") != "" {
+		list := &importedList{
+			URL:        url,
+			File:       file,
+			Source:     source,
+			LastSynced: time.Now(),
+			Unmatched:  len(report.Unmatched),
+		}
+		if err := registerImportedList(conf, list); err != nil {
+			libraryLog.Error(err)
+		}
+	}
+
+	uiFor(ctx).Notify("Quasar", report.summary())
+	if len(report.Added) > 0 && config.Get().UpdateAutoScan && scanning == false {
+		scanning = true
+		libraryScan()
+	}
+	ctx.JSON(200, report)
+}
+
+// ImportedLists shows the lists registered for re-sync, and unregisters
+// the one given with ?remove=.
+func ImportedLists(ctx *gin.Context) {
+	if url := ctx.Query("remove"); url != "" {
+		err := DB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(profileBucket(profileFor(ctx), importedListsBucket)).Delete([]byte(url))
+		})
+		if err != nil {
+			ctx.String(500, err.Error())
+			return
+		}
+	}
+	ctx.JSON(200, importedLists(profileFor(ctx)))
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/logs"
+	"github.com/scakemyer/quasar/util"
+)
+
+//
+// The log buffer, and bug report bundles of it without secrets
+//
+
+const redacted = "<redacted>"
+
+var logsLog = logging.MustGetLogger("logs")
+
+// Settings which aren't secrets, but tell who the user is
+var personalSettings = map[string]bool{
+	"trakt_username":  true,
+	"remote_username": true,
+	"osdb_user":       true,
+	"socks_login":     true,
+}
+
+var redactedPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:token|password|passwd|pass|api_key|apikey|secret|key)=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)((?:Authorization|` + TokenHeader + `|` + CSRFHeader + `):\s*)[^\r\n]+`),
+	regexp.MustCompile(`(?i)(Bearer\s+)\S+`),
+	regexp.MustCompile(`(?i)("(?:access_token|refresh_token|token|password)"\s*:\s*")[^"]*`),
+}
+
+// logFilter reads the filter of a /logs request.
+func logFilter(ctx *gin.Context) (filter logs.Filter, err error) {
+	filter.Level = logging.DEBUG
+	if level := ctx.Query("level"); level != "" {
+		if filter.Level, err = logging.LogLevel(level); err != nil {
+			return
+		}
+	}
+	if modules := ctx.Query("module"); modules != "" {
+		filter.Modules = splitList(modules)
+	}
+	filter.Query = ctx.Query("q")
+	if since := ctx.Query("since"); since != "" {
+		if filter.Since, err = strconv.ParseUint(since, 10, 64); err != nil {
+			return
+		}
+	}
+	filter.Limit = 500
+	if limit := ctx.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Logs lists the latest lines, filtered with ?level=, ?module= (comma
+// separated), ?q=, ?since=<id> and ?limit=, as text with ?format=text.
+func Logs(ctx *gin.Context) {
+	filter, err := logFilter(ctx)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	entries := logs.Buffer.Entries(filter)
+	if ctx.Query("format") == "text" {
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			lines = append(lines, entry.String())
+		}
+		ctx.String(200, strings.Join(lines, "\n"))
+		return
+	}
+	ctx.JSON(200, gin.H{
+		"entries": entries,
+		"levels":  logs.Levels(),
+	})
+}
+
+// redactor hides secrets, and who the user is, from what goes in bug
+// reports.
+type redactor struct {
+	values []string
+	home   string
+}
+
+func newRedactor() *redactor {
+	r := &redactor{home: os.Getenv("HOME")}
+	confs := []*config.Configuration{config.Get()}
+	for _, name := range config.Profiles() {
+		if conf, err := config.GetProfile(name); err == nil {
+			confs = append(confs, conf)
+		}
+	}
+	for _, conf := range confs {
+		for _, setting := range config.Schema {
+			if !setting.Secret && !personalSettings[setting.Key] {
+				continue
+			}
+			// Short values would hide bits of everything
+			if value, ok := conf.Setting(setting.Key).(string); ok && len(value) >= 4 {
+				r.values = append(r.values, value)
+			}
+		}
+	}
+	return r
+}
+
+func (r *redactor) redact(text string) string {
+	for _, value := range r.values {
+		text = strings.Replace(text, value, redacted, -1)
+	}
+	for _, pattern := range redactedPatterns {
+		text = pattern.ReplaceAllString(text, "${1}"+redacted)
+	}
+	if len(r.home) > 1 {
+		text = strings.Replace(text, r.home, "~", -1)
+	}
+	return text
+}
+
+// LogBundle makes a zip of the logs, settings and versions for bug
+// reports, without secrets.
+func LogBundle(ctx *gin.Context) {
+	r := newRedactor()
+	buffer := new(bytes.Buffer)
+	archive := zip.NewWriter(buffer)
+
+	add := func(name string, data []byte) error {
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write([]byte(r.redact(string(data))))
+		return err
+	}
+
+	lines := make([]string, 0)
+	for _, entry := range logs.Buffer.Entries(logs.Filter{Level: logging.DEBUG}) {
+		lines = append(lines, entry.String())
+	}
+	files := map[string][]byte{
+		"buffer.log": []byte(strings.Join(lines, "\n")),
+	}
+	for _, path := range logs.File.Paths() {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			files[filepath.Base(path)] = data
+		}
+	}
+	if data, err := json.MarshalIndent(settingValues(config.Get()), "", "  "); err == nil {
+		files["settings.json"] = data
+	}
+	versions, _ := json.MarshalIndent(gin.H{
+		"version":    util.Version[1 : len(util.Version)-1],
+		"libtorrent": libtorrent.Version(),
+		"go":         runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"headless":   config.Headless,
+		"levels":     logs.Levels(),
+	}, "", "  ")
+	files["versions.json"] = versions
+
+	for name, data := range files {
+		if err := add(name, data); err != nil {
+			logsLog.Error(err)
+			ctx.String(500, err.Error())
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		logsLog.Error(err)
+		ctx.String(500, err.Error())
+		return
+	}
+
+	ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=quasar-logs-%s.zip", time.Now().Format("20060102-150405")))
+	ctx.Data(200, "application/zip", buffer.Bytes())
+}
+
+// LogsPage shows the log buffer in the web UI.
+func LogsPage(ctx *gin.Context) {
+	ctx.Data(200, "text/html; charset=utf-8", []byte(logsPage))
+}
+
+const logsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Quasar logs</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #1b1b1b; color: #ddd; }
+form { position: sticky; top: 0; padding: 8px; background: #2b2b2b; }
+input, select, button { margin-right: 6px; }
+pre { margin: 0; padding: 8px; font-size: 12px; white-space: pre-wrap; }
+.CRITICAL, .ERROR { color: #f66; }
+.WARNING { color: #fc6; }
+.NOTICE { color: #6cf; }
+.DEBUG { color: #999; }
+</style>
+</head>
+<body>
+<form id="filter">
+<select name="level">
+<option>DEBUG</option><option>INFO</option><option>NOTICE</option>
+<option>WARNING</option><option>ERROR</option><option>CRITICAL</option>
+</select>
+<input name="module" placeholder="Modules, e.g. btservice,library">
+<input name="q" placeholder="Search">
+<label><input type="checkbox" id="follow" checked> Follow</label>
+<a href="../logs/bundle"><button type="button">Download bug report</button></a>
+</form>
+<pre id="lines"></pre>
+<script>
+var form = document.getElementById("filter");
+var lines = document.getElementById("lines");
+var since = 0;
+
+function query() {
+	var params = [];
+	["level", "module", "q"].forEach(function (name) {
+		if (form[name].value) {
+			params.push(name + "=" + encodeURIComponent(form[name].value));
+		}
+	});
+	params.push("since=" + since);
+	return params.join("&");
+}
+
+function load() {
+	var request = new XMLHttpRequest();
+	request.open("GET", "../logs?" + query());
+	request.onload = function () {
+		if (request.status != 200) {
+			return;
+		}
+		JSON.parse(request.responseText).entries.forEach(function (entry) {
+			var line = document.createElement("div");
+			line.className = entry.level;
+			line.textContent = entry.time.replace("T", " ").substr(0, 23) + " " + entry.level.substr(0, 4) + "  " + entry.module + " ▶ " + entry.message;
+			lines.appendChild(line);
+			since = entry.id;
+		});
+		if (document.getElementById("follow").checked) {
+			window.scrollTo(0, document.body.scrollHeight);
+		}
+	};
+	request.send();
+}
+
+form.onchange = form.onsubmit = function () {
+	since = 0;
+	lines.innerHTML = "";
+	load();
+	return false;
+};
+
+load();
+setInterval(function () {
+	if (document.getElementById("follow").checked) {
+		load();
+	}
+}, 2000);
+</script>
+</body>
+</html>
+`
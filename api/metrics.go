@@ -0,0 +1,41 @@
+package api
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/metrics"
+	"github.com/scakemyer/quasar/util"
+)
+
+var metricsLog = logging.MustGetLogger("metrics")
+
+var (
+	librarySyncSeconds = metrics.NewHistogram("quasar_library_sync_seconds", "Time taken by library updates and syncs, by result.", metrics.DurationBuckets, "sync", "result")
+	buildInfo          = metrics.NewGauge("quasar_build_info", "Always 1, labeled with the versions running.", "version", "go")
+	goroutines         = metrics.NewGauge("quasar_goroutines", "Goroutines running.")
+)
+
+// observeSync records how long a sync took since start, and whether it
+// ended with *err.
+func observeSync(sync string, start time.Time, err *error) {
+	result := "ok"
+	if *err != nil {
+		result = "error"
+	}
+	librarySyncSeconds.Since(start, sync, result)
+}
+
+// Metrics serves all metrics in Prometheus' text format.
+func Metrics(ctx *gin.Context) {
+	buildInfo.Set(1, util.Version[1:len(util.Version)-1], runtime.Version())
+	goroutines.Set(float64(runtime.NumGoroutine()))
+
+	ctx.Writer.Header().Set("Content-Type", metrics.ContentType)
+	ctx.Writer.WriteHeader(200)
+	if err := metrics.Write(ctx.Writer); err != nil {
+		metricsLog.Error(err)
+	}
+}
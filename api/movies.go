@@ -5,14 +5,14 @@ import (
 	"log"
 	"sort"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/scakemyer/quasar/bittorrent"
-	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/config"
-	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
@@ -121,7 +121,7 @@ func MoviesTrakt(ctx *gin.Context) {
 
 func MoviesTraktLists(ctx *gin.Context) {
 	items := xbmc.ListItems{}
-	for _, list := range trakt.Userlists() {
+	for _, list := range trakt.Userlists(profileFor(ctx)) {
 		item := &xbmc.ListItem{
 			Label: list.Name,
 			Path:  UrlForXBMC("/movies/trakt/lists/id/%d", list.IDs.Trakt),
@@ -146,6 +146,7 @@ func CalendarMovies(ctx *gin.Context) {
 }
 
 func renderMovies(ctx *gin.Context, movies tmdb.Movies, page int, total int, query string) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -191,17 +192,17 @@ func renderMovies(ctx *gin.Context, movies tmdb.Movies, page int, total int, que
 
 		tmdbId := strconv.Itoa(movie.Id)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/add/%d", movie.Id))}
-		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(tmdbId, Movie) {
+		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Movie) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/remove/%d", movie.Id))}
 		}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/add", movie.Id))}
-		if inMoviesWatchlist(movie.Id) {
+		if inMoviesWatchlist(conf, movie.Id) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/remove", movie.Id))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/add", movie.Id))}
-		if inMoviesCollection(movie.Id) {
+		if inMoviesCollection(conf, movie.Id) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/remove", movie.Id))}
 		}
 
@@ -290,16 +291,7 @@ func SearchMovies(ctx *gin.Context) {
 	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	query := ctx.Query("q")
 	if query == "" {
-		if len(searchHistory) > 0 && xbmc.DialogConfirm("Quasar", "LOCALIZE[30262]") {
-			choice := xbmc.ListDialog("LOCALIZE[30261]", searchHistory...)
-			query = searchHistory[choice]
-		} else {
-			query = xbmc.Keyboard("", "LOCALIZE[30206]")
-			if query == "" {
-				return
-			}
-			searchHistory = append(searchHistory, query)
-		}
+		query = searchQuery(profileFor(ctx), "LOCALIZE[30206]")
 	}
 	if query == "" {
 		return
@@ -318,7 +310,7 @@ func movieLinks(tmdbId string) []*bittorrent.Torrent {
 
 	searchers := providers.GetMovieSearchers()
 	if len(searchers) == 0 {
-		xbmc.Notify("Quasar", "LOCALIZE[30204]", config.AddonIcon())
+		ui.Get().Notify("Quasar", "LOCALIZE[30204]")
 	}
 
 	return providers.SearchMovie(searchers, movie)
@@ -326,6 +318,7 @@ func movieLinks(tmdbId string) []*bittorrent.Torrent {
 
 func MovieLinks(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		dialogs := uiFor(ctx)
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
 		tmdbId := ctx.Params.ByName("tmdbId")
@@ -338,7 +331,7 @@ func MovieLinks(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFu
 		movie := tmdb.GetMovieById(tmdbId, config.Get().Language)
 
 		existingTorrent := ExistingTorrent(btService, movie.Title)
-		if existingTorrent != "" && xbmc.DialogConfirm("Quasar", "LOCALIZE[30270]") {
+		if existingTorrent != "" && dialogs.Confirm("Quasar", "LOCALIZE[30270]") {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", existingTorrent,
 				                     "tmdb", tmdbId,
@@ -347,94 +340,75 @@ func MovieLinks(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFu
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		if torrents := InTorrentsMap(tmdbId); len(torrents) > 0 {
+		if torrents := InTorrentsMap(dialogs, tmdbId); len(torrents) > 0 {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", torrents[0].URI,
 				                     "tmdb", tmdbId,
 				                     "library", library,
+				"links", linksParam(linksMovie, tmdbId),
+				"runtime", strconv.Itoa(movie.Runtime),
 				                     "type", "movie")
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		torrents := movieLinks(tmdbId)
+		refresh := ctx.Query("refresh") != ""
+		for {
+			torrents, cached := searchLinks(linksMovie, tmdbId, refresh, func() []*bittorrent.Torrent {
+				return movieLinks(tmdbId)
+			})
 
-		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
-			return
-		}
-
-		choices := make([]string, 0, len(torrents))
-		for _, torrent := range torrents {
-			resolution := ""
-			if torrent.Resolution > 0 {
-				resolution = fmt.Sprintf("[B][COLOR %s]%s[/COLOR][/B] ", bittorrent.Colors[torrent.Resolution], bittorrent.Resolutions[torrent.Resolution])
+			if len(torrents) == 0 {
+				dialogs.Notify("Quasar", "LOCALIZE[30205]")
+				return
 			}
 
-			info := make([]string, 0)
-			if torrent.Size != "" {
-				info = append(info, fmt.Sprintf("[B][%s][/B]", torrent.Size))
-			}
-			if torrent.RipType > 0 {
-				info = append(info, bittorrent.Rips[torrent.RipType])
-			}
-			if torrent.VideoCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.VideoCodec])
-			}
-			if torrent.AudioCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.AudioCodec])
-			}
-			if torrent.Provider != "" {
-				info = append(info, fmt.Sprintf(" - [B]%s[/B]", torrent.Provider))
+			choices := linkChoices(torrents)
+			if !cached.IsZero() {
+				choices = append([]string{refreshChoice(cached)}, choices...)
 			}
 
-			multi := ""
-			if torrent.Multi {
-				multi = "\nmulti"
+			choice := dialogs.Choose("LOCALIZE[30228]", movie.Title, choices...)
+			if !cached.IsZero() {
+				if choice == 0 {
+					refresh = true
+					continue
+				}
+				choice--
 			}
-
-			label := fmt.Sprintf("%s(%d / %d) %s\n%s\n%s%s",
-				resolution,
-				torrent.Seeds,
-				torrent.Peers,
-				strings.Join(info, " "),
-				torrent.Name,
-				torrent.Icon,
-				multi,
-			)
-			choices = append(choices, label)
-		}
-
-		choice := xbmc.ListDialogLarge("LOCALIZE[30228]", movie.Title, choices...)
-		if choice >= 0 {
-			AddToTorrentsMap(tmdbId, torrents[choice])
-
-			rUrl := UrlQuery(
-				UrlForXBMC("/play"), "uri", torrents[choice].URI,
-				                     "tmdb", tmdbId,
-				                     "library", library,
-				                     "type", "movie")
-			if external != "" {
-				xbmc.PlayURL(rUrl)
-			} else {
-				ctx.Redirect(302, rUrl)
+			if choice >= 0 {
+				AddToTorrentsMap(tmdbId, torrents[choice])
+
+				rUrl := UrlQuery(
+					UrlForXBMC("/play"), "uri", torrents[choice].URI,
+					"tmdb", tmdbId,
+					"library", library,
+					"links", linksParam(linksMovie, tmdbId),
+					"runtime", strconv.Itoa(movie.Runtime),
+					"type", "movie")
+				if external != "" {
+					xbmc.PlayURL(rUrl)
+				} else {
+					ctx.Redirect(302, uiQuery(ctx, rUrl))
+				}
 			}
+			return
 		}
 	}
 }
 
 func MoviePlay(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		dialogs := uiFor(ctx)
 
 		tmdbId := ctx.Params.ByName("tmdbId")
 		external := ctx.Query("external")
@@ -446,7 +420,7 @@ func MoviePlay(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFun
 		movie := tmdb.GetMovieById(tmdbId, "")
 
 		existingTorrent := ExistingTorrent(btService, movie.Title)
-		if existingTorrent != "" && xbmc.DialogConfirm("Quasar", "LOCALIZE[30270]") {
+		if existingTorrent != "" && dialogs.Confirm("Quasar", "LOCALIZE[30270]") {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", existingTorrent,
 				                     "tmdb", tmdbId,
@@ -455,32 +429,37 @@ func MoviePlay(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFun
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		if torrents := InTorrentsMap(tmdbId); len(torrents) > 0 {
+		if torrents := InTorrentsMap(dialogs, tmdbId); len(torrents) > 0 {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", torrents[0].URI,
 				                     "tmdb", tmdbId,
 				                     "library", library,
+				"links", linksParam(linksMovie, tmdbId),
+				"runtime", strconv.Itoa(movie.Runtime),
 				                     "type", "movie")
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		torrents := movieLinks(tmdbId)
+		torrents, _ := searchLinks(linksMovie, tmdbId, false, func() []*bittorrent.Torrent {
+			return movieLinks(tmdbId)
+		})
 		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
+			dialogs.Notify("Quasar", "LOCALIZE[30205]")
 			return
 		}
 
 		sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+		providers.SortByScore(torrents)
 
 		AddToTorrentsMap(tmdbId, torrents[0])
 
@@ -488,11 +467,13 @@ func MoviePlay(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFun
 			UrlForXBMC("/play"), "uri", torrents[0].URI,
 			                     "tmdb", tmdbId,
 			                     "library", library,
+			"links", linksParam(linksMovie, tmdbId),
+			"runtime", strconv.Itoa(movie.Runtime),
 			                     "type", "movie")
 		if external != "" {
 			xbmc.PlayURL(rUrl)
 		} else {
-			ctx.Redirect(302, rUrl)
+			ctx.Redirect(302, uiQuery(ctx, rUrl))
 		}
 	}
 }
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/util"
+)
+
+//
+// Kodi .nfo files, written next to .strm files so library scans don't
+// need to scrape, and can't pick the wrong item either.
+//
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoThumb struct {
+	Aspect string `xml:"aspect,attr,omitempty"`
+	Value  string `xml:",chardata"`
+}
+
+type nfoFanart struct {
+	Thumbs []nfoThumb `xml:"thumb"`
+}
+
+type movieNFO struct {
+	XMLName       xml.Name      `xml:"movie"`
+	Title         string        `xml:"title"`
+	OriginalTitle string        `xml:"originaltitle,omitempty"`
+	Year          string        `xml:"year,omitempty"`
+	Premiered     string        `xml:"premiered,omitempty"`
+	Plot          string        `xml:"plot,omitempty"`
+	Tagline       string        `xml:"tagline,omitempty"`
+	Runtime       int           `xml:"runtime,omitempty"`
+	Rating        float32       `xml:"rating,omitempty"`
+	Genres        []string      `xml:"genre"`
+	Studios       []string      `xml:"studio"`
+	Thumbs        []nfoThumb    `xml:"thumb"`
+	Fanart        *nfoFanart    `xml:"fanart,omitempty"`
+	UniqueIDs     []nfoUniqueID `xml:"uniqueid"`
+}
+
+type tvshowNFO struct {
+	XMLName       xml.Name      `xml:"tvshow"`
+	Title         string        `xml:"title"`
+	OriginalTitle string        `xml:"originaltitle,omitempty"`
+	Year          string        `xml:"year,omitempty"`
+	Premiered     string        `xml:"premiered,omitempty"`
+	Status        string        `xml:"status,omitempty"`
+	Plot          string        `xml:"plot,omitempty"`
+	Runtime       int           `xml:"runtime,omitempty"`
+	Rating        float32       `xml:"rating,omitempty"`
+	Genres        []string      `xml:"genre"`
+	Studios       []string      `xml:"studio"`
+	Thumbs        []nfoThumb    `xml:"thumb"`
+	Fanart        *nfoFanart    `xml:"fanart,omitempty"`
+	UniqueIDs     []nfoUniqueID `xml:"uniqueid"`
+}
+
+type episodeNFO struct {
+	XMLName   xml.Name      `xml:"episodedetails"`
+	Title     string        `xml:"title"`
+	ShowTitle string        `xml:"showtitle"`
+	Season    int           `xml:"season"`
+	Episode   int           `xml:"episode"`
+	Aired     string        `xml:"aired,omitempty"`
+	Plot      string        `xml:"plot,omitempty"`
+	Runtime   int           `xml:"runtime,omitempty"`
+	Rating    float32       `xml:"rating,omitempty"`
+	Thumbs    []nfoThumb    `xml:"thumb"`
+	UniqueIDs []nfoUniqueID `xml:"uniqueid"`
+}
+
+func nfoFanarts(backdrop string) *nfoFanart {
+	if backdrop == "" {
+		return nil
+	}
+	return &nfoFanart{Thumbs: []nfoThumb{{Value: tmdb.ImageURL(backdrop, "original")}}}
+}
+
+func nfoPosters(poster string) []nfoThumb {
+	if poster == "" {
+		return nil
+	}
+	return []nfoThumb{{Aspect: "poster", Value: tmdb.ImageURL(poster, "original")}}
+}
+
+func nfoNames(items []*tmdb.IdName) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func writeNFO(path string, nfo interface{}) error {
+	out, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), out...), 0644)
+}
+
+func writeMovieNFO(movie *tmdb.Movie, path string) error {
+	nfo := movieNFO{
+		Title:         movie.Title,
+		OriginalTitle: movie.OriginalTitle,
+		Year:          strings.Split(movie.ReleaseDate, "-")[0],
+		Premiered:     movie.ReleaseDate,
+		Plot:          movie.Overview,
+		Tagline:       movie.TagLine,
+		Runtime:       movie.Runtime,
+		Rating:        movie.VoteAverage,
+		Genres:        nfoNames(movie.Genres),
+		Studios:       nfoNames(movie.ProductionCompanies),
+		Thumbs:        nfoPosters(movie.PosterPath),
+		Fanart:        nfoFanarts(movie.BackdropPath),
+		UniqueIDs:     []nfoUniqueID{{Type: "tmdb", Default: true, Value: strconv.Itoa(movie.Id)}},
+	}
+	if movie.IMDBId != "" {
+		nfo.UniqueIDs = append(nfo.UniqueIDs, nfoUniqueID{Type: "imdb", Value: movie.IMDBId})
+	}
+	return writeNFO(path, nfo)
+}
+
+func writeShowNFO(show *tmdb.Show, path string) error {
+	nfo := tvshowNFO{
+		Title:         show.Name,
+		OriginalTitle: show.OriginalName,
+		Year:          strings.Split(show.FirstAirDate, "-")[0],
+		Premiered:     show.FirstAirDate,
+		Status:        show.Status,
+		Plot:          show.Overview,
+		Rating:        show.VoteAverage,
+		Studios:       nfoNames(show.Networks),
+		Thumbs:        nfoPosters(show.PosterPath),
+		Fanart:        nfoFanarts(show.BackdropPath),
+		UniqueIDs:     []nfoUniqueID{{Type: "tmdb", Default: true, Value: strconv.Itoa(show.Id)}},
+	}
+	if len(show.EpisodeRunTime) > 0 {
+		nfo.Runtime = show.EpisodeRunTime[len(show.EpisodeRunTime)-1]
+	}
+	for _, genre := range show.Genres {
+		nfo.Genres = append(nfo.Genres, genre.Name)
+	}
+	if show.ExternalIDs != nil {
+		if show.ExternalIDs.IMDBId != "" {
+			nfo.UniqueIDs = append(nfo.UniqueIDs, nfoUniqueID{Type: "imdb", Value: show.ExternalIDs.IMDBId})
+		}
+		if tvdbId := util.StrInterfaceToInt(show.ExternalIDs.TVDBID); tvdbId > 0 {
+			nfo.UniqueIDs = append(nfo.UniqueIDs, nfoUniqueID{Type: "tvdb", Value: strconv.Itoa(tvdbId)})
+		}
+	}
+	return writeNFO(path, nfo)
+}
+
+func writeEpisodeNFO(show *tmdb.Show, episode *tmdb.Episode, path string) error {
+	nfo := episodeNFO{
+		Title:     episode.Name,
+		ShowTitle: show.Name,
+		Season:    episode.SeasonNumber,
+		Episode:   episode.EpisodeNumber,
+		Aired:     episode.AirDate,
+		Plot:      episode.Overview,
+		Rating:    episode.VoteAverage,
+		UniqueIDs: []nfoUniqueID{{Type: "tmdb", Default: true, Value: strconv.Itoa(episode.Id)}},
+	}
+	if len(show.EpisodeRunTime) > 0 {
+		nfo.Runtime = show.EpisodeRunTime[len(show.EpisodeRunTime)-1]
+	}
+	if episode.StillPath != "" {
+		nfo.Thumbs = []nfoThumb{{Value: tmdb.ImageURL(episode.StillPath, "original")}}
+	}
+	if episode.ExternalIDs != nil {
+		if episode.ExternalIDs.IMDBId != "" {
+			nfo.UniqueIDs = append(nfo.UniqueIDs, nfoUniqueID{Type: "imdb", Value: episode.ExternalIDs.IMDBId})
+		}
+		if tvdbId := util.StrInterfaceToInt(episode.ExternalIDs.TVDBID); tvdbId > 0 {
+			nfo.UniqueIDs = append(nfo.UniqueIDs, nfoUniqueID{Type: "tvdb", Value: strconv.Itoa(tvdbId)})
+		}
+	}
+	return writeNFO(path, nfo)
+}
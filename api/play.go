@@ -1,14 +1,15 @@
 package api
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"strconv"
-	"encoding/hex"
 
 	"github.com/gin-gonic/gin"
-	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -24,6 +25,8 @@ func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 		show := ctx.Query("show")
 		season := ctx.Query("season")
 		episode := ctx.Query("episode")
+		runtime := ctx.Query("runtime")
+		links := ctx.Query("links")
 
 		if uri == "" && resume == "" {
 			return
@@ -76,6 +79,18 @@ func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 			}
 		}
 
+		runtimeMinutes := 0
+		if runtime != "" {
+			if minutes, err := strconv.Atoi(runtime); err == nil && minutes > 0 {
+				runtimeMinutes = minutes
+			}
+		}
+
+		candidates := make([]*bittorrent.Torrent, 0)
+		if links != "" && resumeIndex < 0 && config.Get().StallFallback {
+			candidates = fallbackLinks(links, uri)
+		}
+
 		params := bittorrent.BTPlayerParams{
 			URI: uri,
 			FromLibrary: fromLibrary,
@@ -86,11 +101,34 @@ func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 			ShowID: showId,
 			Season: seasonNumber,
 			Episode: episodeNumber,
+			Runtime:     runtimeMinutes,
+			Fallback:    len(candidates) > 0,
+			UI:          uiFor(ctx),
+			Profile:     profileFor(ctx),
 		}
 
 		player := bittorrent.NewBTPlayer(btService, params)
-		if player.Buffer() != nil {
-			return
+		for {
+			err := player.Buffer()
+			if err == nil {
+				break
+			}
+			stallErr, stalled := err.(*bittorrent.StallError)
+			if !stalled {
+				return
+			}
+
+			// Fallback is only enabled while there are candidates left
+			markDeadLink(stallErr.InfoHash, stallErr.Reason)
+			linksLog.Infof("%s, falling back to %s", stallErr, candidates[0].Name)
+			params.UI.Notify("Quasar", "Link stalled, trying the next one...")
+			params.URI = candidates[0].URI
+			candidates = candidates[1:]
+			params.Fallback = len(candidates) > 0
+
+			// The stalled player is still removing its torrent
+			<-player.Closed()
+			player = bittorrent.NewBTPlayer(btService, params)
 		}
 
 		rUrl, _ := url.Parse(fmt.Sprintf("%s/files/%s", util.GetHTTPHost(), player.PlayURL()))
@@ -157,7 +195,6 @@ func PlayURI(btService *bittorrent.BTService) gin.HandlerFunc {
 				"type", contentType,
 				"library", library))
 		}
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
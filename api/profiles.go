@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+)
+
+//
+// Profiles, each with its own Trakt account and database buckets, chosen
+// per request with X-Quasar-Profile or a /profile/<name> prefix
+//
+
+const (
+	ProfileHeader = "X-Quasar-Profile"
+	profilePrefix = "/profile/"
+)
+
+// Buckets each profile has its own of, the default profile's being named
+// as they always were. The .strm files of the profiles' libraries are
+// shared, and only removed once no profile has their item.
+var profileBuckets = []string{historyBucket, bucket, importedListsBucket, watchedBucket}
+
+var profilesLog = logging.MustGetLogger("profiles")
+
+func init() {
+	cache.Vary = append(cache.Vary, ProfileHeader)
+}
+
+// ProfileHandler moves the profile of /profile/<name>/... paths into the
+// header, for routes to be the same for all profiles.
+func ProfileHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, profilePrefix) {
+			name := strings.TrimPrefix(r.URL.Path, profilePrefix)
+			path := "/"
+			if i := strings.Index(name, "/"); i >= 0 {
+				name, path = name[:i], name[i:]
+			}
+			r.Header.Set(ProfileHeader, name)
+			r.URL.Path = path
+			r.URL.RawPath = ""
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// checkProfile turns away requests for unknown profiles.
+func checkProfile(ctx *gin.Context) {
+	if _, err := config.GetProfile(ctx.Request.Header.Get(ProfileHeader)); err != nil {
+		ctx.String(404, err.Error())
+		ctx.Abort()
+	}
+}
+
+// profileFor returns the configuration of a request's profile.
+func profileFor(ctx *gin.Context) *config.Configuration {
+	conf, err := config.GetProfile(ctx.Request.Header.Get(ProfileHeader))
+	if err != nil {
+		// Checked before, so only if it got removed since
+		profilesLog.Warning(err)
+		return config.Get()
+	}
+	return conf
+}
+
+// allProfiles returns the configuration of every profile, the default one
+// first.
+func allProfiles() []*config.Configuration {
+	profiles := append([]string{config.DefaultProfile}, config.Profiles()...)
+	confs := make([]*config.Configuration, 0, len(profiles))
+	for _, profile := range profiles {
+		conf, err := config.GetProfile(profile)
+		if err != nil {
+			profilesLog.Error(err)
+			continue
+		}
+		confs = append(confs, conf)
+	}
+	return confs
+}
+
+// profilesWith returns the profiles having an item in their library.
+func profilesWith(id string, addedType int) []*config.Configuration {
+	having := make([]*config.Configuration, 0)
+	for _, conf := range allProfiles() {
+		if isAddedToLibrary(conf, id, addedType) {
+			having = append(having, conf)
+		}
+	}
+	return having
+}
+
+// addedElsewhere tells whether a profile other than conf's has an item in
+// its library, and so its files.
+func addedElsewhere(conf *config.Configuration, id string, addedType int) bool {
+	for _, other := range profilesWith(id, addedType) {
+		if other.Profile != conf.Profile {
+			return true
+		}
+	}
+	return false
+}
+
+// removingProfiles returns the profiles an item is removed from: the
+// request's, or all of them when Kodi or a download removes it.
+func removingProfiles(ctx *gin.Context) []*config.Configuration {
+	if ctx != nil {
+		return []*config.Configuration{profileFor(ctx)}
+	}
+	return allProfiles()
+}
+
+// profileBucket returns the name of a profile's bucket.
+func profileBucket(conf *config.Configuration, bucket string) []byte {
+	if conf.Profile == config.DefaultProfile {
+		return []byte(bucket)
+	}
+	return []byte(bucket + "@" + conf.Profile)
+}
+
+// initProfileBuckets creates the buckets of a profile.
+func initProfileBuckets(conf *config.Configuration) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range profileBuckets {
+			if _, err := tx.CreateBucketIfNotExists(profileBucket(conf, bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Profiles lists the profiles, the default one being "".
+func Profiles(ctx *gin.Context) {
+	ctx.JSON(200, append([]string{config.DefaultProfile}, config.Profiles()...))
+}
+
+// UpdateProfile creates or changes a profile, with a JSON object of the
+// settings which can differ between profiles.
+func UpdateProfile(ctx *gin.Context) {
+	name := ctx.Params.ByName("name")
+	values := make(map[string]interface{})
+	decoder := json.NewDecoder(ctx.Request.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	if name == config.DefaultProfile || !config.ValidProfileName(name) {
+		ctx.String(400, "Invalid profile name")
+		return
+	}
+	if err := config.SaveProfileSettings(name, values); err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	conf, err := config.GetProfile(name)
+	if err == nil {
+		err = initProfileBuckets(conf)
+	}
+	if err != nil {
+		profilesLog.Error(err)
+		ctx.String(500, err.Error())
+		return
+	}
+	profilesLog.Infof("Profile %s saved", name)
+	ctx.String(200, "")
+}
+
+// DeleteProfile removes a profile's settings, leaving its buckets.
+func DeleteProfile(ctx *gin.Context) {
+	if err := config.DeleteProfile(ctx.Params.ByName("name")); err != nil {
+		ctx.String(404, err.Error())
+		return
+	}
+	ctx.String(200, "")
+}
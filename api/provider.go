@@ -26,14 +26,14 @@ func ProviderGetMovie(ctx *gin.Context) {
 	log.Printf("Resolved %s to %s", tmdbId, movie.Title)
 
 	searcher := providers.NewAddonSearcher(provider)
-	torrents := searcher.SearchMovieLinks(movie)
+	torrents := searcher.SearchMovieLinks(movie, "")
 	if ctx.Query("resolve") == "true" {
 		for _, torrent := range torrents {
 			torrent.Resolve()
 		}
 	}
 	data, err := json.MarshalIndent(providerDebugResponse{
-		Payload: searcher.GetMovieSearchObject(movie),
+		Payload: searcher.GetMovieSearchObject(movie, ""),
 		Results: torrents,
 	}, "", "    ")
 	if err != nil {
@@ -62,14 +62,14 @@ func ProviderGetEpisode(ctx *gin.Context) {
 	log.Printf("Resolved %d to %s", showId, show.Name)
 
 	searcher := providers.NewAddonSearcher(provider)
-	torrents := searcher.SearchEpisodeLinks(show, episode)
+	torrents := searcher.SearchEpisodeLinks(show, episode, "")
 	if ctx.Query("resolve") == "true" {
 		for _, torrent := range torrents {
 			torrent.Resolve()
 		}
 	}
 	data, err := json.MarshalIndent(providerDebugResponse{
-		Payload: searcher.GetEpisodeSearchObject(show, episode),
+		Payload: searcher.GetEpisodeSearchObject(show, episode, ""),
 		Results: torrents,
 	}, "", "    ")
 	if err != nil {
@@ -27,37 +27,44 @@ const (
 func Routes(btService *bittorrent.BTService) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Recovery())
-	r.Use(gin.LoggerWithWriter(gin.DefaultWriter, "/torrents/list", "/notification"))
+	r.Use(gin.LoggerWithWriter(gin.DefaultWriter, "/torrents/list", "/notification", "/logs", "/metrics"))
+	r.Use(checkProfile)
+	r.Use(checkCSRF)
 
 	gin.SetMode(gin.ReleaseMode)
 
 	store := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	root := &r.RouterGroup
 
 	r.GET("/", Index)
 	r.GET("/search", Search(btService))
-	r.GET("/playtorrent", PlayTorrent)
+	r.GET("/search/history", SearchHistory)
+	action(root, "/search/history/remove", RemoveSearchHistory)
+	action(root, "/search/history/clear", ClearSearchHistory)
+	action(root, "/playtorrent", PlayTorrent)
 	r.GET("/infolabels", InfoLabelsStored(btService))
 
 	r.LoadHTMLGlob(filepath.Join(config.Get().Info.Path, "resources", "web", "*.html"))
 	web := r.Group("/web")
 	{
 		web.GET("/", func(c *gin.Context) {
-			c.HTML(http.StatusOK, "index.html", nil)
+			c.HTML(http.StatusOK, "index.html", gin.H{"csrfToken": csrfToken})
 		})
 	  web.Static("/static", filepath.Join(config.Get().Info.Path, "resources", "web", "static"))
 		web.StaticFile("/favicon.ico", filepath.Join(config.Get().Info.Path, "resources", "web", "favicon.ico"))
+		web.GET("/logs", LogsPage)
 	}
 
 	torrents := r.Group("/torrents")
 	{
 		torrents.GET("/", ListTorrents(btService))
-		torrents.GET("/add", AddTorrent(btService))
-		torrents.GET("/pause", PauseSession(btService))
-		torrents.GET("/resume", ResumeSession(btService))
-		torrents.GET("/move/:torrentId", MoveTorrent(btService))
-		torrents.GET("/pause/:torrentId", PauseTorrent(btService))
-		torrents.GET("/resume/:torrentId", ResumeTorrent(btService))
-		torrents.GET("/delete/:torrentId", RemoveTorrent(btService))
+		action(torrents, "/add", AddTorrent(btService))
+		action(torrents, "/pause", PauseSession(btService))
+		action(torrents, "/resume", ResumeSession(btService))
+		action(torrents, "/move/:torrentId", MoveTorrent(btService))
+		action(torrents, "/pause/:torrentId", PauseTorrent(btService))
+		action(torrents, "/resume/:torrentId", ResumeTorrent(btService))
+		action(torrents, "/delete/:torrentId", RemoveTorrent(btService))
 
 		// Web UI json
 		torrents.GET("/list", ListTorrentsWeb(btService))
@@ -109,11 +116,11 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 	{
 		movie.GET("/:tmdbId/infolabels", InfoLabelsMovie(btService))
 		movie.GET("/:tmdbId/links", MovieLinks(btService, false))
-		movie.GET("/:tmdbId/play", MoviePlay(btService, false))
-		movie.GET("/:tmdbId/watchlist/add", AddMovieToWatchlist)
-		movie.GET("/:tmdbId/watchlist/remove", RemoveMovieFromWatchlist)
-		movie.GET("/:tmdbId/collection/add", AddMovieToCollection)
-		movie.GET("/:tmdbId/collection/remove", RemoveMovieFromCollection)
+		action(movie, "/:tmdbId/play", MoviePlay(btService, false))
+		action(movie, "/:tmdbId/watchlist/add", AddMovieToWatchlist)
+		action(movie, "/:tmdbId/watchlist/remove", RemoveMovieFromWatchlist)
+		action(movie, "/:tmdbId/collection/add", AddMovieToCollection)
+		action(movie, "/:tmdbId/collection/remove", RemoveMovieFromCollection)
 	}
 
 	shows := r.Group("/shows")
@@ -166,12 +173,12 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 		show.GET("/:showId/season/:season/links", ShowSeasonLinks(btService, false))
 		show.GET("/:showId/season/:season/episodes", cache.Cache(store, RecentCacheExpiration), ShowEpisodes)
 		show.GET("/:showId/season/:season/episode/:episode/infolabels", InfoLabelsEpisode(btService))
-		show.GET("/:showId/season/:season/episode/:episode/play", ShowEpisodePlay(btService, false))
+		action(show, "/:showId/season/:season/episode/:episode/play", ShowEpisodePlay(btService, false))
 		show.GET("/:showId/season/:season/episode/:episode/links", ShowEpisodeLinks(btService, false))
-		show.GET("/:showId/watchlist/add", AddShowToWatchlist)
-		show.GET("/:showId/watchlist/remove", RemoveShowFromWatchlist)
-		show.GET("/:showId/collection/add", AddShowToCollection)
-		show.GET("/:showId/collection/remove", RemoveShowFromCollection)
+		action(show, "/:showId/watchlist/add", AddShowToWatchlist)
+		action(show, "/:showId/watchlist/remove", RemoveShowFromWatchlist)
+		action(show, "/:showId/collection/add", AddShowToCollection)
+		action(show, "/:showId/collection/remove", RemoveShowFromCollection)
 	}
 	// TODO
 	// episode := r.Group("/episode")
@@ -181,30 +188,41 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	library := r.Group("/library")
 	{
-		library.GET("/movie/add/:tmdbId", AddMovie)
-		library.GET("/movie/remove/:tmdbId", RemoveMovie)
-		library.GET("/movie/list/add/:listId", AddMoviesList)
-		library.GET("/movie/play/:tmdbId", PlayMovie(btService))
-		library.GET("/show/add/:tmdbId", AddShow)
-		library.GET("/show/remove/:tmdbId", RemoveShow)
-		library.GET("/show/list/add/:listId", AddShowsList)
-		library.GET("/show/play/:showId/:season/:episode", PlayShow(btService))
-
-		library.GET("/update", UpdateLibrary)
+		action(library, "/movie/add/:tmdbId", AddMovie)
+		action(library, "/movie/remove/:tmdbId", RemoveMovie)
+		action(library, "/movie/list/add/:listId", AddMoviesList)
+		action(library, "/movie/import", ImportMoviesList)
+		library.GET("/movie/import/lists", ImportedLists)
+		action(library, "/movie/play/:tmdbId", PlayMovie(btService))
+		action(library, "/show/add/:tmdbId", AddShow)
+		action(library, "/show/remove/:tmdbId", RemoveShow)
+		action(library, "/show/list/add/:listId", AddShowsList)
+		action(library, "/show/rules/:tmdbId", ShowRules)
+		action(library, "/show/play/:showId/:season/:episode", PlayShow(btService))
+		library.GET("/movie/stream/:tmdbId", MovieStream(btService))
+		library.GET("/show/stream/:showId/:season/:episode", ShowEpisodeStream(btService))
+
+		action(library, "/update", UpdateLibrary)
+		action(library, "/doctor", LibraryDoctor)
+		action(library, "/migrate", LibraryMigrate)
+		action(library, "/sync/watched", SyncWatched)
+		library.GET("/export", ExportLibrary)
+		library.POST("/import", ImportLibrary)
+		action(library, "/restore", RestoreLibrary)
 
 		// DEPRECATED
-		library.GET("/play/movie/:tmdbId", PlayMovie(btService))
-		library.GET("/play/show/:showId/season/:season/episode/:episode", PlayShow(btService))
+		action(library, "/play/movie/:tmdbId", PlayMovie(btService))
+		action(library, "/play/show/:showId/season/:season/episode/:episode", PlayShow(btService))
 	}
 
 	provider := r.Group("/provider")
 	{
 		provider.GET("/", ProviderList)
-		provider.GET("/:provider/check", ProviderCheck)
-		provider.GET("/:provider/enable", ProviderEnable)
-		provider.GET("/:provider/disable", ProviderDisable)
-		provider.GET("/:provider/failure", ProviderFailure)
-		provider.GET("/:provider/settings", ProviderSettings)
+		action(provider, "/:provider/check", ProviderCheck)
+		action(provider, "/:provider/enable", ProviderEnable)
+		action(provider, "/:provider/disable", ProviderDisable)
+		action(provider, "/:provider/failure", ProviderFailure)
+		action(provider, "/:provider/settings", ProviderSettings)
 
 		provider.GET("/:provider/movie/:tmdbId", ProviderGetMovie)
 		provider.GET("/:provider/show/:showId/season/:season/episode/:episode", ProviderGetEpisode)
@@ -212,8 +230,8 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	allproviders := r.Group("/providers")
 	{
-		allproviders.GET("/enable", ProvidersEnableAll)
-		allproviders.GET("/disable", ProvidersDisableAll)
+		action(allproviders, "/enable", ProvidersEnableAll)
+		action(allproviders, "/disable", ProvidersDisableAll)
 	}
 
 	repo := r.Group("/repository")
@@ -224,30 +242,45 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 
 	trakt := r.Group("/trakt")
 	{
-		trakt.GET("/authorize", AuthorizeTrakt)
+		action(trakt, "/authorize", AuthorizeTrakt)
 	}
 
-	r.GET("/setviewmode/:content_type", SetViewMode)
+	action(root, "/setviewmode/:content_type", SetViewMode)
 
-	r.GET("/youtube/:id", PlayYoutubeVideo)
+	action(root, "/youtube/:id", PlayYoutubeVideo)
 
 	r.GET("/subtitles", SubtitlesIndex)
 	r.GET("/subtitle/:id", SubtitleGet)
 
-	r.GET("/play", Play(btService))
-	r.GET("/playuri", PlayURI(btService))
+	action(root, "/play", Play(btService))
+	action(root, "/playuri", PlayURI(btService))
 
 	r.POST("/callbacks/:cid", providers.CallbackHandler)
 
-	r.GET("/notification", Notification(btService))
+	action(root, "/notification", Notification(btService))
+	r.GET("/events", Events)
+	r.GET("/ui/prompts", Prompts)
+	r.POST("/ui/prompts/:id", AnswerPrompt)
 
 	r.GET("/versions", Versions(btService))
 
+	r.GET("/metrics", Metrics)
+
+	r.GET("/logs", Logs)
+	r.GET("/logs/bundle", LogBundle)
+
+	r.GET("/settings", Settings)
+	r.PUT("/settings", UpdateSettings)
+
+	r.GET("/profiles", Profiles)
+	r.PUT("/profiles/:name", UpdateProfile)
+	r.DELETE("/profiles/:name", DeleteProfile)
+
 	cmd := r.Group("/cmd")
 	{
-		cmd.GET("/clear_cache", ClearCache)
-		cmd.GET("/clear_page_cache", ClearPageCache)
-		cmd.GET("/reset_clearances", ResetClearances)
+		action(cmd, "/clear_cache", ClearCache)
+		action(cmd, "/clear_page_cache", ClearPageCache)
+		action(cmd, "/reset_clearances", ResetClearances)
 	}
 
 	return r
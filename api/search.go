@@ -1,34 +1,164 @@
 package api
 
 import (
-	"fmt"
-	"strings"
+	"encoding/json"
+	"sort"
+	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/bittorrent"
-	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
+const maxSearchHistory = 50
+
 var searchLog = logging.MustGetLogger("search")
-var searchHistory []string
+
+type searchHistoryItem struct {
+	Query    string    `json:"query"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// historyItems returns past queries, most recent first.
+func historyItems(b *bolt.Bucket) []*searchHistoryItem {
+	items := make([]*searchHistoryItem, 0)
+	b.ForEach(func(k, v []byte) error {
+		var item *searchHistoryItem
+		if err := json.Unmarshal(v, &item); err == nil {
+			items = append(items, item)
+		}
+		return nil
+	})
+	sort.Sort(byLastUsed(items))
+	return items
+}
+
+type byLastUsed []*searchHistoryItem
+
+func (a byLastUsed) Len() int           { return len(a) }
+func (a byLastUsed) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byLastUsed) Less(i, j int) bool { return a[i].LastUsed.After(a[j].LastUsed) }
+
+func searchHistory(conf *config.Configuration) []string {
+	queries := make([]string, 0)
+	if DB == nil {
+		return queries
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		for _, item := range historyItems(tx.Bucket(profileBucket(conf, historyBucket))) {
+			queries = append(queries, item.Query)
+		}
+		return nil
+	})
+	return queries
+}
+
+func addSearchHistory(conf *config.Configuration, query string) {
+	if DB == nil {
+		return
+	}
+	err := DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(profileBucket(conf, historyBucket))
+		buf, err := json.Marshal(searchHistoryItem{Query: query, LastUsed: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(query), buf); err != nil {
+			return err
+		}
+		// Forget the oldest queries
+		items := historyItems(b)
+		for i := maxSearchHistory; i < len(items); i++ {
+			if err := b.Delete([]byte(items[i].Query)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		searchLog.Error(err)
+	}
+}
+
+func removeSearchHistory(conf *config.Configuration, query string) error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(profileBucket(conf, historyBucket)).Delete([]byte(query))
+	})
+}
+
+func clearSearchHistory(conf *config.Configuration) error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(profileBucket(conf, historyBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(profileBucket(conf, historyBucket))
+		return err
+	})
+}
+
+// searchQuery asks for a query, offering to reuse, edit or forget past
+// ones first.
+func searchQuery(conf *config.Configuration, heading string) string {
+	history := searchHistory(conf)
+	if len(history) > 0 && !xbmc.DialogConfirm("Quasar", "LOCALIZE[30262]") {
+		history = nil
+	}
+	for len(history) > 0 {
+		choices := append([]string{"[B]Clear search history[/B]"}, history...)
+		choice := xbmc.ListDialog("LOCALIZE[30261]", choices...)
+		if choice < 0 {
+			return ""
+		} else if choice == 0 {
+			if err := clearSearchHistory(conf); err != nil {
+				searchLog.Error(err)
+			}
+			break
+		}
+
+		query := history[choice-1]
+		switch xbmc.ListDialog(query, "Search", "Edit", "Remove from history") {
+		case 0:
+			addSearchHistory(conf, query)
+			return query
+		case 1:
+			if query = xbmc.Keyboard(query, heading); query != "" {
+				addSearchHistory(conf, query)
+			}
+			return query
+		case 2:
+			if err := removeSearchHistory(conf, query); err != nil {
+				searchLog.Error(err)
+			}
+			history = searchHistory(conf)
+		default:
+			return ""
+		}
+	}
+
+	query := xbmc.Keyboard("", heading)
+	if query != "" {
+		addSearchHistory(conf, query)
+	}
+	return query
+}
 
 func Search(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		query := ctx.Query("q")
 
-		if len(searchHistory) > 0 && xbmc.DialogConfirm("Quasar", "LOCALIZE[30262]") {
-			choice := xbmc.ListDialog("LOCALIZE[30261]", searchHistory...)
-			query = searchHistory[choice]
-		} else if query == "" {
-			query = xbmc.Keyboard("", "LOCALIZE[30209]")
-			if query == "" {
-				return
-			}
-			searchHistory = append(searchHistory, query)
+		if query == "" {
+			query = searchQuery(profileFor(ctx), "LOCALIZE[30209]")
 		}
 		if query == "" {
 			return
@@ -40,60 +170,55 @@ func Search(btService *bittorrent.BTService) gin.HandlerFunc {
 			return
 		}
 
-		searchLog.Infof("Searching providers for: %s", query)
-
-		searchers := providers.GetSearchers()
-		torrents := providers.Search(searchers, query)
+		refresh := ctx.Query("refresh") != ""
+		for {
+			torrents, cached := searchLinks(linksQuery, query, refresh, func() []*bittorrent.Torrent {
+				searchLog.Infof("Searching providers for: %s", query)
+				return providers.Search(providers.GetSearchers(), query)
+			})
 
-		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
-			return
-		}
-
-		choices := make([]string, 0, len(torrents))
-		for _, torrent := range torrents {
-			resolution := ""
-			if torrent.Resolution > 0 {
-				resolution = fmt.Sprintf("[B][COLOR %s]%s[/COLOR][/B] ", bittorrent.Colors[torrent.Resolution], bittorrent.Resolutions[torrent.Resolution])
+			if len(torrents) == 0 {
+				xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
+				return
 			}
 
-			info := make([]string, 0)
-			if torrent.Size != "" {
-				info = append(info, fmt.Sprintf("[B][%s][/B]", torrent.Size))
-			}
-			if torrent.RipType > 0 {
-				info = append(info, bittorrent.Rips[torrent.RipType])
-			}
-			if torrent.VideoCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.VideoCodec])
+			choices := linkChoices(torrents)
+			if !cached.IsZero() {
+				choices = append([]string{refreshChoice(cached)}, choices...)
 			}
-			if torrent.AudioCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.AudioCodec])
+
+			choice := xbmc.ListDialogLarge("LOCALIZE[30228]", query, choices...)
+			if !cached.IsZero() {
+				if choice == 0 {
+					refresh = true
+					continue
+				}
+				choice--
 			}
-			if torrent.Provider != "" {
-				info = append(info, fmt.Sprintf(" - [B]%s[/B]", torrent.Provider))
+			if choice >= 0 {
+				xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].URI, "links", linksParam(linksQuery, query)))
 			}
+			return
+		}
+	}
+}
 
-			multi := ""
-			if torrent.Multi {
-				multi = "\nmulti"
-			}
+func SearchHistory(ctx *gin.Context) {
+	ctx.JSON(200, searchHistory(profileFor(ctx)))
+}
 
-			label := fmt.Sprintf("%s(%d / %d) %s\n%s\n%s%s",
-				resolution,
-				torrent.Seeds,
-				torrent.Peers,
-				strings.Join(info, " "),
-				torrent.Name,
-				torrent.Icon,
-				multi,
-			)
-			choices = append(choices, label)
-		}
+func RemoveSearchHistory(ctx *gin.Context) {
+	if err := removeSearchHistory(profileFor(ctx), ctx.Query("q")); err != nil {
+		ctx.String(500, err.Error())
+		return
+	}
+	ctx.String(200, "")
+}
 
-		choice := xbmc.ListDialogLarge("LOCALIZE[30228]", query, choices...)
-		if choice >= 0 {
-			xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].URI))
-		}
+func ClearSearchHistory(ctx *gin.Context) {
+	if err := clearSearchHistory(profileFor(ctx)); err != nil {
+		ctx.String(500, err.Error())
+		return
 	}
+	ctx.String(200, "")
 }
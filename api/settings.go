@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+)
+
+var settingsLog = logging.MustGetLogger("settings")
+
+// ApplySettings applies a new configuration, returning the settings which
+// changed. It's set by main, which owns the torrent session.
+var ApplySettings func(old *config.Configuration, conf *config.Configuration) []*config.Setting
+
+type settingValue struct {
+	*config.Setting
+	Value interface{} `json:"value"`
+}
+
+// settingValues returns the settings with their value in conf, but for
+// secrets.
+func settingValues(conf *config.Configuration) []*settingValue {
+	settings := make([]*settingValue, 0, len(config.Schema))
+	for _, setting := range config.Schema {
+		value := conf.Setting(setting.Key)
+		if setting.Secret && value != "" {
+			value = config.SecretMask
+		}
+		settings = append(settings, &settingValue{Setting: setting, Value: value})
+	}
+	return settings
+}
+
+// Settings lists the settings, with their schema and current value in the
+// request's profile.
+func Settings(ctx *gin.Context) {
+	ctx.JSON(200, settingValues(profileFor(ctx)))
+}
+
+// UpdateSettings changes the settings of a JSON object of keys and values,
+// none if any is invalid, and applies them. Profiles other than the default
+// one only change their own settings.
+func UpdateSettings(ctx *gin.Context) {
+	data, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	values := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	config.DropMaskedSecrets(values)
+
+	if profile := profileFor(ctx); profile.Profile != config.DefaultProfile {
+		if err := config.SaveProfileSettings(profile.Profile, values); err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+		saved, err := config.GetProfile(profile.Profile)
+		if err != nil {
+			settingsLog.Error(err)
+			ctx.String(500, err.Error())
+			return
+		}
+		keys := make([]string, 0)
+		for _, setting := range config.Changed(profile, saved) {
+			keys = append(keys, setting.Key)
+		}
+		ctx.JSON(200, gin.H{
+			"changed":          keys,
+			"restart_required": []string{},
+		})
+		return
+	}
+
+	converted, err := config.ValidateSettings(values)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+
+	old := config.Get()
+	if err := config.SaveSettings(converted); err != nil {
+		settingsLog.Error(err)
+		ctx.String(500, err.Error())
+		return
+	}
+	changed := ApplySettings(old, config.Reload())
+
+	restart := make([]string, 0)
+	keys := make([]string, 0, len(changed))
+	for _, setting := range changed {
+		keys = append(keys, setting.Key)
+		if setting.Apply == config.ApplyRestart {
+			restart = append(restart, setting.Key)
+		}
+	}
+	ctx.JSON(200, gin.H{
+		"changed":          keys,
+		"restart_required": restart,
+	})
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Per-show library rules, overriding the global settings for a show
+//
+
+const showRulesBucket = "ShowRules"
+
+const (
+	monitorAll = iota
+	monitorLatest
+	monitorSeasons
+)
+
+const (
+	specialsDefault = iota
+	specialsInclude
+	specialsExclude
+)
+
+var monitorLabels = []string{"All seasons", "Latest season only", "Selected seasons"}
+var specialsLabels = []string{"Default", "Included", "Excluded"}
+
+type showRules struct {
+	Monitor    int      `json:"monitor"`
+	Seasons    []int    `json:"seasons,omitempty"`
+	Specials   int      `json:"specials"`
+	Resolution int      `json:"resolution"`
+	MaxSize    uint64   `json:"max_size"`
+	Providers  []string `json:"providers,omitempty"`
+}
+
+// getShowRules returns the rules of a show, the defaults if it has none.
+func getShowRules(showId string) *showRules {
+	rules := &showRules{}
+	if DB == nil {
+		return rules
+	}
+	DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(showRulesBucket)).Get([]byte(showId)); v != nil {
+			json.Unmarshal(v, rules)
+		}
+		return nil
+	})
+	return rules
+}
+
+func setShowRules(showId string, rules *showRules) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(rules)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(showRulesBucket)).Put([]byte(showId), buf)
+	})
+}
+
+func (r *showRules) includesSpecials() bool {
+	switch r.Specials {
+	case specialsInclude:
+		return true
+	case specialsExclude:
+		return false
+	}
+	return config.Get().AddSpecials
+}
+
+func latestSeason(show *tmdb.Show) (latest int) {
+	for _, season := range show.Seasons {
+		if season.EpisodeCount > 0 && season.Season > latest {
+			latest = season.Season
+		}
+	}
+	return
+}
+
+// monitors tells whether a season of the show is to be in the library.
+func (r *showRules) monitors(show *tmdb.Show, seasonNumber int) bool {
+	if seasonNumber == 0 {
+		return r.includesSpecials()
+	}
+	switch r.Monitor {
+	case monitorLatest:
+		return seasonNumber == latestSeason(show)
+	case monitorSeasons:
+		return intInSlice(seasonNumber, r.Seasons)
+	}
+	return true
+}
+
+// prefer orders links for automatic choices: links over the size cap go
+// last, then links at the preferred resolution and from preferred
+// providers come first, the providers' ranking deciding otherwise.
+func (r *showRules) prefer(torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	rank := func(torrent *bittorrent.Torrent) (rank int) {
+		if r.MaxSize > 0 {
+			if size, err := humanize.ParseBytes(torrent.Size); err == nil && size > r.MaxSize {
+				rank += 4
+			}
+		}
+		if r.Resolution > 0 && torrent.Resolution != r.Resolution {
+			rank += 2
+		}
+		if len(r.Providers) > 0 {
+			preferred := false
+			for _, provider := range strings.Split(torrent.Provider, ", ") {
+				if stringInSlice(provider, r.Providers) {
+					preferred = true
+					break
+				}
+			}
+			if !preferred {
+				rank += 1
+			}
+		}
+		return
+	}
+	ranked := &byRank{torrents: torrents, ranks: make([]int, len(torrents))}
+	for i, torrent := range torrents {
+		ranked.ranks[i] = rank(torrent)
+	}
+	sort.Stable(ranked)
+	return torrents
+}
+
+type byRank struct {
+	torrents []*bittorrent.Torrent
+	ranks    []int
+}
+
+func (a *byRank) Len() int           { return len(a.torrents) }
+func (a *byRank) Less(i, j int) bool { return a.ranks[i] < a.ranks[j] }
+func (a *byRank) Swap(i, j int) {
+	a.torrents[i], a.torrents[j] = a.torrents[j], a.torrents[i]
+	a.ranks[i], a.ranks[j] = a.ranks[j], a.ranks[i]
+}
+
+func (r *showRules) labels() []string {
+	monitor := monitorLabels[r.Monitor]
+	if r.Monitor == monitorSeasons {
+		seasons := make([]string, 0, len(r.Seasons))
+		for _, season := range r.Seasons {
+			seasons = append(seasons, strconv.Itoa(season))
+		}
+		monitor = fmt.Sprintf("Seasons %s", strings.Join(seasons, ", "))
+	}
+	resolution := "Any"
+	if r.Resolution > 0 {
+		resolution = bittorrent.Resolutions[r.Resolution]
+	}
+	maxSize := "None"
+	if r.MaxSize > 0 {
+		maxSize = humanize.Bytes(r.MaxSize)
+	}
+	preferredProviders := "Any"
+	if len(r.Providers) > 0 {
+		preferredProviders = strings.Join(r.Providers, ", ")
+	}
+	return []string{
+		fmt.Sprintf("Monitored seasons: [B]%s[/B]", monitor),
+		fmt.Sprintf("Specials: [B]%s[/B]", specialsLabels[r.Specials]),
+		fmt.Sprintf("Preferred resolution: [B]%s[/B]", resolution),
+		fmt.Sprintf("Size cap: [B]%s[/B]", maxSize),
+		fmt.Sprintf("Preferred providers: [B]%s[/B]", preferredProviders),
+	}
+}
+
+func chooseSeasons(show *tmdb.Show, selected []int) []int {
+	seasons := make([]int, 0)
+	for _, season := range show.Seasons {
+		if season.Season > 0 && season.EpisodeCount > 0 {
+			seasons = append(seasons, season.Season)
+		}
+	}
+	for {
+		choices := make([]string, 0, len(seasons)+1)
+		choices = append(choices, "[B]Done[/B]")
+		for _, season := range seasons {
+			mark := "[ ]"
+			if intInSlice(season, selected) {
+				mark = "[X]"
+			}
+			choices = append(choices, fmt.Sprintf("%s Season %d", mark, season))
+		}
+		choice := xbmc.ListDialog("Monitored seasons", choices...)
+		if choice <= 0 {
+			return selected
+		}
+		season := seasons[choice-1]
+		if intInSlice(season, selected) {
+			kept := make([]int, 0, len(selected))
+			for _, s := range selected {
+				if s != season {
+					kept = append(kept, s)
+				}
+			}
+			selected = kept
+		} else {
+			selected = append(selected, season)
+			sort.Ints(selected)
+		}
+	}
+}
+
+func chooseProviders(selected []string) []string {
+	names := make([]string, 0)
+	for _, addon := range xbmc.GetAddons("xbmc.python.script", "executable", "all", []string{"name", "enabled"}).Addons {
+		if strings.HasPrefix(addon.ID, "script.quasar.") {
+			names = append(names, addon.Name)
+		}
+	}
+	for {
+		choices := make([]string, 0, len(names)+1)
+		choices = append(choices, "[B]Done[/B]")
+		for _, name := range names {
+			mark := "[ ]"
+			if stringInSlice(name, selected) {
+				mark = "[X]"
+			}
+			choices = append(choices, fmt.Sprintf("%s %s", mark, name))
+		}
+		choice := xbmc.ListDialog("Preferred providers", choices...)
+		if choice <= 0 {
+			return selected
+		}
+		name := names[choice-1]
+		if stringInSlice(name, selected) {
+			kept := make([]string, 0, len(selected))
+			for _, s := range selected {
+				if s != name {
+					kept = append(kept, s)
+				}
+			}
+			selected = kept
+		} else {
+			selected = append(selected, name)
+		}
+	}
+}
+
+// ShowRules lets the user edit the library rules of a show.
+func ShowRules(ctx *gin.Context) {
+	showId := ctx.Params.ByName("tmdbId")
+	show := tmdb.GetShowById(showId, config.Get().Language)
+	if show == nil {
+		ctx.String(404, "Unable to find show")
+		return
+	}
+
+	rules := getShowRules(showId)
+	changed := false
+	for {
+		choice := xbmc.ListDialog(show.Name, rules.labels()...)
+		if choice < 0 {
+			break
+		}
+		switch choice {
+		case 0:
+			monitor := xbmc.ListDialog("Monitored seasons", monitorLabels...)
+			if monitor < 0 {
+				continue
+			}
+			rules.Monitor = monitor
+			if monitor == monitorSeasons {
+				rules.Seasons = chooseSeasons(show, rules.Seasons)
+			}
+		case 1:
+			specials := xbmc.ListDialog("Specials", specialsLabels...)
+			if specials < 0 {
+				continue
+			}
+			rules.Specials = specials
+		case 2:
+			resolutions := append([]string{"Any"}, bittorrent.Resolutions[1:]...)
+			resolution := xbmc.ListDialog("Preferred resolution", resolutions...)
+			if resolution < 0 {
+				continue
+			}
+			rules.Resolution = resolution
+		case 3:
+			current := ""
+			if rules.MaxSize > 0 {
+				current = humanize.Bytes(rules.MaxSize)
+			}
+			value := xbmc.Keyboard(current, "Size cap, e.g. 2 GB, empty for none")
+			if value == "" {
+				rules.MaxSize = 0
+			} else if size, err := humanize.ParseBytes(value); err == nil {
+				rules.MaxSize = size
+			} else {
+				xbmc.Notify("Quasar", fmt.Sprintf("Invalid size %s", value), config.AddonIcon())
+				continue
+			}
+		case 4:
+			rules.Providers = chooseProviders(rules.Providers)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+	if err := setShowRules(showId, rules); err != nil {
+		libraryLog.Error(err)
+		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+		return
+	}
+	// Monitored seasons may have changed, make sure they're all there
+	if profiles := profilesWith(showId, Show); len(profiles) > 0 {
+		if _, err := writeShowStrm(profiles[0], showId, false); err != nil {
+			libraryLog.Error(err)
+		}
+	}
+	ctx.String(200, "")
+}
+
+// showRulesAction is the context menu action of show items in the library.
+func showRulesAction(showId int) []string {
+	return []string{"Library rules", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/rules/%d", showId))}
+}
+
+// preferredEpisodeLinks orders the links of an episode for an automatic
+// choice according to its show's rules.
+func preferredEpisodeLinks(showId string, torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	sorted := make([]*bittorrent.Torrent, len(torrents))
+	copy(sorted, torrents)
+	return demoteDeadLinks(getShowRules(showId).prefer(sorted))
+}
@@ -1,22 +1,21 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"errors"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/scakemyer/quasar/bittorrent"
-	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/config"
-	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/providers"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-
 func TVIndex(ctx *gin.Context) {
 	items := xbmc.ListItems{
 		{Label: "LOCALIZE[30056]", Path: UrlForXBMC("/shows/trakt/"), Thumbnail: config.AddonResource("img", "trakt.png")},
@@ -91,7 +90,7 @@ func TVTrakt(ctx *gin.Context) {
 func TVTraktLists(ctx *gin.Context) {
 	items := xbmc.ListItems{}
 
-	for _, list := range trakt.Userlists() {
+	for _, list := range trakt.Userlists(profileFor(ctx)) {
 		item := &xbmc.ListItem{
 			Label: list.Name,
 			Path:  UrlForXBMC("/shows/trakt/lists/id/%d", list.IDs.Trakt),
@@ -119,6 +118,7 @@ func CalendarShows(ctx *gin.Context) {
 }
 
 func renderShows(ctx *gin.Context, shows tmdb.Shows, page int, total int, query string) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -149,18 +149,18 @@ func renderShows(ctx *gin.Context, shows tmdb.Shows, page int, total int, query
 
 		tmdbId := strconv.Itoa(show.Id)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d", show.Id))}
-		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(tmdbId, Show) {
+		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Show) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/remove/%d", show.Id))}
 		}
 		mergeAction := []string{"LOCALIZE[30283]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d?merge=true", show.Id))}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/add", show.Id))}
-		if inShowsWatchlist(show.Id) {
+		if inShowsWatchlist(conf, show.Id) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/remove", show.Id))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/add", show.Id))}
-		if inShowsCollection(show.Id) {
+		if inShowsCollection(conf, show.Id) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/remove", show.Id))}
 		}
 
@@ -171,6 +171,9 @@ func renderShows(ctx *gin.Context, shows tmdb.Shows, page int, total int, query
 			collectionAction,
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/tvshows"))},
 		}
+		if isAddedToLibrary(conf, tmdbId, Show) {
+			item.ContextMenu = append(item.ContextMenu, showRulesAction(show.Id))
+		}
 		if config.Get().Platform.Kodi < 17 {
 			item.ContextMenu = append(item.ContextMenu, []string{"LOCALIZE[30203]", "XBMC.Action(Info)"})
 		}
@@ -238,16 +241,7 @@ func SearchShows(ctx *gin.Context) {
 	ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 	query := ctx.Query("q")
 	if query == "" {
-		if len(searchHistory) > 0 && xbmc.DialogConfirm("Quasar", "LOCALIZE[30262]") {
-			choice := xbmc.ListDialog("LOCALIZE[30261]", searchHistory...)
-			query = searchHistory[choice]
-		} else {
-			query = xbmc.Keyboard("", "LOCALIZE[30201]")
-			if query == "" {
-				return
-			}
-			searchHistory = append(searchHistory, query)
-		}
+		query = searchQuery(profileFor(ctx), "LOCALIZE[30201]")
 	}
 	if query == "" {
 		return
@@ -365,7 +359,7 @@ func showSeasonLinks(showId int, seasonNumber int) ([]*bittorrent.Torrent, error
 
 	searchers := providers.GetSeasonSearchers()
 	if len(searchers) == 0 {
-		xbmc.Notify("Quasar", "LOCALIZE[30204]", config.AddonIcon())
+		ui.Get().Notify("Quasar", "LOCALIZE[30204]")
 	}
 
 	return providers.SearchSeason(searchers, show, season), nil
@@ -373,6 +367,7 @@ func showSeasonLinks(showId int, seasonNumber int) ([]*bittorrent.Torrent, error
 
 func ShowSeasonLinks(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		dialogs := uiFor(ctx)
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
 		showId, _ := strconv.Atoi(ctx.Params.ByName("showId"))
@@ -398,7 +393,7 @@ func ShowSeasonLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 		longName := fmt.Sprintf("%s Season %02d", show.Name, seasonNumber)
 
 		existingTorrent := ExistingTorrent(btService, longName)
-		if existingTorrent != "" && xbmc.DialogConfirm("Quasar", "LOCALIZE[30270]") {
+		if existingTorrent != "" && dialogs.Confirm("Quasar", "LOCALIZE[30270]") {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", existingTorrent,
 				                     "tmdb", strconv.Itoa(season.Id),
@@ -407,12 +402,12 @@ func ShowSeasonLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		if torrents := InTorrentsMap(strconv.Itoa(season.Id)); len(torrents) > 0 {
+		if torrents := InTorrentsMap(dialogs, strconv.Itoa(season.Id)); len(torrents) > 0 {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", torrents[0].URI,
 				                     "tmdb", strconv.Itoa(season.Id),
@@ -421,74 +416,54 @@ func ShowSeasonLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		torrents, err := showSeasonLinks(showId, seasonNumber)
-		if err != nil {
-			ctx.Error(err)
-			return
-		}
-
-		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
-			return
-		}
-
-		choices := make([]string, 0, len(torrents))
-		for _, torrent := range torrents {
-			resolution := ""
-			if torrent.Resolution > 0 {
-				resolution = fmt.Sprintf("[B][COLOR %s]%s[/COLOR][/B] ", bittorrent.Colors[torrent.Resolution], bittorrent.Resolutions[torrent.Resolution])
+		refresh := ctx.Query("refresh") != ""
+		for {
+			var searchErr error
+			torrents, cached := searchLinks(linksSeason, strconv.Itoa(season.Id), refresh, func() []*bittorrent.Torrent {
+				torrents, err := showSeasonLinks(showId, seasonNumber)
+				searchErr = err
+				return torrents
+			})
+			if searchErr != nil {
+				ctx.Error(searchErr)
+				return
 			}
 
-			info := make([]string, 0)
-			if torrent.Size != "" {
-				info = append(info, fmt.Sprintf("[B][%s][/B]", torrent.Size))
-			}
-			if torrent.RipType > 0 {
-				info = append(info, bittorrent.Rips[torrent.RipType])
-			}
-			if torrent.VideoCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.VideoCodec])
-			}
-			if torrent.AudioCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.AudioCodec])
-			}
-			if torrent.Provider != "" {
-				info = append(info, fmt.Sprintf(" - [B]%s[/B]", torrent.Provider))
+			if len(torrents) == 0 {
+				dialogs.Notify("Quasar", "LOCALIZE[30205]")
+				return
 			}
 
-			multi := ""
-			if torrent.Multi {
-				multi = "\nmulti"
+			choices := linkChoices(torrents)
+			if !cached.IsZero() {
+				choices = append([]string{refreshChoice(cached)}, choices...)
 			}
 
-			label := fmt.Sprintf("%s(%d / %d) %s\n%s\n%s%s",
-				resolution,
-				torrent.Seeds,
-				torrent.Peers,
-				strings.Join(info, " "),
-				torrent.Name,
-				torrent.Icon,
-				multi,
-			)
-			choices = append(choices, label)
-		}
-
-		choice := xbmc.ListDialogLarge("LOCALIZE[30228]", longName, choices...)
-		if choice >= 0 {
-			AddToTorrentsMap(strconv.Itoa(season.Id), torrents[choice])
+			choice := dialogs.Choose("LOCALIZE[30228]", longName, choices...)
+			if !cached.IsZero() {
+				if choice == 0 {
+					refresh = true
+					continue
+				}
+				choice--
+			}
+			if choice >= 0 {
+				AddToTorrentsMap(strconv.Itoa(season.Id), torrents[choice])
 
-			rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].URI)
+				rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].URI)
 
-			if external != "" {
-				xbmc.PlayURL(rUrl)
-			} else {
-				ctx.Redirect(302, rUrl)
+				if external != "" {
+					xbmc.PlayURL(rUrl)
+				} else {
+					ctx.Redirect(302, uiQuery(ctx, rUrl))
+				}
 			}
+			return
 		}
 	}
 }
@@ -512,14 +487,22 @@ func showEpisodeLinks(showId int, seasonNumber int, episodeNumber int) ([]*bitto
 
 	searchers := providers.GetEpisodeSearchers()
 	if len(searchers) == 0 {
-		xbmc.Notify("Quasar", "LOCALIZE[30204]", config.AddonIcon())
+		ui.Get().Notify("Quasar", "LOCALIZE[30204]")
 	}
 
 	return providers.SearchEpisode(searchers, show, episode), nil
 }
 
+func episodeRuntime(show *tmdb.Show) string {
+	if len(show.EpisodeRunTime) == 0 {
+		return ""
+	}
+	return strconv.Itoa(show.EpisodeRunTime[len(show.EpisodeRunTime)-1])
+}
+
 func ShowEpisodeLinks(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		dialogs := uiFor(ctx)
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
 		tmdbId := ctx.Params.ByName("showId")
@@ -545,9 +528,17 @@ func ShowEpisodeLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Han
 		}
 
 		longName := fmt.Sprintf("%s S%02dE%02d", show.Name, seasonNumber, episodeNumber)
+		if rUrl := grabbedPlayURL(btService, showId, seasonNumber, episodeNumber, library); rUrl != "" {
+			if external != "" {
+				xbmc.PlayURL(rUrl)
+			} else {
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
+			}
+			return
+		}
 
 		existingTorrent := ExistingTorrent(btService, longName)
-		if existingTorrent != "" && xbmc.DialogConfirm("Quasar", "LOCALIZE[30270]") {
+		if existingTorrent != "" && dialogs.Confirm("Quasar", "LOCALIZE[30270]") {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", existingTorrent,
 				                     "tmdb", strconv.Itoa(episode.Id),
@@ -559,12 +550,12 @@ func ShowEpisodeLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Han
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		if torrents := InTorrentsMap(strconv.Itoa(episode.Id)); len(torrents) > 0 {
+		if torrents := InTorrentsMap(dialogs, strconv.Itoa(episode.Id)); len(torrents) > 0 {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", torrents[0].URI,
 				                     "tmdb", strconv.Itoa(episode.Id),
@@ -572,91 +563,75 @@ func ShowEpisodeLinks(btService *bittorrent.BTService, fromLibrary bool) gin.Han
 				                     "season", ctx.Params.ByName("season"),
 				                     "episode", ctx.Params.ByName("episode"),
 				                     "library", library,
+				"links", linksParam(linksEpisode, strconv.Itoa(episode.Id)),
+				"runtime", episodeRuntime(show),
 				                     "type", "episode")
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		torrents, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
-		if err != nil {
-			ctx.Error(err)
-			return
-		}
-
-		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
-			return
-		}
-
-		choices := make([]string, 0, len(torrents))
-		for _, torrent := range torrents {
-			resolution := ""
-			if torrent.Resolution > 0 {
-				resolution = fmt.Sprintf("[B][COLOR %s]%s[/COLOR][/B] ", bittorrent.Colors[torrent.Resolution], bittorrent.Resolutions[torrent.Resolution])
+		refresh := ctx.Query("refresh") != ""
+		for {
+			var searchErr error
+			torrents, cached := searchLinks(linksEpisode, strconv.Itoa(episode.Id), refresh, func() []*bittorrent.Torrent {
+				torrents, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
+				searchErr = err
+				return torrents
+			})
+			if searchErr != nil {
+				ctx.Error(searchErr)
+				return
 			}
 
-			info := make([]string, 0)
-			if torrent.Size != "" {
-				info = append(info, fmt.Sprintf("[B][%s][/B]", torrent.Size))
-			}
-			if torrent.RipType > 0 {
-				info = append(info, bittorrent.Rips[torrent.RipType])
-			}
-			if torrent.VideoCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.VideoCodec])
-			}
-			if torrent.AudioCodec > 0 {
-				info = append(info, bittorrent.Codecs[torrent.AudioCodec])
-			}
-			if torrent.Provider != "" {
-				info = append(info, fmt.Sprintf(" - [B]%s[/B]", torrent.Provider))
+			if len(torrents) == 0 {
+				dialogs.Notify("Quasar", "LOCALIZE[30205]")
+				return
 			}
 
-			multi := ""
-			if torrent.Multi {
-				multi = "\nmulti"
+			choices := linkChoices(torrents)
+			if !cached.IsZero() {
+				choices = append([]string{refreshChoice(cached)}, choices...)
 			}
 
-			label := fmt.Sprintf("%s(%d / %d) %s\n%s\n%s%s",
-				resolution,
-				torrent.Seeds,
-				torrent.Peers,
-				strings.Join(info, " "),
-				torrent.Name,
-				torrent.Icon,
-				multi,
-			)
-			choices = append(choices, label)
-		}
-
-		choice := xbmc.ListDialogLarge("LOCALIZE[30228]", longName, choices...)
-		if choice >= 0 {
-			AddToTorrentsMap(strconv.Itoa(episode.Id), torrents[choice])
-
-			rUrl := UrlQuery(
-				UrlForXBMC("/play"), "uri", torrents[choice].URI,
-				                     "tmdb", strconv.Itoa(episode.Id),
-				                     "show", tmdbId,
-				                     "season", ctx.Params.ByName("season"),
-				                     "episode", ctx.Params.ByName("episode"),
-				                     "library", library,
-				                     "type", "episode")
-			if external != "" {
-				xbmc.PlayURL(rUrl)
-			} else {
-				ctx.Redirect(302, rUrl)
+			choice := dialogs.Choose("LOCALIZE[30228]", longName, choices...)
+			if !cached.IsZero() {
+				if choice == 0 {
+					refresh = true
+					continue
+				}
+				choice--
+			}
+			if choice >= 0 {
+				AddToTorrentsMap(strconv.Itoa(episode.Id), torrents[choice])
+
+				rUrl := UrlQuery(
+					UrlForXBMC("/play"), "uri", torrents[choice].URI,
+					"tmdb", strconv.Itoa(episode.Id),
+					"show", tmdbId,
+					"season", ctx.Params.ByName("season"),
+					"episode", ctx.Params.ByName("episode"),
+					"library", library,
+					"links", linksParam(linksEpisode, strconv.Itoa(episode.Id)),
+					"runtime", episodeRuntime(show),
+					"type", "episode")
+				if external != "" {
+					xbmc.PlayURL(rUrl)
+				} else {
+					ctx.Redirect(302, uiQuery(ctx, rUrl))
+				}
 			}
+			return
 		}
 	}
 }
 
 func ShowEpisodePlay(btService *bittorrent.BTService, fromLibrary bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		dialogs := uiFor(ctx)
 
 		tmdbId := ctx.Params.ByName("showId")
 		showId, _ := strconv.Atoi(tmdbId)
@@ -681,8 +656,16 @@ func ShowEpisodePlay(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 		}
 
 		longName := fmt.Sprintf("%s S%02dE%02d", show.Name, seasonNumber, episodeNumber)
+		if rUrl := grabbedPlayURL(btService, showId, seasonNumber, episodeNumber, library); rUrl != "" {
+			if external != "" {
+				xbmc.PlayURL(rUrl)
+			} else {
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
+			}
+			return
+		}
 		existingTorrent := ExistingTorrent(btService, longName)
-		if existingTorrent != "" && xbmc.DialogConfirm("Quasar", "LOCALIZE[30270]") {
+		if existingTorrent != "" && dialogs.Confirm("Quasar", "LOCALIZE[30270]") {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", existingTorrent,
 				                     "tmdb", strconv.Itoa(episode.Id),
@@ -694,12 +677,12 @@ func ShowEpisodePlay(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		if torrents := InTorrentsMap(strconv.Itoa(episode.Id)); len(torrents) > 0 {
+		if torrents := InTorrentsMap(dialogs, strconv.Itoa(episode.Id)); len(torrents) > 0 {
 			rUrl := UrlQuery(
 				UrlForXBMC("/play"), "uri", torrents[0].URI,
 				                     "tmdb", strconv.Itoa(episode.Id),
@@ -707,26 +690,34 @@ func ShowEpisodePlay(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 				                     "season", ctx.Params.ByName("season"),
 				                     "episode", ctx.Params.ByName("episode"),
 				                     "library", library,
+				"links", linksParam(linksEpisode, strconv.Itoa(episode.Id)),
+				"runtime", episodeRuntime(show),
 				                     "type", "episode")
 			if external != "" {
 				xbmc.PlayURL(rUrl)
 			} else {
-				ctx.Redirect(302, rUrl)
+				ctx.Redirect(302, uiQuery(ctx, rUrl))
 			}
 			return
 		}
 
-		torrents, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
-		if err != nil {
-			ctx.Error(err)
+		var searchErr error
+		torrents, _ := searchLinks(linksEpisode, strconv.Itoa(episode.Id), false, func() []*bittorrent.Torrent {
+			torrents, err := showEpisodeLinks(showId, seasonNumber, episodeNumber)
+			searchErr = err
+			return torrents
+		})
+		if searchErr != nil {
+			ctx.Error(searchErr)
 			return
 		}
 
 		if len(torrents) == 0 {
-			xbmc.Notify("Quasar", "LOCALIZE[30205]", config.AddonIcon())
+			dialogs.Notify("Quasar", "LOCALIZE[30205]")
 			return
 		}
 
+		torrents = preferredEpisodeLinks(tmdbId, torrents)
 		AddToTorrentsMap(strconv.Itoa(episode.Id), torrents[0])
 
 		rUrl := UrlQuery(
@@ -736,11 +727,13 @@ func ShowEpisodePlay(btService *bittorrent.BTService, fromLibrary bool) gin.Hand
 			                     "season", ctx.Params.ByName("season"),
 			                     "episode", ctx.Params.ByName("episode"),
 			                     "library", library,
+			"links", linksParam(linksEpisode, strconv.Itoa(episode.Id)),
+			"runtime", episodeRuntime(show),
 			                     "type", "episode")
 		if external != "" {
 			xbmc.PlayURL(rUrl)
 		} else {
-			ctx.Redirect(302, rUrl)
+			ctx.Redirect(302, uiQuery(ctx, rUrl))
 		}
 	}
 }
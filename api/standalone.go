@@ -0,0 +1,292 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/providers"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/util"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Standalone library, with .strm files pointing at HTTP streams so media
+// servers other than Kodi can play them
+//
+
+const (
+	LibraryModeKodi = iota
+	LibraryModeStandalone
+)
+
+var streamLog = logging.MustGetLogger("stream")
+
+// How long a stream request waits for the torrent's metadata and first
+// piece, players giving up on slow servers anyway
+const streamMaxWait = 3 * time.Minute
+
+// kodiLibrary tells whether Kodi is the one reading the library.
+func kodiLibrary() bool {
+	return config.Get().LibraryMode != LibraryModeStandalone
+}
+
+// streamHost is where media servers reach Quasar, which may not be the
+// machine they run on.
+func streamHost() string {
+	if host := strings.TrimRight(config.Get().LibraryStreamHost, "/"); host != "" {
+		return host
+	}
+	return util.GetHTTPHost()
+}
+
+// streamURL returns where media servers get a stream, with the stream
+// token if set, never an API token, as .strm files are read by anyone with
+// access to the library.
+func streamURL(path string) string {
+	if token := config.Get().LibraryStreamToken; token != "" {
+		return UrlQuery(streamHost()+path, streamField, token)
+	}
+	return streamHost() + path
+}
+
+func movieLibraryURL(tmdbId string) string {
+	if kodiLibrary() {
+		return UrlForXBMC("/library/movie/play/%s", tmdbId)
+	}
+	return streamURL(fmt.Sprintf("/library/movie/stream/%s", tmdbId))
+}
+
+func episodeLibraryURL(showId string, seasonNumber int, episodeNumber int) string {
+	if kodiLibrary() {
+		return UrlForXBMC("/library/show/play/%s/%d/%d", showId, seasonNumber, episodeNumber)
+	}
+	return streamURL(fmt.Sprintf("/library/show/stream/%s/%d/%d", showId, seasonNumber, episodeNumber))
+}
+
+// libraryScan and libraryClean only bother Kodi when it's reading the
+// library.
+func libraryScan() {
+	if !kodiLibrary() {
+		scanning = false
+		return
+	}
+	xbmc.VideoLibraryScan()
+}
+
+func libraryClean() {
+	if !kodiLibrary() {
+		return
+	}
+	xbmc.VideoLibraryClean()
+}
+
+// streamItem is what a stream request is for.
+type streamItem struct {
+	Type    string
+	ID      int
+	ShowID  int
+	Season  int
+	Episode int
+}
+
+func (s *streamItem) String() string {
+	if s.Type == "movie" {
+		return fmt.Sprintf("movie %d", s.ID)
+	}
+	return fmt.Sprintf("S%02dE%02d of show %d", s.Season, s.Episode, s.ShowID)
+}
+
+func (s *streamItem) tracked() string {
+	return activeTorrent(func(item *bittorrent.DBItem) bool {
+		if s.Type == "movie" {
+			return item.Type == "movie" && item.ID == s.ID
+		}
+		return item.Type == "episode" && item.ShowID == s.ShowID && item.Season == s.Season && item.Episode == s.Episode
+	})
+}
+
+// chooseFile picks the episode's file when its name says which it is, the
+// biggest file otherwise.
+func (s *streamItem) chooseFile(torrentInfo libtorrent.TorrentInfo) int {
+	files := torrentInfo.Files()
+	numFiles := torrentInfo.NumFiles()
+	var episodePattern *regexp.Regexp
+	if s.Type == "episode" {
+		episodePattern = regexp.MustCompile(fmt.Sprintf("(?i)(^|\\W)S0*?%dE0*?%d\\W", s.Season, s.Episode))
+	}
+	biggestFile := 0
+	maxSize := int64(0)
+	for i := 0; i < numFiles; i++ {
+		if episodePattern != nil && episodePattern.MatchString(filepath.Base(files.FilePath(i))) {
+			return i
+		}
+		if size := files.FileSize(i); size > maxSize {
+			maxSize = size
+			biggestFile = i
+		}
+	}
+	return biggestFile
+}
+
+// addStreamTorrent adds the first of the links which can be.
+func addStreamTorrent(btService *bittorrent.BTService, torrents []*bittorrent.Torrent) (string, error) {
+	for _, torrent := range torrents {
+		infoHash, err := addTorrent(btService, torrent.URI)
+		if err != nil {
+			streamLog.Warningf("Unable to add %s: %s", torrent.Name, err)
+			continue
+		}
+		streamLog.Noticef("Streaming %s", torrent.Name)
+		return infoHash, nil
+	}
+	return "", fmt.Errorf("None of %d links could be added", len(torrents))
+}
+
+// streamFile waits for the torrent's metadata to choose the file to
+// stream, downloads it in order, and returns its path once on disk.
+func streamFile(btService *bittorrent.BTService, infoHash string, item *streamItem) (string, error) {
+	timeout := time.After(streamMaxWait)
+	oneSecond := time.NewTicker(1 * time.Second)
+	defer oneSecond.Stop()
+
+	chosenFile := -1
+	for {
+		index := sessionIndex(btService, infoHash)
+		if index < 0 {
+			return "", errors.New("Torrent is gone from the session")
+		}
+		torrentHandle := btService.Session.GetHandle().GetTorrents().Get(index)
+		status := torrentHandle.Status()
+		if status.GetHasMetadata() {
+			torrentInfo := torrentHandle.TorrentFile()
+			if chosenFile < 0 {
+				chosenFile = item.chooseFile(torrentInfo)
+				filesPriorities := libtorrent.NewStdVectorInt()
+				for i := 0; i < torrentInfo.NumFiles(); i++ {
+					if i == chosenFile {
+						filesPriorities.Add(4)
+					} else {
+						filesPriorities.Add(0)
+					}
+				}
+				torrentHandle.PrioritizeFiles(filesPriorities)
+				libtorrent.DeleteStdVectorInt(filesPriorities)
+				torrentHandle.SetSequentialDownload(true)
+
+				err := btService.UpdateDB(bittorrent.Update, infoHash, item.ID, item.Type, chosenFile, item.ShowID, item.Season, item.Episode)
+				if err != nil {
+					streamLog.Error(err)
+				}
+			}
+			filePath := torrentInfo.Files().FilePath(chosenFile)
+			if _, err := os.Stat(filepath.Join(config.Get().DownloadPath, filePath)); err == nil {
+				return filePath, nil
+			}
+		}
+
+		select {
+		case <-timeout:
+			return "", fmt.Errorf("Nothing to stream after %s", streamMaxWait)
+		case <-oneSecond.C:
+		}
+	}
+}
+
+func stream(btService *bittorrent.BTService, ctx *gin.Context, item *streamItem, links func() []*bittorrent.Torrent) {
+	if config.Get().DownloadPath == "." {
+		ctx.String(503, "Download path empty")
+		return
+	}
+
+	infoHash := item.tracked()
+	if sessionIndex(btService, infoHash) < 0 {
+		torrents := links()
+		if len(torrents) == 0 {
+			ctx.String(404, fmt.Sprintf("No links found for %s", item))
+			return
+		}
+		var err error
+		if infoHash, err = addStreamTorrent(btService, torrents); err != nil {
+			streamLog.Error(err)
+			ctx.String(502, err.Error())
+			return
+		}
+	}
+
+	filePath, err := streamFile(btService, infoHash, item)
+	if err != nil {
+		streamLog.Warningf("Unable to stream %s: %s", item, err)
+		ctx.String(504, err.Error())
+		return
+	}
+	// Players following the redirect stream from the torrent's file server
+	rUrl, _ := url.Parse(fmt.Sprintf("%s/files/%s", streamHost(), filePath))
+	if token := ctx.Query(streamField); token != "" {
+		rUrl.RawQuery = url.Values{streamField: {token}}.Encode()
+	}
+	ctx.Redirect(302, rUrl.String())
+}
+
+// MovieStream is what the .strm files of a standalone library point at.
+func MovieStream(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tmdbId := ctx.Params.ByName("tmdbId")
+		Id, err := strconv.Atoi(tmdbId)
+		if err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+		item := &streamItem{Type: "movie", ID: Id}
+		stream(btService, ctx, item, func() []*bittorrent.Torrent {
+			torrents, _ := searchLinks(linksMovie, tmdbId, false, func() []*bittorrent.Torrent {
+				return movieLinks(tmdbId)
+			})
+			sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+			providers.SortByScore(torrents)
+			return demoteDeadLinks(torrents)
+		})
+	}
+}
+
+func ShowEpisodeStream(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		showId := ctx.Params.ByName("showId")
+		Id, err := strconv.Atoi(showId)
+		if err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+		seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+		episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+		episode := tmdb.GetEpisode(Id, seasonNumber, episodeNumber, config.Get().Language)
+		if episode == nil {
+			ctx.String(404, "Unable to find episode")
+			return
+		}
+		item := &streamItem{Type: "episode", ID: episode.Id, ShowID: Id, Season: seasonNumber, Episode: episodeNumber}
+		stream(btService, ctx, item, func() []*bittorrent.Torrent {
+			torrents, _ := searchLinks(linksEpisode, strconv.Itoa(episode.Id), false, func() []*bittorrent.Torrent {
+				torrents, err := showEpisodeLinks(Id, seasonNumber, episodeNumber)
+				if err != nil {
+					streamLog.Warning(err)
+				}
+				return torrents
+			})
+			return preferredEpisodeLinks(showId, torrents)
+		})
+	}
+}
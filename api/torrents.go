@@ -1,24 +1,25 @@
 package api
 
 import (
-	"os"
-	"fmt"
-	"time"
+	"encoding/hex"
 	"errors"
-	"strings"
-	"strconv"
-	"unicode"
+	"fmt"
 	"io/ioutil"
-	"encoding/hex"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
-	"github.com/op/go-logging"
-	"github.com/gin-gonic/gin"
-	"github.com/dustin/go-humanize"
 	"github.com/cloudflare/ahocorasick"
+	"github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/op/go-logging"
 	"github.com/scakemyer/libtorrent-go"
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 	"github.com/zeebo/bencode"
@@ -44,36 +45,20 @@ type TorrentsWeb struct {
 	PeersTotal   int     `json:"peers_total"`
 }
 
-type TorrentMap struct {
-	tmdbId  string
-	torrent *bittorrent.Torrent
-}
-var TorrentsMap []*TorrentMap
-
+// AddToTorrentsMap remembers the link chosen for a media, so it's offered
+// again next time, even after a restart.
 func AddToTorrentsMap(tmdbId string, torrent *bittorrent.Torrent) {
-	inTorrentsMap := false
-	for _, torrentMap := range TorrentsMap {
-		if tmdbId == torrentMap.tmdbId {
-			inTorrentsMap = true
-		}
-	}
-	if inTorrentsMap == false {
-		torrentMap := &TorrentMap{
-			tmdbId: tmdbId,
-			torrent: torrent,
-		}
-		TorrentsMap = append(TorrentsMap, torrentMap)
+	if torrents, _ := getCachedLinks(linksChosen, tmdbId); len(torrents) == 0 {
+		setCachedLinks(linksChosen, tmdbId, []*bittorrent.Torrent{torrent})
 	}
 }
 
-func InTorrentsMap(tmdbId string) (torrents []*bittorrent.Torrent) {
-	for index, torrentMap := range TorrentsMap {
-		if tmdbId == torrentMap.tmdbId {
-			if xbmc.DialogConfirm("Quasar", "LOCALIZE[30260]") {
-				torrents = append(torrents, torrentMap.torrent)
-			} else {
-				TorrentsMap = append(TorrentsMap[:index], TorrentsMap[index + 1:]...)
-			}
+func InTorrentsMap(dialogs ui.UI, tmdbId string) (torrents []*bittorrent.Torrent) {
+	if chosen, _ := getCachedLinks(linksChosen, tmdbId); len(chosen) > 0 {
+		if dialogs.Confirm("Quasar", "LOCALIZE[30260]") {
+			torrents = append(torrents, chosen[0])
+		} else {
+			deleteCachedLinks(linksChosen, tmdbId)
 		}
 	}
 	return torrents
@@ -337,7 +322,6 @@ func PauseSession(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		btService.Session.GetHandle().Pause()
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
@@ -346,7 +330,6 @@ func ResumeSession(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		btService.Session.GetHandle().Resume()
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
@@ -354,7 +337,6 @@ func ResumeSession(btService *bittorrent.BTService) gin.HandlerFunc {
 func AddTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		uri := ctx.Query("uri")
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
 		if uri == "" {
 			ctx.String(404, "Missing torrent URI")
@@ -362,102 +344,107 @@ func AddTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		torrentsLog.Infof("Adding torrent from %s", uri)
 
 		if config.Get().DownloadPath == "." {
-			xbmc.Notify("Quasar", "LOCALIZE[30113]", config.AddonIcon())
+			uiFor(ctx).Notify("Quasar", "LOCALIZE[30113]")
 			ctx.String(404, "Download path empty")
 			return
 		}
 
-		torrentParams := libtorrent.NewAddTorrentParams()
-		defer libtorrent.DeleteAddTorrentParams(torrentParams)
+		if _, err := addTorrent(btService, uri); err != nil {
+			ctx.String(404, err.Error())
+			return
+		}
 
-		var infoHash string
+		xbmc.Refresh()
+		ctx.String(200, "")
+	}
+}
 
-		loadFromFile := false
-		torrent := bittorrent.NewTorrent(uri)
-		if strings.HasPrefix(uri, "magnet") || strings.HasPrefix(uri, "http") {
-			if torrent.IsMagnet() {
-				torrent.Magnet()
-				torrentsLog.Infof("Parsed magnet: %s", torrent.URI)
-				if err := torrent.IsValidMagnet(); err == nil {
-					torrentParams.SetUrl(torrent.URI)
-				} else {
-					ctx.String(404, err.Error())
-					return
-				}
+// addTorrent adds a torrent to the session for downloading in the
+// background, and returns its info hash.
+func addTorrent(btService *bittorrent.BTService, uri string) (string, error) {
+	torrentParams := libtorrent.NewAddTorrentParams()
+	defer libtorrent.DeleteAddTorrentParams(torrentParams)
+
+	var infoHash string
+
+	loadFromFile := false
+	torrent := bittorrent.NewTorrent(uri)
+	if strings.HasPrefix(uri, "magnet") || strings.HasPrefix(uri, "http") {
+		if torrent.IsMagnet() {
+			torrent.Magnet()
+			torrentsLog.Infof("Parsed magnet: %s", torrent.URI)
+			if err := torrent.IsValidMagnet(); err == nil {
+				torrentParams.SetUrl(torrent.URI)
 			} else {
-				if err := torrent.Resolve(); err == nil {
-					loadFromFile = true
-				} else {
-					ctx.String(404, err.Error())
-					return
-				}
+				return "", err
 			}
-			infoHash = torrent.InfoHash
 		} else {
-			loadFromFile = true
-		}
-
-		if loadFromFile {
-			if _, err := os.Stat(torrent.URI); err != nil {
-				ctx.String(404, err.Error())
-				return
-			}
-
-			file, err := os.Open(torrent.URI)
-			if err != nil {
-				ctx.String(404, err.Error())
-				return
-			}
-			dec := bencode.NewDecoder(file)
-			var torrentFile *bittorrent.TorrentFileRaw
-			if err := dec.Decode(&torrentFile); err != nil {
-				errMsg := fmt.Sprintf("Invalid torrent file %s, failed to decode with: %s", torrent.URI, err.Error())
-				torrentsLog.Error(errMsg)
-				ctx.String(404, errMsg)
-				return
+			if err := torrent.Resolve(); err == nil {
+				loadFromFile = true
+			} else {
+				return "", err
 			}
-
-			info := libtorrent.NewTorrentInfo(torrent.URI)
-			torrentParams.SetTorrentInfo(info)
-
-			shaHash := info.InfoHash().ToString()
-			infoHash = hex.EncodeToString([]byte(shaHash))
 		}
+		infoHash = torrent.InfoHash
+	} else {
+		loadFromFile = true
+	}
 
-		torrentsLog.Infof("Setting save path to %s", config.Get().DownloadPath)
-		torrentParams.SetSavePath(config.Get().DownloadPath)
+	if loadFromFile {
+		if _, err := os.Stat(torrent.URI); err != nil {
+			return "", err
+		}
 
-		torrentsLog.Infof("Checking for fast resume data in %s.fastresume", infoHash)
-		fastResumeFile := filepath.Join(config.Get().TorrentsPath, fmt.Sprintf("%s.fastresume", infoHash))
-		if _, err := os.Stat(fastResumeFile); err == nil {
-			torrentsLog.Info("Found fast resume data...")
-			fastResumeData, err := ioutil.ReadFile(fastResumeFile)
-			if err != nil {
-				torrentsLog.Error(err.Error())
-				ctx.String(404, err.Error())
-				return
-			}
-			fastResumeVector := libtorrent.NewStdVectorChar()
-			defer libtorrent.DeleteStdVectorChar(fastResumeVector)
-			for _, c := range fastResumeData {
-				fastResumeVector.Add(c)
-			}
-			torrentParams.SetResumeData(fastResumeVector)
+		file, err := os.Open(torrent.URI)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		dec := bencode.NewDecoder(file)
+		var torrentFile *bittorrent.TorrentFileRaw
+		if err := dec.Decode(&torrentFile); err != nil {
+			errMsg := fmt.Sprintf("Invalid torrent file %s, failed to decode with: %s", torrent.URI, err.Error())
+			torrentsLog.Error(errMsg)
+			return "", errors.New(errMsg)
 		}
 
-		torrentHandle := btService.Session.GetHandle().AddTorrent(torrentParams)
+		info := libtorrent.NewTorrentInfo(torrent.URI)
+		torrentParams.SetTorrentInfo(info)
 
-		if torrentHandle == nil {
-			ctx.String(404, fmt.Sprintf("Unable to add torrent with URI %s", uri))
-			return
+		shaHash := info.InfoHash().ToString()
+		infoHash = hex.EncodeToString([]byte(shaHash))
+	}
+
+	torrentsLog.Infof("Setting save path to %s", config.Get().DownloadPath)
+	torrentParams.SetSavePath(config.Get().DownloadPath)
+
+	torrentsLog.Infof("Checking for fast resume data in %s.fastresume", infoHash)
+	fastResumeFile := filepath.Join(config.Get().TorrentsPath, fmt.Sprintf("%s.fastresume", infoHash))
+	if _, err := os.Stat(fastResumeFile); err == nil {
+		torrentsLog.Info("Found fast resume data...")
+		fastResumeData, err := ioutil.ReadFile(fastResumeFile)
+		if err != nil {
+			torrentsLog.Error(err.Error())
+			return "", err
 		}
+		fastResumeVector := libtorrent.NewStdVectorChar()
+		defer libtorrent.DeleteStdVectorChar(fastResumeVector)
+		for _, c := range fastResumeData {
+			fastResumeVector.Add(c)
+		}
+		torrentParams.SetResumeData(fastResumeVector)
+	}
 
-		torrentsLog.Infof("Downloading %s", uri)
-		btService.SpaceChecked[infoHash] = false
+	torrentHandle := btService.Session.GetHandle().AddTorrent(torrentParams)
 
-		xbmc.Refresh()
-		ctx.String(200, "")
+	if torrentHandle == nil {
+		return "", fmt.Errorf("Unable to add torrent with URI %s", uri)
 	}
+
+	torrentsLog.Infof("Downloading %s", uri)
+	btService.SpaceChecked[infoHash] = false
+
+	return infoHash, nil
 }
 
 func ResumeTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
@@ -479,7 +466,6 @@ func ResumeTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		torrentHandle.AutoManaged(true)
 
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
@@ -499,7 +485,6 @@ func MoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		btService.MarkedToMove = torrentIndex
 
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
@@ -520,7 +505,6 @@ func PauseTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		torrentHandle.Pause(1)
 
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
@@ -563,7 +547,7 @@ func RemoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 
 		askedToDelete := false
 		if config.Get().KeepFilesAsk == true && deleteFiles == "" {
-			if xbmc.DialogConfirm("Quasar", "LOCALIZE[30269]") {
+			if uiFor(ctx).Confirm("Quasar", "LOCALIZE[30269]") {
 				askedToDelete = true
 			}
 		}
@@ -579,7 +563,6 @@ func RemoveTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
 		}
 
 		xbmc.Refresh()
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.String(200, "")
 	}
 }
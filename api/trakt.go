@@ -15,17 +15,17 @@ import (
 	"github.com/scakemyer/quasar/xbmc"
 )
 
-func inMoviesWatchlist(tmdbId int) bool {
-	if config.Get().TraktToken == "" {
+func inMoviesWatchlist(conf *config.Configuration, tmdbId int) bool {
+	if conf.TraktToken == "" {
 		return false
 	}
 
 	var movies []*trakt.Movies
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.trakt.watchlist.movies")
+	key := trakt.AccountKey(conf, "com.trakt.watchlist.movies")
 	if err := cacheStore.Get(key, &movies); err != nil {
-		movies, _ := trakt.WatchlistMovies()
+		movies, _ := trakt.WatchlistMovies(conf)
 		cacheStore.Set(key, movies, 30 * time.Second)
 	}
 
@@ -37,17 +37,17 @@ func inMoviesWatchlist(tmdbId int) bool {
 	return false
 }
 
-func inShowsWatchlist(tmdbId int) bool {
-	if config.Get().TraktToken == "" {
+func inShowsWatchlist(conf *config.Configuration, tmdbId int) bool {
+	if conf.TraktToken == "" {
 		return false
 	}
 
 	var shows []*trakt.Shows
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.trakt.watchlist.shows")
+	key := trakt.AccountKey(conf, "com.trakt.watchlist.shows")
 	if err := cacheStore.Get(key, &shows); err != nil {
-		shows, _ := trakt.WatchlistShows()
+		shows, _ := trakt.WatchlistShows(conf)
 		cacheStore.Set(key, shows, 30 * time.Second)
 	}
 
@@ -59,17 +59,17 @@ func inShowsWatchlist(tmdbId int) bool {
 	return false
 }
 
-func inMoviesCollection(tmdbId int) bool {
-	if config.Get().TraktToken == "" {
+func inMoviesCollection(conf *config.Configuration, tmdbId int) bool {
+	if conf.TraktToken == "" {
 		return false
 	}
 
 	var movies []*trakt.Movies
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.trakt.collection.movies")
+	key := trakt.AccountKey(conf, "com.trakt.collection.movies")
 	if err := cacheStore.Get(key, &movies); err != nil {
-		movies, _ := trakt.CollectionMovies()
+		movies, _ := trakt.CollectionMovies(conf)
 		cacheStore.Set(key, movies, 30 * time.Second)
 	}
 
@@ -81,17 +81,17 @@ func inMoviesCollection(tmdbId int) bool {
 	return false
 }
 
-func inShowsCollection(tmdbId int) bool {
-	if config.Get().TraktToken == "" {
+func inShowsCollection(conf *config.Configuration, tmdbId int) bool {
+	if conf.TraktToken == "" {
 		return false
 	}
 
 	var shows []*trakt.Shows
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.trakt.collection.shows")
+	key := trakt.AccountKey(conf, "com.trakt.collection.shows")
 	if err := cacheStore.Get(key, &shows); err != nil {
-		shows, _ := trakt.CollectionShows()
+		shows, _ := trakt.CollectionShows(conf)
 		cacheStore.Set(key, shows, 30 * time.Second)
 	}
 
@@ -107,7 +107,7 @@ func inShowsCollection(tmdbId int) bool {
 // Authorization
 //
 func AuthorizeTrakt(ctx *gin.Context) {
-	err := trakt.Authorize(true)
+	err := trakt.Authorize(profileFor(ctx), true)
 	if err == nil {
 		ctx.String(200, "")
 	} else {
@@ -120,7 +120,7 @@ func AuthorizeTrakt(ctx *gin.Context) {
 // Main lists
 //
 func WatchlistMovies(ctx *gin.Context) {
-	movies, err := trakt.WatchlistMovies()
+	movies, err := trakt.WatchlistMovies(profileFor(ctx))
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -128,7 +128,7 @@ func WatchlistMovies(ctx *gin.Context) {
 }
 
 func WatchlistShows(ctx *gin.Context) {
-	shows, err := trakt.WatchlistShows()
+	shows, err := trakt.WatchlistShows(profileFor(ctx))
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -136,7 +136,7 @@ func WatchlistShows(ctx *gin.Context) {
 }
 
 func CollectionMovies(ctx *gin.Context) {
-	movies, err := trakt.CollectionMovies()
+	movies, err := trakt.CollectionMovies(profileFor(ctx))
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -144,7 +144,7 @@ func CollectionMovies(ctx *gin.Context) {
 }
 
 func CollectionShows(ctx *gin.Context) {
-	shows, err := trakt.CollectionShows()
+	shows, err := trakt.CollectionShows(profileFor(ctx))
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -155,7 +155,7 @@ func UserlistMovies(ctx *gin.Context) {
 	listId := ctx.Params.ByName("listId")
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	movies, err := trakt.ListItemsMovies(listId, true)
+	movies, err := trakt.ListItemsMovies(profileFor(ctx), listId, true)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -166,7 +166,7 @@ func UserlistShows(ctx *gin.Context) {
 	listId := ctx.Params.ByName("listId")
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, err := trakt.ListItemsShows(listId, true)
+	shows, err := trakt.ListItemsShows(profileFor(ctx), listId, true)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -185,121 +185,129 @@ func UserlistShows(ctx *gin.Context) {
 // Main lists actions
 //
 func AddMovieToWatchlist(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
-	resp, err := trakt.AddToWatchlist("movies", tmdbId)
+	resp, err := trakt.AddToWatchlist(conf, "movies", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 201 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Movie added to watchlist", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.watchlist.movies"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.movies.watchlist"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.watchlist.movies")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.movies.watchlist")))
 		clearPageCache(ctx)
 	}
 }
 
 func RemoveMovieFromWatchlist(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
-	resp, err := trakt.RemoveFromWatchlist("movies", tmdbId)
+	resp, err := trakt.RemoveFromWatchlist(conf, "movies", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 200 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Movie removed from watchlist", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.watchlist.movies"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.movies.watchlist"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.watchlist.movies")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.movies.watchlist")))
 		clearPageCache(ctx)
 	}
 }
 
 func AddShowToWatchlist(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("showId")
-	resp, err := trakt.AddToWatchlist("shows", tmdbId)
+	resp, err := trakt.AddToWatchlist(conf, "shows", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 201 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed %d", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Show added to watchlist", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.watchlist.shows"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.shows.watchlist"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.watchlist.shows")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.shows.watchlist")))
 		clearPageCache(ctx)
 	}
 }
 
 func RemoveShowFromWatchlist(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("showId")
-	resp, err := trakt.RemoveFromWatchlist("shows", tmdbId)
+	resp, err := trakt.RemoveFromWatchlist(conf, "shows", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 200 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Show removed from watchlist", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.watchlist.shows"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.shows.watchlist"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.watchlist.shows")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.shows.watchlist")))
 		clearPageCache(ctx)
 	}
 }
 
 func AddMovieToCollection(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
-	resp, err := trakt.AddToCollection("movies", tmdbId)
+	resp, err := trakt.AddToCollection(conf, "movies", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 201 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Movie added to collection", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.collection.movies"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.movies.collection"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.collection.movies")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.movies.collection")))
 		clearPageCache(ctx)
 	}
 }
 
 func RemoveMovieFromCollection(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("tmdbId")
-	resp, err := trakt.RemoveFromCollection("movies", tmdbId)
+	resp, err := trakt.RemoveFromCollection(conf, "movies", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 200 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Movie removed from collection", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.collection.movies"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.movies.collection"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.collection.movies")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.movies.collection")))
 		clearPageCache(ctx)
 	}
 }
 
 func AddShowToCollection(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("showId")
-	resp, err := trakt.AddToCollection("shows", tmdbId)
+	resp, err := trakt.AddToCollection(conf, "shows", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 201 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Show added to collection", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.collection.shows"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.shows.collection"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.collection.shows")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.shows.collection")))
 		clearPageCache(ctx)
 	}
 }
 
 func RemoveShowFromCollection(ctx *gin.Context) {
+	conf := profileFor(ctx)
 	tmdbId := ctx.Params.ByName("showId")
-	resp, err := trakt.RemoveFromCollection("shows", tmdbId)
+	resp, err := trakt.RemoveFromCollection(conf, "shows", tmdbId)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	} else if resp.Status() != 200 {
 		xbmc.Notify("Quasar", fmt.Sprintf("Failed with %d status code", resp.Status()), config.AddonIcon())
 	} else {
 		xbmc.Notify("Quasar", "Show removed from collection", config.AddonIcon())
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.collection.shows"))
-		os.Remove(filepath.Join(config.Get().Info.Profile, "cache", "com.trakt.shows.collection"))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.collection.shows")))
+		os.Remove(filepath.Join(conf.Info.Profile, "cache", trakt.AccountKey(conf, "com.trakt.shows.collection")))
 		clearPageCache(ctx)
 	}
 }
@@ -317,6 +325,7 @@ func RemoveShowFromCollection(ctx *gin.Context) {
 // }
 
 func renderTraktMovies(ctx *gin.Context, movies []*trakt.Movies, total int, page int) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -366,17 +375,17 @@ func renderTraktMovies(ctx *gin.Context, movies []*trakt.Movies, total int, page
 
 		tmdbId := strconv.Itoa(movie.IDs.TMDB)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/add/%d", movie.IDs.TMDB))}
-		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(tmdbId, Movie) {
+		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Movie) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/remove/%d", movie.IDs.TMDB))}
 		}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/add", movie.IDs.TMDB))}
-		if inMoviesWatchlist(movie.IDs.TMDB) {
+		if inMoviesWatchlist(conf, movie.IDs.TMDB) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/remove", movie.IDs.TMDB))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/add", movie.IDs.TMDB))}
-		if inMoviesCollection(movie.IDs.TMDB) {
+		if inMoviesCollection(conf, movie.IDs.TMDB) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/remove", movie.IDs.TMDB))}
 		}
 
@@ -484,6 +493,7 @@ func TraktBoxOffice(ctx *gin.Context) {
 
 
 func renderTraktShows(ctx *gin.Context, shows []*trakt.Shows, total int, page int) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -518,18 +528,18 @@ func renderTraktShows(ctx *gin.Context, shows []*trakt.Shows, total int, page in
 
 		tmdbId := strconv.Itoa(show.IDs.TMDB)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d", show.IDs.TMDB))}
-		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(tmdbId, Show) {
+		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Show) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/remove/%d", show.IDs.TMDB))}
 		}
 		mergeAction := []string{"LOCALIZE[30283]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d?merge=true", show.IDs.TMDB))}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/add", show.IDs.TMDB))}
-		if inShowsWatchlist(show.IDs.TMDB) {
+		if inShowsWatchlist(conf, show.IDs.TMDB) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/remove", show.IDs.TMDB))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/add", show.IDs.TMDB))}
-		if inShowsCollection(show.IDs.TMDB) {
+		if inShowsCollection(conf, show.IDs.TMDB) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/remove", show.IDs.TMDB))}
 		}
 
@@ -540,6 +550,9 @@ func renderTraktShows(ctx *gin.Context, shows []*trakt.Shows, total int, page in
 			collectionAction,
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/tvshows"))},
 		}
+		if isAddedToLibrary(conf, tmdbId, Show) {
+			item.ContextMenu = append(item.ContextMenu, showRulesAction(show.IDs.TMDB))
+		}
 		if config.Get().Platform.Kodi < 17 {
 			item.ContextMenu = append(item.ContextMenu, []string{"LOCALIZE[30203]", "XBMC.Action(Info)"})
 		}
@@ -623,7 +636,7 @@ func TraktMostAnticipatedShows(ctx *gin.Context) {
 func TraktMyShows(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("my/shows", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "my/shows", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -633,7 +646,7 @@ func TraktMyShows(ctx *gin.Context) {
 func TraktMyNewShows(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("my/shows/new", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "my/shows/new", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -643,7 +656,7 @@ func TraktMyNewShows(ctx *gin.Context) {
 func TraktMyPremieres(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("my/shows/premieres", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "my/shows/premieres", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -653,7 +666,7 @@ func TraktMyPremieres(ctx *gin.Context) {
 func TraktMyMovies(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	movies, total, err := trakt.CalendarMovies("my/movies", pageParam)
+	movies, total, err := trakt.CalendarMovies(profileFor(ctx), "my/movies", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -663,7 +676,7 @@ func TraktMyMovies(ctx *gin.Context) {
 func TraktMyReleases(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	movies, total, err := trakt.CalendarMovies("my/dvd", pageParam)
+	movies, total, err := trakt.CalendarMovies(profileFor(ctx), "my/dvd", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -673,7 +686,7 @@ func TraktMyReleases(ctx *gin.Context) {
 func TraktAllShows(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("all/shows", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "all/shows", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -683,7 +696,7 @@ func TraktAllShows(ctx *gin.Context) {
 func TraktAllNewShows(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("all/shows/new", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "all/shows/new", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -693,7 +706,7 @@ func TraktAllNewShows(ctx *gin.Context) {
 func TraktAllPremieres(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	shows, total, err := trakt.CalendarShows("all/shows/premieres", pageParam)
+	shows, total, err := trakt.CalendarShows(profileFor(ctx), "all/shows/premieres", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -703,7 +716,7 @@ func TraktAllPremieres(ctx *gin.Context) {
 func TraktAllMovies(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	movies, total, err := trakt.CalendarMovies("all/movies", pageParam)
+	movies, total, err := trakt.CalendarMovies(profileFor(ctx), "all/movies", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -713,7 +726,7 @@ func TraktAllMovies(ctx *gin.Context) {
 func TraktAllReleases(ctx *gin.Context) {
 	pageParam := ctx.DefaultQuery("page", "1")
 	page, _ := strconv.Atoi(pageParam)
-	movies, total, err := trakt.CalendarMovies("all/dvd", pageParam)
+	movies, total, err := trakt.CalendarMovies(profileFor(ctx), "all/dvd", pageParam)
 	if err != nil {
 		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 	}
@@ -721,6 +734,7 @@ func TraktAllReleases(ctx *gin.Context) {
 }
 
 func renderCalendarMovies(ctx *gin.Context, movies []*trakt.CalendarMovie, total int, page int) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -773,17 +787,17 @@ func renderCalendarMovies(ctx *gin.Context, movies []*trakt.CalendarMovie, total
 
 		tmdbId := strconv.Itoa(movie.IDs.TMDB)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/add/%d", movie.IDs.TMDB))}
-		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(tmdbId, Movie) {
+		if _, err := isDuplicateMovie(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Movie) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/remove/%d", movie.IDs.TMDB))}
 		}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/add", movie.IDs.TMDB))}
-		if inMoviesWatchlist(movie.IDs.TMDB) {
+		if inMoviesWatchlist(conf, movie.IDs.TMDB) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/watchlist/remove", movie.IDs.TMDB))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/add", movie.IDs.TMDB))}
-		if inMoviesCollection(movie.IDs.TMDB) {
+		if inMoviesCollection(conf, movie.IDs.TMDB) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%d/collection/remove", movie.IDs.TMDB))}
 		}
 
@@ -822,6 +836,7 @@ func renderCalendarMovies(ctx *gin.Context, movies []*trakt.CalendarMovie, total
 }
 
 func renderCalendarShows(ctx *gin.Context, shows []*trakt.CalendarShow, total int, page int) {
+	conf := profileFor(ctx)
 	hasNextPage := 0
 	if page > 0 {
 		resultsPerPage := config.Get().ResultsPerPage
@@ -865,18 +880,18 @@ func renderCalendarShows(ctx *gin.Context, shows []*trakt.CalendarShow, total in
 
 		tmdbId := strconv.Itoa(show.IDs.TMDB)
 		libraryAction := []string{"LOCALIZE[30252]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d", show.IDs.TMDB))}
-		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(tmdbId, Show) {
+		if _, err := isDuplicateShow(tmdbId); err != nil || isAddedToLibrary(conf, tmdbId, Show) {
 			libraryAction = []string{"LOCALIZE[30253]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/remove/%d", show.IDs.TMDB))}
 		}
 		mergeAction := []string{"LOCALIZE[30283]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/add/%d?merge=true", show.IDs.TMDB))}
 
 		watchlistAction := []string{"LOCALIZE[30255]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/add", show.IDs.TMDB))}
-		if inShowsWatchlist(show.IDs.TMDB) {
+		if inShowsWatchlist(conf, show.IDs.TMDB) {
 			watchlistAction = []string{"LOCALIZE[30256]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/watchlist/remove", show.IDs.TMDB))}
 		}
 
 		collectionAction := []string{"LOCALIZE[30258]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/add", show.IDs.TMDB))}
-		if inShowsCollection(show.IDs.TMDB) {
+		if inShowsCollection(conf, show.IDs.TMDB) {
 			collectionAction = []string{"LOCALIZE[30259]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/show/%d/collection/remove", show.IDs.TMDB))}
 		}
 
@@ -889,6 +904,9 @@ func renderCalendarShows(ctx *gin.Context, shows []*trakt.CalendarShow, total in
 			[]string{"LOCALIZE[30268]", "XBMC.Action(ToggleWatched)"},
 			[]string{"LOCALIZE[30035]", fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/setviewmode/tvshows"))},
 		}
+		if isAddedToLibrary(conf, tmdbId, Show) {
+			item.ContextMenu = append(item.ContextMenu, showRulesAction(show.IDs.TMDB))
+		}
 		item.IsPlayable = true
 
 		items = append(items, item)
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/ui"
+)
+
+// webUI holds the dialogs of requests made from the web UI, and of
+// everything else when it's the one configured.
+var webUI = ui.NewWeb(ui.WebTimeout, nil)
+
+// SetUI sets who answers the dialogs of background jobs and of requests
+// from Kodi.
+func SetUI(u ui.UI) {
+	if web, ok := u.(*ui.Web); ok {
+		webUI = web
+	}
+	ui.Set(u)
+}
+
+// uiFor returns the UI answering a request's dialogs, the web UI's when
+// it asks for it with ?ui=web or X-Quasar-UI.
+func uiFor(ctx *gin.Context) ui.UI {
+	if ctx.Query("ui") == "web" || ctx.Request.Header.Get("X-Quasar-UI") == "web" {
+		return webUI
+	}
+	return ui.Get()
+}
+
+// uiQuery keeps ?ui= along redirects to /play, so that the player's dialogs
+// go where the request's did.
+func uiQuery(ctx *gin.Context, rUrl string) string {
+	if ctx.Query("ui") == "" {
+		return rUrl
+	}
+	return rUrl + "&ui=" + url.QueryEscape(ctx.Query("ui"))
+}
+
+// Prompts lists the dialogs waiting for an answer in the web UI, and the
+// latest notifications.
+func Prompts(ctx *gin.Context) {
+	ctx.JSON(200, gin.H{
+		"prompts": webUI.Prompts(),
+		"notices": webUI.Notices(),
+	})
+}
+
+// AnswerPrompt answers a dialog with ?answer=: yes or no, an item's index,
+// some text, or cancel.
+func AnswerPrompt(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Params.ByName("id"))
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	if err := webUI.Answer(id, ctx.Query("answer")); err != nil {
+		ctx.String(404, err.Error())
+		return
+	}
+	ctx.String(200, "")
+}
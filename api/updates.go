@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+)
+
+//
+// Incremental show updates, so only shows changed on TMDB or with newly
+// aired episodes get their seasons fetched again.
+//
+
+const (
+	showUpdatesBucket = "ShowUpdates"
+	changesFeedKey    = "changes_feed"
+)
+
+type showUpdate struct {
+	LastChecked time.Time `json:"last_checked"`
+	Status      string    `json:"status"`
+	LastAirDate string    `json:"last_air_date"`
+	NextAirDate string    `json:"next_air_date"`
+	NextSeason  int       `json:"next_season"`
+}
+
+func getShowUpdate(key string) (update *showUpdate) {
+	DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(showUpdatesBucket)).Get([]byte(key)); v != nil {
+			json.Unmarshal(v, &update)
+		}
+		return nil
+	})
+	return
+}
+
+func setShowUpdate(key string, update *showUpdate) {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(showUpdatesBucket)).Put([]byte(key), buf)
+	})
+	if err != nil {
+		libraryLog.Error(err)
+	}
+}
+
+func showEnded(status string) bool {
+	return status == "Ended" || status == "Canceled"
+}
+
+// seasonsToUpdate tells whether a show needs updating, and which of its
+// seasons do, all of them when nil.
+func seasonsToUpdate(showId string, update *showUpdate, changed map[int]bool) (bool, []int) {
+	// Never checked, or for too long to rely on TMDB's changes
+	if update == nil || time.Since(update.LastChecked) > tmdb.MaxChangesAge {
+		return true, nil
+	}
+
+	Id, _ := strconv.Atoi(showId)
+	if changed == nil || changed[Id] {
+		seasons, err := tmdb.ShowChangedSeasons(Id, update.LastChecked)
+		if err != nil {
+			libraryLog.Warningf("Unable to get changes of show %s: %s", showId, err)
+			return true, nil
+		}
+		tmdb.ForgetShow(Id, "en")
+		for _, season := range seasons {
+			tmdb.ForgetSeason(Id, season, "en")
+		}
+		if len(seasons) > 0 {
+			return true, seasons
+		}
+		if changed != nil {
+			// Show details changed, which only affects its last season
+			if update.NextSeason > 0 {
+				return true, []int{update.NextSeason}
+			}
+			return true, nil
+		}
+	}
+
+	// Nothing changed, but episodes may have aired since
+	if !showEnded(update.Status) && update.NextAirDate != "" {
+		nextAired, err := time.Parse("2006-01-02", update.NextAirDate)
+		if err == nil && nextAired.Before(time.Now().UTC()) {
+			tmdb.ForgetShow(Id, "en")
+			tmdb.ForgetSeason(Id, update.NextSeason, "en")
+			return true, []int{update.NextSeason}
+		}
+	}
+
+	return false, nil
+}
+
+// updateShows writes the .strm files of the shows' new episodes, skipping
+// the shows which didn't change since they were last checked. Shows are
+// given with a profile having them, whose removed episodes are left out.
+func updateShows(shows map[string]*config.Configuration) {
+	started := time.Now()
+
+	var changed map[int]bool
+	if feed := getShowUpdate(changesFeedKey); feed != nil {
+		var err error
+		if changed, err = tmdb.ChangedShows(feed.LastChecked); err != nil {
+			libraryLog.Warningf("Unable to get TMDB changes, checking shows one by one: %s", err)
+			changed = nil
+		}
+	}
+
+	updated := 0
+	for showId, conf := range shows {
+		update := getShowUpdate(showId)
+		needsUpdate, seasons := seasonsToUpdate(showId, update, changed)
+		if !needsUpdate {
+			continue
+		}
+
+		show, err := writeShowSeasonsStrm(conf, showId, false, seasons)
+		if err != nil {
+			libraryLog.Error(err)
+			continue
+		}
+		updated++
+
+		update = &showUpdate{
+			LastChecked: started,
+			Status:      show.Status,
+			LastAirDate: show.LastAirDate,
+		}
+		if show.NextEpisodeToAir != nil {
+			update.NextAirDate = show.NextEpisodeToAir.AirDate
+			update.NextSeason = show.NextEpisodeToAir.SeasonNumber
+		} else if len(show.Seasons) > 0 {
+			update.NextSeason = show.Seasons[len(show.Seasons)-1].Season
+		}
+		setShowUpdate(showId, update)
+	}
+
+	setShowUpdate(changesFeedKey, &showUpdate{LastChecked: started})
+	libraryLog.Noticef("Updated %d of %d shows in %s", updated, len(shows), time.Since(started))
+}
+
+func intInSlice(a int, list []int) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,464 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Two-way sync of watched states and resume points between Trakt and the
+// Kodi library, the latest change winning. Kodi's library being every
+// profile's, each profile keeps what Kodi had when it last synced, and
+// only sends Trakt what changed in Kodi since.
+//
+
+const (
+	watchedBucket = "WatchedState"
+
+	syncTargetKodi  = "kodi"
+	syncTargetTrakt = "trakt"
+
+	syncWatched = "watched"
+	syncResume  = "resume"
+
+	// Resume points closer than this, in percents, are the same
+	resumeTolerance = 1.0
+)
+
+type watchedChange struct {
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	TMDBId   int       `json:"tmdb_id"`
+	Season   int       `json:"season,omitempty"`
+	Episode  int       `json:"episode,omitempty"`
+	Target   string    `json:"target"`
+	Change   string    `json:"change"`
+	Progress float64   `json:"progress,omitempty"`
+	At       time.Time `json:"at"`
+
+	key       string
+	libraryId int
+	episodeId int
+	total     float64
+}
+
+type watchedSyncReport struct {
+	DryRun    bool             `json:"dry_run"`
+	Changes   []*watchedChange `json:"changes"`
+	Conflicts int              `json:"conflicts"`
+	Errors    []string         `json:"errors"`
+
+	conf  *config.Configuration
+	marks map[string]*kodiMark
+}
+
+// playState is where an item stands on either side, at its latest change.
+type playState struct {
+	Watched    bool
+	WatchedAt  time.Time
+	Progress   float64
+	PausedAt   time.Time
+	LastPlayed time.Time
+}
+
+// kodiMark is what Kodi had of an item when a profile last synced it.
+type kodiMark struct {
+	Watched  bool    `json:"watched"`
+	Progress float64 `json:"progress,omitempty"`
+}
+
+func markOf(state *playState) *kodiMark {
+	return &kodiMark{Watched: state.Watched, Progress: state.Progress}
+}
+
+func (m *kodiMark) same(other *kodiMark) bool {
+	return m.Watched == other.Watched && math.Abs(m.Progress-other.Progress) < resumeTolerance
+}
+
+func kodiMarks(conf *config.Configuration) map[string]*kodiMark {
+	marks := make(map[string]*kodiMark)
+	DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(profileBucket(conf, watchedBucket)).ForEach(func(k, v []byte) error {
+			var mark *kodiMark
+			if err := json.Unmarshal(v, &mark); err == nil {
+				marks[string(k)] = mark
+			}
+			return nil
+		})
+	})
+	return marks
+}
+
+func saveKodiMarks(conf *config.Configuration, marks map[string]*kodiMark) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(profileBucket(conf, watchedBucket))
+		for key, mark := range marks {
+			buf, err := json.Marshal(mark)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *watchedSyncReport) summary() string {
+	toKodi := 0
+	for _, change := range r.Changes {
+		if change.Target == syncTargetKodi {
+			toKodi++
+		}
+	}
+	return fmt.Sprintf("%d changes to Kodi, %d to Trakt, %d conflicts", toKodi, len(r.Changes)-toKodi, r.Conflicts)
+}
+
+func kodiLastPlayed(lastPlayed string) time.Time {
+	played, err := time.ParseInLocation(xbmc.LastPlayedFormat, lastPlayed, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return played
+}
+
+func kodiPlayState(playCount int, resume *xbmc.Resume, lastPlayed string) *playState {
+	state := &playState{}
+	played := kodiLastPlayed(lastPlayed)
+	state.LastPlayed = played
+	if playCount > 0 {
+		state.Watched = true
+		state.WatchedAt = played
+	}
+	if resume != nil && resume.Position > 0 && resume.Total > 0 {
+		state.Progress = resume.Position / resume.Total * 100
+		state.PausedAt = played
+	}
+	return state
+}
+
+// reconcile compares both sides of an item, adding to the report what
+// needs changing for the latest change to win. Kodi's side only goes to
+// Trakt if it changed since the profile last synced.
+func (r *watchedSyncReport) reconcile(item *watchedChange, kodi *playState, remote *playState, kodiChanged bool) {
+	add := func(target string, change string, progress float64, at time.Time) {
+		c := *item
+		c.Target = target
+		c.Change = change
+		c.Progress = progress
+		c.At = at
+		r.Changes = append(r.Changes, &c)
+	}
+
+	switch {
+	case kodi.Watched && !remote.Watched:
+		if !kodiChanged {
+			return
+		}
+		at := kodi.WatchedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		add(syncTargetTrakt, syncWatched, 0, at)
+		return
+	case !kodi.Watched && remote.Watched:
+		// Played in Kodi since, and unwatched there. Plays in the Trakt
+		// history did happen though, so they're kept.
+		if kodi.LastPlayed.After(remote.WatchedAt) {
+			r.Conflicts++
+			break
+		}
+		add(syncTargetKodi, syncWatched, 0, remote.WatchedAt)
+		return
+	case kodi.Watched && remote.Watched:
+		return
+	}
+
+	// Resume points older than the last watch are stale
+	if remote.Progress > 0 && remote.PausedAt.Before(remote.WatchedAt) {
+		remote.Progress = 0
+	}
+	if math.Abs(kodi.Progress-remote.Progress) < resumeTolerance {
+		return
+	}
+	if remote.Progress > 0 && remote.PausedAt.After(kodi.PausedAt) {
+		if kodi.Progress > 0 {
+			r.Conflicts++
+		}
+		add(syncTargetKodi, syncResume, remote.Progress, remote.PausedAt)
+	} else if kodiChanged && kodi.Progress > 0 && kodi.PausedAt.After(remote.PausedAt) {
+		if remote.Progress > 0 {
+			r.Conflicts++
+		}
+		add(syncTargetTrakt, syncResume, kodi.Progress, kodi.PausedAt)
+	}
+}
+
+// watchedSync compares the Trakt history and playback progress with the
+// library items in Kodi with the Trakt account of conf, and applies the
+// changes unless dryRun is set.
+func watchedSync(conf *config.Configuration, dryRun bool) (report *watchedSyncReport, err error) {
+	defer observeSync("watched", time.Now(), &err)
+
+	if conf.TraktToken == "" {
+		return nil, errors.New("Trakt isn't authorized")
+	}
+
+	watchedMovies, err := trakt.WatchedMovies(conf)
+	if err != nil {
+		return nil, err
+	}
+	watchedShows, err := trakt.WatchedShows(conf)
+	if err != nil {
+		return nil, err
+	}
+	playbacks, err := trakt.PlaybackProgress(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := make(map[string]*playState)
+	remoteState := func(key string) *playState {
+		if _, ok := remote[key]; !ok {
+			remote[key] = &playState{}
+		}
+		return remote[key]
+	}
+	for _, watched := range watchedMovies {
+		if watched.Movie == nil || watched.Movie.IDs == nil {
+			continue
+		}
+		state := remoteState(fmt.Sprintf("movie_%d", watched.Movie.IDs.TMDB))
+		state.Watched = true
+		state.WatchedAt = watched.LastWatchedAt
+	}
+	for _, watched := range watchedShows {
+		if watched.Show == nil || watched.Show.IDs == nil {
+			continue
+		}
+		for _, season := range watched.Seasons {
+			for _, episode := range season.Episodes {
+				state := remoteState(fmt.Sprintf("episode_%d_%d_%d", watched.Show.IDs.TMDB, season.Number, episode.Number))
+				state.Watched = true
+				state.WatchedAt = episode.LastWatchedAt
+			}
+		}
+	}
+	for _, playback := range playbacks {
+		var key string
+		if playback.Type == "movie" && playback.Movie != nil && playback.Movie.IDs != nil {
+			key = fmt.Sprintf("movie_%d", playback.Movie.IDs.TMDB)
+		} else if playback.Type == "episode" && playback.Show != nil && playback.Show.IDs != nil && playback.Episode != nil {
+			key = fmt.Sprintf("episode_%d_%d_%d", playback.Show.IDs.TMDB, playback.Episode.Season, playback.Episode.Number)
+		} else {
+			continue
+		}
+		state := remoteState(key)
+		state.Progress = playback.Progress
+		state.PausedAt = playback.PausedAt
+	}
+
+	// Kodi's last played dates only come with a fresh listing
+	updateLibraryMovies()
+	updateLibraryShows()
+
+	report = &watchedSyncReport{
+		DryRun:  dryRun,
+		Changes: make([]*watchedChange, 0),
+		Errors:  make([]string, 0),
+		conf:    conf,
+		marks:   make(map[string]*kodiMark),
+	}
+	language := config.Get().Language
+	tracked := trackedItems(conf)
+	synced := kodiMarks(conf)
+	reconcile := func(item *watchedChange, kodi *playState) {
+		mark := markOf(kodi)
+		report.marks[item.key] = mark
+		kodiChanged := synced[item.key] == nil || !synced[item.key].same(mark)
+		report.reconcile(item, kodi, remoteState(item.key), kodiChanged)
+	}
+
+	for tmdbId := range tracked[Movie] {
+		movie := tmdb.GetMovieById(tmdbId, language)
+		if movie == nil {
+			continue
+		}
+		libraryMovie := FindMovieInLibrary(movie)
+		if libraryMovie == nil {
+			continue
+		}
+		item := &watchedChange{
+			Type:      "movie",
+			Title:     movie.Title,
+			TMDBId:    movie.Id,
+			key:       fmt.Sprintf("movie_%d", movie.Id),
+			libraryId: libraryMovie.ID,
+			total:     float64(movie.Runtime * 60),
+		}
+		if libraryMovie.Resume != nil && libraryMovie.Resume.Total > 0 {
+			item.total = libraryMovie.Resume.Total
+		}
+		reconcile(item, kodiPlayState(libraryMovie.PlayCount, libraryMovie.Resume, libraryMovie.LastPlayed))
+	}
+
+	for showId := range tracked[Show] {
+		show := tmdb.GetShowById(showId, language)
+		if show == nil {
+			continue
+		}
+		episodeRuntime := 0
+		if len(show.EpisodeRunTime) > 0 {
+			episodeRuntime = show.EpisodeRunTime[0] * 60
+		}
+		for _, season := range show.Seasons {
+			if season.EpisodeCount == 0 {
+				continue
+			}
+			fullSeason := tmdb.GetSeason(show.Id, season.Season, language)
+			if fullSeason == nil {
+				continue
+			}
+			for _, episode := range fullSeason.Episodes {
+				if episode == nil {
+					continue
+				}
+				libraryEpisode := FindEpisodeInLibrary(show, episode)
+				if libraryEpisode == nil {
+					continue
+				}
+				item := &watchedChange{
+					Type:      "episode",
+					Title:     fmt.Sprintf("%s S%02dE%02d", show.Name, episode.SeasonNumber, episode.EpisodeNumber),
+					TMDBId:    show.Id,
+					Season:    episode.SeasonNumber,
+					Episode:   episode.EpisodeNumber,
+					key:       fmt.Sprintf("episode_%d_%d_%d", show.Id, episode.SeasonNumber, episode.EpisodeNumber),
+					libraryId: libraryEpisode.ID,
+					episodeId: episode.Id,
+					total:     float64(episodeRuntime),
+				}
+				if libraryEpisode.Resume != nil && libraryEpisode.Resume.Total > 0 {
+					item.total = libraryEpisode.Resume.Total
+				}
+				reconcile(item, kodiPlayState(libraryEpisode.PlayCount, libraryEpisode.Resume, libraryEpisode.LastPlayed))
+			}
+		}
+	}
+
+	for _, change := range report.Changes {
+		libraryLog.Infof("Watched sync: %s %s of %s at %s", change.Target, change.Change, change.Title, change.At.Format(time.RFC3339))
+	}
+	if !dryRun {
+		report.apply()
+	}
+	libraryLog.Noticef("Watched sync: %s", report.summary())
+	return report, nil
+}
+
+func (r *watchedSyncReport) apply() {
+	historyMovies := make([]*trakt.HistoryItem, 0)
+	historyEpisodes := make([]*trakt.HistoryItem, 0)
+	// What Kodi has after the sync, for other profiles not to take it
+	// for a change of their own
+	results := make(map[string]*kodiMark)
+
+	for _, change := range r.Changes {
+		switch change.Target {
+		case syncTargetKodi:
+			playCount, position := 0, 0
+			if change.Change == syncWatched {
+				playCount = 1
+			} else if change.total > 0 {
+				position = int(change.Progress / 100 * change.total)
+			} else {
+				r.Errors = append(r.Errors, fmt.Sprintf("Unknown duration of %s, unable to resume", change.Title))
+				continue
+			}
+			if playCount > 0 {
+				results[change.key] = &kodiMark{Watched: true}
+			} else {
+				results[change.key] = &kodiMark{Progress: change.Progress}
+			}
+			total := int(change.total)
+			if change.Type == "movie" {
+				xbmc.SetMoviePlayState(change.libraryId, playCount, position, total, change.At)
+			} else {
+				xbmc.SetEpisodePlayState(change.libraryId, playCount, position, total, change.At)
+			}
+		case syncTargetTrakt:
+			if change.Change == syncResume {
+				tmdbId := change.TMDBId
+				if change.Type == "episode" {
+					tmdbId = change.episodeId
+				}
+				if err := trakt.SetPlaybackProgress(r.conf, change.Type, tmdbId, change.Progress); err != nil {
+					r.Errors = append(r.Errors, err.Error())
+				}
+				continue
+			}
+			historyItem := &trakt.HistoryItem{
+				TMDBId:    change.TMDBId,
+				Season:    change.Season,
+				Episode:   change.Episode,
+				WatchedAt: change.At,
+			}
+			if change.Type == "movie" {
+				historyMovies = append(historyMovies, historyItem)
+			} else {
+				historyEpisodes = append(historyEpisodes, historyItem)
+			}
+		}
+	}
+
+	if err := trakt.AddToHistory(r.conf, historyMovies, historyEpisodes); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	for _, err := range r.Errors {
+		libraryLog.Warningf("Watched sync: %s", err)
+	}
+	// Failed changes are tried again next time
+	if len(r.Errors) == 0 {
+		for key, mark := range results {
+			r.marks[key] = mark
+		}
+		for _, conf := range allProfiles() {
+			marks := results
+			if conf.Profile == r.conf.Profile {
+				marks = r.marks
+			}
+			if err := saveKodiMarks(conf, marks); err != nil {
+				libraryLog.Warningf("Watched sync: %s", err)
+			}
+		}
+	}
+	if len(r.Changes) > 0 {
+		updateLibraryMovies()
+		updateLibraryShows()
+	}
+}
+
+// SyncWatched shows what syncing watched states and resume points with
+// Trakt would change, and changes it with ?apply=1.
+func SyncWatched(ctx *gin.Context) {
+	report, err := watchedSync(profileFor(ctx), ctx.Query("apply") == "")
+	if err != nil {
+		ctx.String(200, err.Error())
+		return
+	}
+	if ctx.Query("apply") != "" {
+		uiFor(ctx).Notify("Quasar", report.summary())
+	}
+	ctx.JSON(200, report)
+}
@@ -0,0 +1,74 @@
+package bittorrent
+
+import (
+	"encoding/hex"
+
+	"github.com/scakemyer/libtorrent-go"
+	"github.com/scakemyer/quasar/metrics"
+)
+
+var (
+	sessionDownloadRate = metrics.NewGauge("quasar_session_download_bytes_per_second", "Download rate of all torrents.")
+	sessionUploadRate   = metrics.NewGauge("quasar_session_upload_bytes_per_second", "Upload rate of all torrents.")
+	sessionTorrents     = metrics.NewGauge("quasar_session_torrents", "Torrents in the session.")
+	torrentProgress     = metrics.NewGauge("quasar_torrent_progress_ratio", "Progress of a torrent, from 0 to 1.", "infohash", "name")
+	torrentPeers        = metrics.NewGauge("quasar_torrent_peers", "Peers a torrent is connected to, seeds apart.", "infohash", "name")
+	torrentSeeds        = metrics.NewGauge("quasar_torrent_seeds", "Seeds a torrent is connected to.", "infohash", "name")
+	torrentDownloadRate = metrics.NewGauge("quasar_torrent_download_bytes_per_second", "Download rate of a torrent.", "infohash", "name")
+	torrentUploadRate   = metrics.NewGauge("quasar_torrent_upload_bytes_per_second", "Upload rate of a torrent.", "infohash", "name")
+	bufferSeconds       = metrics.NewHistogram("quasar_player_buffer_seconds", "Time taken to buffer before playing, by result.", metrics.DurationBuckets, "result")
+)
+
+// torrentMetrics gathers the metrics of torrents over a round of
+// downloadProgress, for torrents gone to be dropped after.
+type torrentMetrics struct {
+	seen         map[string]bool
+	downloadRate float64
+	uploadRate   float64
+}
+
+func newTorrentMetrics() *torrentMetrics {
+	return &torrentMetrics{seen: make(map[string]bool)}
+}
+
+func (m *torrentMetrics) observe(torrentStatus libtorrent.TorrentStatus) {
+	infoHash := hex.EncodeToString([]byte(torrentStatus.GetInfoHash().ToString()))
+	name := torrentStatus.GetName()
+	m.seen[infoHash+"\xff"+name] = true
+
+	downloadRate := float64(torrentStatus.GetDownloadRate())
+	uploadRate := float64(torrentStatus.GetUploadRate())
+	m.downloadRate += downloadRate
+	m.uploadRate += uploadRate
+
+	seeds := torrentStatus.GetNumSeeds()
+	torrentProgress.Set(float64(torrentStatus.GetProgress()), infoHash, name)
+	torrentPeers.Set(float64(torrentStatus.GetNumPeers()-seeds), infoHash, name)
+	torrentSeeds.Set(float64(seeds), infoHash, name)
+	torrentDownloadRate.Set(downloadRate, infoHash, name)
+	torrentUploadRate.Set(uploadRate, infoHash, name)
+}
+
+func (m *torrentMetrics) publish() {
+	sessionDownloadRate.Set(m.downloadRate)
+	sessionUploadRate.Set(m.uploadRate)
+	sessionTorrents.Set(float64(len(m.seen)))
+
+	keep := func(values []string) bool {
+		return m.seen[values[0]+"\xff"+values[1]]
+	}
+	for _, gauge := range []*metrics.Gauge{torrentProgress, torrentPeers, torrentSeeds, torrentDownloadRate, torrentUploadRate} {
+		gauge.Retain(keep)
+	}
+}
+
+// bufferResult labels how buffering ended.
+func bufferResult(err error) string {
+	switch err.(type) {
+	case nil:
+		return "buffered"
+	case *StallError:
+		return "stalled"
+	}
+	return "failed"
+}
@@ -1,29 +1,30 @@
 package bittorrent
 
 import (
-	"os"
-	"fmt"
-	"math"
-	"sort"
-	"sync"
-	"time"
 	"bufio"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"os/exec"
-	"io/ioutil"
-	"encoding/hex"
-	"path/filepath"
+	"sync"
+	"time"
 
-	"github.com/op/go-logging"
 	"github.com/dustin/go-humanize"
+	"github.com/op/go-logging"
 	"github.com/scakemyer/libtorrent-go"
 	"github.com/scakemyer/quasar/broadcast"
-	"github.com/scakemyer/quasar/diskusage"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/diskusage"
 	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/xbmc"
 	"github.com/zeebo/bencode"
 )
@@ -35,20 +36,104 @@ const (
 	minCandidateSize   = 100 * 1024 * 1024
 )
 
-var (
-	Paused        bool
-	Seeked        bool
-	Playing       bool
-	WasPlaying    bool
-	FromLibrary   bool
-	WatchedTime   float64
-	VideoDuration float64
-)
+// PlayerState is where a player's playback is at, kept once it stopped
+// until the next one starts.
+type PlayerState struct {
+	mx            sync.RWMutex
+	fromLibrary   bool
+	paused        bool
+	stopped       bool
+	stopHandled   bool
+	updateHandled bool
+	resumed       bool
+	watchedTime   float64
+	videoDuration float64
+}
+
+// FromLibrary tells whether playback started from Kodi's library.
+func (ps *PlayerState) FromLibrary() bool {
+	return ps.fromLibrary
+}
+
+// Times returns how far playback got and the video's duration, in seconds.
+func (ps *PlayerState) Times() (watchedTime float64, videoDuration float64) {
+	ps.mx.RLock()
+	defer ps.mx.RUnlock()
+	return ps.watchedTime, ps.videoDuration
+}
+
+func (ps *PlayerState) setTimes(watchedTime float64, videoDuration float64) {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	ps.watchedTime = watchedTime
+	ps.videoDuration = videoDuration
+}
+
+// SetPaused marks playback paused or not, returning whether it was.
+func (ps *PlayerState) SetPaused(paused bool) bool {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	was := ps.paused
+	ps.paused = paused
+	return was
+}
+
+// TakeResume tells whether playback should resume from where it was left,
+// only once per player.
+func (ps *PlayerState) TakeResume() bool {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	if ps.resumed || ps.stopped {
+		return false
+	}
+	ps.resumed = true
+	return true
+}
+
+// Stopped tells whether the player stopped.
+func (ps *PlayerState) Stopped() bool {
+	ps.mx.RLock()
+	defer ps.mx.RUnlock()
+	return ps.stopped
+}
+
+// TakeStop tells whether Kodi's stop notification is the player's, only
+// once, as later ones are of other players.
+func (ps *PlayerState) TakeStop() bool {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	if ps.stopHandled {
+		return false
+	}
+	ps.stopHandled = true
+	return true
+}
+
+// TakeLibraryUpdate tells whether the player stopped, only once, for the
+// library to be updated once after it.
+func (ps *PlayerState) TakeLibraryUpdate() bool {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	if !ps.stopped || ps.updateHandled {
+		return false
+	}
+	ps.updateHandled = true
+	return true
+}
+
+func (ps *PlayerState) stop() {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+	ps.stopped = true
+	ps.paused = false
+}
 
 type BTPlayer struct {
 	bts                      *BTService
 	log                      *logging.Logger
-	dialogProgress           *xbmc.DialogProgress
+	state                    *PlayerState
+	ui                       ui.UI
+	dialogProgress           ui.Progress
 	overlayStatus            *xbmc.OverlayStatus
 	uri                      string
 	fastResumeFile           string
@@ -61,7 +146,14 @@ type BTPlayer struct {
 	showId                   int
 	season                   int
 	episode                  int
+	runtime                  int
+	fallback                 bool
+	stalled                  bool
+	bufferStart              time.Time
+	lastPeersTime            time.Time
+	slowSince                time.Time
 	scrobble                 bool
+	profile                  *config.Configuration
 	deleteAfter              bool
 	askToDelete              bool
 	askToKeepDownloading     bool
@@ -84,6 +176,7 @@ type BTPlayer struct {
 	diskStatus               *diskusage.DiskStatus
 	bufferEvents             *broadcast.Broadcaster
 	closing                  chan interface{}
+	closed                   chan interface{}
 }
 
 type BTPlayerParams struct {
@@ -96,6 +189,23 @@ type BTPlayerParams struct {
 	ShowID       int
 	Season       int
 	Episode      int
+	Runtime     int
+	Fallback    bool
+	// Who answers the player's dialogs, the current UI when nil
+	UI ui.UI
+	// Whose Trakt account gets the scrobbles, the default profile when nil
+	Profile *config.Configuration
+}
+
+// StallError is returned by Buffer when the torrent doesn't get going,
+// so another link can be tried in its place.
+type StallError struct {
+	InfoHash string
+	Reason   string
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("Torrent %s stalled: %s", e.InfoHash, e.Reason)
 }
 
 type candidateFile struct {
@@ -109,13 +219,15 @@ func (a byFilename) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byFilename) Less(i, j int) bool { return a[i].Filename < a[j].Filename }
 
 func NewBTPlayer(bts *BTService, params BTPlayerParams) *BTPlayer {
-	Playing = true
-	if params.FromLibrary {
-		FromLibrary = true
+	profile := params.Profile
+	if profile == nil {
+		profile = config.Get()
 	}
 	btp := &BTPlayer{
 		log:                  logging.MustGetLogger("btplayer"),
 		bts:                  bts,
+		state:                &PlayerState{fromLibrary: params.FromLibrary},
+		ui:                   ui.Or(params.UI),
 		uri:                  params.URI,
 		fileIndex:            params.FileIndex,
 		resumeIndex:          params.ResumeIndex,
@@ -125,12 +237,15 @@ func NewBTPlayer(bts *BTService, params BTPlayerParams) *BTPlayer {
 		askToKeepDownloading: config.Get().BackgroundHandling == false,
 		deleteAfter:          config.Get().KeepFilesAfterStop == false,
 		askToDelete:          config.Get().KeepFilesAsk == true,
-		scrobble:             config.Get().Scrobble == true && params.TMDBId > 0 && config.Get().TraktToken != "",
+		scrobble:             profile.Scrobble == true && params.TMDBId > 0 && profile.TraktToken != "",
+		profile:              profile,
 		contentType:          params.ContentType,
 		tmdbId:               params.TMDBId,
 		showId:               params.ShowID,
 		season:               params.Season,
 		episode:              params.Episode,
+		runtime:              params.Runtime,
+		fallback:             params.Fallback,
 		fastResumeFile:       "",
 		torrentFile:          "",
 		partsFile:            "",
@@ -138,9 +253,11 @@ func NewBTPlayer(bts *BTService, params BTPlayerParams) *BTPlayer {
 		isDownloading:        false,
 		notEnoughSpace:       false,
 		closing:              make(chan interface{}),
+		closed:               make(chan interface{}),
 		bufferEvents:         broadcast.NewBroadcaster(),
 		bufferPiecesProgress: map[int]float64{},
 	}
+	bts.setPlayerState(btp.state)
 	return btp
 }
 
@@ -148,7 +265,7 @@ func (btp *BTPlayer) addTorrent() error {
 	btp.log.Infof("Adding torrent from %s", btp.uri)
 
 	if btp.bts.config.DownloadPath == "." {
-		xbmc.Notify("Quasar", "LOCALIZE[30113]", config.AddonIcon())
+		btp.ui.Notify("Quasar", "LOCALIZE[30113]")
 		return fmt.Errorf("Download path empty")
 	}
 
@@ -294,7 +411,11 @@ func (btp *BTPlayer) PlayURL() string {
 	}
 }
 
-func (btp *BTPlayer) Buffer() error {
+func (btp *BTPlayer) Buffer() (err error) {
+	defer func(start time.Time) {
+		bufferSeconds.Since(start, bufferResult(err))
+	}(time.Now())
+
 	if btp.resumeIndex >= 0 {
 		if err := btp.resumeTorrent(); err != nil {
 			return err
@@ -308,7 +429,7 @@ func (btp *BTPlayer) Buffer() error {
 	buffered, done := btp.bufferEvents.Listen()
 	defer close(done)
 
-	btp.dialogProgress = xbmc.NewDialogProgress("Quasar", "", "", "")
+	btp.dialogProgress = btp.ui.Progress("Quasar")
 	defer btp.dialogProgress.Close()
 
 	btp.overlayStatus = xbmc.NewOverlayStatus()
@@ -370,7 +491,7 @@ func (btp *BTPlayer) CheckAvailableSpace() bool {
 
 		if availableSpace < sizeLeft {
 			btp.log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", btp.bts.config.DownloadPath, btp.diskStatus.Free, sizeLeft)
-			xbmc.Notify("Quasar", "LOCALIZE[30207]", config.AddonIcon())
+			btp.ui.Notify("Quasar", "LOCALIZE[30207]")
 			btp.bufferEvents.Broadcast(errors.New("Not enough space on download destination."))
 			btp.notEnoughSpace = true
 			return false
@@ -560,7 +681,7 @@ func (btp *BTPlayer) chooseFile() (int, error) {
 		re := regexp.MustCompile("(?i).*\\.rar")
 		if re.MatchString(fileName) && size > 10 * 1024 * 1024 {
 			btp.isRarArchive = true
-			if !xbmc.DialogConfirm("Quasar", "LOCALIZE[30303]") {
+			if !btp.ui.Confirm("Quasar", "LOCALIZE[30303]") {
 				btp.notEnoughSpace = true
 				return i, errors.New("RAR archive detected and download was cancelled")
 			}
@@ -609,7 +730,7 @@ func (btp *BTPlayer) chooseFile() (int, error) {
 			items = append(items, choice.Filename)
 		}
 
-		choice := xbmc.ListDialog("LOCALIZE[30223]", items...)
+		choice := btp.ui.Choose("LOCALIZE[30223]", "", items...)
 		if choice >= 0 {
 			return choices[choice].Index, nil
 		} else {
@@ -656,18 +777,25 @@ func (btp *BTPlayer) onStateChanged(stateAlert libtorrent.StateChangedAlert) {
 }
 
 func (btp *BTPlayer) Close() {
+	defer close(btp.closed)
 	close(btp.closing)
+	btp.state.stop()
+
+	if btp.stalled {
+		btp.removeStalled()
+		return
+	}
 
 	askedToKeepDownloading := true
 	if btp.askToKeepDownloading == true {
-		if !xbmc.DialogConfirm("Quasar", "LOCALIZE[30146]") {
+		if !btp.ui.Confirm("Quasar", "LOCALIZE[30146]") {
 			askedToKeepDownloading = false
 		}
 	}
 
 	askedToDelete := false
 	if btp.askToDelete == true && (btp.askToKeepDownloading == false || askedToKeepDownloading == false) {
-		if xbmc.DialogConfirm("Quasar", "LOCALIZE[30269]") {
+		if btp.ui.Confirm("Quasar", "LOCALIZE[30269]") {
 			askedToDelete = true
 		}
 	}
@@ -700,6 +828,63 @@ func (btp *BTPlayer) Close() {
 	}
 }
 
+// Closed is closed once the player is done with its torrent.
+func (btp *BTPlayer) Closed() <-chan interface{} {
+	return btp.closed
+}
+
+// removeStalled gets rid of a torrent that failed to buffer, without
+// asking anything as another one is about to replace it.
+func (btp *BTPlayer) removeStalled() {
+	status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
+	shaHash := status.GetInfoHash().ToString()
+	infoHash := hex.EncodeToString([]byte(shaHash))
+
+	btp.log.Infof("Removing stalled torrent %s", infoHash)
+	btp.bts.UpdateDB(Delete, infoHash, 0, "")
+	btp.bts.Session.GetHandle().RemoveTorrent(btp.torrentHandle, int(libtorrent.SessionHandleDeleteFiles))
+
+	for _, file := range []string{btp.torrentFile, btp.fastResumeFile, btp.partsFile} {
+		if _, err := os.Stat(file); file != "" && err == nil {
+			os.Remove(file)
+		}
+	}
+}
+
+// stallReason tells why buffering should be given up on, if it should,
+// according to the configured stall conditions.
+func (btp *BTPlayer) stallReason(status libtorrent.TorrentStatus) string {
+	now := time.Now()
+	if status.GetNumPeers() > 0 {
+		btp.lastPeersTime = now
+	}
+
+	metadataTimeout := time.Duration(btp.bts.config.StallMetadataTimeout) * time.Second
+	if metadataTimeout > 0 && status.GetHasMetadata() == false && now.Sub(btp.bufferStart) > metadataTimeout {
+		return fmt.Sprintf("no metadata after %d seconds", btp.bts.config.StallMetadataTimeout)
+	}
+
+	peersTimeout := time.Duration(btp.bts.config.StallPeersTimeout) * time.Second
+	if peersTimeout > 0 && now.Sub(btp.lastPeersTime) > peersTimeout {
+		return fmt.Sprintf("no peers for %d seconds", btp.bts.config.StallPeersTimeout)
+	}
+
+	// Too slow to ever keep up with playback
+	speedTimeout := time.Duration(btp.bts.config.StallSpeedTimeout) * time.Second
+	if speedTimeout > 0 && btp.runtime > 0 && btp.fileSize > 0 {
+		bitrate := btp.fileSize / int64(btp.runtime*60)
+		if int64(status.GetDownloadRate()) >= bitrate {
+			btp.slowSince = time.Time{}
+		} else if btp.slowSince.IsZero() {
+			btp.slowSince = now
+		} else if now.Sub(btp.slowSince) > speedTimeout {
+			return fmt.Sprintf("download rate below %s/s for %d seconds", humanize.Bytes(uint64(bitrate)), btp.bts.config.StallSpeedTimeout)
+		}
+	}
+
+	return ""
+}
+
 func (btp *BTPlayer) consumeAlerts() {
 	alerts, alertsDone := btp.bts.Alerts()
 	defer close(alertsDone)
@@ -763,6 +948,9 @@ func (btp *BTPlayer) bufferDialog() {
 	oneSecond := time.NewTicker(1 * time.Second)
 	defer oneSecond.Stop()
 
+	btp.bufferStart = time.Now()
+	btp.lastPeersTime = btp.bufferStart
+
 	for {
 		select {
 		case <-halfSecond.C:
@@ -775,6 +963,20 @@ func (btp *BTPlayer) bufferDialog() {
 		case <-oneSecond.C:
 			status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 
+			if btp.fallback && int(status.GetState()) != 1 && !btp.isRarArchive {
+				if reason := btp.stallReason(status); reason != "" {
+					shaHash := status.GetInfoHash().ToString()
+					err := &StallError{
+						InfoHash: hex.EncodeToString([]byte(shaHash)),
+						Reason:   reason,
+					}
+					btp.log.Warning(err)
+					btp.stalled = true
+					btp.bufferEvents.Broadcast(err)
+					return
+				}
+			}
+
 			// Handle "Checking" state for resumed downloads
 			if int(status.GetState()) == 1 || btp.isRarArchive {
 				progress := float64(status.GetProgress())
@@ -805,7 +1007,7 @@ func (btp *BTPlayer) bufferDialog() {
 					if err != nil {
 						btp.log.Error(err)
 						btp.bufferEvents.Broadcast(err)
-						xbmc.Notify("Quasar", "LOCALIZE[30304]", config.AddonIcon())
+						btp.ui.Notify("Quasar", "LOCALIZE[30304]")
 						return
 					}
 
@@ -820,7 +1022,7 @@ func (btp *BTPlayer) bufferDialog() {
 					if err != nil {
 						btp.log.Error(err)
 						btp.bufferEvents.Broadcast(err)
-						xbmc.Notify("Quasar", "LOCALIZE[30305]", config.AddonIcon())
+						btp.ui.Notify("Quasar", "LOCALIZE[30305]")
 						return
 					}
 
@@ -828,7 +1030,7 @@ func (btp *BTPlayer) bufferDialog() {
 					if err != nil {
 						btp.log.Error(err)
 						btp.bufferEvents.Broadcast(err)
-						xbmc.Notify("Quasar", "LOCALIZE[30306]", config.AddonIcon())
+						btp.ui.Notify("Quasar", "LOCALIZE[30306]")
 						return
 					}
 
@@ -866,7 +1068,7 @@ func (btp *BTPlayer) findExtracted(destPath string) {
 	if err != nil {
 		btp.log.Error(err)
 		btp.bufferEvents.Broadcast(err)
-		xbmc.Notify("Quasar", "LOCALIZE[30307]", config.AddonIcon())
+		btp.ui.Notify("Quasar", "LOCALIZE[30307]")
 		return
 	}
 	if len(files) == 1 {
@@ -907,12 +1109,21 @@ func (btp *BTPlayer) setRateLimiting(enable bool) {
 	}
 }
 
-func updateWatchTimes() {
+func (btp *BTPlayer) updateWatchTimes() (watchedTime float64, videoDuration float64) {
 	ret := xbmc.GetWatchTimes()
-	err := ret["error"]
-	if err == "" {
-		WatchedTime, _ = strconv.ParseFloat(ret["watchedTime"], 64)
-		VideoDuration, _ = strconv.ParseFloat(ret["videoDuration"], 64)
+	if ret["error"] == "" {
+		watchedTime, _ = strconv.ParseFloat(ret["watchedTime"], 64)
+		videoDuration, _ = strconv.ParseFloat(ret["videoDuration"], 64)
+		btp.state.setTimes(watchedTime, videoDuration)
+	}
+	return btp.state.Times()
+}
+
+// traktScrobble tells Trakt where playback is at, if enabled.
+func (btp *BTPlayer) traktScrobble(action string) {
+	if btp.scrobble {
+		watchedTime, videoDuration := btp.state.Times()
+		trakt.Scrobble(btp.profile, action, btp.contentType, btp.tmdbId, watchedTime, videoDuration)
 	}
 }
 
@@ -953,12 +1164,13 @@ playbackWaitLoop:
 	overlayStatusActive := false
 	playing := true
 
-	updateWatchTimes()
+	events, eventsDone := xbmc.KodiEvents.Listen()
+	defer close(eventsDone)
 
-	btp.log.Infof("Got playback: %fs / %fs", WatchedTime, VideoDuration)
-	if btp.scrobble {
-		trakt.Scrobble("start", btp.contentType, btp.tmdbId, WatchedTime, VideoDuration)
-	}
+	watchedTime, videoDuration := btp.updateWatchTimes()
+
+	btp.log.Infof("Got playback: %fs / %fs", watchedTime, videoDuration)
+	btp.traktScrobble("start")
 
 playbackLoop:
 	for {
@@ -966,21 +1178,34 @@ playbackLoop:
 			break playbackLoop
 		}
 		select {
-		case <-oneSecond.C:
-			if Seeked {
-				Seeked = false
-				updateWatchTimes()
-				if btp.scrobble {
-					trakt.Scrobble("start", btp.contentType, btp.tmdbId, WatchedTime, VideoDuration)
-				}
-			} else if xbmc.PlayerIsPaused() {
+		case event := <-events:
+			playerEvent, ok := event.(*xbmc.PlayerEvent)
+			if !ok {
+				continue
+			}
+			switch playerEvent.Method {
+			case xbmc.PlayerOnSeek:
+				btp.updateWatchTimes()
+				btp.traktScrobble("start")
+			case xbmc.PlayerOnPause:
 				if playing == true {
 					playing = false
-					updateWatchTimes()
-					if btp.scrobble {
-						trakt.Scrobble("pause", btp.contentType, btp.tmdbId, WatchedTime, VideoDuration)
-					}
+					btp.updateWatchTimes()
+					btp.traktScrobble("pause")
+				}
+			case xbmc.PlayerOnResume:
+				if playing == false {
+					playing = true
+					btp.updateWatchTimes()
+					btp.traktScrobble("start")
+				}
+				if overlayStatusActive == true {
+					btp.overlayStatus.Hide()
+					overlayStatusActive = false
 				}
+			}
+		case <-oneSecond.C:
+			if playing == false {
 				if btp.overlayStatusEnabled == true {
 					status := btp.torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 					progress := float64(status.GetProgress())
@@ -992,31 +1217,13 @@ playbackLoop:
 					}
 				}
 			} else {
-				updateWatchTimes()
-				if playing == false {
-					playing = true
-					if btp.scrobble {
-						trakt.Scrobble("start", btp.contentType, btp.tmdbId, WatchedTime, VideoDuration)
-					}
-				}
-				if overlayStatusActive == true {
-					btp.overlayStatus.Hide()
-					overlayStatusActive = false
-				}
+				btp.updateWatchTimes()
 			}
 		}
 	}
 
-	if btp.scrobble {
-		trakt.Scrobble("stop", btp.contentType, btp.tmdbId, WatchedTime, VideoDuration)
-	}
-	Paused = false
-	Seeked = false
-	Playing = false
-	WasPlaying = true
-	FromLibrary = false
-	WatchedTime = 0
-	VideoDuration = 0
+	btp.traktScrobble("stop")
+	btp.state.stop()
 
 	btp.overlayStatus.Close()
 	btp.setRateLimiting(false)
@@ -1,29 +1,31 @@
 package bittorrent
 
 import (
-	"os"
-	"io"
-	"fmt"
-	"time"
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"regexp"
-	"strings"
-	"strconv"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
-	"encoding/hex"
-	"encoding/json"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/op/go-logging"
 	"github.com/dustin/go-humanize"
+	"github.com/op/go-logging"
 	"github.com/scakemyer/libtorrent-go"
 	"github.com/scakemyer/quasar/broadcast"
-	"github.com/scakemyer/quasar/diskusage"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/diskusage"
 	"github.com/scakemyer/quasar/tmdb"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 	"github.com/zeebo/bencode"
@@ -123,9 +125,57 @@ type BTConfiguration struct {
 	CompletedMove       bool
 	CompletedMoviesPath string
 	CompletedShowsPath  string
+	StallMetadataTimeout int
+	StallPeersTimeout    int
+	StallSpeedTimeout    int
 	Proxy               *ProxySettings
 }
 
+// NewBTConfiguration makes the session configuration out of the settings.
+func NewBTConfiguration(conf *config.Configuration) *BTConfiguration {
+	btConfig := &BTConfiguration{
+		SpoofUserAgent:       conf.SpoofUserAgent,
+		BufferSize:           conf.BufferSize,
+		MaxUploadRate:        conf.UploadRateLimit,
+		MaxDownloadRate:      conf.DownloadRateLimit,
+		LimitAfterBuffering:  conf.LimitAfterBuffering,
+		ConnectionsLimit:     conf.ConnectionsLimit,
+		SessionSave:          conf.SessionSave,
+		ShareRatioLimit:      conf.ShareRatioLimit,
+		SeedTimeRatioLimit:   conf.SeedTimeRatioLimit,
+		SeedTimeLimit:        conf.SeedTimeLimit,
+		DisableDHT:           conf.DisableDHT,
+		DisableUPNP:          conf.DisableUPNP,
+		EncryptionPolicy:     conf.EncryptionPolicy,
+		LowerListenPort:      conf.BTListenPortMin,
+		UpperListenPort:      conf.BTListenPortMax,
+		ListenInterfaces:     conf.ListenInterfaces,
+		OutgoingInterfaces:   conf.OutgoingInterfaces,
+		TunedStorage:         conf.TunedStorage,
+		DownloadPath:         conf.DownloadPath,
+		TorrentsPath:         conf.TorrentsPath,
+		DisableBgProgress:    conf.DisableBgProgress,
+		CompletedMove:        conf.CompletedMove,
+		CompletedMoviesPath:  conf.CompletedMoviesPath,
+		CompletedShowsPath:   conf.CompletedShowsPath,
+		StallMetadataTimeout: conf.StallMetadataTimeout,
+		StallPeersTimeout:    conf.StallPeersTimeout,
+		StallSpeedTimeout:    conf.StallSpeedTimeout,
+	}
+
+	if conf.SocksEnabled == true {
+		btConfig.Proxy = &ProxySettings{
+			Type:     conf.ProxyType,
+			Hostname: conf.SocksHost,
+			Port:     conf.SocksPort,
+			Username: conf.SocksLogin,
+			Password: conf.SocksPassword,
+		}
+	}
+
+	return btConfig
+}
+
 type BTService struct {
 	db                *bolt.DB
 	Session           libtorrent.Session
@@ -139,6 +189,9 @@ type BTService struct {
 	MarkedToMove      int
 	UserAgent         string
 	closing           chan interface{}
+
+	playerMx    sync.RWMutex
+	playerState *PlayerState
 }
 
 type DBItem struct {
@@ -157,6 +210,26 @@ type PlayingItem struct {
 	Duration    float64
 }
 
+func (s *BTService) setPlayerState(state *PlayerState) {
+	s.playerMx.Lock()
+	defer s.playerMx.Unlock()
+	s.playerState = state
+}
+
+// PlayerState returns the state of the current player, or of the last one
+// once stopped, nil before any played.
+func (s *BTService) PlayerState() *PlayerState {
+	s.playerMx.RLock()
+	defer s.playerMx.RUnlock()
+	return s.playerState
+}
+
+// IsPlaying tells whether a player is running.
+func (s *BTService) IsPlaying() bool {
+	state := s.PlayerState()
+	return state != nil && !state.Stopped()
+}
+
 type ResumeFile struct {
 	InfoHash string     `bencode:"info-hash"`
 	Trackers [][]string `bencode:"trackers"`
@@ -191,7 +264,7 @@ func NewBTService(conf BTConfiguration, db *bolt.DB) *BTService {
 		_, err := tx.CreateBucketIfNotExists([]byte(Bucket))
 		if err != nil {
 			s.log.Error(err)
-			xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+			ui.Get().Notify("Quasar", err.Error())
 			return err
 		}
 		return nil
@@ -228,6 +301,59 @@ func (s *BTService) Reconfigure(config BTConfiguration) {
 	s.loadTorrentFiles()
 }
 
+// Apply applies a new configuration, only restarting the session when some
+// of its changes need it.
+func (s *BTService) Apply(config BTConfiguration, restartSession bool) {
+	if restartSession {
+		s.Reconfigure(config)
+		return
+	}
+	s.config = &config
+	s.log.Info("Applying session settings live...")
+	s.applyLimits(s.packSettings)
+	s.Session.GetHandle().ApplySettings(s.packSettings)
+}
+
+// applyLimits sets the rate, seeding and connections limits, which don't
+// need a restart of the session.
+func (s *BTService) applyLimits(settings libtorrent.SettingsPack) {
+	settings.SetInt(libtorrent.SettingByName("download_rate_limit"), 0)
+	settings.SetInt(libtorrent.SettingByName("upload_rate_limit"), 0)
+	settings.SetInt(libtorrent.SettingByName("choking_algorithm"), 0)
+	settings.SetInt(libtorrent.SettingByName("share_ratio_limit"), 0)
+	settings.SetInt(libtorrent.SettingByName("seed_time_ratio_limit"), 0)
+	settings.SetInt(libtorrent.SettingByName("seed_time_limit"), 0)
+
+	if s.config.ConnectionsLimit > 0 {
+		settings.SetInt(libtorrent.SettingByName("connections_limit"), s.config.ConnectionsLimit)
+	} else {
+		setPlatformSpecificSettings(settings)
+	}
+
+	if s.config.LimitAfterBuffering == false {
+		if s.config.MaxDownloadRate > 0 {
+			s.log.Infof("Rate limiting download to %dkB/s", s.config.MaxDownloadRate/1024)
+			settings.SetInt(libtorrent.SettingByName("download_rate_limit"), s.config.MaxDownloadRate)
+		}
+		if s.config.MaxUploadRate > 0 {
+			s.log.Infof("Rate limiting upload to %dkB/s", s.config.MaxUploadRate/1024)
+			// If we have an upload rate, use the nicer bittyrant choker
+			settings.SetInt(libtorrent.SettingByName("upload_rate_limit"), s.config.MaxUploadRate)
+			settings.SetInt(libtorrent.SettingByName("choking_algorithm"), int(libtorrent.SettingsPackBittyrantChoker))
+		}
+	}
+
+	if s.config.ShareRatioLimit > 0 {
+		settings.SetInt(libtorrent.SettingByName("share_ratio_limit"), s.config.ShareRatioLimit)
+	}
+	if s.config.SeedTimeRatioLimit > 0 {
+		settings.SetInt(libtorrent.SettingByName("seed_time_ratio_limit"), s.config.SeedTimeRatioLimit)
+	}
+	if s.config.SeedTimeLimit > 0 {
+		settings.SetInt(libtorrent.SettingByName("seed_time_limit"), s.config.SeedTimeLimit)
+	}
+}
+
 func (s *BTService) configure() {
 	settings := libtorrent.NewSettingsPack()
 	s.Session = libtorrent.NewSession(settings, int(libtorrent.SessionHandleAddDefaultPlugins))
@@ -290,12 +416,6 @@ func (s *BTService) configure() {
 	settings.SetBool(libtorrent.SettingByName("announce_to_all_trackers"), true)
 	settings.SetBool(libtorrent.SettingByName("announce_to_all_tiers"), true)
 	settings.SetInt(libtorrent.SettingByName("connection_speed"), 500)
-	settings.SetInt(libtorrent.SettingByName("download_rate_limit"), 0)
-	settings.SetInt(libtorrent.SettingByName("upload_rate_limit"), 0)
-	settings.SetInt(libtorrent.SettingByName("choking_algorithm"), 0)
-	settings.SetInt(libtorrent.SettingByName("share_ratio_limit"), 0)
-	settings.SetInt(libtorrent.SettingByName("seed_time_ratio_limit"), 0)
-	settings.SetInt(libtorrent.SettingByName("seed_time_limit"), 0)
 	settings.SetInt(libtorrent.SettingByName("peer_tos"), ipToSLowCost)
 	settings.SetInt(libtorrent.SettingByName("torrent_connect_boost"), 0)
 	settings.SetBool(libtorrent.SettingByName("rate_limit_ip_overhead"), true)
@@ -323,34 +443,7 @@ func (s *BTService) configure() {
 		settings.SetInt(libtorrent.SettingByName("cache_size"), -1)
 	}
 
-	if s.config.ConnectionsLimit > 0 {
-		settings.SetInt(libtorrent.SettingByName("connections_limit"), s.config.ConnectionsLimit)
-	} else {
-		setPlatformSpecificSettings(settings)
-	}
-
-	if s.config.LimitAfterBuffering == false {
-		if s.config.MaxDownloadRate > 0 {
-			s.log.Infof("Rate limiting download to %dkB/s", s.config.MaxDownloadRate / 1024)
-			settings.SetInt(libtorrent.SettingByName("download_rate_limit"), s.config.MaxDownloadRate)
-		}
-		if s.config.MaxUploadRate > 0 {
-			s.log.Infof("Rate limiting upload to %dkB/s", s.config.MaxUploadRate / 1024)
-			// If we have an upload rate, use the nicer bittyrant choker
-			settings.SetInt(libtorrent.SettingByName("upload_rate_limit"), s.config.MaxUploadRate)
-			settings.SetInt(libtorrent.SettingByName("choking_algorithm"), int(libtorrent.SettingsPackBittyrantChoker))
-		}
-	}
-
-	if s.config.ShareRatioLimit > 0 {
-		settings.SetInt(libtorrent.SettingByName("share_ratio_limit"), s.config.ShareRatioLimit)
-	}
-	if s.config.SeedTimeRatioLimit > 0 {
-		settings.SetInt(libtorrent.SettingByName("seed_time_ratio_limit"), s.config.SeedTimeRatioLimit)
-	}
-	if s.config.SeedTimeLimit > 0 {
-		settings.SetInt(libtorrent.SettingByName("seed_time_limit"), s.config.SeedTimeLimit)
-	}
+	s.applyLimits(settings)
 
 	s.log.Info("Applying encryption settings...")
 	if s.config.EncryptionPolicy > 0 {
@@ -525,7 +618,7 @@ func (s *BTService) checkAvailableSpace(torrentHandle libtorrent.TorrentHandle)
 
 		if availableSpace < sizeLeft {
 			s.log.Errorf("Unsufficient free space on %s. Has %d, needs %d.", path, diskStatus.Free, sizeLeft)
-			xbmc.Notify("Quasar", "LOCALIZE[30207]", config.AddonIcon())
+			ui.Get().Notify("Quasar", "LOCALIZE[30207]")
 
 			s.log.Infof("Pausing torrent %s", torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName)).GetName())
 			torrentHandle.AutoManaged(false)
@@ -720,6 +813,7 @@ func (s *BTService) downloadProgress() {
 			torrentsVector := s.Session.GetHandle().GetTorrents()
 			torrentsVectorSize := int(torrentsVector.Size())
 			activeTorrents := make([]*activeTorrent, 0)
+			observed := newTorrentMetrics()
 
 			for i := 0; i < torrentsVectorSize; i++ {
 				torrentHandle := torrentsVector.Get(i)
@@ -730,6 +824,7 @@ func (s *BTService) downloadProgress() {
 				torrentStatus := torrentHandle.Status(uint(libtorrent.TorrentHandleQueryName))
 				status := StatusStrings[int(torrentStatus.GetState())]
 				isPaused := torrentStatus.GetPaused()
+				observed.observe(torrentStatus)
 				if torrentStatus.GetHasMetadata() == false || s.Session.GetHandle().IsPaused() {
 					continue
 				}
@@ -807,7 +902,7 @@ func (s *BTService) downloadProgress() {
 				//
 				// Handle moving completed downloads
 				//
-				if !s.config.CompletedMove || status != "Seeded" || Playing {
+				if !s.config.CompletedMove || status != "Seeded" || s.IsPlaying() {
 					continue
 				}
 				if xbmc.PlayerIsPlaying() {
@@ -960,6 +1055,7 @@ func (s *BTService) downloadProgress() {
 					return nil
 				})
 			}
+			observed.publish()
 
 			totalActive := len(activeTorrents)
 			if totalActive > 0 {
@@ -1,24 +1,24 @@
 package bittorrent
 
 import (
-	"os"
-	"io"
-	"fmt"
-	"time"
 	"bytes"
-	"regexp"
-	"strings"
-	"net/url"
-	"net/http"
 	"crypto/sha1"
+	"encoding/base32"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/cloudhole"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/release"
 	"github.com/scakemyer/quasar/xbmc"
 	"github.com/zeebo/bencode"
 )
@@ -45,6 +45,12 @@ type Torrent struct {
 	RipType     int    `json:"rip_type"`
 	SceneRating int    `json:"scene_rating"`
 
+	Release      *release.Info `json:"-"`
+	Score        float64       `json:"score,omitempty"`
+	ScoreReasons []string      `json:"score_reasons,omitempty"`
+	// Minutes of what it was found for, for rules to score it again
+	Runtime int `json:"runtime,omitempty"`
+
 	hasResolved bool
 }
 
@@ -64,12 +70,13 @@ const (
 )
 
 var (
-	resolutionTags = map[*regexp.Regexp]int{
-		regexp.MustCompile(`\W+(480p|xvid|dvd|hdtv)\W*`):         Resolution480p,
-		regexp.MustCompile(`\W+(720p|hdrip|bluray|b[rd]rip)\W*`): Resolution720p,
-		regexp.MustCompile(`\W+(1080p|fullhd|fhd)\W*`):           Resolution1080p,
-		regexp.MustCompile(`\W+1440p\W*`):                        Resolution1440p,
-		regexp.MustCompile(`\W+(4K|2160p)\W*`):                   Resolution4k,
+	releaseResolutions = map[string]int{
+		"480p":  Resolution480p,
+		"576p":  Resolution480p,
+		"720p":  Resolution720p,
+		"1080p": Resolution1080p,
+		"1440p": Resolution1440p,
+		"2160p": Resolution4k,
 	}
 	Resolutions = []string{"", "480p", "720p", "1080p", "1440p", "4K"}
 	Colors = []string{"", "FFA56F01", "FF539A02", "FF0166FC", "FFF15052", "FF6BB9EC"}
@@ -89,16 +96,17 @@ const (
 )
 
 var (
-	ripTags = map[*regexp.Regexp]int{
-		regexp.MustCompile(`\W+(cam|camrip|hdcam)\W*`):   RipCam,
-		regexp.MustCompile(`\W+(ts|telesync)\W*`):        RipTS,
-		regexp.MustCompile(`\W+(tc|telecine)\W*`):        RipTC,
-		regexp.MustCompile(`\W+(scr|screener)\W*`):       RipScr,
-		regexp.MustCompile(`\W+dvd\W*scr\W*`):            RipDVDScr,
-		regexp.MustCompile(`\W+dvd\W*rip\W*`):            RipDVD,
-		regexp.MustCompile(`\W+hd(tv|rip)\W*`):           RipHDTV,
-		regexp.MustCompile(`\W+(web\W*dl|web\W*rip)\W*`): RipWeb,
-		regexp.MustCompile(`\W+(bluray|b[rd]rip)\W*`):    RipBluRay,
+	releaseRips = map[string]int{
+		"CAM":    RipCam,
+		"TS":     RipTS,
+		"TC":     RipTC,
+		"SCR":    RipScr,
+		"DVDSCR": RipDVDScr,
+		"DVD":    RipDVD,
+		"HDTV":   RipHDTV,
+		"HDRip":  RipHDTV,
+		"WEB":    RipWeb,
+		"BluRay": RipBluRay,
 	}
 	Rips = []string{"", "Cam", "TeleSync", "TeleCine", "Screener", "DVD Screener", "DVDRip", "HDTV", "WebDL", "Blu-Ray"}
 )
@@ -109,13 +117,6 @@ const (
 	RatingNuked
 )
 
-var (
-	sceneTags = map[*regexp.Regexp]int{
-		regexp.MustCompile(`\W+nuked\W*`):  RatingNuked,
-		regexp.MustCompile(`\W+proper\W*`): RatingProper,
-	}
-)
-
 const (
 	CodecUnknown = iota
 
@@ -129,23 +130,31 @@ const (
 	CodecDTS
 	CodecDTSHD
 	CodecDTSHDMA
+
+	CodecAV1
+	CodecEAC3
+	CodecTrueHD
+	CodecFLAC
 )
 
 var (
-	videoTags = map[*regexp.Regexp]int{
-		regexp.MustCompile(`\W+xvid\W*`):           CodecXVid,
-		regexp.MustCompile(`\W+([hx]264)\W*`):      CodecH264,
-		regexp.MustCompile(`\W+([hx]265|hevc)\W*`): CodecH265,
-	}
-	audioTags = map[*regexp.Regexp]int{
-		regexp.MustCompile(`\W+mp3\W*`):              CodecMp3,
-		regexp.MustCompile(`\W+aac\W*`):              CodecAAC,
-		regexp.MustCompile(`\W+(ac3|[Dd]*5\W+1)\W*`): CodecAC3,
-		regexp.MustCompile(`\W+dts\W*`):              CodecDTS,
-		regexp.MustCompile(`\W+dts\W+hd\W*`):         CodecDTSHD,
-		regexp.MustCompile(`\W+dts\W+hd\W+ma\W*`):    CodecDTSHDMA,
-	}
-	Codecs = []string{"", "Xvid", "H.264", "H.265", "MP3", "AAC", "AC3", "DTS", "DTS HD", "DTS HD MA"}
+	releaseCodecs = map[string]int{
+		"XviD":      CodecXVid,
+		"H.264":     CodecH264,
+		"H.265":     CodecH265,
+		"AV1":       CodecAV1,
+		"MP3":       CodecMp3,
+		"AAC":       CodecAAC,
+		"AC3":       CodecAC3,
+		"E-AC3":     CodecEAC3,
+		"DTS":       CodecDTS,
+		"DTS-HD":    CodecDTSHD,
+		"DTS-HD MA": CodecDTSHDMA,
+		"DTS:X":     CodecDTSHDMA,
+		"TrueHD":    CodecTrueHD,
+		"FLAC":      CodecFLAC,
+	}
+	Codecs = []string{"", "Xvid", "H.264", "H.265", "MP3", "AAC", "AC3", "DTS", "DTS HD", "DTS HD MA", "AV1", "E-AC3", "TrueHD", "FLAC"}
 )
 
 var (
@@ -233,23 +242,32 @@ func (t *Torrent) initialize() {
 		t.initializeFromMagnet()
 	}
 
+	t.Release = release.Parse(t.Name)
+
 	if t.Resolution == ResolutionUnknown {
-		t.Resolution = matchTags(t, resolutionTags)
+		t.Resolution = releaseResolutions[t.Release.Resolution]
 		if t.Resolution == 0 {
 			t.Resolution = 1
 		}
 	}
 	if t.VideoCodec == CodecUnknown {
-		t.VideoCodec = matchTags(t, videoTags)
+		t.VideoCodec = releaseCodecs[t.Release.VideoCodec]
 	}
 	if t.AudioCodec == CodecUnknown {
-		t.AudioCodec = matchTags(t, audioTags)
+		t.AudioCodec = releaseCodecs[t.Release.AudioCodec]
 	}
 	if t.RipType == RipUnknown {
-		t.RipType = matchTags(t, ripTags)
+		t.RipType = releaseRips[t.Release.Source]
 	}
 	if t.SceneRating == RatingUnkown {
-		t.SceneRating = matchTags(t, sceneTags)
+		if t.Release.Nuked {
+			t.SceneRating = RatingNuked
+		} else if t.Release.Proper || t.Release.Repack {
+			t.SceneRating = RatingProper
+		}
+	}
+	if t.Language == "" && len(t.Release.Languages) > 0 {
+		t.Language = strings.Join(t.Release.Languages, ",")
 	}
 }
 
@@ -398,19 +416,6 @@ func (t *Torrent) Resolve() error {
 	return nil
 }
 
-func matchTags(t *Torrent, tokens map[*regexp.Regexp]int) int {
-	lowName := strings.ToLower(t.Name)
-	codec := 0
-	for re, value := range tokens {
-		if re.MatchString(lowName) {
-			if value > codec {
-				codec = value
-			}
-		}
-	}
-	return codec
-}
-
 func (t *Torrent) StreamInfo() *xbmc.StreamInfo {
 	sie := &xbmc.StreamInfo{
 		Video: &xbmc.StreamInfoEntry{
@@ -421,6 +426,18 @@ func (t *Torrent) StreamInfo() *xbmc.StreamInfo {
 		},
 	}
 
+	if t.Release != nil {
+		sie.Audio.Channels = t.Release.Channels()
+		if len(t.Release.Languages) > 0 && t.Release.Languages[0] != "multi" {
+			sie.Audio.Language = t.Release.Languages[0]
+		}
+		if len(t.Release.Subtitles) > 0 && t.Release.Subtitles[0] != "unknown" {
+			sie.Subtitle = &xbmc.StreamInfoEntry{
+				Language: t.Release.Subtitles[0],
+			}
+		}
+	}
+
 	switch t.Resolution {
 	case Resolution480p:
 		sie.Video.Width = 853
@@ -188,11 +188,11 @@ func (tf *TorrentFile) Close() error {
 	tf.tfs.log.Info("Closing file...")
 	tf.removed.Signal()
 
-	tf.libraryBroadcaster.Broadcast(&PlayingItem{
-		DBItem:      tf.dbItem,
-		WatchedTime: WatchedTime,
-		Duration:    VideoDuration,
-	})
+	item := &PlayingItem{DBItem: tf.dbItem}
+	if state := tf.tfs.service.PlayerState(); state != nil {
+		item.WatchedTime, item.Duration = state.Times()
+	}
+	tf.libraryBroadcaster.Broadcast(item)
 
 	return tf.File.Close()
 }
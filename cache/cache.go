@@ -1,13 +1,14 @@
 package cache
 
 import (
-	"time"
 	"errors"
-	"strings"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/metrics"
 	"github.com/scakemyer/quasar/util"
 )
 
@@ -23,6 +24,7 @@ var (
 	errNotStored    = errors.New("cache: not stored")
 	errNotSupported = errors.New("cache: not supported")
 	log             = logging.MustGetLogger("cache")
+	pageRequests    = metrics.NewCounter("quasar_page_cache_requests_total", "Requests of cached pages, by hit or miss.", "result")
 )
 
 type CacheStore interface {
@@ -96,12 +98,21 @@ func (w *cachedWriter) Write(data []byte) (int, error) {
 	return ret, err
 }
 
+// Vary lists the request headers pages differ by, besides their URL.
+var Vary = []string{}
+
 // Cache Middleware
 func Cache(store CacheStore, expire time.Duration) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var cache responseCache
 		key := cacheKey(pageCachePrefix, ctx.Request.URL.RequestURI())
+		for _, header := range Vary {
+			if value := ctx.Request.Header.Get(header); value != "" {
+				key += "." + util.ToFileName(value)
+			}
+		}
 		if err := store.Get(key, &cache); err == nil {
+			pageRequests.Inc("hit")
 			for k, vals := range cache.Header {
 				for _, v := range vals {
 					ctx.Writer.Header().Add(k, v)
@@ -110,6 +121,7 @@ func Cache(store CacheStore, expire time.Duration) gin.HandlerFunc {
 			ctx.AbortWithStatus(cache.Status)
 			ctx.Writer.Write(cache.Data)
 		} else {
+			pageRequests.Inc("miss")
 			// replace writer
 			writer := ctx.Writer
 			ctx.Writer = newCachedWriter(store, expire, ctx.Writer, key)
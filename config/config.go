@@ -59,9 +59,16 @@ type Configuration struct {
 	TraktRefreshToken   string
 	TraktTokenExpiry    int
 	TraktSyncFrequency  int
+	TraktSyncWatched       bool
 	UpdateFrequency     int
 	UpdateDelay         int
 	UpdateAutoScan      bool
+	LibraryBackupFrequency int
+	LibraryBackupsKept     int
+	AutoGrabEpisodes       bool
+	LibraryMode            int
+	LibraryStreamHost      string
+	LibraryStreamToken     string
 	TvScraper           int
 	LibraryResume       int
 	UseCloudHole        bool
@@ -89,6 +96,30 @@ type Configuration struct {
 	CompletedMove       bool
 	CompletedMoviesPath string
 	CompletedShowsPath  string
+
+	StallFallback        bool
+	StallMetadataTimeout int
+	StallPeersTimeout    int
+	StallSpeedTimeout    int
+
+	RemoteBind            string
+	RemoteAllowedNetworks string
+	RemoteTokens          string
+	RemoteUsername        string
+	RemotePassword        string
+
+	// Levels like "info,libtorrent=warning", everything logged when empty
+	LogLevels string
+
+	// Who answers dialogs, set in headless mode only
+	UI           string
+	UIPolicyFile string
+
+	// Name of the profile, empty for the default one
+	Profile string
+
+	// Values of the schema's settings, for telling what changed
+	settings map[string]interface{}
 }
 
 type Addon struct {
@@ -115,6 +146,14 @@ func Get() *Configuration {
 func Reload() *Configuration {
 	log.Info("Reloading configuration...")
 
+	if Headless {
+		// A broken file keeps the current configuration going
+		if _, err := LoadFile(); err != nil {
+			log.Errorf("Unable to reload %s: %s", ConfigFile, err)
+		}
+		return Get()
+	}
+
 	info := xbmc.GetAddonInfo()
 	info.Path = xbmc.TranslatePath(info.Path)
 	info.Profile = xbmc.TranslatePath(info.Profile)
@@ -191,13 +230,30 @@ func Reload() *Configuration {
 		}
 	}
 
-	newConfig := Configuration{
+	newConfig := newConfiguration(info, platform, xbmc.GetLanguageISO_639_1(), downloadPath, libraryPath, normalizeSettings(settings))
+
+	lock.Lock()
+	config = newConfig
+	lock.Unlock()
+
+	return config
+}
+
+// newConfiguration makes a configuration out of settings, which must all
+// be there with the type of the schema.
+func newConfiguration(info *xbmc.AddonInfo, platform *xbmc.Platform, language string, downloadPath string, libraryPath string, settings map[string]interface{}) *Configuration {
+	values := make(map[string]interface{}, len(Schema))
+	for _, setting := range Schema {
+		values[setting.Key] = settings[setting.Key]
+	}
+	return &Configuration{
+		settings:               values,
 		DownloadPath:        downloadPath,
 		LibraryPath:         libraryPath,
 		TorrentsPath:        filepath.Join(downloadPath, "Torrents"),
 		Info:                info,
 		Platform:            platform,
-		Language:            xbmc.GetLanguageISO_639_1(),
+		Language:               language,
 		ProfilePath:         info.Profile,
 		BufferSize:          settings["buffer_size"].(int) * 1024 * 1024,
 		UploadRateLimit:     settings["max_upload_rate"].(int) * 1024,
@@ -234,9 +290,16 @@ func Reload() *Configuration {
 		TraktRefreshToken:   settings["trakt_refresh_token"].(string),
 		TraktTokenExpiry:    settings["trakt_token_expiry"].(int),
 		TraktSyncFrequency:  settings["trakt_sync"].(int),
+		TraktSyncWatched:       settings["trakt_sync_watched"].(bool),
 		UpdateFrequency:     settings["library_update_frequency"].(int),
 		UpdateDelay:         settings["library_update_delay"].(int),
 		UpdateAutoScan:      settings["library_auto_scan"].(bool),
+		LibraryBackupFrequency: settings["library_backup_frequency"].(int),
+		LibraryBackupsKept:     settings["library_backups_kept"].(int),
+		AutoGrabEpisodes:       settings["library_auto_grab"].(bool),
+		LibraryMode:            settings["library_mode"].(int),
+		LibraryStreamHost:      settings["library_stream_host"].(string),
+		LibraryStreamToken:     settings["library_stream_token"].(string),
 		TvScraper:           settings["library_tv_scraper"].(int),
 		LibraryResume:       settings["library_resume"].(int),
 		UseCloudHole:        settings["use_cloudhole"].(bool),
@@ -264,13 +327,20 @@ func Reload() *Configuration {
 		CompletedMove:       settings["completed_move"].(bool),
 		CompletedMoviesPath: settings["completed_movies_path"].(string),
 		CompletedShowsPath:  settings["completed_shows_path"].(string),
-	}
 
-	lock.Lock()
-	config = &newConfig
-	lock.Unlock()
+		StallFallback:        settings["stall_fallback"].(bool),
+		StallMetadataTimeout: settings["stall_metadata_timeout"].(int),
+		StallPeersTimeout:    settings["stall_peers_timeout"].(int),
+		StallSpeedTimeout:    settings["stall_speed_timeout"].(int),
 
-	return config
+		RemoteBind:            settings["remote_bind"].(string),
+		RemoteAllowedNetworks: settings["remote_allowed_networks"].(string),
+		RemoteTokens:          settings["remote_tokens"].(string),
+		RemoteUsername:        settings["remote_username"].(string),
+		RemotePassword:        settings["remote_password"].(string),
+
+		LogLevels: settings["log_levels"].(string),
+	}
 }
 
 func AddonIcon() string {
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Headless mode, with the settings read from a JSON file instead of Kodi
+//
+
+const (
+	HeadlessEnv       = "QUASAR_HEADLESS"
+	ConfigFileEnv     = "QUASAR_CONFIG"
+	DefaultConfigFile = "quasar.json"
+
+	UIKodi   = "kodi"
+	UIPolicy = "policy"
+	UIWeb    = "web"
+)
+
+var (
+	Headless   = false
+	ConfigFile = DefaultConfigFile
+	// Version of the binary, which Kodi tells otherwise
+	Version = ""
+)
+
+// Settings of the file which aren't add-on settings in Kodi
+var pathDefaults = map[string]string{
+	// Where the database, cache and library layout go, next to the file
+	// when empty
+	"profile_path":  "",
+	"download_path": "",
+	"library_path":  "",
+	"language":      "en",
+	// Who answers dialogs, and the rules of the policy UI
+	"ui":             UIPolicy,
+	"ui_policy_file": "",
+}
+
+// fileDefaults are the defaults which differ from Kodi's.
+var fileDefaults = map[string]interface{}{
+	// Without Kodi, the library is for other media servers
+	"library_mode": 1,
+}
+
+func readConfigFile(file string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return values, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("Invalid JSON in %s: %s", file, err)
+	}
+	return values, nil
+}
+
+// loadSettings validates the file's values, all problems at once, and
+// fills in the defaults.
+func loadSettings(values map[string]interface{}) (map[string]interface{}, map[string]string, error) {
+	settings := make(map[string]interface{}, len(Schema))
+	for _, setting := range Schema {
+		settings[setting.Key] = setting.Default
+	}
+	for key, value := range fileDefaults {
+		settings[key] = value
+	}
+	paths := make(map[string]string, len(pathDefaults))
+	for key, value := range pathDefaults {
+		paths[key] = value
+	}
+
+	problems := make([]string, 0)
+	for key, value := range values {
+		if _, ok := pathDefaults[key]; ok {
+			if path, ok := value.(string); ok {
+				paths[key] = path
+			} else {
+				problems = append(problems, fmt.Sprintf("%s must be a string, not %v", key, value))
+			}
+			continue
+		}
+		setting := GetSetting(key)
+		if setting == nil {
+			problems = append(problems, fmt.Sprintf("Unknown setting %s", key))
+			continue
+		}
+		converted, err := setting.Convert(value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		settings[key] = converted
+	}
+
+	if settings["listen_port_min"].(int) > settings["listen_port_max"].(int) {
+		problems = append(problems, "listen_port_min must not be over listen_port_max")
+	}
+	if paths["ui"] != UIPolicy && paths["ui"] != UIWeb {
+		problems = append(problems, fmt.Sprintf("ui must be %s or %s, not %q", UIPolicy, UIWeb, paths["ui"]))
+	}
+	if paths["download_path"] == "" {
+		problems = append(problems, "download_path is required")
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, nil, errors.New(fmt.Sprint(problems))
+	}
+	return settings, paths, nil
+}
+
+// LoadFile loads the configuration of headless mode from ConfigFile.
+func LoadFile() (*Configuration, error) {
+	values, err := readConfigFile(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	settings, paths, err := loadSettings(values)
+	if err != nil {
+		return nil, err
+	}
+
+	profilePath := paths["profile_path"]
+	if profilePath == "" {
+		profilePath = filepath.Dir(ConfigFile)
+	}
+	if profilePath, err = filepath.Abs(profilePath); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(profilePath, 0755); err != nil {
+		return nil, err
+	}
+	info := &xbmc.AddonInfo{
+		Id:       "plugin.video.quasar",
+		Name:     "Quasar",
+		Path:     profilePath,
+		Profile:  profilePath,
+		Version:  Version,
+		TempPath: filepath.Join(os.TempDir(), "quasar"),
+	}
+	os.MkdirAll(info.TempPath, 0777)
+	platform := &xbmc.Platform{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+
+	downloadPath := filepath.Clean(paths["download_path"])
+	if err := IsWritablePath(downloadPath); err != nil {
+		return nil, fmt.Errorf("download_path: %s", err)
+	}
+	libraryPath := downloadPath
+	if paths["library_path"] != "" {
+		libraryPath = filepath.Clean(paths["library_path"])
+		if err := IsWritablePath(libraryPath); err != nil {
+			return nil, fmt.Errorf("library_path: %s", err)
+		}
+	}
+	log.Infof("Using download path: %s", downloadPath)
+	log.Infof("Using library path: %s", libraryPath)
+
+	newConfig := newConfiguration(info, platform, paths["language"], downloadPath, libraryPath, settings)
+	newConfig.UI = paths["ui"]
+	newConfig.UIPolicyFile = paths["ui_policy_file"]
+	if newConfig.UIPolicyFile != "" && !filepath.IsAbs(newConfig.UIPolicyFile) {
+		newConfig.UIPolicyFile = filepath.Join(profilePath, newConfig.UIPolicyFile)
+	}
+	xbmc.SettingSaver = saveFileSetting
+
+	lock.Lock()
+	config = newConfig
+	settingsSet = true
+	lock.Unlock()
+
+	return newConfig, nil
+}
+
+// saveFileSetting writes a setting changed by Quasar back to the file, and
+// applies it.
+func saveFileSetting(id string, value interface{}) error {
+	setting := GetSetting(id)
+	if setting == nil {
+		// Kodi-only settings, e.g. view modes
+		log.Debugf("Not saving %s, unknown to the file", id)
+		return nil
+	}
+	converted, err := setting.Convert(value)
+	if err != nil {
+		return err
+	}
+	return saveFileSettings(map[string]interface{}{id: converted})
+}
+
+// saveFileSettings writes settings to the file at once, and applies them.
+func saveFileSettings(settings map[string]interface{}) error {
+	values, err := readConfigFile(ConfigFile)
+	if err != nil {
+		return err
+	}
+	for key, value := range settings {
+		values[key] = value
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(ConfigFile, data, 0600); err != nil {
+		return err
+	}
+	_, err = LoadFile()
+	return err
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Profiles, sharing Quasar and its torrent session, each with its own Trakt
+// account and the settings going with it. The default profile is the
+// add-on's settings, others are JSON files of the profile directory.
+//
+
+const DefaultProfile = ""
+
+var profileNameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+type cachedProfile struct {
+	base    *Configuration
+	modTime time.Time
+	config  *Configuration
+}
+
+var (
+	profilesMx     sync.Mutex
+	cachedProfiles = make(map[string]*cachedProfile)
+)
+
+func profilesDir() string {
+	return filepath.Join(Get().ProfilePath, "profiles")
+}
+
+func profileFile(name string) string {
+	return filepath.Join(profilesDir(), name+".json")
+}
+
+// ValidProfileName tells whether a name can be a profile's: lower case
+// letters, digits, - and _.
+func ValidProfileName(name string) bool {
+	return profileNameRegexp.MatchString(name)
+}
+
+// Profiles lists the profiles but the default one.
+func Profiles() []string {
+	files, _ := filepath.Glob(filepath.Join(profilesDir(), "*.json"))
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		if ValidProfileName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the configuration of a profile, the default one's
+// with the profile's own settings over it.
+func GetProfile(name string) (*Configuration, error) {
+	base := Get()
+	if name == DefaultProfile {
+		return base, nil
+	}
+	if !ValidProfileName(name) {
+		return nil, fmt.Errorf("Invalid profile name %q", name)
+	}
+	file := profileFile(name)
+	stat, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No profile %s", name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	profilesMx.Lock()
+	defer profilesMx.Unlock()
+	if cached, ok := cachedProfiles[name]; ok && cached.base == base && cached.modTime.Equal(stat.ModTime()) {
+		return cached.config, nil
+	}
+
+	values, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := profileSettings(values)
+	if err != nil {
+		return nil, fmt.Errorf("Profile %s: %s", name, err)
+	}
+	settings := make(map[string]interface{}, len(base.settings))
+	for key, value := range base.settings {
+		settings[key] = value
+	}
+	for key, value := range overrides {
+		settings[key] = value
+	}
+	profileConfig := newConfiguration(base.Info, base.Platform, base.Language, base.DownloadPath, base.LibraryPath, settings)
+	profileConfig.UI = base.UI
+	profileConfig.UIPolicyFile = base.UIPolicyFile
+	profileConfig.Profile = name
+
+	cachedProfiles[name] = &cachedProfile{
+		base:    base,
+		modTime: stat.ModTime(),
+		config:  profileConfig,
+	}
+	return profileConfig, nil
+}
+
+// profileSettings validates the settings of a profile, which may only be
+// those which can differ between profiles.
+func profileSettings(values map[string]interface{}) (map[string]interface{}, error) {
+	settings := make(map[string]interface{}, len(values))
+	problems := make([]string, 0)
+	for key, value := range values {
+		setting := GetSetting(key)
+		if setting == nil {
+			problems = append(problems, fmt.Sprintf("Unknown setting %s", key))
+			continue
+		}
+		if !setting.Profile {
+			problems = append(problems, fmt.Sprintf("%s is the same for all profiles", key))
+			continue
+		}
+		converted, err := setting.Convert(value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		settings[key] = converted
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, errors.New(fmt.Sprint(problems))
+	}
+	return settings, nil
+}
+
+// SaveProfileSettings stores settings of a profile, creating it if needed.
+// The default profile's are the add-on's settings.
+func SaveProfileSettings(name string, values map[string]interface{}) error {
+	DropMaskedSecrets(values)
+	if name == DefaultProfile {
+		return SaveSettings(values)
+	}
+	if !ValidProfileName(name) {
+		return fmt.Errorf("Invalid profile name %q", name)
+	}
+	settings, err := profileSettings(values)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profilesDir(), 0755); err != nil {
+		return err
+	}
+	file := profileFile(name)
+	saved, err := readConfigFile(file)
+	if err != nil {
+		return err
+	}
+	for key, value := range settings {
+		saved[key] = value
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+	profilesMx.Lock()
+	delete(cachedProfiles, name)
+	profilesMx.Unlock()
+	return nil
+}
+
+// DeleteProfile removes a profile's settings.
+func DeleteProfile(name string) error {
+	if name == DefaultProfile || !ValidProfileName(name) {
+		return fmt.Errorf("Invalid profile name %q", name)
+	}
+	profilesMx.Lock()
+	delete(cachedProfiles, name)
+	profilesMx.Unlock()
+	return os.Remove(profileFile(name))
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+//
+// Settings, with their type, default and valid values, and what it takes
+// for a change to apply
+//
+
+const (
+	SettingInt    = "int"
+	SettingBool   = "bool"
+	SettingString = "string"
+)
+
+const (
+	// Read when used, or applied to the torrent session as is
+	ApplyLive = iota
+	// Restarts the torrent session
+	ApplySession
+	// Only read when Quasar starts
+	ApplyRestart
+)
+
+// Largest whole numbers settings take
+const unbounded = 1<<31 - 1
+
+// SecretMask is shown instead of the value of secrets.
+const SecretMask = "********"
+
+// Setting describes an add-on setting. Whole numbers must be within Min and
+// Max.
+type Setting struct {
+	Key     string      `json:"key"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+	Min     int         `json:"min,omitempty"`
+	Max     int         `json:"max,omitempty"`
+	Apply   int         `json:"apply"`
+	// Left out of what the settings API shows
+	Secret bool `json:"secret,omitempty"`
+	// Can differ between profiles
+	Profile bool `json:"profile,omitempty"`
+}
+
+var Schema = []*Setting{
+	{Key: "buffer_size", Type: SettingInt, Default: 20, Min: 1, Max: 1024},
+	{Key: "max_upload_rate", Type: SettingInt, Default: 0, Max: unbounded},
+	{Key: "max_download_rate", Type: SettingInt, Default: 0, Max: unbounded},
+	{Key: "spoof_user_agent", Type: SettingInt, Default: 0, Max: 11, Apply: ApplySession},
+	{Key: "limit_after_buffering", Type: SettingBool, Default: false},
+	{Key: "background_handling", Type: SettingBool, Default: true},
+	{Key: "keep_files", Type: SettingBool, Default: false},
+	{Key: "keep_files_ask", Type: SettingBool, Default: false},
+	{Key: "disable_bg_progress", Type: SettingBool, Default: false},
+	{Key: "results_per_page", Type: SettingInt, Default: 20, Min: 1, Max: 500},
+	{Key: "enable_overlay_status", Type: SettingBool, Default: false},
+	{Key: "choose_stream_auto", Type: SettingBool, Default: true},
+	{Key: "use_original_title", Type: SettingBool, Default: false},
+	{Key: "add_specials", Type: SettingBool, Default: false},
+	{Key: "unaired_seasons", Type: SettingBool, Default: false},
+	{Key: "unaired_episodes", Type: SettingBool, Default: false},
+	{Key: "share_ratio_limit", Type: SettingInt, Default: 200, Max: unbounded},
+	{Key: "seed_time_ratio_limit", Type: SettingInt, Default: 700, Max: unbounded},
+	{Key: "seed_time_limit", Type: SettingInt, Default: 24, Max: unbounded},
+	{Key: "disable_dht", Type: SettingBool, Default: false, Apply: ApplySession},
+	{Key: "disable_upnp", Type: SettingBool, Default: false, Apply: ApplySession},
+	{Key: "encryption_policy", Type: SettingInt, Default: 0, Max: 2, Apply: ApplySession},
+	{Key: "listen_port_min", Type: SettingInt, Default: 6891, Min: 1, Max: 65535, Apply: ApplySession},
+	{Key: "listen_port_max", Type: SettingInt, Default: 6899, Min: 1, Max: 65535, Apply: ApplySession},
+	{Key: "listen_interfaces", Type: SettingString, Default: "", Apply: ApplySession},
+	{Key: "outgoing_interfaces", Type: SettingString, Default: "", Apply: ApplySession},
+	{Key: "tuned_storage", Type: SettingBool, Default: false, Apply: ApplySession},
+	{Key: "connections_limit", Type: SettingInt, Default: 200, Max: unbounded},
+	{Key: "session_save", Type: SettingInt, Default: 10, Min: 1, Max: unbounded, Apply: ApplyRestart},
+	{Key: "trakt_scrobble", Type: SettingBool, Default: true, Profile: true},
+	{Key: "trakt_username", Type: SettingString, Default: "", Profile: true},
+	{Key: "trakt_token", Type: SettingString, Default: "", Secret: true, Profile: true},
+	{Key: "trakt_refresh_token", Type: SettingString, Default: "", Secret: true, Profile: true},
+	{Key: "trakt_token_expiry", Type: SettingInt, Default: 0, Max: unbounded, Profile: true},
+	{Key: "trakt_sync", Type: SettingInt, Default: 0, Max: unbounded, Apply: ApplyRestart},
+	{Key: "trakt_sync_watched", Type: SettingBool, Default: false, Profile: true},
+	{Key: "library_update_frequency", Type: SettingInt, Default: 6, Max: unbounded, Apply: ApplyRestart},
+	{Key: "library_update_delay", Type: SettingInt, Default: 0, Max: unbounded, Apply: ApplyRestart},
+	{Key: "library_auto_scan", Type: SettingBool, Default: false},
+	{Key: "library_backup_frequency", Type: SettingInt, Default: 0, Max: unbounded},
+	{Key: "library_backups_kept", Type: SettingInt, Default: 5, Min: 1, Max: 1000},
+	{Key: "library_auto_grab", Type: SettingBool, Default: false},
+	{Key: "library_mode", Type: SettingInt, Default: 0, Max: 1},
+	{Key: "library_stream_host", Type: SettingString, Default: ""},
+	{Key: "library_stream_token", Type: SettingString, Default: "", Secret: true},
+	{Key: "library_tv_scraper", Type: SettingInt, Default: 0, Max: 2},
+	{Key: "library_resume", Type: SettingInt, Default: 0, Max: unbounded},
+	{Key: "use_cloudhole", Type: SettingBool, Default: false},
+	{Key: "cloudhole_key", Type: SettingString, Default: "", Secret: true},
+	{Key: "tmdb_api_key", Type: SettingString, Default: "", Secret: true},
+	{Key: "osdb_user", Type: SettingString, Default: ""},
+	{Key: "osdb_pass", Type: SettingString, Default: "", Secret: true},
+	{Key: "sorting_mode_movies", Type: SettingInt, Default: 0, Max: 2},
+	{Key: "sorting_mode_shows", Type: SettingInt, Default: 0, Max: 2},
+	{Key: "resolution_preference_movies", Type: SettingInt, Default: 0, Max: 3},
+	{Key: "resolution_preference_shows", Type: SettingInt, Default: 0, Max: 3},
+	{Key: "percentage_additional_seeders", Type: SettingInt, Default: 0, Max: 100},
+	{Key: "custom_provider_timeout_enabled", Type: SettingBool, Default: false},
+	{Key: "custom_provider_timeout", Type: SettingInt, Default: 30, Min: 1, Max: 3600},
+	{Key: "proxy_type", Type: SettingInt, Default: 0, Max: 5, Apply: ApplySession},
+	{Key: "socks_enabled", Type: SettingBool, Default: false, Apply: ApplySession},
+	{Key: "socks_host", Type: SettingString, Default: "127.0.0.1", Apply: ApplySession},
+	{Key: "socks_port", Type: SettingInt, Default: 9050, Min: 1, Max: 65535, Apply: ApplySession},
+	{Key: "socks_login", Type: SettingString, Default: "", Apply: ApplySession},
+	{Key: "socks_password", Type: SettingString, Default: "", Apply: ApplySession, Secret: true},
+	{Key: "completed_move", Type: SettingBool, Default: false},
+	{Key: "completed_movies_path", Type: SettingString, Default: ""},
+	{Key: "completed_shows_path", Type: SettingString, Default: ""},
+	{Key: "stall_fallback", Type: SettingBool, Default: false},
+	{Key: "stall_metadata_timeout", Type: SettingInt, Default: 30, Min: 1, Max: 3600},
+	{Key: "stall_peers_timeout", Type: SettingInt, Default: 60, Min: 1, Max: 3600},
+	{Key: "stall_speed_timeout", Type: SettingInt, Default: 60, Min: 1, Max: 3600},
+	{Key: "remote_bind", Type: SettingString, Default: "", Apply: ApplyRestart},
+	{Key: "remote_allowed_networks", Type: SettingString, Default: "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7,fe80::/10"},
+	{Key: "remote_tokens", Type: SettingString, Default: "", Secret: true},
+	{Key: "remote_username", Type: SettingString, Default: ""},
+	{Key: "remote_password", Type: SettingString, Default: "", Secret: true},
+	{Key: "log_levels", Type: SettingString, Default: ""},
+}
+
+// Paths aren't in the schema, as Kodi's need translating, but changing
+// them is a change like others.
+var (
+	downloadPathSetting = &Setting{Key: "download_path", Type: SettingString, Default: "", Apply: ApplySession}
+	libraryPathSetting  = &Setting{Key: "library_path", Type: SettingString, Default: ""}
+)
+
+var schemaByKey = make(map[string]*Setting)
+
+func init() {
+	for _, setting := range Schema {
+		schemaByKey[setting.Key] = setting
+	}
+}
+
+// GetSetting returns the setting of a key, nil if there's none.
+func GetSetting(key string) *Setting {
+	return schemaByKey[key]
+}
+
+// Convert gives a value the setting's type, strings being accepted for any,
+// as Kodi stores them, and checks it's within range.
+func (s *Setting) Convert(value interface{}) (interface{}, error) {
+	converted, err := s.convert(value)
+	if err != nil {
+		return nil, err
+	}
+	if number, ok := converted.(int); ok && (number < s.Min || number > s.Max) {
+		return nil, fmt.Errorf("%s must be between %d and %d, not %d", s.Key, s.Min, s.Max, number)
+	}
+	return converted, nil
+}
+
+func (s *Setting) convert(value interface{}) (interface{}, error) {
+	if text, ok := value.(string); ok {
+		switch s.Type {
+		case SettingInt:
+			number, err := strconv.Atoi(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a whole number, not %q", s.Key, text)
+			}
+			return number, nil
+		case SettingBool:
+			flag, err := strconv.ParseBool(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false, not %q", s.Key, text)
+			}
+			return flag, nil
+		}
+		return text, nil
+	}
+
+	switch s.Type {
+	case SettingInt:
+		switch number := value.(type) {
+		case int:
+			return number, nil
+		case float32:
+			// Kodi's sliders
+			return int(number), nil
+		case json.Number:
+			if n, err := number.Int64(); err == nil {
+				return int(n), nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be a whole number, not %v", s.Key, value)
+	case SettingBool:
+		if flag, ok := value.(bool); ok {
+			return flag, nil
+		}
+		return nil, fmt.Errorf("%s must be true or false, not %v", s.Key, value)
+	}
+	return nil, fmt.Errorf("%s must be a string, not %v", s.Key, value)
+}
+
+// normalizeSettings gives Kodi's settings their type, and missing or
+// invalid ones their default, so that a renamed setting doesn't bring
+// everything down.
+func normalizeSettings(settings map[string]interface{}) map[string]interface{} {
+	for _, setting := range Schema {
+		value, ok := settings[setting.Key]
+		if !ok {
+			log.Warningf("Missing setting %s, using its default", setting.Key)
+			settings[setting.Key] = setting.Default
+			continue
+		}
+		converted, err := setting.Convert(value)
+		if err != nil {
+			log.Warningf("%s, using its default", err)
+			converted = setting.Default
+		}
+		settings[setting.Key] = converted
+	}
+	return settings
+}
+
+// ValidateSettings converts new values of settings, all problems at once.
+func ValidateSettings(values map[string]interface{}) (map[string]interface{}, error) {
+	converted := make(map[string]interface{}, len(values))
+	problems := make([]string, 0)
+	for key, value := range values {
+		setting := GetSetting(key)
+		if setting == nil {
+			problems = append(problems, fmt.Sprintf("Unknown setting %s", key))
+			continue
+		}
+		if value, err := setting.Convert(value); err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			converted[key] = value
+		}
+	}
+
+	portMin, portMax := Get().Setting("listen_port_min"), Get().Setting("listen_port_max")
+	if value, ok := converted["listen_port_min"]; ok {
+		portMin = value
+	}
+	if value, ok := converted["listen_port_max"]; ok {
+		portMax = value
+	}
+	if min, ok := portMin.(int); ok {
+		if max, ok := portMax.(int); ok && min > max {
+			problems = append(problems, "listen_port_min must not be over listen_port_max")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, errors.New(fmt.Sprint(problems))
+	}
+	return converted, nil
+}
+
+// DropMaskedSecrets leaves out the secrets sent back as shown, masked, so
+// that saving what was read doesn't change them.
+func DropMaskedSecrets(values map[string]interface{}) {
+	for key, value := range values {
+		if setting := GetSetting(key); setting != nil && setting.Secret && value == SecretMask {
+			delete(values, key)
+		}
+	}
+}
+
+// SaveSettings stores new values of settings, which must be valid, and
+// reloads the configuration.
+func SaveSettings(values map[string]interface{}) error {
+	if Headless {
+		return saveFileSettings(values)
+	}
+	for key, value := range values {
+		xbmc.SetSetting(key, fmt.Sprint(value))
+	}
+	return nil
+}
+
+// Setting returns the value of a setting, nil if unknown.
+func (c *Configuration) Setting(key string) interface{} {
+	return c.settings[key]
+}
+
+// Changed returns the settings whose value differs between two
+// configurations.
+func Changed(old *Configuration, new *Configuration) []*Setting {
+	changed := make([]*Setting, 0)
+	for _, setting := range Schema {
+		if !reflect.DeepEqual(old.Setting(setting.Key), new.Setting(setting.Key)) {
+			changed = append(changed, setting)
+		}
+	}
+	if old.DownloadPath != new.DownloadPath {
+		changed = append(changed, downloadPathSetting)
+	}
+	if old.LibraryPath != new.LibraryPath {
+		changed = append(changed, libraryPathSetting)
+	}
+	return changed
+}
+
+// ApplyLevel returns what it takes for changes to apply, the most of them.
+func ApplyLevel(changed []*Setting) int {
+	level := ApplyLive
+	for _, setting := range changed {
+		if setting.Apply > level {
+			level = setting.Apply
+		}
+	}
+	return level
+}
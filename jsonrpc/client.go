@@ -60,6 +60,32 @@ func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
 	return c.enc.Encode(&c.req)
 }
 
+// writeRequests writes requests at once, as an array when batched.
+func (c *clientCodec) writeRequests(requests []*clientRequest, batch bool) error {
+	if batch {
+		return c.enc.Encode(requests)
+	}
+	return c.enc.Encode(requests[0])
+}
+
+// readMessages reads the next message, or the messages of a batch's answer.
+func (c *clientCodec) readMessages() ([]*message, error) {
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 && raw[0] == '[' {
+		messages := make([]*message, 0)
+		err := json.Unmarshal(raw, &messages)
+		return messages, err
+	}
+	m := &message{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, err
+	}
+	return []*message{m}, nil
+}
+
 type clientResponse struct {
 	Id     uint64           `json:"id"`
 	Result *json.RawMessage `json:"result"`
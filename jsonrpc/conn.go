@@ -0,0 +1,285 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrClosed is returned by calls whose connection closed before they were
+// answered.
+var ErrClosed = errors.New("jsonrpc: connection closed")
+
+// Notification is a message sent by the server on its own, e.g. Kodi's
+// Player.OnPlay.
+type Notification struct {
+	Method string
+	Params json.RawMessage
+}
+
+// Call is a call of a batch, Error being set once it's answered.
+type Call struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Error  error
+}
+
+// Conn is a client over a long-lived connection, on which calls run
+// concurrently, and which receives notifications.
+type Conn struct {
+	codec          *clientCodec
+	onNotification func(*Notification)
+
+	writeMx sync.Mutex
+	mx      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan *message
+	closed  chan struct{}
+	err     error
+
+	notifyMx      sync.Mutex
+	notifications []*Notification
+	notified      chan struct{}
+}
+
+// NewConn starts reading answers and notifications on conn, notifications
+// being given in order to onNotification, which may be nil, off the reading
+// for answers to keep coming while it runs.
+func NewConn(conn io.ReadWriteCloser, onNotification func(*Notification)) *Conn {
+	c := &Conn{
+		codec:          NewClientCodec(conn).(*clientCodec),
+		onNotification: onNotification,
+		pending:        make(map[uint64]chan *message),
+		closed:         make(chan struct{}),
+		notified:       make(chan struct{}, 1),
+	}
+	go c.readLoop()
+	if onNotification != nil {
+		go c.notifyLoop()
+	}
+	return c
+}
+
+// Closed is closed with the connection.
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the connection closed.
+func (c *Conn) Err() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.err
+}
+
+func (c *Conn) Close() error {
+	return c.codec.Close()
+}
+
+func (c *Conn) readLoop() {
+	var err error
+	for {
+		var messages []*message
+		if messages, err = c.codec.readMessages(); err != nil {
+			break
+		}
+		for _, m := range messages {
+			if m.Id == nil {
+				if m.Method != "" && c.onNotification != nil {
+					c.queue(&Notification{Method: m.Method, Params: m.Params})
+				}
+				continue
+			}
+			c.mx.Lock()
+			answer, ok := c.pending[*m.Id]
+			delete(c.pending, *m.Id)
+			c.mx.Unlock()
+			if ok {
+				answer <- m
+			}
+		}
+	}
+
+	c.codec.Close()
+	c.mx.Lock()
+	if err == io.EOF {
+		err = ErrClosed
+	}
+	c.err = err
+	c.pending = make(map[uint64]chan *message)
+	c.mx.Unlock()
+	close(c.closed)
+}
+
+// queue queues a notification for notifyLoop.
+func (c *Conn) queue(n *Notification) {
+	c.notifyMx.Lock()
+	c.notifications = append(c.notifications, n)
+	c.notifyMx.Unlock()
+	select {
+	case c.notified <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop gives queued notifications to onNotification, until the
+// connection closes and those queued by then are given.
+func (c *Conn) notifyLoop() {
+	for {
+		closed := false
+		select {
+		case <-c.notified:
+		case <-c.closed:
+			closed = true
+		}
+
+		c.notifyMx.Lock()
+		notifications := c.notifications
+		c.notifications = nil
+		c.notifyMx.Unlock()
+		for _, n := range notifications {
+			c.onNotification(n)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+func (c *Conn) register() (uint64, chan *message, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	select {
+	case <-c.closed:
+		return 0, nil, ErrClosed
+	default:
+	}
+	c.seq++
+	answer := make(chan *message, 1)
+	c.pending[c.seq] = answer
+	return c.seq, answer, nil
+}
+
+func (c *Conn) forget(id uint64) {
+	c.mx.Lock()
+	delete(c.pending, id)
+	c.mx.Unlock()
+}
+
+func (c *Conn) wait(ctx context.Context, answer chan *message) (*message, error) {
+	select {
+	case m := <-answer:
+		return m, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Call calls a method and decodes its result into result, unless nil.
+func (c *Conn) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	id, answer, err := c.register()
+	if err != nil {
+		return err
+	}
+	c.writeMx.Lock()
+	err = c.codec.writeRequests([]*clientRequest{{JSONRPC: "2.0", Method: method, Params: params, Id: id}}, false)
+	c.writeMx.Unlock()
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+
+	m, err := c.wait(ctx, answer)
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+	return m.decode(result)
+}
+
+// Batch sends calls at once, each getting its result or error. The error
+// returned is for the batch as a whole.
+func (c *Conn) Batch(ctx context.Context, calls []*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	requests := make([]*clientRequest, 0, len(calls))
+	answers := make([]chan *message, 0, len(calls))
+	ids := make([]uint64, 0, len(calls))
+	forget := func() {
+		for _, id := range ids {
+			c.forget(id)
+		}
+	}
+	for _, call := range calls {
+		id, answer, err := c.register()
+		if err != nil {
+			forget()
+			return err
+		}
+		ids = append(ids, id)
+		answers = append(answers, answer)
+		requests = append(requests, &clientRequest{JSONRPC: "2.0", Method: call.Method, Params: call.Params, Id: id})
+	}
+	c.writeMx.Lock()
+	err := c.codec.writeRequests(requests, true)
+	c.writeMx.Unlock()
+	if err != nil {
+		forget()
+		return err
+	}
+
+	for i, call := range calls {
+		m, err := c.wait(ctx, answers[i])
+		if err != nil {
+			forget()
+			return err
+		}
+		call.Error = m.decode(call.Result)
+	}
+	return nil
+}
+
+// message is any of what servers send: answers and notifications.
+type message struct {
+	Id     *uint64          `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+func (m *message) decode(result interface{}) error {
+	if m.Error != nil || m.Result == nil {
+		return remoteError(m.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(*m.Result, result)
+}
+
+// remoteError makes an error of the server's, a string or, as Kodi sends
+// them, an object with a message.
+func remoteError(e interface{}) error {
+	switch value := e.(type) {
+	case string:
+		if value == "" {
+			return errors.New("unspecified error")
+		}
+		return errors.New(value)
+	case map[string]interface{}:
+		if text, ok := value["message"].(string); ok {
+			return fmt.Errorf("%s (%v)", text, value["code"])
+		}
+	case nil:
+		return errors.New("unspecified error")
+	}
+	return fmt.Errorf("invalid error %v", e)
+}
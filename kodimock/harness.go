@@ -0,0 +1,96 @@
+package kodimock
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/scakemyer/quasar/api"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+)
+
+// Harness runs Quasar's API against a fake Kodi, as main does against the
+// real one, for end-to-end tests of the handlers:
+//
+//	h, err := kodimock.NewHarness()
+//	defer h.Close()
+//	h.Kodi.State.Confirm = true
+//	resp := h.Get("/library/movie/add/550")
+//	calls := h.Kodi.Calls("VideoLibrary.Scan")
+type Harness struct {
+	Kodi      *Server
+	DB        *bolt.DB
+	BTService *bittorrent.BTService
+	Router    http.Handler
+}
+
+func NewHarness() (*Harness, error) {
+	kodi := New()
+	if err := kodi.Install(); err != nil {
+		return nil, err
+	}
+	h := &Harness{Kodi: kodi}
+
+	// The routes load the web UI's templates
+	webPath := filepath.Join(kodi.State.Info.Path, "resources", "web")
+	os.MkdirAll(webPath, 0755)
+	if err := ioutil.WriteFile(filepath.Join(webPath, "index.html"), []byte("<html></html>"), 0644); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	conf := config.Reload()
+
+	db, err := bolt.Open(filepath.Join(conf.Info.Profile, "library.db"), 0600, &bolt.Options{
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	h.DB = db
+	api.InitDB(db)
+
+	h.BTService = bittorrent.NewBTService(*bittorrent.NewBTConfiguration(conf), db)
+	h.Router = api.Routes(h.BTService)
+	kodi.Reset()
+	return h, nil
+}
+
+// Do runs a request through the API, coming from this machine like Kodi's
+// unless its RemoteAddr or Host say otherwise.
+func (h *Harness) Do(req *http.Request) *httptest.ResponseRecorder {
+	if req.RemoteAddr == "" {
+		req.RemoteAddr = "127.0.0.1:50000"
+	}
+	if req.Host == "" {
+		req.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(config.ListenPort))
+	}
+	recorder := httptest.NewRecorder()
+	h.Router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func (h *Harness) Get(path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	return h.Do(req)
+}
+
+// Close stops everything, and removes the add-on's files.
+func (h *Harness) Close() {
+	if h.BTService != nil {
+		h.BTService.Close()
+	}
+	if h.DB != nil {
+		h.DB.Close()
+	}
+	h.Kodi.Close()
+	os.RemoveAll(h.Kodi.State.Info.Path)
+}
@@ -0,0 +1,252 @@
+package kodimock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/scakemyer/quasar/api"
+	"github.com/scakemyer/quasar/config"
+)
+
+// The API keeps its database, configuration and Kodi hosts in globals, so
+// all tests share one harness.
+var h *Harness
+
+func TestMain(m *testing.M) {
+	var err error
+	if h, err = NewHarness(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	h.Close()
+	os.Exit(code)
+}
+
+func newRequest(t *testing.T, method string, path string, body string) *http.Request {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// onlyCall returns the single call made of a method.
+func onlyCall(t *testing.T, method string) *Call {
+	calls := h.Kodi.Calls(method)
+	if len(calls) != 1 {
+		t.Fatalf("%d calls of %s, want 1", len(calls), method)
+	}
+	return calls[0]
+}
+
+func checkArgs(t *testing.T, call *Call, want ...interface{}) {
+	args := call.Args()
+	if len(args) < len(want) {
+		t.Fatalf("%s called with %v, want %v", call.Method, args, want)
+	}
+	for i, value := range want {
+		if args[i] != value {
+			t.Errorf("%s argument %d is %v, want %v", call.Method, i, args[i], value)
+		}
+	}
+}
+
+func TestClearCacheNotifies(t *testing.T) {
+	h.Kodi.Reset()
+	if resp := h.Get("/cmd/clear_cache"); resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	checkArgs(t, onlyCall(t, "Notify"), "Quasar", "LOCALIZE[30200]")
+}
+
+func TestSetViewModeSavesSetting(t *testing.T) {
+	h.Kodi.Reset()
+	h.Kodi.Respond("GetCurrentView", "55")
+	if resp := h.Get("/setviewmode/movies"); resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	checkArgs(t, onlyCall(t, "SetSetting"), "viewmode_movies", "55")
+
+	h.Kodi.State.Lock()
+	defer h.Kodi.State.Unlock()
+	if value := h.Kodi.State.Settings["viewmode_movies"]; value != "55" {
+		t.Errorf("viewmode_movies is %q, want 55", value)
+	}
+}
+
+func TestUpdateSettingsWritesToKodi(t *testing.T) {
+	h.Kodi.Reset()
+	resp := h.Do(newRequest(t, "PUT", "/settings", `{"results_per_page": 30}`))
+	if resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	checkArgs(t, onlyCall(t, "SetSetting"), "results_per_page", "30")
+	if len(h.Kodi.Calls("GetAllSettings")) == 0 {
+		t.Error("Settings weren't reloaded from Kodi")
+	}
+
+	var result struct {
+		Changed []string `json:"changed"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changed) != 1 || result.Changed[0] != "results_per_page" {
+		t.Errorf("changed %v, want [results_per_page]", result.Changed)
+	}
+}
+
+func TestUpdateSettingsRejectsInvalid(t *testing.T) {
+	h.Kodi.Reset()
+	resp := h.Do(newRequest(t, "PUT", "/settings", `{"results_per_page": "many"}`))
+	if resp.Code != 400 {
+		t.Errorf("status %d, want 400", resp.Code)
+	}
+	if calls := h.Kodi.Calls("SetSetting"); len(calls) > 0 {
+		t.Errorf("%d settings written to Kodi, want none", len(calls))
+	}
+}
+
+// Settings read and saved back as they are keep their secrets, which are
+// read masked.
+func TestUpdateSettingsKeepsMaskedSecrets(t *testing.T) {
+	h.Kodi.State.Lock()
+	h.Kodi.State.Settings["trakt_token"] = "secret"
+	h.Kodi.State.Unlock()
+	config.Reload()
+	defer func() {
+		h.Kodi.State.Lock()
+		delete(h.Kodi.State.Settings, "trakt_token")
+		h.Kodi.State.Unlock()
+		config.Reload()
+	}()
+
+	resp := h.Get("/settings")
+	if resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	var settings []struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]interface{}, len(settings))
+	for _, setting := range settings {
+		values[setting.Key] = setting.Value
+	}
+	if values["trakt_token"] != config.SecretMask {
+		t.Fatalf("trakt_token read as %v, want it masked", values["trakt_token"])
+	}
+	body, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Kodi.Reset()
+	if resp := h.Do(newRequest(t, "PUT", "/settings", string(body))); resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	for _, call := range h.Kodi.Calls("SetSetting") {
+		if args := call.Args(); len(args) > 0 && args[0] == "trakt_token" {
+			t.Errorf("trakt_token written as %v", args[1:])
+		}
+	}
+	if token := config.Get().TraktToken; token != "secret" {
+		t.Errorf("trakt_token is %q, want it unchanged", token)
+	}
+}
+
+func TestRestoreLibraryWithoutBackups(t *testing.T) {
+	h.Kodi.Reset()
+	if resp := h.Get("/library/restore"); resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	checkArgs(t, onlyCall(t, "Notify"), "Quasar", "LOCALIZE[30309]")
+	if calls := h.Kodi.Calls("Dialog_Select"); len(calls) > 0 {
+		t.Error("Backups listed without any backup")
+	}
+}
+
+func TestImportLibraryRejectsNull(t *testing.T) {
+	h.Kodi.Reset()
+	resp := h.Do(newRequest(t, "POST", "/library/import", "null"))
+	if resp.Code != 500 || !strings.Contains(resp.Body.String(), "Unsupported library export version") {
+		t.Errorf("status %d: %s", resp.Code, resp.Body)
+	}
+	if calls := h.Kodi.Calls("VideoLibrary.Scan"); len(calls) > 0 {
+		t.Error("Library scanned after a failed import")
+	}
+}
+
+func TestRemoteGetOfActionRefused(t *testing.T) {
+	h.Kodi.Reset()
+	req := newRequest(t, "GET", "/cmd/clear_cache", "")
+	req.RemoteAddr = "192.168.1.10:50000"
+	if resp := h.Do(req); resp.Code < 400 {
+		t.Errorf("status %d, want an error", resp.Code)
+	}
+	if calls := h.Kodi.Calls("Notify"); len(calls) > 0 {
+		t.Error("Remote GET cleared the cache")
+	}
+}
+
+// Pages of sites whose name resolves to loopback aren't Kodi, whatever
+// address they come from.
+func TestForgedHostRefused(t *testing.T) {
+	handler := api.AccessHandler(h.Router)
+	port := strconv.Itoa(config.ListenPort)
+	for host, want := range map[string]int{
+		"evil.example.com:" + port: 403,
+		"127.0.0.1:80":             403,
+		"localhost:" + port:        200,
+		"[::1]:" + port:            200,
+	} {
+		h.Kodi.Reset()
+		req := newRequest(t, "GET", "/cmd/clear_cache", "")
+		req.RemoteAddr = "127.0.0.1:50000"
+		req.Host = host
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != want {
+			t.Errorf("Host %s got status %d, want %d", host, resp.Code, want)
+		}
+		if calls := h.Kodi.Calls("Notify"); want == 403 && len(calls) > 0 {
+			t.Errorf("Host %s cleared the cache", host)
+		}
+	}
+}
+
+func TestMetricsScrape(t *testing.T) {
+	resp := h.Get("/metrics")
+	if resp.Code != 200 {
+		t.Fatalf("status %d: %s", resp.Code, resp.Body)
+	}
+	if contentType := resp.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q, want Prometheus' text format", contentType)
+	}
+	body := resp.Body.String()
+	for _, want := range []string{
+		"# TYPE quasar_build_info gauge\n",
+		"# TYPE quasar_goroutines gauge\n",
+		"# TYPE quasar_library_sync_seconds histogram\n",
+		"\nquasar_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "# ") && len(strings.Fields(line)) < 2 {
+			t.Errorf("invalid sample line %q", line)
+		}
+	}
+}
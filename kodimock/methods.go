@@ -0,0 +1,357 @@
+package kodimock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+type Addon struct {
+	ID      string `json:"addonid"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Enabled bool   `json:"enabled"`
+}
+
+type Show struct {
+	ID        int    `json:"tvshowid"`
+	Title     string `json:"label"`
+	ScraperID string `json:"imdbnumber"`
+	Episodes  int    `json:"episode"`
+}
+
+// State is what the fake Kodi knows, which tests set up and check.
+type State struct {
+	sync.Mutex
+
+	Info     *xbmc.AddonInfo
+	Platform *xbmc.Platform
+	Language string
+	// Add-on settings, as Kodi stores them
+	Settings map[string]string
+
+	Movies   []*xbmc.VideoLibraryMovieItem
+	Shows    []*Show
+	Episodes []*xbmc.VideoLibraryEpisodeItem
+	Addons   []*Addon
+
+	// Answers of dialogs, canceling them by default
+	Confirm  bool
+	Selected int
+	Keyboard string
+	Canceled bool
+
+	PlayingFile   string
+	Paused        bool
+	WatchedTime   float64
+	VideoDuration float64
+
+	Properties    map[string]string
+	lastHandle    int64
+	Notifications []string
+}
+
+// NewState returns the state of a Kodi with an empty library, whose
+// add-on's paths are in a temporary directory.
+func NewState() *State {
+	root, err := ioutil.TempDir("", "kodimock")
+	if err != nil {
+		root = filepath.Join(os.TempDir(), fmt.Sprintf("kodimock-%d", os.Getpid()))
+	}
+	profile := filepath.Join(root, "profile")
+	downloads := filepath.Join(root, "downloads")
+	os.MkdirAll(profile, 0755)
+	os.MkdirAll(downloads, 0755)
+	return &State{
+		Info: &xbmc.AddonInfo{
+			Id:       "plugin.video.quasar",
+			Name:     "Quasar",
+			Version:  "0.0.0",
+			Path:     root,
+			Profile:  profile,
+			TempPath: filepath.Join(root, "temp"),
+		},
+		Platform: &xbmc.Platform{
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Version: "17.0",
+			Kodi:    17,
+		},
+		Language: "en",
+		Settings: map[string]string{
+			// A file's path, of which Quasar takes the directory
+			"download_path": downloads + string(filepath.Separator),
+		},
+		Movies:     make([]*xbmc.VideoLibraryMovieItem, 0),
+		Shows:      make([]*Show, 0),
+		Episodes:   make([]*xbmc.VideoLibraryEpisodeItem, 0),
+		Addons:     make([]*Addon, 0),
+		Selected:   -1,
+		Properties: make(map[string]string),
+	}
+}
+
+// args decodes positional params into the pointers given.
+func args(params json.RawMessage, into ...interface{}) error {
+	raw := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(params, &raw); err != nil {
+		return err
+	}
+	for i, target := range into {
+		if i >= len(raw) {
+			break
+		}
+		if err := json.Unmarshal(raw[i], target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func object(params json.RawMessage) map[string]interface{} {
+	values := make(map[string]interface{})
+	json.Unmarshal(params, &values)
+	return values
+}
+
+func (s *Server) constant(result interface{}) Handler {
+	return func(params json.RawMessage) (interface{}, error) {
+		return result, nil
+	}
+}
+
+func (s *Server) registerDefaults() {
+	state := s.State
+	locked := func(handler func(params json.RawMessage) (interface{}, error)) Handler {
+		return func(params json.RawMessage) (interface{}, error) {
+			state.Lock()
+			defer state.Unlock()
+			return handler(params)
+		}
+	}
+	newHandle := func(params json.RawMessage) (interface{}, error) {
+		state.lastHandle++
+		return state.lastHandle, nil
+	}
+
+	// The add-on
+	s.Handle("GetAddonInfo", locked(func(params json.RawMessage) (interface{}, error) {
+		return state.Info, nil
+	}))
+	s.Handle("GetPlatform", locked(func(params json.RawMessage) (interface{}, error) {
+		return state.Platform, nil
+	}))
+	s.Handle("GetLanguage", locked(func(params json.RawMessage) (interface{}, error) {
+		return state.Language, nil
+	}))
+	s.Handle("ConvertLanguage", func(params json.RawMessage) (interface{}, error) {
+		var language string
+		args(params, &language)
+		return language, nil
+	})
+	s.Handle("TranslatePath", locked(func(params json.RawMessage) (interface{}, error) {
+		var path string
+		args(params, &path)
+		if strings.HasPrefix(path, "special://") {
+			return filepath.Join(state.Info.Path, strings.TrimPrefix(path, "special://")), nil
+		}
+		return path, nil
+	}))
+	s.Handle("GetLocalizedString", func(params json.RawMessage) (interface{}, error) {
+		var id int
+		args(params, &id)
+		return fmt.Sprintf("LOCALIZE[%d]", id), nil
+	})
+	s.Handle("GetAllSettings", locked(func(params json.RawMessage) (interface{}, error) {
+		settings := make([]*xbmc.Setting, 0, len(state.Settings))
+		for key, value := range state.Settings {
+			settings = append(settings, &xbmc.Setting{Key: key, Type: "text", Value: value})
+		}
+		return settings, nil
+	}))
+	s.Handle("GetSetting", locked(func(params json.RawMessage) (interface{}, error) {
+		var key string
+		args(params, &key)
+		return state.Settings[key], nil
+	}))
+	s.Handle("SetSetting", locked(func(params json.RawMessage) (interface{}, error) {
+		var key string
+		var value interface{}
+		args(params, &key, &value)
+		state.Settings[key] = fmt.Sprint(value)
+		return 0, nil
+	}))
+	for _, method := range []string{"AddonSettings", "UpdateAddonRepos", "UpdateLocalAddons", "InstallAddon", "Reset", "Refresh", "Log", "SetResolvedUrl"} {
+		s.Handle(method, s.constant(""))
+	}
+	s.Handle("AddonFailure", s.constant(0))
+	s.Handle("AddonCheck", s.constant(0))
+	s.Handle("GetCurrentView", s.constant(""))
+	s.Handle("GetWindowProperty", locked(func(params json.RawMessage) (interface{}, error) {
+		var key string
+		args(params, &key)
+		return state.Properties[key], nil
+	}))
+	s.Handle("SetWindowProperty", locked(func(params json.RawMessage) (interface{}, error) {
+		var key, value string
+		args(params, &key, &value)
+		state.Properties[key] = value
+		return "", nil
+	}))
+
+	// Dialogs
+	s.Handle("Notify", locked(func(params json.RawMessage) (interface{}, error) {
+		var header, message string
+		args(params, &header, &message)
+		state.Notifications = append(state.Notifications, message)
+		return "", nil
+	}))
+	confirm := locked(func(params json.RawMessage) (interface{}, error) {
+		if state.Confirm {
+			return 1, nil
+		}
+		return 0, nil
+	})
+	s.Handle("Dialog", confirm)
+	s.Handle("Dialog_Confirm", confirm)
+	selected := locked(func(params json.RawMessage) (interface{}, error) {
+		return state.Selected, nil
+	})
+	s.Handle("Dialog_Select", selected)
+	s.Handle("Dialog_Select_Large", selected)
+	s.Handle("Dialog_CloseAll", s.constant(1))
+	s.Handle("DialogInsert", s.constant(map[string]string{}))
+	s.Handle("Keyboard", locked(func(params json.RawMessage) (interface{}, error) {
+		return state.Keyboard, nil
+	}))
+	for _, window := range []string{"DialogProgress", "DialogProgressBG", "OverlayStatus", "EventPlayer"} {
+		s.Handle(window+"_Create", locked(newHandle))
+		for _, method := range []string{"_Update", "_Close", "_Show", "_Hide", "_Clear", "_Delete"} {
+			s.Handle(window+method, s.constant(0))
+		}
+	}
+	s.Handle("DialogProgress_IsCanceled", locked(func(params json.RawMessage) (interface{}, error) {
+		if state.Canceled {
+			return 1, nil
+		}
+		return 0, nil
+	}))
+	s.Handle("DialogProgressBG_IsFinished", s.constant(0))
+	s.Handle("EventPlayer_PopEvent", s.constant(""))
+
+	// The player
+	s.Handle("Player_Open", locked(func(params json.RawMessage) (interface{}, error) {
+		var url string
+		args(params, &url)
+		state.PlayingFile = url
+		state.Paused = false
+		return "", nil
+	}))
+	playing := locked(func(params json.RawMessage) (interface{}, error) {
+		if state.PlayingFile != "" {
+			return 1, nil
+		}
+		return 0, nil
+	})
+	s.Handle("Player_IsPlaying", playing)
+	s.Handle("EventPlayer_IsPlaying", playing)
+	s.Handle("Player_IsPaused", locked(func(params json.RawMessage) (interface{}, error) {
+		if state.Paused {
+			return 1, nil
+		}
+		return 0, nil
+	}))
+	s.Handle("Player_GetPlayingFile", locked(func(params json.RawMessage) (interface{}, error) {
+		return state.PlayingFile, nil
+	}))
+	s.Handle("Player_Seek", locked(func(params json.RawMessage) (interface{}, error) {
+		args(params, &state.WatchedTime)
+		return "", nil
+	}))
+	s.Handle("Player_SetSubtitles", s.constant(""))
+	s.Handle("Player_WatchTimes", locked(func(params json.RawMessage) (interface{}, error) {
+		return map[string]string{
+			"watchedTime":   fmt.Sprintf("%f", state.WatchedTime),
+			"videoDuration": fmt.Sprintf("%f", state.VideoDuration),
+		}, nil
+	}))
+
+	// Kodi
+	s.Handle("JSONRPC.Ping", s.constant("pong"))
+	s.Handle("XBMC.GetInfoLabels", s.constant(map[string]string{}))
+	s.Handle("VideoLibrary.Scan", s.constant("OK"))
+	s.Handle("VideoLibrary.Clean", s.constant("OK"))
+	s.Handle("VideoLibrary.GetMovies", locked(func(params json.RawMessage) (interface{}, error) {
+		return &xbmc.VideoLibraryMovies{Movies: state.Movies}, nil
+	}))
+	s.Handle("VideoLibrary.GetTVShows", locked(func(params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"tvshows": state.Shows}, nil
+	}))
+	s.Handle("VideoLibrary.GetEpisodes", locked(func(params json.RawMessage) (interface{}, error) {
+		showId, _ := object(params)["tvshowid"].(float64)
+		episodes := make([]*xbmc.VideoLibraryEpisodeItem, 0)
+		for _, episode := range state.Episodes {
+			if episode.TVShowID == int(showId) {
+				episodes = append(episodes, episode)
+			}
+		}
+		return &xbmc.VideoLibraryEpisodes{Episodes: episodes}, nil
+	}))
+	s.Handle("VideoLibrary.SetMovieDetails", locked(func(params json.RawMessage) (interface{}, error) {
+		details := object(params)
+		id, _ := details["movieid"].(float64)
+		for _, movie := range state.Movies {
+			if movie.ID == int(id) {
+				movie.PlayCount, movie.Resume, movie.LastPlayed = playState(details)
+				return "OK", nil
+			}
+		}
+		return nil, fmt.Errorf("No movie %d", int(id))
+	}))
+	s.Handle("VideoLibrary.SetEpisodeDetails", locked(func(params json.RawMessage) (interface{}, error) {
+		details := object(params)
+		id, _ := details["episodeid"].(float64)
+		for _, episode := range state.Episodes {
+			if episode.ID == int(id) {
+				episode.PlayCount, episode.Resume, episode.LastPlayed = playState(details)
+				return "OK", nil
+			}
+		}
+		return nil, fmt.Errorf("No episode %d", int(id))
+	}))
+	s.Handle("VideoLibrary.SetFileDetails", s.constant("OK"))
+	s.Handle("Addons.GetAddons", locked(func(params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"addons": state.Addons}, nil
+	}))
+	s.Handle("Addons.SetAddonEnabled", locked(func(params json.RawMessage) (interface{}, error) {
+		var id string
+		var enabled bool
+		args(params, &id, &enabled)
+		for _, addon := range state.Addons {
+			if addon.ID == id {
+				addon.Enabled = enabled
+			}
+		}
+		return "OK", nil
+	}))
+	s.Handle("Addons.ExecuteAddon", s.constant("OK"))
+}
+
+func playState(details map[string]interface{}) (int, *xbmc.Resume, string) {
+	playCount, _ := details["playcount"].(float64)
+	resume := &xbmc.Resume{}
+	if values, ok := details["resume"].(map[string]interface{}); ok {
+		resume.Position, _ = values["position"].(float64)
+		resume.Total, _ = values["total"].(float64)
+	}
+	lastPlayed, _ := details["lastplayed"].(string)
+	return int(playCount), resume, lastPlayed
+}
@@ -0,0 +1,324 @@
+// Package kodimock is a fake Kodi, for running Quasar without one, e.g. in
+// tests. It serves both Kodi's JSON-RPC and the add-on's, answering the
+// methods Quasar calls from a small state of settings, library and player,
+// and records every call.
+package kodimock
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+var log = logging.MustGetLogger("kodimock")
+
+const (
+	// Which of the servers a call went to
+	HostKodi  = "kodi"
+	HostAddon = "addon"
+)
+
+// Handler answers a call, the result being sent as JSON.
+type Handler func(params json.RawMessage) (interface{}, error)
+
+type Call struct {
+	Time   time.Time       `json:"time"`
+	Host   string          `json:"host"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Args decodes the params of a call to the add-on, which are positional.
+func (c *Call) Args() []interface{} {
+	args := make([]interface{}, 0)
+	json.Unmarshal(c.Params, &args)
+	return args
+}
+
+type request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Id     *uint64         `json:"id"`
+}
+
+type response struct {
+	Id     uint64      `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type Server struct {
+	State *State
+
+	mx        sync.Mutex
+	handlers  map[string]Handler
+	scripted  map[string][]interface{}
+	calls     []*Call
+	called    chan *Call
+	listeners []net.Listener
+	conns     map[net.Conn]*json.Encoder
+	connsMx   sync.Mutex
+
+	KodiAddr  string
+	AddonAddr string
+}
+
+// New returns a fake Kodi with default answers to everything Quasar calls.
+func New() *Server {
+	s := &Server{
+		State:    NewState(),
+		handlers: make(map[string]Handler),
+		scripted: make(map[string][]interface{}),
+		calls:    make([]*Call, 0),
+		called:   make(chan *Call, 1000),
+		conns:    make(map[net.Conn]*json.Encoder),
+	}
+	s.registerDefaults()
+	return s
+}
+
+// Start listens on local ports for both servers.
+func (s *Server) Start() error {
+	kodi, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addon, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		kodi.Close()
+		return err
+	}
+	s.listeners = []net.Listener{kodi, addon}
+	s.KodiAddr = kodi.Addr().String()
+	s.AddonAddr = addon.Addr().String()
+	go s.serve(kodi, HostKodi)
+	go s.serve(addon, HostAddon)
+	log.Infof("Fake Kodi listening on %s, add-on on %s", s.KodiAddr, s.AddonAddr)
+	return nil
+}
+
+// Install starts the servers and points the xbmc package at them.
+func (s *Server) Install() error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	xbmc.SetJSONRPCHosts([]string{s.KodiAddr}, []string{s.AddonAddr})
+	return nil
+}
+
+func (s *Server) Close() {
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.connsMx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMx.Unlock()
+}
+
+// Handle answers a method with a handler, in place of its default one.
+func (s *Server) Handle(method string, handler Handler) {
+	s.mx.Lock()
+	s.handlers[method] = handler
+	s.mx.Unlock()
+}
+
+// Respond scripts the results of the next calls of a method, one per call,
+// the last one sticking. Errors are sent as errors.
+func (s *Server) Respond(method string, results ...interface{}) {
+	s.mx.Lock()
+	s.scripted[method] = results
+	s.mx.Unlock()
+}
+
+// Calls returns the calls made of a method, all of them for "".
+func (s *Server) Calls(method string) []*Call {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	calls := make([]*Call, 0)
+	for _, call := range s.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// WaitCall waits for the next call of a method, nil after the timeout.
+func (s *Server) WaitCall(method string, timeout time.Duration) *Call {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case call := <-s.called:
+			if call.Method == method {
+				return call
+			}
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+// Reset forgets the calls made and scripted results.
+func (s *Server) Reset() {
+	s.mx.Lock()
+	s.calls = make([]*Call, 0)
+	s.scripted = make(map[string][]interface{})
+	s.mx.Unlock()
+	for {
+		select {
+		case <-s.called:
+		default:
+			return
+		}
+	}
+}
+
+// Notify sends a notification to the clients connected to Kodi's server,
+// as Kodi does for player and library events.
+func (s *Server) Notify(method string, data interface{}) {
+	message := &notification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  map[string]interface{}{"sender": "xbmc", "data": data},
+	}
+	s.connsMx.Lock()
+	defer s.connsMx.Unlock()
+	for conn, encoder := range s.conns {
+		if conn.LocalAddr().String() != s.KodiAddr {
+			continue
+		}
+		if err := encoder.Encode(message); err != nil {
+			log.Warningf("Unable to notify %s: %s", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+func (s *Server) serve(listener net.Listener, host string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn, host)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn, host string) {
+	encoder := json.NewEncoder(conn)
+	s.connsMx.Lock()
+	s.conns[conn] = encoder
+	s.connsMx.Unlock()
+	defer func() {
+		s.connsMx.Lock()
+		delete(s.conns, conn)
+		s.connsMx.Unlock()
+		conn.Close()
+	}()
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if err != io.EOF {
+				log.Debugf("Closing %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		// Batches are arrays of requests, answered with an array
+		var answer interface{}
+		if len(raw) > 0 && raw[0] == '[' {
+			batch := make([]*request, 0)
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return
+			}
+			responses := make([]*response, 0, len(batch))
+			for _, req := range batch {
+				if resp := s.answer(host, req); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			answer = responses
+		} else {
+			req := &request{}
+			if err := json.Unmarshal(raw, req); err != nil {
+				return
+			}
+			resp := s.answer(host, req)
+			if resp == nil {
+				continue
+			}
+			answer = resp
+		}
+		s.connsMx.Lock()
+		err := encoder.Encode(answer)
+		s.connsMx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// answer records a call and answers it, nil for notifications.
+func (s *Server) answer(host string, req *request) *response {
+	call := &Call{Time: time.Now(), Host: host, Method: req.Method, Params: req.Params}
+	s.mx.Lock()
+	s.calls = append(s.calls, call)
+	var handler Handler
+	var scripted interface{}
+	isScripted := false
+	if results, ok := s.scripted[req.Method]; ok && len(results) > 0 {
+		scripted, isScripted = results[0], true
+		if len(results) > 1 {
+			s.scripted[req.Method] = results[1:]
+		}
+	} else {
+		handler = s.handlers[req.Method]
+	}
+	s.mx.Unlock()
+	select {
+	case s.called <- call:
+	default:
+	}
+
+	if req.Id == nil {
+		return nil
+	}
+	resp := &response{Id: *req.Id}
+	var result interface{}
+	var err error
+	switch {
+	case isScripted:
+		if scriptedErr, ok := scripted.(error); ok {
+			err = scriptedErr
+		} else {
+			result = scripted
+		}
+	case handler != nil:
+		result, err = handler(req.Params)
+	default:
+		err = errors.New("Method not found: " + req.Method)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if result == nil {
+		// Nulls are taken for errors by Quasar's client
+		resp.Result = "OK"
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
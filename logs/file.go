@@ -0,0 +1,112 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Size at which the log file is rotated
+	fileMaxSize = 5 * 1024 * 1024
+	// Rotated files kept, as quasar.log.1 and so on
+	filesKept = 3
+)
+
+// RotatingFile writes to a file, moving it aside once big enough. It
+// drops what's written before being opened.
+type RotatingFile struct {
+	mx   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+// Open starts writing to path, appending to what's there.
+func (f *RotatingFile) Open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.path = path
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Paths returns the log file and the rotated ones there are, newest first.
+func (f *RotatingFile) Paths() []string {
+	f.mx.Lock()
+	path := f.path
+	f.mx.Unlock()
+	if path == "" {
+		return nil
+	}
+	paths := []string{path}
+	for i := 1; i <= filesKept; i++ {
+		rotated := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(rotated); err == nil {
+			paths = append(paths, rotated)
+		}
+	}
+	return paths
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.file == nil {
+		return len(p), nil
+	}
+	if f.size+int64(len(p)) > fileMaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	for i := filesKept - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	os.Rename(f.path, f.path+".1")
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+// Close stops writing to the file.
+func (f *RotatingFile) Close() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
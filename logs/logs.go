@@ -0,0 +1,143 @@
+package logs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+//
+// Logging to stdout, a rotating file and an in-memory ring buffer, with
+// levels per module
+//
+
+const (
+	stdoutFormat = `%{color}%{level:.4s}  %{module:-12s} ▶ %{shortfunc:-15s}  %{color:reset}%{message}`
+	fileFormat   = `%{time:2006-01-02 15:04:05.000} %{level:.4s}  %{module:-12s} ▶ %{shortfunc:-15s}  %{message}`
+)
+
+// Buffer keeps the latest lines, for /logs and bug reports.
+var Buffer = NewRing(ringSize)
+
+// File is where lines go once opened, with older ones rotated.
+var File = &RotatingFile{}
+
+var levels = &moduleLevels{
+	levels:   make(map[string]logging.Level),
+	fallback: logging.DEBUG,
+}
+
+// moduleLevels is go-logging's leveled backend, safe for changing levels
+// while logging, passing records on to several backends.
+type moduleLevels struct {
+	mx       sync.RWMutex
+	levels   map[string]logging.Level
+	fallback logging.Level
+	backends []logging.Backend
+}
+
+func (l *moduleLevels) GetLevel(module string) logging.Level {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	if level, ok := l.levels[module]; ok {
+		return level
+	}
+	return l.fallback
+}
+
+func (l *moduleLevels) SetLevel(level logging.Level, module string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if module == "" {
+		l.fallback = level
+	} else {
+		l.levels[module] = level
+	}
+}
+
+func (l *moduleLevels) IsEnabledFor(level logging.Level, module string) bool {
+	return level <= l.GetLevel(module)
+}
+
+func (l *moduleLevels) Log(level logging.Level, calldepth int, rec *logging.Record) (err error) {
+	if !l.IsEnabledFor(level, rec.Module) {
+		return nil
+	}
+	for _, backend := range l.backends {
+		// Formatted lines are cached in records, so each backend gets a copy
+		record := *rec
+		if e := backend.Log(level, calldepth+1, &record); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Setup sends all loggers' lines to stdout, the buffer and the file.
+func Setup(stdout io.Writer) {
+	levels.backends = []logging.Backend{
+		logging.NewBackendFormatter(logging.NewLogBackend(stdout, "", 0), logging.MustStringFormatter(stdoutFormat)),
+		logging.NewBackendFormatter(logging.NewLogBackend(File, "", 0), logging.MustStringFormatter(fileFormat)),
+		Buffer,
+	}
+	logging.SetBackend(levels)
+}
+
+// ParseLevels reads levels like "info,libtorrent=warning,trakt=debug", the
+// one without a module being everybody else's.
+func ParseLevels(spec string) (map[string]logging.Level, error) {
+	parsed := make(map[string]logging.Level)
+	problems := make([]string, 0)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		module, name := "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			module, name = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		level, err := logging.LogLevel(name)
+		if err != nil {
+			problems = append(problems, "unknown level "+name)
+			continue
+		}
+		parsed[module] = level
+	}
+	if len(problems) > 0 {
+		return parsed, errors.New(strings.Join(problems, ", "))
+	}
+	return parsed, nil
+}
+
+// SetLevels replaces the levels with those of a spec, everything being
+// logged when it doesn't give one for other modules.
+func SetLevels(spec string) error {
+	parsed, err := ParseLevels(spec)
+	fallback, ok := parsed[""]
+	if !ok {
+		fallback = logging.DEBUG
+	}
+	delete(parsed, "")
+
+	levels.mx.Lock()
+	levels.levels = parsed
+	levels.fallback = fallback
+	levels.mx.Unlock()
+	return err
+}
+
+// Levels returns the level of each module set one, and "" for the others.
+func Levels() map[string]string {
+	levels.mx.RLock()
+	defer levels.mx.RUnlock()
+	current := make(map[string]string, len(levels.levels)+1)
+	current[""] = levels.fallback.String()
+	for module, level := range levels.levels {
+		current[module] = level.String()
+	}
+	return current
+}
@@ -0,0 +1,110 @@
+package logs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// Lines the buffer keeps
+const ringSize = 5000
+
+type Entry struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module"`
+	Message string    `json:"message"`
+
+	level logging.Level
+}
+
+// Filter picks lines of the buffer. Zero values let everything through,
+// but for Level, which is the least severe level shown.
+type Filter struct {
+	Level   logging.Level
+	Modules []string
+	Query   string
+	// Only lines after this ID, for following the log
+	Since uint64
+	// Only the latest lines
+	Limit int
+}
+
+func (f *Filter) match(entry *Entry) bool {
+	if entry.ID <= f.Since || entry.level > f.Level {
+		return false
+	}
+	if len(f.Modules) > 0 {
+		found := false
+		for _, module := range f.Modules {
+			if module == entry.Module {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.Query == "" || strings.Contains(strings.ToLower(entry.Message), strings.ToLower(f.Query))
+}
+
+// Ring is a log backend keeping the latest lines.
+type Ring struct {
+	mx      sync.RWMutex
+	entries []*Entry
+	next    int
+	full    bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]*Entry, size)}
+}
+
+func (r *Ring) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	entry := &Entry{
+		ID:      rec.ID,
+		Time:    rec.Time,
+		Level:   level.String(),
+		Module:  rec.Module,
+		Message: rec.Message(),
+		level:   level,
+	}
+	r.mx.Lock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mx.Unlock()
+	return nil
+}
+
+// Entries returns the lines matching a filter, oldest first.
+func (r *Ring) Entries(filter Filter) []*Entry {
+	r.mx.RLock()
+	ordered := make([]*Entry, 0, len(r.entries))
+	if r.full {
+		ordered = append(ordered, r.entries[r.next:]...)
+	}
+	ordered = append(ordered, r.entries[:r.next]...)
+	r.mx.RUnlock()
+
+	entries := make([]*Entry, 0)
+	for _, entry := range ordered {
+		if filter.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries
+}
+
+func (e *Entry) String() string {
+	return e.Time.Format("2006-01-02 15:04:05.000") + " " + e.Level[:4] + "  " + e.Module + " ▶ " + e.Message
+}
@@ -1,21 +1,23 @@
 package main
 
 import (
-	"os"
-	"time"
-	"strings"
-	"runtime"
-	"strconv"
+	"flag"
 	"net/http"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/api"
-	"github.com/scakemyer/quasar/lockfile"
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/lockfile"
+	"github.com/scakemyer/quasar/logs"
 	"github.com/scakemyer/quasar/trakt"
+	"github.com/scakemyer/quasar/ui"
 	"github.com/scakemyer/quasar/util"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -62,62 +64,44 @@ func ensureSingleInstance(conf *config.Configuration) (lock *lockfile.LockFile,
 	return
 }
 
-func makeBTConfiguration(conf *config.Configuration) *bittorrent.BTConfiguration {
-	btConfig := &bittorrent.BTConfiguration{
-		SpoofUserAgent:      conf.SpoofUserAgent,
-		BufferSize:          conf.BufferSize,
-		MaxUploadRate:       conf.UploadRateLimit,
-		MaxDownloadRate:     conf.DownloadRateLimit,
-		LimitAfterBuffering: conf.LimitAfterBuffering,
-		ConnectionsLimit:    conf.ConnectionsLimit,
-		SessionSave:         conf.SessionSave,
-		ShareRatioLimit:     conf.ShareRatioLimit,
-		SeedTimeRatioLimit:  conf.SeedTimeRatioLimit,
-		SeedTimeLimit:       conf.SeedTimeLimit,
-		DisableDHT:          conf.DisableDHT,
-		DisableUPNP:         conf.DisableUPNP,
-		EncryptionPolicy:    conf.EncryptionPolicy,
-		LowerListenPort:     conf.BTListenPortMin,
-		UpperListenPort:     conf.BTListenPortMax,
-		ListenInterfaces:    conf.ListenInterfaces,
-		OutgoingInterfaces:  conf.OutgoingInterfaces,
-		TunedStorage:        conf.TunedStorage,
-		DownloadPath:        conf.DownloadPath,
-		TorrentsPath:        conf.TorrentsPath,
-		DisableBgProgress:   conf.DisableBgProgress,
-		CompletedMove:       conf.CompletedMove,
-		CompletedMoviesPath: conf.CompletedMoviesPath,
-		CompletedShowsPath:  conf.CompletedShowsPath,
-	}
-
-	if conf.SocksEnabled == true {
-		btConfig.Proxy = &bittorrent.ProxySettings{
-			Type:     conf.ProxyType,
-			Hostname: conf.SocksHost,
-			Port:     conf.SocksPort,
-			Username: conf.SocksLogin,
-			Password: conf.SocksPassword,
-		}
-	}
-
-	return btConfig
+// parentGone tells whether Kodi, which started us, has shut down. Headless,
+// init being the parent, e.g. under systemd, is no reason to stop.
+func parentGone(ppid int) bool {
+	return !config.Headless && ppid == 1
 }
 
 func main() {
 	// Make sure we are properly multithreaded.
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	logging.SetFormatter(logging.MustStringFormatter(
-		`%{color}%{level:.4s}  %{module:-12s} ▶ %{shortfunc:-15s}  %{color:reset}%{message}`,
-	))
-	logging.SetBackend(logging.NewLogBackend(os.Stdout, "", 0))
+	configFile := config.DefaultConfigFile
+	if file := os.Getenv(config.ConfigFileEnv); file != "" {
+		configFile = file
+	}
+	flag.BoolVar(&config.Headless, "headless", os.Getenv(config.HeadlessEnv) != "", "Run without Kodi, with settings from -config")
+	flag.StringVar(&config.ConfigFile, "config", configFile, "Settings file of headless mode")
+	flag.Parse()
+	xbmc.Headless = config.Headless
+
+	logs.Setup(os.Stdout)
 
 	for _, line := range strings.Split(QuasarLogo, "\n") {
 		log.Debug(line)
 	}
 	log.Infof("Version: %s Go: %s", util.Version[1:len(util.Version) - 1], runtime.Version())
 
-	conf := config.Reload()
+	var conf *config.Configuration
+	if config.Headless {
+		var err error
+		config.Version = util.Version[1 : len(util.Version)-1]
+		if conf, err = config.LoadFile(); err != nil {
+			log.Criticalf("Invalid configuration in %s: %s", config.ConfigFile, err)
+			os.Exit(1)
+		}
+		log.Infof("Running headless with %s", config.ConfigFile)
+	} else {
+		conf = config.Reload()
+	}
 
 	log.Infof("Addon: %s v%s", conf.Info.Id, conf.Info.Version)
 
@@ -128,7 +112,26 @@ func main() {
 		os.Exit(1)
 	}
 
-	wasFirstRun := Migrate()
+	if err := logs.File.Open(filepath.Join(conf.Info.Profile, "quasar.log")); err != nil {
+		log.Error(err)
+	}
+	defer logs.File.Close()
+	if err := logs.SetLevels(conf.LogLevels); err != nil {
+		log.Warningf("Invalid log levels: %s", err)
+	}
+
+	dialogs, err := ui.ForConfig(conf)
+	if err != nil {
+		log.Criticalf("Unable to set up the UI: %s", err)
+		os.Exit(1)
+	}
+	api.SetUI(dialogs)
+
+	// The repository add-on is only of use to Kodi
+	wasFirstRun := false
+	if !config.Headless {
+		wasFirstRun = Migrate()
+	}
 
 	db, err := bolt.Open(filepath.Join(conf.Info.Profile, "library.db"), 0600, &bolt.Options{
 		ReadOnly: false,
@@ -140,7 +143,9 @@ func main() {
 	}
 	defer db.Close()
 
-	btService := bittorrent.NewBTService(*makeBTConfiguration(conf), db)
+	api.InitDB(db)
+
+	btService := bittorrent.NewBTService(*bittorrent.NewBTConfiguration(conf), db)
 
 	var shutdown = func() {
 		log.Info("Shutting down...")
@@ -152,7 +157,7 @@ func main() {
 
 	var watchParentProcess = func() {
 		for {
-			if os.Getppid() == 1 {
+			if parentGone(os.Getppid()) {
 				log.Warning("Parent shut down, shutting down too...")
 				go shutdown()
 				break
@@ -160,34 +165,71 @@ func main() {
 			time.Sleep(1 * time.Second)
 		}
 	}
-	go watchParentProcess()
+	if !config.Headless {
+		go watchParentProcess()
+	}
 
-	http.Handle("/", api.Routes(btService))
+	http.Handle("/", api.ProfileHandler(api.Routes(btService)))
 	http.Handle("/files/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handler := http.StripPrefix("/files/", http.FileServer(bittorrent.NewTorrentFS(btService, config.Get().DownloadPath)))
 		handler.ServeHTTP(w, r)
 	}))
-	http.Handle("/reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		btService.Reconfigure(*makeBTConfiguration(config.Reload()))
-	}))
-	http.Handle("/shutdown", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	api.ApplySettings = func(old *config.Configuration, conf *config.Configuration) []*config.Setting {
+		changed := config.Changed(old, conf)
+		for _, setting := range changed {
+			log.Infof("Setting %s changed", setting.Key)
+			if setting.Key == "log_levels" {
+				if err := logs.SetLevels(conf.LogLevels); err != nil {
+					log.Warningf("Invalid log levels: %s", err)
+				}
+			}
+		}
+		level := config.ApplyLevel(changed)
+		if level == config.ApplyRestart {
+			log.Warning("Some settings changed only apply once Quasar restarts")
+		}
+		btService.Apply(*bittorrent.NewBTConfiguration(conf), level >= config.ApplySession)
+		return changed
+	}
+	http.Handle("/reload", api.ChangesState(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		old := config.Get()
+		api.ApplySettings(old, config.Reload())
+	})))
+	http.Handle("/shutdown", api.ChangesState(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		shutdown()
-	}))
+	})))
 
 	xbmc.Notify("Quasar", "LOCALIZE[30208]", config.AddonIcon())
 
-	go func() {
-		if !wasFirstRun {
-			log.Info("Updating Kodi add-on repositories...")
-			xbmc.UpdateAddonRepos()
-		}
+	if !config.Headless {
+		go func() {
+			if !wasFirstRun {
+				log.Info("Updating Kodi add-on repositories...")
+				xbmc.UpdateAddonRepos()
+			}
 
-		xbmc.ResetRPC()
-	}()
+			xbmc.ResetRPC()
+		}()
+		go xbmc.ListenNotifications(nil)
+		go api.NotificationListener(btService)
+	}
 
 	go api.LibraryUpdate(db)
 	go api.LibraryListener()
+	go api.AutoGrab(btService)
 	go trakt.TokenRefreshHandler()
 
-	http.ListenAndServe(":" + strconv.Itoa(config.ListenPort), nil)
+	handler := api.AccessHandler(http.DefaultServeMux)
+	addresses := api.ListenAddresses()
+	for _, address := range addresses[1:] {
+		go func(address string) {
+			if err := http.ListenAndServe(address, handler); err != nil {
+				log.Error(err)
+			}
+		}(address)
+	}
+	log.Infof("Listening on %s", strings.Join(addresses, ", "))
+	if err := http.ListenAndServe(addresses[0], handler); err != nil {
+		log.Error(err)
+	}
 }
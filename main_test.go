@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scakemyer/quasar/config"
+)
+
+func TestParentGone(t *testing.T) {
+	defer func(headless bool) {
+		config.Headless = headless
+	}(config.Headless)
+
+	config.Headless = false
+	if !parentGone(1) {
+		t.Error("Kodi's add-on keeps running once reparented to init")
+	}
+	if parentGone(4242) {
+		t.Error("Kodi's add-on stops while Kodi runs")
+	}
+
+	config.Headless = true
+	if parentGone(1) {
+		t.Error("headless daemon stops when its parent is init")
+	}
+}
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Counters, gauges and histograms, written in Prometheus' text format
+//
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Buckets in seconds, from quick API calls to slow buffering
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	registryMx sync.RWMutex
+	registry   = make(map[string]*family)
+)
+
+type series struct {
+	labels []string
+	value  float64
+	// Histograms' counts of each bucket, not cumulated
+	buckets []uint64
+	count   uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mx     sync.Mutex
+	series map[string]*series
+}
+
+func register(name string, help string, kind string, labels []string, buckets []float64) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	registryMx.Lock()
+	defer registryMx.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	registry[name] = f
+	return f
+}
+
+// get returns the series of label values, the lock being held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, not %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string{}, values...)}
+		if f.kind == kindHistogram {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+type Counter struct {
+	*family
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, kindCounter, labels, nil)}
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	c.mx.Lock()
+	c.get(values).value += delta
+	c.mx.Unlock()
+}
+
+type Gauge struct {
+	*family
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, kindGauge, labels, nil)}
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mx.Lock()
+	g.get(values).value = value
+	g.mx.Unlock()
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mx.Lock()
+	g.get(values).value += delta
+	g.mx.Unlock()
+}
+
+// Retain drops the series keep doesn't want, e.g. of torrents gone.
+func (g *Gauge) Retain(keep func(values []string) bool) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for key, s := range g.series {
+		if !keep(s.labels) {
+			delete(g.series, key)
+		}
+	}
+}
+
+type Histogram struct {
+	*family
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{register(name, help, kindHistogram, labels, buckets)}
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	s := h.get(values)
+	s.value += value
+	s.count++
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// Since observes the seconds gone by since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.Replace(f.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labels), formatFloat(s.value))
+			continue
+		}
+		cumulated := uint64(0)
+		for i, bound := range f.buckets {
+			cumulated += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labels, "le", formatFloat(bound)), cumulated)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labels), s.count)
+	}
+}
+
+// Write writes all metrics, sorted by name.
+func Write(out io.Writer) error {
+	registryMx.RLock()
+	families := make([]*family, 0, len(registry))
+	for _, f := range registry {
+		families = append(families, f)
+	}
+	registryMx.RUnlock()
+	sort.Sort(byName(families))
+
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.write(w)
+	}
+	return w.Flush()
+}
+
+type byName []*family
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].name < a[j].name }
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// scrape returns what Write writes of the metrics whose name starts with
+// prefix, comments included.
+func scrape(t *testing.T, prefix string) []string {
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if strings.HasPrefix(name, prefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func checkLines(t *testing.T, got []string, want []string) {
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "Things\ncounted.", "kind")
+	c.Inc("b")
+	c.Add(2.5, "a")
+	c.Inc("a")
+	c.Inc(`quote"d`)
+
+	checkLines(t, scrape(t, "test_counter_"), []string{
+		"# HELP test_counter_total Things counted.",
+		"# TYPE test_counter_total counter",
+		`test_counter_total{kind="a"} 3.5`,
+		`test_counter_total{kind="b"} 1`,
+		`test_counter_total{kind="quote\"d"} 1`,
+	})
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "A level.")
+	g.Set(7)
+	g.Add(-2)
+
+	checkLines(t, scrape(t, "test_gauge"), []string{
+		"# HELP test_gauge A level.",
+		"# TYPE test_gauge gauge",
+		"test_gauge 5",
+	})
+}
+
+func TestGaugeRetain(t *testing.T) {
+	g := NewGauge("test_retained", "Per torrent.", "infohash", "state")
+	g.Set(1, "aaa", "seeding")
+	g.Set(2, "bbb", "downloading")
+	g.Set(3, "ccc", "downloading")
+
+	g.Retain(func(values []string) bool {
+		return values[0] != "bbb"
+	})
+	checkLines(t, scrape(t, "test_retained"), []string{
+		"# HELP test_retained Per torrent.",
+		"# TYPE test_retained gauge",
+		`test_retained{infohash="aaa",state="seeding"} 1`,
+		`test_retained{infohash="ccc",state="downloading"} 3`,
+	})
+
+	g.Retain(func(values []string) bool {
+		return false
+	})
+	checkLines(t, scrape(t, "test_retained"), []string{
+		"# HELP test_retained Per torrent.",
+		"# TYPE test_retained gauge",
+	})
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_seconds", "Time taken.", []float64{0.5, 1, 5}, "result")
+	h.Observe(0.2, "ok")
+	h.Observe(0.5, "ok")
+	h.Observe(3, "ok")
+	h.Observe(10, "ok")
+
+	checkLines(t, scrape(t, "test_seconds"), []string{
+		"# HELP test_seconds Time taken.",
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{result="ok",le="0.5"} 2`,
+		`test_seconds_bucket{result="ok",le="1"} 2`,
+		`test_seconds_bucket{result="ok",le="5"} 3`,
+		`test_seconds_bucket{result="ok",le="+Inf"} 4`,
+		`test_seconds_sum{result="ok"} 13.7`,
+		`test_seconds_count{result="ok"} 4`,
+	})
+}
+
+func TestWriteSortsByName(t *testing.T) {
+	NewGauge("test_sorted_b", "B.").Set(1)
+	NewGauge("test_sorted_a", "A.").Set(1)
+
+	lines := scrape(t, "test_sorted_")
+	if len(lines) != 6 || !strings.HasPrefix(lines[0], "# HELP test_sorted_a") || !strings.HasPrefix(lines[3], "# HELP test_sorted_b") {
+		t.Errorf("not sorted by name:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	NewCounter("test_twice_total", "Once.")
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic registering a name twice")
+		}
+	}()
+	NewCounter("test_twice_total", "Twice.")
+}
@@ -10,13 +10,13 @@ type Searcher interface {
 }
 
 type MovieSearcher interface {
-	SearchMovieLinks(movie *tmdb.Movie) []*bittorrent.Torrent
+	SearchMovieLinks(movie *tmdb.Movie, title string) []*bittorrent.Torrent
 }
 
 type SeasonSearcher interface {
-	SearchSeasonLinks(show *tmdb.Show, season *tmdb.Season) []*bittorrent.Torrent
+	SearchSeasonLinks(show *tmdb.Show, season *tmdb.Season, title string) []*bittorrent.Torrent
 }
 
 type EpisodeSearcher interface {
-	SearchEpisodeLinks(show *tmdb.Show, episode *tmdb.Episode) []*bittorrent.Torrent
+	SearchEpisodeLinks(show *tmdb.Show, episode *tmdb.Episode, title string) []*bittorrent.Torrent
 }
@@ -0,0 +1,344 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/release"
+)
+
+const (
+	RulesFile = "rules.json"
+)
+
+const (
+	RuleExclude = "exclude"
+	RuleRequire = "require"
+	RuleScore   = "score"
+)
+
+// Condition tests a single torrent field, i.e.
+// {"field": "resolution", "op": "gte", "value": "1080p"}
+type Condition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// Rule is applied to every search result, i.e.
+// {"name": "x265 for 4K", "action": "require",
+//  "when": [{"field": "resolution", "op": "gt", "value": "1080p"}],
+//  "match": [{"field": "video_codec", "op": "eq", "value": "H.265"}]}
+//
+// Exclude rules drop results matching all of Match, require rules drop
+// results that don't, and score rules add Score to matching results. When,
+// if set, limits which results the rule applies to at all.
+type Rule struct {
+	Name   string      `json:"name"`
+	Action string      `json:"action"`
+	Score  float64     `json:"score"`
+	When   []Condition `json:"when"`
+	Match  []Condition `json:"match"`
+}
+
+type Rules []*Rule
+
+// ScoreContext holds what we know about the media being searched for.
+type ScoreContext struct {
+	Runtime int // minutes
+}
+
+func RulesPath() string {
+	return filepath.Join(config.Get().ProfilePath, RulesFile)
+}
+
+// LoadRules reads the user's rules file, a missing file meaning no rules.
+func LoadRules() (Rules, error) {
+	rules := Rules{}
+	data, err := ioutil.ReadFile(RulesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules, nil
+		}
+		return rules, err
+	}
+	return parseRules(data)
+}
+
+func parseRules(data []byte) (Rules, error) {
+	rules := Rules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, err
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return Rules{}, fmt.Errorf("rule #%d: %s", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// ApplyRules applies the rules file to torrents, leaving them as they are
+// when it's invalid.
+func ApplyRules(torrents []*bittorrent.Torrent, context *ScoreContext) []*bittorrent.Torrent {
+	rules, err := LoadRules()
+	if err != nil {
+		log.Errorf("Unable to load %s: %s", RulesPath(), err)
+		return torrents
+	}
+	return rules.Apply(torrents, context)
+}
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case RuleExclude, RuleRequire, RuleScore:
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if len(r.Match) == 0 {
+		return fmt.Errorf("no match conditions")
+	}
+	for _, c := range append(append([]Condition{}, r.When...), r.Match...) {
+		if _, exists := ruleFields[c.Field]; !exists {
+			return fmt.Errorf("unknown field %q", c.Field)
+		}
+		if _, exists := ruleOps[c.Op]; !exists {
+			return fmt.Errorf("unknown operator %q", c.Op)
+		}
+		if c.Op == "matches" {
+			if _, err := regexp.Compile(c.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	conditions := make([]string, 0, len(r.Match))
+	for _, c := range r.Match {
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", c.Field, c.Op, c.Value))
+	}
+	return strings.Join(conditions, " & ")
+}
+
+// Apply filters out excluded torrents and scores the rest, then stably
+// sorts them by score so the existing sorting acts as a tie breaker.
+func (rules Rules) Apply(torrents []*bittorrent.Torrent, context *ScoreContext) []*bittorrent.Torrent {
+	kept := make([]*bittorrent.Torrent, 0, len(torrents))
+	for _, torrent := range torrents {
+		torrent.Score = 0
+		torrent.ScoreReasons = make([]string, 0)
+
+		excluded := false
+		for _, rule := range rules {
+			if !rule.applies(torrent, context) {
+				continue
+			}
+			matched := rule.matches(torrent, context)
+			switch rule.Action {
+			case RuleExclude:
+				excluded = matched
+			case RuleRequire:
+				excluded = !matched
+			case RuleScore:
+				if matched {
+					torrent.Score += rule.Score
+					torrent.ScoreReasons = append(torrent.ScoreReasons, fmt.Sprintf("%+g %s", rule.Score, rule))
+				}
+			}
+			if excluded {
+				log.Debugf("Excluding %s (%s: %s)", torrent.Name, rule.Action, rule)
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, torrent)
+		}
+	}
+
+	SortByScore(kept)
+
+	if excluded := len(torrents) - len(kept); excluded > 0 {
+		log.Noticef("Rules excluded %d of %d links", excluded, len(torrents))
+	}
+	return kept
+}
+
+// SortByScore stably sorts torrents by their rules score, highest first.
+func SortByScore(torrents []*bittorrent.Torrent) {
+	sort.Stable(sort.Reverse(ByScore(torrents)))
+}
+
+func (r *Rule) applies(t *bittorrent.Torrent, context *ScoreContext) bool {
+	for _, c := range r.When {
+		if !c.test(t, context) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) matches(t *bittorrent.Torrent, context *ScoreContext) bool {
+	for _, c := range r.Match {
+		if !c.test(t, context) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fields are either numeric, where named values like "1080p" or "H.265"
+// are translated to their constant, or plain strings.
+type fieldValue struct {
+	number func(t *bittorrent.Torrent, context *ScoreContext) (float64, bool)
+	text   func(t *bittorrent.Torrent) string
+	names  []string
+}
+
+var ruleFields = map[string]fieldValue{
+	"resolution":  {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.Resolution), true }, names: bittorrent.Resolutions},
+	"rip_type":    {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.RipType), true }, names: bittorrent.Rips},
+	"video_codec": {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.VideoCodec), true }, names: bittorrent.Codecs},
+	"audio_codec": {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.AudioCodec), true }, names: bittorrent.Codecs},
+	"seeds":       {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.Seeds), true }},
+	"peers":       {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return float64(t.Peers), true }},
+	"size":        {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) { return torrentSize(t) }},
+	"size_per_minute": {number: func(t *bittorrent.Torrent, context *ScoreContext) (float64, bool) {
+		size, ok := torrentSize(t)
+		if !ok || context == nil || context.Runtime <= 0 {
+			return 0, false
+		}
+		return size / float64(context.Runtime), true
+	}},
+	"bit_depth": {number: func(t *bittorrent.Torrent, _ *ScoreContext) (float64, bool) {
+		if t.Release == nil || t.Release.BitDepth == 0 {
+			return 0, false
+		}
+		return float64(t.Release.BitDepth), true
+	}},
+	"name":     {text: func(t *bittorrent.Torrent) string { return t.Name }},
+	"provider": {text: func(t *bittorrent.Torrent) string { return t.Provider }},
+	"language": {text: func(t *bittorrent.Torrent) string { return t.Language }},
+	"tags":     {text: func(t *bittorrent.Torrent) string { return strings.Join(releaseField(t, (*release.Info).Tags), ",") }},
+	"hdr": {text: func(t *bittorrent.Torrent) string {
+		return strings.Join(releaseField(t, func(r *release.Info) []string { return r.HDR }), ",")
+	}},
+	"group": {text: func(t *bittorrent.Torrent) string {
+		return strings.Join(releaseField(t, func(r *release.Info) []string { return []string{r.Group} }), ",")
+	}},
+}
+
+func releaseField(t *bittorrent.Torrent, field func(*release.Info) []string) []string {
+	if t.Release == nil {
+		return []string{}
+	}
+	return field(t.Release)
+}
+
+var ruleOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true,
+	"in": true, "contains": true, "matches": true,
+}
+
+func torrentSize(t *bittorrent.Torrent) (float64, bool) {
+	if t.Size == "" {
+		return 0, false
+	}
+	size, err := humanize.ParseBytes(t.Size)
+	if err != nil {
+		return 0, false
+	}
+	return float64(size), true
+}
+
+func (c *Condition) test(t *bittorrent.Torrent, context *ScoreContext) bool {
+	field := ruleFields[c.Field]
+
+	if field.text != nil {
+		value := strings.ToLower(field.text(t))
+		expected := strings.ToLower(c.Value)
+		switch c.Op {
+		case "eq":
+			return value == expected
+		case "ne":
+			return value != expected
+		case "contains":
+			return strings.Contains(value, expected)
+		case "in":
+			// Lists, like tags, match when one of their items is a candidate
+			for _, item := range strings.Split(value, ",") {
+				for _, candidate := range strings.Split(expected, ",") {
+					if strings.TrimSpace(item) == strings.TrimSpace(candidate) {
+						return true
+					}
+				}
+			}
+			return false
+		case "matches":
+			matched, _ := regexp.MatchString("(?i)"+c.Value, field.text(t))
+			return matched
+		}
+		return false
+	}
+
+	value, known := field.number(t, context)
+	if !known {
+		return false
+	}
+	if c.Op == "in" {
+		for _, candidate := range strings.Split(c.Value, ",") {
+			if expected, ok := parseFieldValue(field, strings.TrimSpace(candidate)); ok && value == expected {
+				return true
+			}
+		}
+		return false
+	}
+	expected, ok := parseFieldValue(field, c.Value)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case "eq":
+		return value == expected
+	case "ne":
+		return value != expected
+	case "lt":
+		return value < expected
+	case "lte":
+		return value <= expected
+	case "gt":
+		return value > expected
+	case "gte":
+		return value >= expected
+	}
+	return false
+}
+
+func parseFieldValue(field fieldValue, value string) (float64, bool) {
+	for i, name := range field.names {
+		if name != "" && strings.EqualFold(name, value) {
+			return float64(i), true
+		}
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, true
+	}
+	if size, err := humanize.ParseBytes(value); err == nil {
+		return float64(size), true
+	}
+	return 0, false
+}
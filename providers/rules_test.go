@@ -0,0 +1,225 @@
+package providers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scakemyer/quasar/bittorrent"
+	"github.com/scakemyer/quasar/release"
+)
+
+func testTorrent() *bittorrent.Torrent {
+	return &bittorrent.Torrent{
+		Name:       "Blade.Runner.2049.2017.2160p.UHD.BluRay.REMUX.HDR.DV.HEVC.TrueHD.7.1.Atmos-FGT",
+		Size:       "6 GB",
+		Seeds:      120,
+		Peers:      5,
+		Provider:   "YTS",
+		Language:   "en",
+		Resolution: 5,
+		RipType:    bittorrent.RipBluRay,
+		VideoCodec: bittorrent.CodecH265,
+		AudioCodec: bittorrent.CodecTrueHD,
+		Release: &release.Info{
+			BitDepth:      10,
+			HDR:           []string{"HDR10", "DV"},
+			AudioChannels: "7.1",
+			Atmos:         true,
+			Remux:         true,
+			Group:         "FGT",
+		},
+	}
+}
+
+var conditionCases = []struct {
+	field string
+	op    string
+	value string
+	want  bool
+}{
+	{"resolution", "eq", "4K", true},
+	{"resolution", "eq", "1080p", false},
+	{"resolution", "ne", "1080p", true},
+	{"resolution", "lt", "4K", false},
+	{"resolution", "lte", "4K", true},
+	{"resolution", "gt", "1080p", true},
+	{"resolution", "gte", "1440p", true},
+	{"resolution", "in", "1080p, 4K", true},
+	{"resolution", "in", "720p,1080p", false},
+	{"resolution", "eq", "huge", false},
+	{"rip_type", "eq", "Blu-Ray", true},
+	{"rip_type", "gt", "WebDL", true},
+	{"rip_type", "in", "HDTV,WebDL", false},
+	{"video_codec", "eq", "H.265", true},
+	{"video_codec", "ne", "h.265", false},
+	{"audio_codec", "in", "DTS HD MA, TrueHD", true},
+	{"audio_codec", "lt", "TrueHD", false},
+	{"seeds", "gte", "100", true},
+	{"seeds", "lt", "100", false},
+	{"peers", "eq", "5", true},
+	{"size", "gt", "4 GB", true},
+	{"size", "lte", "5GB", false},
+	{"size_per_minute", "gte", "40MB", true},
+	{"size_per_minute", "gt", "60MB", false},
+	{"bit_depth", "eq", "10", true},
+	{"bit_depth", "lt", "10", false},
+	{"name", "contains", "remux", true},
+	{"name", "matches", `\bHEVC\b`, true},
+	{"name", "matches", `x265`, false},
+	{"name", "eq", "blade runner", false},
+	{"provider", "eq", "yts", true},
+	{"provider", "ne", "YTS", false},
+	{"provider", "in", "eztv, yts", true},
+	{"language", "in", "fr,en", true},
+	{"language", "eq", "fr", false},
+	{"hdr", "in", "DV", true},
+	{"hdr", "in", "HDR", false},
+	{"hdr", "contains", "hdr", true},
+	{"group", "eq", "fgt", true},
+	{"group", "in", "SPARKS,FGT", true},
+	{"group", "matches", "^SPA", false},
+	{"tags", "in", "REMUX", true},
+	{"tags", "in", "10bit, PROPER", true},
+	{"tags", "in", "bit", false},
+	{"tags", "contains", "atmos", true},
+}
+
+func TestConditions(t *testing.T) {
+	context := &ScoreContext{Runtime: 120}
+	for _, c := range conditionCases {
+		condition := &Condition{Field: c.field, Op: c.op, Value: c.value}
+		if got := condition.test(testTorrent(), context); got != c.want {
+			t.Errorf("%s %s %q is %v, want %v", c.field, c.op, c.value, got, c.want)
+		}
+	}
+}
+
+// Fields a torrent doesn't tell never match, whatever the operator.
+func TestUnknownFieldValues(t *testing.T) {
+	torrent := testTorrent()
+	torrent.Size = ""
+	torrent.Release = nil
+	for _, condition := range []*Condition{
+		{Field: "size", Op: "gte", Value: "0"},
+		{Field: "size", Op: "ne", Value: "1GB"},
+		{Field: "size_per_minute", Op: "gte", Value: "0"},
+		{Field: "bit_depth", Op: "ne", Value: "10"},
+		{Field: "hdr", Op: "in", Value: "DV"},
+		{Field: "tags", Op: "contains", Value: "REMUX"},
+	} {
+		if condition.test(torrent, &ScoreContext{Runtime: 120}) {
+			t.Errorf("%s %s %s matches without a value", condition.Field, condition.Op, condition.Value)
+		}
+	}
+
+	condition := &Condition{Field: "size_per_minute", Op: "gte", Value: "0"}
+	if condition.test(testTorrent(), &ScoreContext{}) {
+		t.Error("size_per_minute matches without a runtime")
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	valid := `[{"name": "x265 for 4K", "action": "require",
+		"when": [{"field": "resolution", "op": "gt", "value": "1080p"}],
+		"match": [{"field": "video_codec", "op": "eq", "value": "H.265"}]}]`
+	rules, err := parseRules([]byte(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].String() != "x265 for 4K" || len(rules[0].When) != 1 {
+		t.Errorf("parsed %+v", rules)
+	}
+
+	for _, invalid := range []string{
+		`[{"action": "drop", "match": [{"field": "name", "op": "eq", "value": "x"}]}]`,
+		`[{"action": "exclude"}]`,
+		`[{"action": "exclude", "match": [{"field": "quality", "op": "eq", "value": "x"}]}]`,
+		`[{"action": "exclude", "match": [{"field": "name", "op": "like", "value": "x"}]}]`,
+		`[{"action": "exclude", "match": [{"field": "name", "op": "matches", "value": "("}]}]`,
+		`[{"action": "score", "when": [{"field": "seed", "op": "gt", "value": "1"}], "match": [{"field": "name", "op": "eq", "value": "x"}]}]`,
+		`{"action": "exclude"}`,
+	} {
+		if rules, err := parseRules([]byte(invalid)); err == nil || len(rules) > 0 {
+			t.Errorf("%s parsed without error", invalid)
+		}
+	}
+}
+
+func names(torrents []*bittorrent.Torrent) []string {
+	result := make([]string, 0, len(torrents))
+	for _, torrent := range torrents {
+		result = append(result, torrent.Name)
+	}
+	return result
+}
+
+func candidates() []*bittorrent.Torrent {
+	return []*bittorrent.Torrent{
+		{Name: "a.1080p.x264", Resolution: 3, VideoCodec: bittorrent.CodecH264, Seeds: 50},
+		{Name: "b.2160p.x265", Resolution: 5, VideoCodec: bittorrent.CodecH265, Seeds: 10},
+		{Name: "c.720p.x264.CAM", Resolution: 2, VideoCodec: bittorrent.CodecH264, RipType: bittorrent.RipCam, Seeds: 500},
+		{Name: "d.2160p.x264", Resolution: 5, VideoCodec: bittorrent.CodecH264, Seeds: 30},
+		{Name: "e.1080p.x265", Resolution: 3, VideoCodec: bittorrent.CodecH265, Seeds: 5},
+	}
+}
+
+func TestApply(t *testing.T) {
+	rules := Rules{
+		{Action: RuleExclude, Match: []Condition{{Field: "rip_type", Op: "eq", Value: "Cam"}}},
+		{Name: "x265 for 4K", Action: RuleRequire,
+			When:  []Condition{{Field: "resolution", Op: "gt", Value: "1080p"}},
+			Match: []Condition{{Field: "video_codec", Op: "eq", Value: "H.265"}}},
+		{Action: RuleScore, Score: 10, Match: []Condition{{Field: "video_codec", Op: "eq", Value: "H.265"}}},
+		{Action: RuleScore, Score: -5, Match: []Condition{{Field: "seeds", Op: "lt", Value: "20"}}},
+	}
+	kept := rules.Apply(candidates(), &ScoreContext{})
+
+	if want := []string{"b.2160p.x265", "e.1080p.x265", "a.1080p.x264"}; !reflect.DeepEqual(names(kept), want) {
+		t.Fatalf("kept %v, want %v", names(kept), want)
+	}
+	if kept[0].Score != 5 || kept[2].Score != 0 {
+		t.Errorf("scores %v and %v, want 5 and 0", kept[0].Score, kept[2].Score)
+	}
+	if reasons := strings.Join(kept[0].ScoreReasons, "; "); reasons != "+10 video_codec eq H.265; -5 seeds lt 20" {
+		t.Errorf("reasons %q", reasons)
+	}
+}
+
+// Results scoring the same keep the order they were sorted in before, and
+// scores left from an earlier apply, e.g. of cached links, don't stay.
+func TestApplyKeepsOrderOnTies(t *testing.T) {
+	torrents := candidates()
+	for _, torrent := range torrents {
+		torrent.Score = 100
+		torrent.ScoreReasons = []string{"stale"}
+	}
+	torrents[4].Score = 0
+
+	kept := Rules{}.Apply(torrents, nil)
+	if want := names(candidates()); !reflect.DeepEqual(names(kept), want) {
+		t.Errorf("order %v, want %v", names(kept), want)
+	}
+	for _, torrent := range kept {
+		if torrent.Score != 0 || len(torrent.ScoreReasons) != 0 {
+			t.Errorf("%s kept score %v %v", torrent.Name, torrent.Score, torrent.ScoreReasons)
+		}
+	}
+
+	rules := Rules{{Action: RuleScore, Score: 1, Match: []Condition{{Field: "resolution", Op: "gte", Value: "4K"}}}}
+	kept = rules.Apply(candidates(), nil)
+	if want := []string{"b.2160p.x265", "d.2160p.x264", "a.1080p.x264", "c.720p.x264.CAM", "e.1080p.x265"}; !reflect.DeepEqual(names(kept), want) {
+		t.Errorf("order %v, want %v", names(kept), want)
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	torrents := candidates()
+	for i, score := range []float64{1, 3, 1, 3, 2} {
+		torrents[i].Score = score
+	}
+	SortByScore(torrents)
+	if want := []string{"b.2160p.x265", "d.2160p.x264", "e.1080p.x265", "a.1080p.x264", "c.720p.x264.CAM"}; !reflect.DeepEqual(names(torrents), want) {
+		t.Errorf("order %v, want %v", names(torrents), want)
+	}
+}
@@ -2,13 +2,14 @@ package providers
 
 import (
 	"sort"
+	"strings"
 	"sync"
 	"time"
-	"strings"
 
 	"github.com/op/go-logging"
 	"github.com/scakemyer/quasar/bittorrent"
 	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/metrics"
 	"github.com/scakemyer/quasar/tmdb"
 	"github.com/scakemyer/quasar/xbmc"
 )
@@ -36,6 +37,13 @@ var (
 	log = logging.MustGetLogger("linkssearch")
 )
 
+var (
+	providerSearchSeconds = metrics.NewHistogram("quasar_provider_search_seconds", "Time providers take to answer a search.", metrics.DurationBuckets, "provider", "method")
+	providerFailures      = metrics.NewCounter("quasar_provider_failures_total", "Searches providers didn't answer in time or properly.", "provider", "reason")
+	linksSearchSeconds    = metrics.NewHistogram("quasar_links_search_seconds", "Time taken to search and resolve links, all providers together.", metrics.DurationBuckets)
+	linksResolveFailures  = metrics.NewCounter("quasar_links_resolve_failures_total", "Torrent links which couldn't be resolved.")
+)
+
 func Search(searchers []Searcher, query string) []*bittorrent.Torrent {
 	torrentsChan := make(chan *bittorrent.Torrent)
 	go func() {
@@ -53,70 +61,92 @@ func Search(searchers []Searcher, query string) []*bittorrent.Torrent {
 		close(torrentsChan)
 	}()
 
-	return processLinks(torrentsChan, SortMovies)
+	return processLinks(torrentsChan, SortMovies, &ScoreContext{})
 }
 
 func SearchMovie(searchers []MovieSearcher, movie *tmdb.Movie) []*bittorrent.Torrent {
+	titles := MovieTitles(movie)
+	log.Infof("Searching for titles: %s", strings.Join(titles, ", "))
+
 	torrentsChan := make(chan *bittorrent.Torrent)
 	go func() {
 		wg := sync.WaitGroup{}
 		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher MovieSearcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchMovieLinks(movie) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
+			for _, title := range titles {
+				wg.Add(1)
+				go func(searcher MovieSearcher, title string) {
+					defer wg.Done()
+					for _, torrent := range searcher.SearchMovieLinks(movie, title) {
+						torrentsChan <- torrent
+					}
+				}(searcher, title)
+			}
 		}
 		wg.Wait()
 		close(torrentsChan)
 	}()
 
-	return processLinks(torrentsChan, SortMovies)
+	return processLinks(torrentsChan, SortMovies, &ScoreContext{Runtime: movie.Runtime})
 }
 
 func SearchSeason(searchers []SeasonSearcher, show *tmdb.Show, season *tmdb.Season) []*bittorrent.Torrent {
+	titles := ShowTitles(show)
+	log.Infof("Searching for titles: %s", strings.Join(titles, ", "))
+
 	torrentsChan := make(chan *bittorrent.Torrent)
 	go func() {
 		wg := sync.WaitGroup{}
 		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher SeasonSearcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchSeasonLinks(show, season) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
+			for _, title := range titles {
+				wg.Add(1)
+				go func(searcher SeasonSearcher, title string) {
+					defer wg.Done()
+					for _, torrent := range searcher.SearchSeasonLinks(show, season, title) {
+						torrentsChan <- torrent
+					}
+				}(searcher, title)
+			}
 		}
 		wg.Wait()
 		close(torrentsChan)
 	}()
 
-	return processLinks(torrentsChan, SortShows)
+	return processLinks(torrentsChan, SortShows, &ScoreContext{})
 }
 
 func SearchEpisode(searchers []EpisodeSearcher, show *tmdb.Show, episode *tmdb.Episode) []*bittorrent.Torrent {
+	titles := ShowTitles(show)
+	log.Infof("Searching for titles: %s", strings.Join(titles, ", "))
+
 	torrentsChan := make(chan *bittorrent.Torrent)
 	go func() {
 		wg := sync.WaitGroup{}
 		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher EpisodeSearcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchEpisodeLinks(show, episode) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
+			for _, title := range titles {
+				wg.Add(1)
+				go func(searcher EpisodeSearcher, title string) {
+					defer wg.Done()
+					for _, torrent := range searcher.SearchEpisodeLinks(show, episode, title) {
+						torrentsChan <- torrent
+					}
+				}(searcher, title)
+			}
 		}
 		wg.Wait()
 		close(torrentsChan)
 	}()
 
-	return processLinks(torrentsChan, SortShows)
+	context := &ScoreContext{}
+	if len(show.EpisodeRunTime) > 0 {
+		context.Runtime = show.EpisodeRunTime[len(show.EpisodeRunTime)-1]
+	}
+
+	return processLinks(torrentsChan, SortShows, context)
 }
 
-func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittorrent.Torrent {
+func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int, context *ScoreContext) []*bittorrent.Torrent {
+	defer linksSearchSeconds.Since(time.Now())
+
 	trackers := map[string]*bittorrent.Tracker{}
 	torrentsMap := map[string]*bittorrent.Torrent{}
 
@@ -138,6 +168,7 @@ func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittor
 			defer wg.Done()
 			if err := torrent.Resolve(); err != nil {
 				log.Warningf("Resolve failed for %s : %s", torrent.URI, err.Error())
+				linksResolveFailures.Inc()
 			}
 			if !strings.HasPrefix(torrent.URI, "magnet") {
 				progress += 1
@@ -183,10 +214,20 @@ func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittor
 		}
 
 		if existingTorrent, exists := torrentsMap[torrentKey]; exists {
-			existingTorrent.Trackers = append(existingTorrent.Trackers, torrent.Trackers...)
-			existingTorrent.Provider += ", " + torrent.Provider
+			for _, tracker := range torrent.Trackers {
+				if !containsString(existingTorrent.Trackers, tracker) {
+					existingTorrent.Trackers = append(existingTorrent.Trackers, tracker)
+				}
+			}
+			// Searching several titles often returns the same link twice
+			// from a single provider
+			if !containsString(strings.Split(existingTorrent.Provider, ", "), torrent.Provider) {
+				existingTorrent.Provider += ", " + torrent.Provider
+				existingTorrent.Multi = true
+			}
 			if torrent.Resolution > existingTorrent.Resolution {
 				existingTorrent.Name = torrent.Name
+				existingTorrent.Release = torrent.Release
 				existingTorrent.Resolution = torrent.Resolution
 			}
 			if torrent.VideoCodec > existingTorrent.VideoCodec {
@@ -201,7 +242,6 @@ func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittor
 			if torrent.SceneRating > existingTorrent.SceneRating {
 				existingTorrent.SceneRating = torrent.SceneRating
 			}
-			existingTorrent.Multi = true
 		} else {
 			torrentsMap[torrentKey] = torrent
 		}
@@ -382,6 +422,11 @@ func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittor
 		}
 	}
 
+	for _, torrent := range torrents {
+		torrent.Runtime = context.Runtime
+	}
+	torrents = ApplyRules(torrents, context)
+
 	log.Info("Sorted torrent candidates.")
 	// for _, torrent := range torrents {
 	// 	log.Infof("S:%d P:%d %s - %s - %s", torrent.Seeds, torrent.Peers, torrent.Name, torrent.Provider, torrent.URI)
@@ -389,3 +434,12 @@ func processLinks(torrentsChan chan *bittorrent.Torrent, sortType int) []*bittor
 
 	return torrents
 }
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
@@ -18,6 +18,12 @@ func (a ByResolution) Len() int           { return len(a) }
 func (a ByResolution) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByResolution) Less(i, j int) bool { return a[i].Resolution < a[j].Resolution }
 
+type ByScore []*bittorrent.Torrent
+
+func (a ByScore) Len() int           { return len(a) }
+func (a ByScore) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByScore) Less(i, j int) bool { return a[i].Score < a[j].Score }
+
 type ByQuality []*bittorrent.Torrent
 func (a ByQuality) Len() int           { return len(a) }
 func (a ByQuality) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"strings"
+
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/tmdb"
+)
+
+// Bounds how many times each provider gets queried for a single media
+const maxSearchTitles = 4
+
+// Languages whose main country code isn't simply the upper cased language
+var languageRegions = map[string]string{
+	"en": "US",
+	"ja": "JP",
+	"ko": "KR",
+	"zh": "CN",
+	"cs": "CZ",
+	"da": "DK",
+	"el": "GR",
+	"he": "IL",
+	"sv": "SE",
+	"uk": "UA",
+	"pt": "BR",
+}
+
+// searchTitles returns the distinct normalized forms of a title to search
+// for: the original one first, then the localized one, then alternative
+// titles from the user's country and from English speaking countries, as
+// most release names use those.
+func searchTitles(original string, localized string, alternatives []*tmdb.AlternativeTitle) []string {
+	titles := make([]string, 0, maxSearchTitles)
+	seen := map[string]bool{}
+	add := func(title string) {
+		title = NormalizeTitle(title)
+		if title == "" || seen[title] || len(titles) >= maxSearchTitles {
+			return
+		}
+		seen[title] = true
+		titles = append(titles, title)
+	}
+
+	add(original)
+	add(localized)
+
+	language := config.Get().Language
+	region, ok := languageRegions[language]
+	if !ok {
+		region = strings.ToUpper(language)
+	}
+	for _, country := range []string{region, "US", "GB"} {
+		for _, alternative := range alternatives {
+			if alternative.ISO_3166_1 == country {
+				add(alternative.Title)
+			}
+		}
+	}
+
+	return titles
+}
+
+func MovieTitles(movie *tmdb.Movie) []string {
+	var alternatives []*tmdb.AlternativeTitle
+	if movie.AlternativeTitles != nil {
+		alternatives = movie.AlternativeTitles.Titles
+	}
+	return searchTitles(movie.OriginalTitle, movie.Title, alternatives)
+}
+
+func ShowTitles(show *tmdb.Show) []string {
+	var alternatives []*tmdb.AlternativeTitle
+	if show.AlternativeTitles != nil {
+		alternatives = show.AlternativeTitles.Titles
+	}
+	return searchTitles(show.OriginalName, show.Name, alternatives)
+}
@@ -118,9 +118,21 @@ func NewAddonSearcher(addonId string) *AddonSearcher {
 	}
 }
 
-func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObject {
+func alternativeTitles(titles []*tmdb.AlternativeTitle) map[string]string {
+	alternatives := make(map[string]string)
+	for _, title := range titles {
+		alternatives[strings.ToLower(title.ISO_3166_1)] = NormalizeTitle(title.Title)
+	}
+	return alternatives
+}
+
+// GetMovieSearchObject builds the search object of a movie for the given
+// title, which defaults to the original one when empty.
+func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie, title string) *MovieSearchObject {
 	year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
-	title := movie.OriginalTitle
+	if title == "" {
+		title = movie.OriginalTitle
+	}
 	if title == "" {
 		title = movie.Title
 	}
@@ -130,33 +142,43 @@ func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObj
 		Year:   year,
 		Titles: make(map[string]string),
 	}
-	for _, title := range movie.AlternativeTitles.Titles {
-		sObject.Titles[strings.ToLower(title.ISO_3166_1)] = NormalizeTitle(title.Title)
+	if movie.AlternativeTitles != nil {
+		sObject.Titles = alternativeTitles(movie.AlternativeTitles.Titles)
 	}
 	return sObject
 }
 
-func (as *AddonSearcher) GetSeasonSearchObject(show *tmdb.Show, season *tmdb.Season) *SeasonSearchObject {
+func (as *AddonSearcher) GetSeasonSearchObject(show *tmdb.Show, season *tmdb.Season, title string) *SeasonSearchObject {
 	year, _ := strconv.Atoi(strings.Split(season.AirDate, "-")[0])
-	title := show.OriginalName
+	if title == "" {
+		title = show.OriginalName
+	}
 	if title == "" {
 		title = show.Name
 	}
 
-	return &SeasonSearchObject{
+	sObject := &SeasonSearchObject{
 		IMDBId:         show.ExternalIDs.IMDBId,
 		TVDBId:         util.StrInterfaceToInt(show.ExternalIDs.TVDBID),
 		Title:          NormalizeTitle(title),
 		Year:           year,
 		Season:         season.Season,
+		Titles: make(map[string]string),
 	}
+	if show.AlternativeTitles != nil {
+		sObject.Titles = alternativeTitles(show.AlternativeTitles.Titles)
+	}
+	return sObject
 }
 
-func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.Episode) *EpisodeSearchObject {
+func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.Episode, title string) *EpisodeSearchObject {
 	year, _ := strconv.Atoi(strings.Split(episode.AirDate, "-")[0])
-	title := show.OriginalName
+	originalTitle := show.OriginalName
+	if originalTitle == "" {
+		originalTitle = show.Name
+	}
 	if title == "" {
-		title = show.Name
+		title = originalTitle
 	}
 
 	tvdbId := util.StrInterfaceToInt(show.ExternalIDs.TVDBID)
@@ -187,21 +209,30 @@ func (as *AddonSearcher) GetEpisodeSearchObject(show *tmdb.Show, episode *tmdb.E
 					if tvdbEpisode.AbsoluteNumber > 0 {
 						absoluteNumber = tvdbEpisode.AbsoluteNumber
 					}
-					title = tvdbShow.SeriesName
+					// TVDB's name is the one anime releases use, keep other
+					// variants as they are.
+					if NormalizeTitle(title) == NormalizeTitle(originalTitle) {
+						title = tvdbShow.SeriesName
+					}
 				}
 			}
 		}
 	}
 
-	return &EpisodeSearchObject{
+	sObject := &EpisodeSearchObject{
 		IMDBId:         show.ExternalIDs.IMDBId,
 		TVDBId:         tvdbId,
 		Title:          NormalizeTitle(title),
 		Season:         episode.SeasonNumber,
 		Episode:        episode.EpisodeNumber,
 		Year:           year,
+		Titles:         make(map[string]string),
 		AbsoluteNumber: absoluteNumber,
 	}
+	if show.AlternativeTitles != nil {
+		sObject.Titles = alternativeTitles(show.AlternativeTitles.Titles)
+	}
+	return sObject
 }
 
 func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittorrent.Torrent {
@@ -215,6 +246,7 @@ func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittor
 		SearchObject: searchObject,
 	}
 
+	start := time.Now()
 	xbmc.ExecuteAddon(as.addonId, payload.String())
 
 	timeout := providerTimeout()
@@ -226,8 +258,13 @@ func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittor
 	case <-time.After(timeout):
 		as.log.Warningf("Provider %s was too slow. Ignored.", as.addonId)
 		RemoveCallback(cid)
+		providerFailures.Inc(as.addonId, "timeout")
 	case result := <-c:
-		json.Unmarshal(result, &torrents)
+		providerSearchSeconds.Since(start, as.addonId, method)
+		if err := json.Unmarshal(result, &torrents); err != nil {
+			as.log.Warningf("Provider %s answered with invalid results: %s", as.addonId, err)
+			providerFailures.Inc(as.addonId, "invalid")
+		}
 	}
 
 	return torrents
@@ -237,14 +274,14 @@ func (as *AddonSearcher) SearchLinks(query string) []*bittorrent.Torrent {
 	return as.call("search", query)
 }
 
-func (as *AddonSearcher) SearchMovieLinks(movie *tmdb.Movie) []*bittorrent.Torrent {
-	return as.call("search_movie", as.GetMovieSearchObject(movie))
+func (as *AddonSearcher) SearchMovieLinks(movie *tmdb.Movie, title string) []*bittorrent.Torrent {
+	return as.call("search_movie", as.GetMovieSearchObject(movie, title))
 }
 
-func (as *AddonSearcher) SearchSeasonLinks(show *tmdb.Show, season *tmdb.Season) []*bittorrent.Torrent {
-	return as.call("search_season", as.GetSeasonSearchObject(show, season))
+func (as *AddonSearcher) SearchSeasonLinks(show *tmdb.Show, season *tmdb.Season, title string) []*bittorrent.Torrent {
+	return as.call("search_season", as.GetSeasonSearchObject(show, season, title))
 }
 
-func (as *AddonSearcher) SearchEpisodeLinks(show *tmdb.Show, episode *tmdb.Episode) []*bittorrent.Torrent {
-	return as.call("search_episode", as.GetEpisodeSearchObject(show, episode))
+func (as *AddonSearcher) SearchEpisodeLinks(show *tmdb.Show, episode *tmdb.Episode, title string) []*bittorrent.Torrent {
+	return as.call("search_episode", as.GetEpisodeSearchObject(show, episode, title))
 }
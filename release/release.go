@@ -0,0 +1,492 @@
+// Package release parses scene and P2P release names, i.e.
+// "Movie.Title.2019.2160p.UHD.BluRay.REMUX.HDR.HEVC.Atmos-GROUP", into
+// structured metadata.
+package release
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Confidence levels for detected fields. Explicit tags like "1080p" are
+// certain, fields inferred from other tags (i.e. resolution from "BluRay")
+// are only a guess.
+const (
+	ConfidenceNone     = 0.0
+	ConfidenceInferred = 0.3
+	ConfidenceLikely   = 0.7
+	ConfidenceExplicit = 1.0
+)
+
+type Info struct {
+	Name string `json:"name"`
+
+	Title   string `json:"title"`
+	Year    int    `json:"year,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+
+	Resolution    string   `json:"resolution,omitempty"`
+	Source        string   `json:"source,omitempty"`
+	VideoCodec    string   `json:"video_codec,omitempty"`
+	BitDepth      int      `json:"bit_depth,omitempty"`
+	HDR           []string `json:"hdr,omitempty"`
+	AudioCodec    string   `json:"audio_codec,omitempty"`
+	AudioChannels string   `json:"audio_channels,omitempty"`
+	Atmos         bool     `json:"atmos,omitempty"`
+	Remux         bool     `json:"remux,omitempty"`
+
+	Edition   string   `json:"edition,omitempty"`
+	Repack    bool     `json:"repack,omitempty"`
+	Proper    bool     `json:"proper,omitempty"`
+	Nuked     bool     `json:"nuked,omitempty"`
+	Group     string   `json:"group,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	Subtitles []string `json:"subtitles,omitempty"`
+
+	// Confidence of each detected field, keyed by its json name.
+	Confidence map[string]float64 `json:"confidence"`
+}
+
+type tag struct {
+	re    *regexp.Regexp
+	value string
+}
+
+// Wraps a pattern so it only matches whole tokens, release names using
+// dots, dashes, underscores, spaces and brackets as separators.
+func token(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:^|[^a-z0-9])(?:` + pattern + `)(?:[^a-z0-9]|$)`)
+}
+
+// Tags are ordered, the first match wins.
+var (
+	resolutionTags = []tag{
+		{token(`2160p|4k|uhd`), "2160p"},
+		{token(`1440p`), "1440p"},
+		{token(`1080[pi]|fullhd|fhd`), "1080p"},
+		{token(`720p`), "720p"},
+		{token(`576[pi]`), "576p"},
+		{token(`480p`), "480p"},
+	}
+	sourceTags = []tag{
+		{token(`(?:hd)?cam(?:rip)?`), "CAM"},
+		{token(`(?:hd)?ts|telesync|pdvd`), "TS"},
+		{token(`tc|telecine`), "TC"},
+		{token(`dvd[ .-]?scr|dvdscreener`), "DVDSCR"},
+		{token(`scr|screener|bdscr`), "SCR"},
+		{token(`uhd[ .-]?blu[ .-]?ray|blu[ .-]?ray|bd(?:rip|remux)?|br[ .-]?rip|bd25|bd50`), "BluRay"},
+		{token(`web[ .-]?dl|web[ .-]?rip|web|amzn|nf|dsnp|hmax|atvp`), "WEB"},
+		{token(`hdtv|pdtv|sdtv|dsr|tvrip`), "HDTV"},
+		{token(`dvd[ .-]?rip|dvd(?:r|5|9)?`), "DVD"},
+		{token(`hd[ .-]?rip`), "HDRip"},
+	}
+	videoCodecTags = []tag{
+		{token(`av1`), "AV1"},
+		{token(`[xh][ .]?265|hevc`), "H.265"},
+		{token(`[xh][ .]?264|avc`), "H.264"},
+		{token(`vp9`), "VP9"},
+		{token(`xvid|divx`), "XviD"},
+		{token(`mpeg[ .-]?2`), "MPEG-2"},
+	}
+	audioCodecTags = []tag{
+		{token(`truehd`), "TrueHD"},
+		{token(`dts[ .-]?x`), "DTS:X"},
+		{token(`dts[ .-]?hd[ .-]?ma(?:[ .]?[257][ .][01])?`), "DTS-HD MA"},
+		{token(`dts[ .-]?hd(?:[ .]?[257][ .][01])?`), "DTS-HD"},
+		{token(`dts(?:[ .]?[257][ .][01])?`), "DTS"},
+		{token(`e[ .-]?ac[ .-]?3|ddp(?:[ .]?[257][ .][01])?|dd\+(?:[ .]?[257][ .][01])?`), "E-AC3"},
+		{token(`ac[ .-]?3|dd(?:[ .]?[257][ .][01])?|dolby digital`), "AC3"},
+		{token(`l?pcm`), "PCM"},
+		{token(`flac`), "FLAC"},
+		{token(`opus`), "Opus"},
+		{token(`aac(?:[ .]?[257][ .][01])?`), "AAC"},
+		{token(`mp3`), "MP3"},
+	}
+	hdrTags = []tag{
+		{token(`hdr10(?:\+|plus)`), "HDR10+"},
+		{token(`hdr(?:10)?`), "HDR10"},
+		{token(`dv|dovi|dolby[ .-]?vision`), "DV"},
+		{token(`hlg`), "HLG"},
+	}
+	editionTags = []tag{
+		{token(`director'?s[ .-]?cut|dc`), "Director's Cut"},
+		{token(`extended(?:[ .-]?(?:cut|edition))?`), "Extended"},
+		{token(`theatrical(?:[ .-]?cut)?`), "Theatrical"},
+		{token(`unrated`), "Unrated"},
+		{token(`uncut`), "Uncut"},
+		{token(`final[ .-]?cut`), "Final Cut"},
+		{token(`ultimate[ .-]?(?:cut|edition)`), "Ultimate"},
+		{token(`special[ .-]?edition`), "Special Edition"},
+		{token(`criterion(?:[ .-]?collection)?`), "Criterion"},
+		{token(`remastered`), "Remastered"},
+		{token(`imax`), "IMAX"},
+	}
+	languageTags = []tag{
+		{token(`multi`), "multi"},
+		{token(`truefrench|french|vff|vfq|vf2?|fr`), "fr"},
+		{token(`german|ger|deutsch`), "de"},
+		{token(`ita(?:lian)?`), "it"},
+		{token(`spanish|spa|castellano|esp|latino`), "es"},
+		{token(`portuguese|por|dublado`), "pt"},
+		{token(`russian|rus`), "ru"},
+		{token(`japanese|jap|jpn`), "ja"},
+		{token(`korean|kor`), "ko"},
+		{token(`chinese|chi|mandarin|cantonese`), "zh"},
+		{token(`hindi|hin`), "hi"},
+		{token(`dutch|nl`), "nl"},
+		{token(`polish|pl`), "pl"},
+		{token(`swedish|swe`), "sv"},
+		{token(`turkish|tur`), "tr"},
+	}
+	subtitleTags = []tag{
+		{token(`vostfr|stfr`), "fr"},
+		{token(`nl[ .-]?subs?`), "nl"},
+		{token(`e[ .-]?subs?|eng[ .-]?subs?`), "en"},
+		{token(`multi(?:ple)?[ .-]?sub(?:s|titles?)?`), "multi"},
+		{token(`hard[ .-]?subs?|hc`), "hardcoded"},
+	}
+	// Subtitles of languages not told
+	subtitledTag = token(`subs?|subbed|subtitles?`)
+
+	atmosTag  = token(`atmos`)
+	remuxTag  = token(`remux|bdremux`)
+	repackTag = token(`repack|rerip`)
+	properTag = token(`proper`)
+	nukedTag  = token(`nuked`)
+	dualTag   = token(`dual[ .-]?audio`)
+
+	channelsRe   = regexp.MustCompile(`(?:^|[^0-9])([1-9])[ .]([01])(?:ch)?(?:[^0-9]|$)`)
+	bitDepthRe   = regexp.MustCompile(`(?:^|[^a-z0-9])(?:(8|10|12)[ .-]?bits?|hi(10)p)(?:[^a-z0-9]|$)`)
+	yearRe       = regexp.MustCompile(`(?:^|[^a-z0-9])[\[(]?((?:19|20)\d\d)[\])]?(?:[^a-z0-9]|$)`)
+	episodeRe    = regexp.MustCompile(`(?:^|[^a-z0-9])s(\d{1,2})[ .-]?e(\d{1,3})`)
+	animeEpRe    = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:\s|$)`)
+	crossEpRe    = regexp.MustCompile(`(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^a-z0-9]|$)`)
+	seasonRe     = regexp.MustCompile(`(?:^|[^a-z0-9])(?:s(\d{1,2})|season[ .-]?(\d{1,2}))(?:[^a-z0-9]|$)`)
+	groupRe      = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\[[^\]]*\])?$`)
+	leadGroupRe  = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+	separatorsRe = regexp.MustCompile(`[._]+`)
+	spacesRe     = regexp.MustCompile(`\s+`)
+
+	// Inferred resolutions when the release name has none, as was done
+	// before by the bittorrent package's tag matching.
+	inferredResolutions = map[string]string{
+		"BluRay": "720p",
+		"HDRip":  "720p",
+		"DVD":    "480p",
+		"HDTV":   "480p",
+	}
+
+	videoExtensions = map[string]bool{
+		".mkv": true, ".mp4": true, ".avi": true, ".m4v": true, ".ts": true, ".wmv": true, ".mov": true,
+	}
+)
+
+// Parse extracts everything it can from a release name.
+func Parse(name string) *Info {
+	info := &Info{
+		Name:       name,
+		Confidence: map[string]float64{},
+	}
+
+	name = strings.TrimSpace(name)
+	if ext := strings.ToLower(path.Ext(name)); videoExtensions[ext] {
+		name = name[:len(name)-len(ext)]
+	}
+
+	// Anime releases lead with the group
+	if match := leadGroupRe.FindStringSubmatch(name); match != nil {
+		info.Group = match[1]
+		info.Confidence["group"] = ConfidenceLikely
+		name = name[len(match[0]):]
+	}
+
+	lower := strings.ToLower(name)
+
+	// The title ends where the first recognizable tag starts
+	titleEnd := len(lower)
+	found := func(re *regexp.Regexp) []int {
+		loc := re.FindStringSubmatchIndex(lower)
+		if loc != nil && loc[0] > 0 && loc[0] < titleEnd {
+			titleEnd = loc[0]
+		}
+		return loc
+	}
+	match := func(tags []tag) (string, bool) {
+		for _, t := range tags {
+			if found(t.re) != nil {
+				return t.value, true
+			}
+		}
+		return "", false
+	}
+	if loc := found(episodeRe); loc != nil {
+		info.Season, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+		info.Episode, _ = strconv.Atoi(lower[loc[4]:loc[5]])
+		info.Confidence["season"] = ConfidenceExplicit
+		info.Confidence["episode"] = ConfidenceExplicit
+	} else if loc := found(crossEpRe); loc != nil {
+		info.Season, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+		info.Episode, _ = strconv.Atoi(lower[loc[4]:loc[5]])
+		info.Confidence["season"] = ConfidenceLikely
+		info.Confidence["episode"] = ConfidenceLikely
+	} else if loc := found(animeEpRe); loc != nil && info.Group != "" {
+		// Anime only numbers episodes, i.e. "[Group] Title - 25 [1080p]"
+		info.Episode, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+		info.Confidence["episode"] = ConfidenceLikely
+	} else if loc := found(seasonRe); loc != nil {
+		if loc[2] >= 0 {
+			info.Season, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+		} else {
+			info.Season, _ = strconv.Atoi(lower[loc[4]:loc[5]])
+		}
+		info.Confidence["season"] = ConfidenceLikely
+	}
+
+	// The last year is the release year, titles can start with or contain
+	// one, i.e. "2001 A Space Odyssey 1968" or "Blade Runner 2049 2017".
+	var years [][]int
+	for offset := 0; offset < len(lower); {
+		loc := yearRe.FindStringSubmatchIndex(lower[offset:])
+		if loc == nil {
+			break
+		}
+		for i := range loc {
+			loc[i] += offset
+		}
+		years = append(years, loc)
+		offset = loc[3]
+	}
+	if len(years) > 0 {
+		loc := years[len(years)-1]
+		if loc[0] > 0 || loc[2] > 0 {
+			info.Year, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+			info.Confidence["year"] = ConfidenceLikely
+			if loc[0] < titleEnd {
+				titleEnd = loc[0]
+			}
+		}
+	}
+	// Where the title surely ends, if there's a year or an episode
+	anchor := titleEnd
+
+	if value, ok := match(resolutionTags); ok {
+		info.Resolution = value
+		info.Confidence["resolution"] = ConfidenceExplicit
+	}
+	// Groups can be named like sources, i.e. "-TS"
+	sourceIn := lower
+	if loc := groupRe.FindStringIndex(name); loc != nil && loc[0] > 0 && (loc[0] > anchor || anchor == len(lower)) {
+		sourceIn = lower[:loc[0]]
+	}
+	sourceAt := -1
+	if value, at, ok := matchSource(sourceIn, anchor); ok {
+		info.Source = value
+		sourceAt = at
+		info.Confidence["source"] = ConfidenceExplicit
+		if value == "TS" || value == "TC" {
+			// Two letter tags are easily mistaken for something else
+			info.Confidence["source"] = ConfidenceLikely
+		}
+	}
+	if sourceAt > 0 && sourceAt < titleEnd {
+		titleEnd = sourceAt
+	}
+	if info.Resolution == "" {
+		if value, ok := inferredResolutions[info.Source]; ok {
+			info.Resolution = value
+			info.Confidence["resolution"] = ConfidenceInferred
+		}
+	}
+	if value, ok := match(videoCodecTags); ok {
+		info.VideoCodec = value
+		info.Confidence["video_codec"] = ConfidenceExplicit
+		if value == "XviD" && info.Resolution == "" {
+			info.Resolution = "480p"
+			info.Confidence["resolution"] = ConfidenceInferred
+		}
+	}
+	if value, ok := match(audioCodecTags); ok {
+		info.AudioCodec = value
+		info.Confidence["audio_codec"] = ConfidenceExplicit
+	}
+	if loc := found(channelsRe); loc != nil {
+		info.AudioChannels = lower[loc[2]:loc[3]] + "." + lower[loc[4]:loc[5]]
+		info.Confidence["audio_channels"] = ConfidenceLikely
+	}
+	if found(atmosTag) != nil {
+		info.Atmos = true
+		info.Confidence["atmos"] = ConfidenceExplicit
+		if info.AudioCodec == "" {
+			info.AudioCodec = "TrueHD"
+			info.Confidence["audio_codec"] = ConfidenceInferred
+		}
+	}
+	if loc := found(bitDepthRe); loc != nil {
+		if loc[2] >= 0 {
+			info.BitDepth, _ = strconv.Atoi(lower[loc[2]:loc[3]])
+		} else {
+			info.BitDepth = 10
+		}
+		info.Confidence["bit_depth"] = ConfidenceExplicit
+	}
+	// Others end with it, but only trust it past the title or we'd
+	// take "Man" as the group of "Spider-Man".
+	tags := lower[titleEnd:]
+	if loc := groupRe.FindStringSubmatchIndex(name); loc != nil && loc[0] > titleEnd && info.Group == "" {
+		info.Group = name[loc[2]:loc[3]]
+		info.Confidence["group"] = ConfidenceLikely
+		tags = lower[titleEnd:loc[0]]
+	}
+
+	// Everything else is short or ambiguous enough to also appear in titles,
+	// i.e. "Uncut Gems" or "DC's Legends of Tomorrow", only look past it.
+	matchTags := func(tags string, list []tag) []string {
+		values := make([]string, 0)
+		for _, t := range list {
+			if t.re.MatchString(tags) && !contains(values, t.value) {
+				values = append(values, t.value)
+			}
+		}
+		return values
+	}
+
+	if info.HDR = matchTags(tags, hdrTags); len(info.HDR) > 0 {
+		info.Confidence["hdr"] = ConfidenceExplicit
+		if contains(info.HDR, "DV") && len(info.HDR) == 1 {
+			// "DV" alone is also used for DVD/DV captures
+			info.Confidence["hdr"] = ConfidenceLikely
+		}
+		if info.BitDepth == 0 {
+			info.BitDepth = 10
+			info.Confidence["bit_depth"] = ConfidenceInferred
+		}
+	}
+	if remuxTag.MatchString(tags) {
+		info.Remux = true
+		info.Confidence["remux"] = ConfidenceExplicit
+	}
+	if editions := matchTags(tags, editionTags); len(editions) > 0 {
+		info.Edition = editions[0]
+		info.Confidence["edition"] = ConfidenceExplicit
+		if info.Edition == "Director's Cut" && !strings.Contains(tags, "cut") {
+			info.Confidence["edition"] = ConfidenceInferred
+		}
+	}
+	if repackTag.MatchString(tags) {
+		info.Repack = true
+		info.Confidence["repack"] = ConfidenceExplicit
+	}
+	if properTag.MatchString(tags) {
+		info.Proper = true
+		info.Confidence["proper"] = ConfidenceExplicit
+	}
+	if nukedTag.MatchString(tags) {
+		info.Nuked = true
+		info.Confidence["nuked"] = ConfidenceExplicit
+	}
+
+	info.Languages = matchTags(tags, languageTags)
+	if dualTag.MatchString(tags) && !contains(info.Languages, "multi") {
+		info.Languages = append(info.Languages, "multi")
+	}
+	if len(info.Languages) > 0 {
+		info.Confidence["languages"] = ConfidenceLikely
+	}
+	info.Subtitles = matchTags(tags, subtitleTags)
+	if len(info.Subtitles) == 0 && subtitledTag.MatchString(tags) {
+		info.Subtitles = append(info.Subtitles, "unknown")
+	}
+	if len(info.Subtitles) > 0 {
+		info.Confidence["subtitles"] = ConfidenceLikely
+	}
+
+	title := separatorsRe.ReplaceAllString(name[:titleEnd], " ")
+	title = strings.Trim(spacesRe.ReplaceAllString(title, " "), " -[](")
+	info.Title = title
+	if title != "" {
+		info.Confidence["title"] = ConfidenceLikely
+		if titleEnd == len(lower) {
+			// Nothing recognizable at all, the whole name is the title
+			info.Confidence["title"] = ConfidenceInferred
+		}
+	}
+
+	return info
+}
+
+// matchSource finds the source of a release. Sources are also words of
+// titles, i.e. "Cam Girl" or "The Screener", so only those past the year or
+// episode count, the first tag winning, or else the last one in the name.
+func matchSource(lower string, anchor int) (string, int, bool) {
+	if anchor < len(lower) {
+		for _, t := range sourceTags {
+			if loc := t.re.FindStringIndex(lower[anchor:]); loc != nil {
+				return t.value, anchor + loc[0], true
+			}
+		}
+		return "", -1, false
+	}
+	value, at := "", -1
+	for _, t := range sourceTags {
+		locs := t.re.FindAllStringIndex(lower, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		// The title's first word isn't a source
+		if loc := locs[len(locs)-1]; loc[0] > 0 && loc[0] > at {
+			value, at = t.value, loc[0]
+		}
+	}
+	return value, at, at > 0
+}
+
+// Tags returns the notable extras of a release, the ones not already
+// covered by resolution, source and codecs, for display.
+func (info *Info) Tags() []string {
+	tags := make([]string, 0)
+	if info.Remux {
+		tags = append(tags, "REMUX")
+	}
+	tags = append(tags, info.HDR...)
+	if info.BitDepth > 8 {
+		tags = append(tags, strconv.Itoa(info.BitDepth)+"bit")
+	}
+	if info.Atmos {
+		tags = append(tags, "Atmos")
+	}
+	if info.AudioChannels != "" {
+		tags = append(tags, info.AudioChannels)
+	}
+	if info.Edition != "" {
+		tags = append(tags, info.Edition)
+	}
+	if info.Repack {
+		tags = append(tags, "REPACK")
+	}
+	if info.Proper {
+		tags = append(tags, "PROPER")
+	}
+	return tags
+}
+
+// Channels returns the audio channel count, i.e. 6 for "5.1".
+func (info *Info) Channels() int {
+	if len(info.AudioChannels) != 3 {
+		return 0
+	}
+	main, _ := strconv.Atoi(info.AudioChannels[:1])
+	lfe, _ := strconv.Atoi(info.AudioChannels[2:])
+	return main + lfe
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
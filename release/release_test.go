@@ -0,0 +1,246 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+type parseCase struct {
+	name       string
+	source     string
+	resolution string
+	codec      string
+	hdr        []string
+	group      string
+	bitDepth   int
+	// Confidence of source, resolution, video_codec, hdr, group and
+	// bit_depth, the ones left out being none.
+	confidence map[string]float64
+	details    parseDetails
+}
+
+// parseDetails are the other fields, the ones left out being empty.
+type parseDetails struct {
+	year      int
+	season    int
+	episode   int
+	audio     string
+	channels  string
+	atmos     bool
+	edition   string
+	repack    bool
+	proper    bool
+	languages []string
+	subtitles []string
+}
+
+var confidenceFields = []string{"source", "resolution", "video_codec", "hdr", "group", "bit_depth"}
+
+var parseCases = []parseCase{
+	// Movies
+	{"The.Matrix.1999.2160p.UHD.BluRay.REMUX.HDR.HEVC.Atmos-EPSiLON", "BluRay", "2160p", "H.265", []string{"HDR10"}, "EPSiLON", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 0.3},
+		parseDetails{year: 1999, audio: "TrueHD", atmos: true}},
+	{"Blade.Runner.2049.2017.1080p.BluRay.x264-SPARKS", "BluRay", "1080p", "H.264", nil, "SPARKS", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2017}},
+	{"2001.A.Space.Odyssey.1968.1080p.BluRay.x264-AMIABLE", "BluRay", "1080p", "H.264", nil, "AMIABLE", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 1968}},
+	{"Spider-Man.No.Way.Home.2021.2160p.WEB-DL.DDP5.1.Atmos.DV.HDR10.HEVC-CMRG", "WEB", "2160p", "H.265", []string{"HDR10", "DV"}, "CMRG", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 0.3},
+		parseDetails{year: 2021, audio: "E-AC3", channels: "5.1", atmos: true}},
+	{"Dune.Part.Two.2024.2160p.AMZN.WEB-DL.DDP5.1.Atmos.HDR10Plus.H.265-FLUX", "WEB", "2160p", "H.265", []string{"HDR10+"}, "FLUX", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 0.3},
+		parseDetails{year: 2024, audio: "E-AC3", channels: "5.1", atmos: true}},
+	{"Oppenheimer.2023.1080p.WEBRip.x265.10bit.AAC5.1-RARBG", "WEB", "1080p", "H.265", nil, "RARBG", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7, "bit_depth": 1},
+		parseDetails{year: 2023, audio: "AAC", channels: "5.1"}},
+	{"Interstellar.2014.IMAX.1080p.BluRay.DTS-HD.MA.5.1.x264-FGT", "BluRay", "1080p", "H.264", nil, "FGT", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2014, audio: "DTS-HD MA", channels: "5.1", edition: "IMAX"}},
+	{"Apocalypse.Now.1979.Final.Cut.2160p.UHD.BluRay.x265.10bit.HDR.DTS-HD.MA.5.1-SWTYST", "BluRay", "2160p", "H.265", []string{"HDR10"}, "SWTYST", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 1},
+		parseDetails{year: 1979, audio: "DTS-HD MA", channels: "5.1", edition: "Final Cut"}},
+	{"Tenet.2020.2160p.UHD.BluRay.x265.10bit.HDR.TrueHD.7.1.Atmos-RARBG", "BluRay", "2160p", "H.265", []string{"HDR10"}, "RARBG", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 1},
+		parseDetails{year: 2020, audio: "TrueHD", channels: "7.1", atmos: true}},
+	{"Mad.Max.Fury.Road.2015.1080p.BluRay.AVC.TrueHD.7.1.Atmos-FGT", "BluRay", "1080p", "H.264", nil, "FGT", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2015, audio: "TrueHD", channels: "7.1", atmos: true}},
+	{"Arrival.2016.REPACK.1080p.BluRay.x265.HEVC.10bit.AAC.7.1-Tigole", "BluRay", "1080p", "H.265", nil, "Tigole", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7, "bit_depth": 1},
+		parseDetails{year: 2016, audio: "AAC", channels: "7.1", repack: true}},
+	{"Pulp.Fiction.1994.720p.BRRip.x264.AC3-JYK", "BluRay", "720p", "H.264", nil, "JYK", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 1994, audio: "AC3"}},
+	{"The.Lord.of.the.Rings.The.Fellowship.of.the.Ring.2001.EXTENDED.1080p.BluRay.x264-SiNNERS", "BluRay", "1080p", "H.264", nil, "SiNNERS", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2001, edition: "Extended"}},
+	{"Alien.1979.Directors.Cut.1080p.BluRay.x264.DTS-FGT", "BluRay", "1080p", "H.264", nil, "FGT", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 1979, audio: "DTS", edition: "Director's Cut"}},
+	{"Amelie.2001.FRENCH.1080p.BluRay.x264-LOST", "BluRay", "1080p", "H.264", nil, "LOST", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2001, languages: []string{"fr"}}},
+	{"Parasite.2019.KOREAN.1080p.BluRay.H264.AAC-VXT", "BluRay", "1080p", "H.264", nil, "VXT", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019, audio: "AAC", languages: []string{"ko"}}},
+	{"Some.Movie.2020.1080p.BluRay.x264.10bit.Hi10P-GRP", "BluRay", "1080p", "H.264", nil, "GRP", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7, "bit_depth": 1},
+		parseDetails{year: 2020}},
+
+	// Early releases
+	{"Avengers.Endgame.2019.HDCAM.x264-ETRG", "CAM", "", "H.264", nil, "ETRG", 0,
+		map[string]float64{"source": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019}},
+	{"Joker.2019.HDTS.x264-NoGRP", "TS", "", "H.264", nil, "NoGRP", 0,
+		map[string]float64{"source": 0.7, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019}},
+	{"The.Screener.2019.DVDScr.XviD-EVO", "DVDSCR", "480p", "XviD", nil, "EVO", 0,
+		map[string]float64{"source": 1, "resolution": 0.3, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019}},
+
+	// Sources in titles or groups
+	{"Cam.Girl.2021.1080p.WEB-DL.DD5.1.H.264-GROUP", "WEB", "1080p", "H.264", nil, "GROUP", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2021, audio: "AC3", channels: "5.1"}},
+	{"Cam.2018.1080p.NF.WEB-DL.DDP5.1.x264-NTG", "WEB", "1080p", "H.264", nil, "NTG", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2018, audio: "E-AC3", channels: "5.1"}},
+	{"The.Cam.Girl.720p.WEBRip.x264-GRP", "WEB", "720p", "H.264", nil, "GRP", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{}},
+	{"Uncut.Gems.2019.1080p.NF.WEB-DL.DDP5.1.x264-NTG", "WEB", "1080p", "H.264", nil, "NTG", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019, audio: "E-AC3", channels: "5.1"}},
+	{"Movie.Title.2019.1080p.WEB-DL.DD5.1.H.264-TS", "WEB", "1080p", "H.264", nil, "TS", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2019, audio: "AC3", channels: "5.1"}},
+	{"Movie.Name.DVDRip.XviD-GRP", "DVD", "480p", "XviD", nil, "GRP", 0,
+		map[string]float64{"source": 1, "resolution": 0.3, "video_codec": 1, "group": 0.7},
+		parseDetails{}},
+
+	// Shows
+	{"Game.of.Thrones.S08E06.1080p.WEB.H264-MEMENTO", "WEB", "1080p", "H.264", nil, "MEMENTO", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 8, episode: 6}},
+	{"The.Office.US.S05E14.720p.HDTV.x264-CTU", "HDTV", "720p", "H.264", nil, "CTU", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 5, episode: 14}},
+	{"Breaking.Bad.S05.1080p.BluRay.x264-ROVERS", "BluRay", "1080p", "H.264", nil, "ROVERS", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 5}},
+	{"Friends.1x01.The.One.Where.Monica.Gets.a.Roommate.DVDRip.XviD-SAiNTS", "DVD", "480p", "XviD", nil, "SAiNTS", 0,
+		map[string]float64{"source": 1, "resolution": 0.3, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 1, episode: 1}},
+	{"The.Mandalorian.S02E08.2160p.DSNP.WEB-DL.DDP5.1.Atmos.DV.HEVC-MZABI", "WEB", "2160p", "H.265", []string{"DV"}, "MZABI", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 0.7, "group": 0.7, "bit_depth": 0.3},
+		parseDetails{season: 2, episode: 8, audio: "E-AC3", channels: "5.1", atmos: true}},
+	{"House.of.the.Dragon.S01E01.1080p.HMAX.WEB-DL.DDP5.1.H.264-NTb", "WEB", "1080p", "H.264", nil, "NTb", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 1, episode: 1, audio: "E-AC3", channels: "5.1"}},
+	{"Ted.Lasso.S03E12.2160p.ATVP.WEB-DL.DDP5.1.Atmos.HDR.H.265-FLUX", "WEB", "2160p", "H.265", []string{"HDR10"}, "FLUX", 10,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "hdr": 1, "group": 0.7, "bit_depth": 0.3},
+		parseDetails{season: 3, episode: 12, audio: "E-AC3", channels: "5.1", atmos: true}},
+	{"The.Boys.S04E01.720p.WEB.x265-MiNX", "WEB", "720p", "H.265", nil, "MiNX", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 4, episode: 1}},
+	{"The.Walking.Dead.S10E01.PROPER.720p.HDTV.x264-KILLERS", "HDTV", "720p", "H.264", nil, "KILLERS", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 10, episode: 1, proper: true}},
+	{"Money.Heist.S01E01.MULTi.1080p.NF.WEB-DL.DDP5.1.x264.VOSTFR-GRP", "WEB", "1080p", "H.264", nil, "GRP", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{season: 1, episode: 1, audio: "E-AC3", channels: "5.1", languages: []string{"multi"}, subtitles: []string{"fr"}}},
+	{"Shogun.2024.S01E01.1080p.WEB.H264.ENG.SUBS-GRP", "WEB", "1080p", "H.264", nil, "GRP", 0,
+		map[string]float64{"source": 1, "resolution": 1, "video_codec": 1, "group": 0.7},
+		parseDetails{year: 2024, season: 1, episode: 1, subtitles: []string{"en"}}},
+
+	// Anime
+	{"[SubsPlease] Jujutsu Kaisen - 25 (1080p) [5C9FC2EC].mkv", "", "1080p", "", nil, "SubsPlease", 0,
+		map[string]float64{"resolution": 1, "group": 0.7},
+		parseDetails{episode: 25}},
+	{"[Erai-raws] One Piece - 1085 [720p][Multiple Subtitle].mkv", "", "720p", "", nil, "Erai-raws", 0,
+		map[string]float64{"resolution": 1, "group": 0.7},
+		parseDetails{episode: 1085, subtitles: []string{"multi"}}},
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range parseCases {
+		info := Parse(c.name)
+		if info.Source != c.source {
+			t.Errorf("%s: source %q, want %q", c.name, info.Source, c.source)
+		}
+		if info.Resolution != c.resolution {
+			t.Errorf("%s: resolution %q, want %q", c.name, info.Resolution, c.resolution)
+		}
+		if info.VideoCodec != c.codec {
+			t.Errorf("%s: video codec %q, want %q", c.name, info.VideoCodec, c.codec)
+		}
+		if len(info.HDR) > 0 || len(c.hdr) > 0 {
+			if !reflect.DeepEqual(info.HDR, c.hdr) {
+				t.Errorf("%s: hdr %v, want %v", c.name, info.HDR, c.hdr)
+			}
+		}
+		if info.Group != c.group {
+			t.Errorf("%s: group %q, want %q", c.name, info.Group, c.group)
+		}
+		if info.BitDepth != c.bitDepth {
+			t.Errorf("%s: bit depth %d, want %d", c.name, info.BitDepth, c.bitDepth)
+		}
+		checkDetails(t, info, c.details)
+		for _, field := range confidenceFields {
+			if info.Confidence[field] != c.confidence[field] {
+				t.Errorf("%s: %s confidence %v, want %v", c.name, field, info.Confidence[field], c.confidence[field])
+			}
+		}
+	}
+}
+
+func TestParseTitle(t *testing.T) {
+	cases := map[string]string{
+		"Cam.Girl.2021.1080p.WEB-DL.DD5.1.H.264-GROUP":                             "Cam Girl",
+		"Blade.Runner.2049.2017.1080p.BluRay.x264-SPARKS":                          "Blade Runner 2049",
+		"2001.A.Space.Odyssey.1968.1080p.BluRay.x264-AMIABLE":                      "2001 A Space Odyssey",
+		"Spider-Man.No.Way.Home.2021.2160p.WEB-DL.DDP5.1.Atmos.DV.HDR10.HEVC-CMRG": "Spider-Man No Way Home",
+		"Movie.Name.DVDRip.XviD-GRP":                                               "Movie Name",
+		"[SubsPlease] Jujutsu Kaisen - 25 (1080p) [5C9FC2EC].mkv":                  "Jujutsu Kaisen",
+	}
+	for name, title := range cases {
+		if info := Parse(name); info.Title != title {
+			t.Errorf("%s: title %q, want %q", name, info.Title, title)
+		}
+	}
+}
+
+func sameList(got []string, want []string) bool {
+	return len(got) == 0 && len(want) == 0 || reflect.DeepEqual(got, want)
+}
+
+func checkDetails(t *testing.T, info *Info, want parseDetails) {
+	got := parseDetails{
+		year:      info.Year,
+		season:    info.Season,
+		episode:   info.Episode,
+		audio:     info.AudioCodec,
+		channels:  info.AudioChannels,
+		atmos:     info.Atmos,
+		edition:   info.Edition,
+		repack:    info.Repack,
+		proper:    info.Proper,
+		languages: info.Languages,
+		subtitles: info.Subtitles,
+	}
+	if got.year != want.year || got.season != want.season || got.episode != want.episode {
+		t.Errorf("%s: year %d S%02dE%02d, want %d S%02dE%02d", info.Name, got.year, got.season, got.episode, want.year, want.season, want.episode)
+	}
+	if got.audio != want.audio || got.channels != want.channels || got.atmos != want.atmos {
+		t.Errorf("%s: audio %q %q atmos %v, want %q %q atmos %v", info.Name, got.audio, got.channels, got.atmos, want.audio, want.channels, want.atmos)
+	}
+	if got.edition != want.edition || got.repack != want.repack || got.proper != want.proper {
+		t.Errorf("%s: edition %q repack %v proper %v, want %q %v %v", info.Name, got.edition, got.repack, got.proper, want.edition, want.repack, want.proper)
+	}
+	if !sameList(got.languages, want.languages) || !sameList(got.subtitles, want.subtitles) {
+		t.Errorf("%s: languages %v subtitles %v, want %v %v", info.Name, got.languages, got.subtitles, want.languages, want.subtitles)
+	}
+}
@@ -0,0 +1,161 @@
+package tmdb
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/cache"
+	"github.com/scakemyer/quasar/config"
+)
+
+// TMDB only keeps change feeds for that long
+const MaxChangesAge = 14 * 24 * time.Hour
+
+var ErrChangesTooOld = errors.New("Changes are only available for the last 14 days")
+
+type changesList struct {
+	Results []*struct {
+		Id int `json:"id"`
+	} `json:"results"`
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+}
+
+type showChanges struct {
+	Changes []*struct {
+		Key   string `json:"key"`
+		Items []*struct {
+			Action string      `json:"action"`
+			Value  interface{} `json:"value"`
+		} `json:"items"`
+	} `json:"changes"`
+}
+
+func changesParams(since time.Time) napping.Params {
+	return napping.Params{
+		"api_key":    apiKey,
+		"start_date": since.UTC().Format("2006-01-02"),
+		"end_date":   time.Now().UTC().Format("2006-01-02"),
+	}
+}
+
+// ChangedShows returns the IDs of all the shows changed on TMDB since the
+// given time.
+func ChangedShows(since time.Time) (map[int]bool, error) {
+	if time.Since(since) > MaxChangesAge {
+		return nil, ErrChangesTooOld
+	}
+
+	changed := make(map[int]bool)
+	for page, totalPages := 1, 1; page <= totalPages; page++ {
+		var results *changesList
+		var err error
+		rateLimiter.Call(func() {
+			params := changesParams(since)
+			params["page"] = fmt.Sprintf("%d", page)
+			urlValues := params.AsUrlValues()
+			var resp *napping.Response
+			resp, err = napping.Get(
+				tmdbEndpoint+"tv/changes",
+				&urlValues,
+				&results,
+				nil,
+			)
+			if err != nil {
+				return
+			} else if resp.Status() == 429 {
+				log.Warning("Rate limit exceeded getting show changes, cooling down...")
+				rateLimiter.CoolDown(resp.HttpResponse().Header)
+				err = fmt.Errorf("Rate limit exceeded getting show changes")
+			} else if resp.Status() != 200 {
+				err = fmt.Errorf("Bad status getting show changes: %d", resp.Status())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if results == nil {
+			break
+		}
+		for _, result := range results.Results {
+			changed[result.Id] = true
+		}
+		totalPages = results.TotalPages
+	}
+
+	log.Infof("%d shows changed on TMDB since %s", len(changed), since.Format("2006-01-02"))
+	return changed, nil
+}
+
+// ShowChangedSeasons returns the numbers of the seasons of a show changed
+// since the given time.
+func ShowChangedSeasons(showId int, since time.Time) ([]int, error) {
+	if time.Since(since) > MaxChangesAge {
+		return nil, ErrChangesTooOld
+	}
+
+	var changes *showChanges
+	var err error
+	rateLimiter.Call(func() {
+		urlValues := changesParams(since).AsUrlValues()
+		var resp *napping.Response
+		resp, err = napping.Get(
+			fmt.Sprintf("%stv/%d/changes", tmdbEndpoint, showId),
+			&urlValues,
+			&changes,
+			nil,
+		)
+		if err != nil {
+			return
+		} else if resp.Status() == 429 {
+			log.Warningf("Rate limit exceeded getting changes of show %d, cooling down...", showId)
+			rateLimiter.CoolDown(resp.HttpResponse().Header)
+			err = fmt.Errorf("Rate limit exceeded getting changes of show %d", showId)
+		} else if resp.Status() != 200 {
+			err = fmt.Errorf("Bad status getting changes of show %d: %d", showId, resp.Status())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seasons := make([]int, 0)
+	if changes == nil {
+		return seasons, nil
+	}
+	seen := make(map[int]bool)
+	for _, change := range changes.Changes {
+		if change.Key != "season" {
+			continue
+		}
+		for _, item := range change.Items {
+			value, ok := item.Value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			number, ok := value["season_number"].(float64)
+			if !ok || seen[int(number)] {
+				continue
+			}
+			seen[int(number)] = true
+			seasons = append(seasons, int(number))
+		}
+	}
+	return seasons, nil
+}
+
+// ForgetShow drops the cached details of a show, so they get fetched again.
+func ForgetShow(showId int, language string) {
+	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore.Delete(fmt.Sprintf("com.tmdb.show.%d.%s", showId, language))
+}
+
+// ForgetSeason drops the cached details of a season, so they get fetched
+// again.
+func ForgetSeason(showId int, seasonNumber int, language string) {
+	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	cacheStore.Delete(fmt.Sprintf("com.tmdb.season.%d.%d.%s", showId, seasonNumber, language))
+}
@@ -72,7 +72,7 @@ func GetShow(showId int, language string) (show *Show) {
 		rateLimiter.Call(func() {
 			urlValues := napping.Params{
 				"api_key": apiKey,
-				"append_to_response": "credits,images,alternative_titles,translations,external_ids",
+				"append_to_response": "credits,images,alternative_titles,translations,external_ids,content_ratings",
 				"language": language,
 			}.AsUrlValues()
 			resp, err := napping.Get(
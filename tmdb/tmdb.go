@@ -70,6 +70,7 @@ type Show struct {
 	InProduction        bool         `json:"in_production"`
 	FirstAirDate        string       `json:"first_air_date"`
 	LastAirDate         string       `json:"last_air_date"`
+	NextEpisodeToAir    *Episode     `json:"next_episode_to_air"`
 	Networks            []*IdName    `json:"networks"`
 	NumberOfEpisodes    int          `json:"number_of_episodes"`
 	NumberOfSeasons     int          `json:"number_of_seasons"`
@@ -81,10 +82,19 @@ type Show struct {
 	ProductionCompanies []*IdName    `json:"production_companies"`
 	Status              string       `json:"status"`
 	ExternalIDs         *ExternalIDs `json:"external_ids"`
+
+	AlternativeTitles *struct {
+		Titles []*AlternativeTitle `json:"results"`
+	} `json:"alternative_titles"`
+
 	Translations        *struct {
 		Translations []*Language `json:"translations"`
 	} `json:"translations"`
 
+	ContentRatings *struct {
+		Ratings []*ContentRating `json:"results"`
+	} `json:"content_ratings"`
+
 	Credits *Credits `json:"credits,omitempty"`
 	Images  *Images  `json:"images,omitempty"`
 
@@ -238,6 +248,11 @@ type ReleaseDates struct {
 	ReleaseDates []*ReleaseDate `json:"release_dates"`
 }
 
+type ContentRating struct {
+	ISO_3166_1 string `json:"iso_3166_1"`
+	Rating     string `json:"rating"`
+}
+
 type ReleaseDate struct {
 	Certification string `json:"certification"`
 	ISO_639_1     string `json:"iso_639_1"`
@@ -266,7 +281,7 @@ var (
 	WarmingUp = true
 )
 
-var rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
+var rateLimiter = util.NewRateLimiter("tmdb", burstRate, burstTime, simultaneousConnections)
 
 func CheckApiKey() {
 	log.Info("Checking TMDB API key...")
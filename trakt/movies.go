@@ -211,8 +211,8 @@ func TopMovies(topCategory string, page string) (movies []*Movies, total int, er
 	return
 }
 
-func WatchlistMovies() (movies []*Movies, err error) {
-	if err := Authorized(); err != nil {
+func WatchlistMovies(conf *config.Configuration) (movies []*Movies, err error) {
+	if err := Authorized(conf); err != nil {
 		return movies, err
 	}
 
@@ -223,9 +223,9 @@ func WatchlistMovies() (movies []*Movies, err error) {
 	}.AsUrlValues()
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := "com.trakt.movies.watchlist"
+	key := AccountKey(conf, "com.trakt.movies.watchlist")
 	if err := cacheStore.Get(key, &movies); err != nil {
-		resp, err := GetWithAuth(endPoint, params)
+		resp, err := GetWithAuth(conf, endPoint, params)
 
 		if err != nil {
 			return movies, err
@@ -255,8 +255,8 @@ func WatchlistMovies() (movies []*Movies, err error) {
 	return
 }
 
-func CollectionMovies() (movies []*Movies, err error) {
-	if err := Authorized(); err != nil {
+func CollectionMovies(conf *config.Configuration) (movies []*Movies, err error) {
+	if err := Authorized(conf); err != nil {
 		return movies, err
 	}
 
@@ -267,9 +267,9 @@ func CollectionMovies() (movies []*Movies, err error) {
 	}.AsUrlValues()
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := "com.trakt.movies.collection"
+	key := AccountKey(conf, "com.trakt.movies.collection")
 	if err := cacheStore.Get(key, &movies); err != nil {
-		resp, err := GetWithAuth(endPoint, params)
+		resp, err := GetWithAuth(conf, endPoint, params)
 
 		if err != nil {
 			return movies, err
@@ -297,8 +297,8 @@ func CollectionMovies() (movies []*Movies, err error) {
 	return movies, err
 }
 
-func Userlists() (lists []*List) {
-	traktUsername := config.Get().TraktUsername
+func Userlists(conf *config.Configuration) (lists []*List) {
+	traktUsername := conf.TraktUsername
 	if traktUsername == "" {
 		xbmc.Notify("Quasar", "LOCALIZE[30149]", config.AddonIcon())
 		return lists
@@ -310,10 +310,10 @@ func Userlists() (lists []*List) {
 	var resp *napping.Response
 	var err error
 
-	if erra := Authorized(); erra != nil {
+	if erra := Authorized(conf); erra != nil {
 		resp, err = Get(endPoint, params)
 	} else {
-		resp, err = GetWithAuth(endPoint, params)
+		resp, err = GetWithAuth(conf, endPoint, params)
 	}
 
 	if err != nil {
@@ -335,8 +335,8 @@ func Userlists() (lists []*List) {
 	return lists
 }
 
-func ListItemsMovies(listId string, withImages bool) (movies []*Movies, err error) {
-	endPoint := fmt.Sprintf("users/%s/lists/%s/items/movies", config.Get().TraktUsername, listId)
+func ListItemsMovies(conf *config.Configuration, listId string, withImages bool) (movies []*Movies, err error) {
+	endPoint := fmt.Sprintf("users/%s/lists/%s/items/movies", conf.TraktUsername, listId)
 
 	params := napping.Params{}.AsUrlValues()
 
@@ -347,12 +347,12 @@ func ListItemsMovies(listId string, withImages bool) (movies []*Movies, err erro
 	if withImages {
 		full = ".full"
 	}
-	key := fmt.Sprintf("com.trakt.movies.list.%s%s", listId, full)
+	key := AccountKey(conf, fmt.Sprintf("com.trakt.movies.list.%s%s", listId, full))
 	if err := cacheStore.Get(key, &movies); err != nil {
-		if erra := Authorized(); erra != nil {
+		if erra := Authorized(conf); erra != nil {
 			resp, err = Get(endPoint, params)
 		} else {
-			resp, err = GetWithAuth(endPoint, params)
+			resp, err = GetWithAuth(conf, endPoint, params)
 		}
 
 		if err != nil || resp.Status() != 200 {
@@ -386,7 +386,7 @@ func ListItemsMovies(listId string, withImages bool) (movies []*Movies, err erro
 	return movies, err
 }
 
-func CalendarMovies(endPoint string, page string) (movies []*CalendarMovie, total int, err error) {
+func CalendarMovies(conf *config.Configuration, endPoint string, page string) (movies []*CalendarMovie, total int, err error) {
 	resultsPerPage := config.Get().ResultsPerPage
 	limit := resultsPerPage * PagesAtOnce
 	pageInt, err := strconv.Atoi(page)
@@ -402,10 +402,10 @@ func CalendarMovies(endPoint string, page string) (movies []*CalendarMovie, tota
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
 	endPointKey := strings.Replace(endPoint, "/", ".", -1)
-	key := fmt.Sprintf("com.trakt.mymovies.%s.%s", endPointKey, page)
-	totalKey := fmt.Sprintf("com.trakt.mymovies.%s.total", endPointKey)
+	key := AccountKey(conf, fmt.Sprintf("com.trakt.mymovies.%s.%s", endPointKey, page))
+	totalKey := AccountKey(conf, fmt.Sprintf("com.trakt.mymovies.%s.total", endPointKey))
 	if err := cacheStore.Get(key, &movies); err != nil {
-		resp, err := GetWithAuth("calendars/" + endPoint, params)
+		resp, err := GetWithAuth(conf, "calendars/"+endPoint, params)
 
 		if err != nil {
 			log.Error(err)
@@ -313,8 +313,8 @@ func TopShows(topCategory string, page string) (shows []*Shows, total int, err e
 	return
 }
 
-func WatchlistShows() (shows []*Shows, err error) {
-	if err := Authorized(); err != nil {
+func WatchlistShows(conf *config.Configuration) (shows []*Shows, err error) {
+	if err := Authorized(conf); err != nil {
 		return shows, err
 	}
 
@@ -325,9 +325,9 @@ func WatchlistShows() (shows []*Shows, err error) {
 	}.AsUrlValues()
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := "com.trakt.shows.watchlist"
+	key := AccountKey(conf, "com.trakt.shows.watchlist")
 	if err := cacheStore.Get(key, &shows); err != nil {
-		resp, err := GetWithAuth(endPoint, params)
+		resp, err := GetWithAuth(conf, endPoint, params)
 
 		if err != nil {
 			return shows, err
@@ -358,8 +358,8 @@ func WatchlistShows() (shows []*Shows, err error) {
 	return
 }
 
-func CollectionShows() (shows []*Shows, err error) {
-	if err := Authorized(); err != nil {
+func CollectionShows(conf *config.Configuration) (shows []*Shows, err error) {
+	if err := Authorized(conf); err != nil {
 		return shows, err
 	}
 
@@ -370,9 +370,9 @@ func CollectionShows() (shows []*Shows, err error) {
 	}.AsUrlValues()
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := "com.trakt.shows.collection"
+	key := AccountKey(conf, "com.trakt.shows.collection")
 	if err := cacheStore.Get(key, &shows); err != nil {
-		resp, err := GetWithAuth(endPoint, params)
+		resp, err := GetWithAuth(conf, endPoint, params)
 
 		if err != nil {
 			return shows, err
@@ -402,8 +402,8 @@ func CollectionShows() (shows []*Shows, err error) {
 	return
 }
 
-func ListItemsShows(listId string, withImages bool) (shows []*Shows, err error) {
-	endPoint := fmt.Sprintf("users/%s/lists/%s/items/shows", config.Get().TraktUsername, listId)
+func ListItemsShows(conf *config.Configuration, listId string, withImages bool) (shows []*Shows, err error) {
+	endPoint := fmt.Sprintf("users/%s/lists/%s/items/shows", conf.TraktUsername, listId)
 
 	params := napping.Params{}.AsUrlValues()
 
@@ -414,12 +414,12 @@ func ListItemsShows(listId string, withImages bool) (shows []*Shows, err error)
 	if withImages {
 		full = ".full"
 	}
-	key := fmt.Sprintf("com.trakt.shows.list.%s%s", listId, full)
+	key := AccountKey(conf, fmt.Sprintf("com.trakt.shows.list.%s%s", listId, full))
 	if err := cacheStore.Get(key, &shows); err != nil {
-		if erra := Authorized(); erra != nil {
+		if erra := Authorized(conf); erra != nil {
 			resp, err = Get(endPoint, params)
 		} else {
-			resp, err = GetWithAuth(endPoint, params)
+			resp, err = GetWithAuth(conf, endPoint, params)
 		}
 
 		if err != nil || resp.Status() != 200 {
@@ -450,7 +450,7 @@ func ListItemsShows(listId string, withImages bool) (shows []*Shows, err error)
 	return
 }
 
-func CalendarShows(endPoint string, page string) (shows []*CalendarShow, total int, err error) {
+func CalendarShows(conf *config.Configuration, endPoint string, page string) (shows []*CalendarShow, total int, err error) {
 	resultsPerPage := config.Get().ResultsPerPage
 	limit := resultsPerPage * PagesAtOnce
 	pageInt, err := strconv.Atoi(page)
@@ -466,10 +466,10 @@ func CalendarShows(endPoint string, page string) (shows []*CalendarShow, total i
 
 	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
 	endPointKey := strings.Replace(endPoint, "/", ".", -1)
-	key := fmt.Sprintf("com.trakt.myshows.%s.%s", endPointKey, page)
-	totalKey := fmt.Sprintf("com.trakt.myshows.%s.total", endPointKey)
+	key := AccountKey(conf, fmt.Sprintf("com.trakt.myshows.%s.%s", endPointKey, page))
+	totalKey := AccountKey(conf, fmt.Sprintf("com.trakt.myshows.%s.total", endPointKey))
 	if err := cacheStore.Get(key, &shows); err != nil {
-		resp, err := GetWithAuth("calendars/" + endPoint, params)
+		resp, err := GetWithAuth(conf, "calendars/"+endPoint, params)
 
 		if err != nil {
 			return shows, 0, err
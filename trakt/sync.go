@@ -0,0 +1,199 @@
+package trakt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmcvetta/napping"
+	"github.com/scakemyer/quasar/config"
+)
+
+//
+// Watched history and playback progress, for syncing them with Kodi
+//
+
+type WatchedMovie struct {
+	Plays         int       `json:"plays"`
+	LastWatchedAt time.Time `json:"last_watched_at"`
+	Movie         *Movie    `json:"movie"`
+}
+
+type WatchedShow struct {
+	Plays         int              `json:"plays"`
+	LastWatchedAt time.Time        `json:"last_watched_at"`
+	Show          *Show            `json:"show"`
+	Seasons       []*WatchedSeason `json:"seasons"`
+}
+
+type WatchedSeason struct {
+	Number   int               `json:"number"`
+	Episodes []*WatchedEpisode `json:"episodes"`
+}
+
+type WatchedEpisode struct {
+	Number        int       `json:"number"`
+	Plays         int       `json:"plays"`
+	LastWatchedAt time.Time `json:"last_watched_at"`
+}
+
+// Playback is a paused movie or episode, Progress being a percentage.
+type Playback struct {
+	ID       int       `json:"id"`
+	Progress float64   `json:"progress"`
+	PausedAt time.Time `json:"paused_at"`
+	Type     string    `json:"type"`
+	Movie    *Movie    `json:"movie"`
+	Episode  *Episode  `json:"episode"`
+	Show     *Show     `json:"show"`
+}
+
+// HistoryItem is a play to add to the history, of a movie, or of an
+// episode of a show when Season and Episode are set.
+type HistoryItem struct {
+	TMDBId    int
+	Season    int
+	Episode   int
+	WatchedAt time.Time
+}
+
+func WatchedMovies(conf *config.Configuration) (movies []*WatchedMovie, err error) {
+	if err := Authorized(conf); err != nil {
+		return movies, err
+	}
+
+	resp, err := GetWithAuth(conf, "sync/watched/movies", napping.Params{}.AsUrlValues())
+	if err != nil {
+		return movies, err
+	} else if resp.Status() != 200 {
+		return movies, errors.New(fmt.Sprintf("Bad status getting Trakt watched movies: %d", resp.Status()))
+	}
+	err = resp.Unmarshal(&movies)
+	return
+}
+
+func WatchedShows(conf *config.Configuration) (shows []*WatchedShow, err error) {
+	if err := Authorized(conf); err != nil {
+		return shows, err
+	}
+
+	resp, err := GetWithAuth(conf, "sync/watched/shows", napping.Params{}.AsUrlValues())
+	if err != nil {
+		return shows, err
+	} else if resp.Status() != 200 {
+		return shows, errors.New(fmt.Sprintf("Bad status getting Trakt watched shows: %d", resp.Status()))
+	}
+	err = resp.Unmarshal(&shows)
+	return
+}
+
+// PlaybackProgress returns the paused movies and episodes.
+func PlaybackProgress(conf *config.Configuration) (playbacks []*Playback, err error) {
+	if err := Authorized(conf); err != nil {
+		return playbacks, err
+	}
+
+	resp, err := GetWithAuth(conf, "sync/playback", napping.Params{}.AsUrlValues())
+	if err != nil {
+		return playbacks, err
+	} else if resp.Status() != 200 {
+		return playbacks, errors.New(fmt.Sprintf("Bad status getting Trakt playback progress: %d", resp.Status()))
+	}
+	err = resp.Unmarshal(&playbacks)
+	return
+}
+
+// AddToHistory adds plays of movies and episodes, at the time they
+// happened.
+func AddToHistory(conf *config.Configuration, movies []*HistoryItem, episodes []*HistoryItem) error {
+	if err := Authorized(conf); err != nil {
+		return err
+	}
+	if len(movies) == 0 && len(episodes) == 0 {
+		return nil
+	}
+
+	type ids struct {
+		TMDB int `json:"tmdb"`
+	}
+	type historyEpisode struct {
+		Number    int       `json:"number"`
+		WatchedAt time.Time `json:"watched_at"`
+	}
+	type historySeason struct {
+		Number   int               `json:"number"`
+		Episodes []*historyEpisode `json:"episodes"`
+	}
+	type historyShow struct {
+		IDs     ids              `json:"ids"`
+		Seasons []*historySeason `json:"seasons"`
+	}
+	type historyMovie struct {
+		IDs       ids       `json:"ids"`
+		WatchedAt time.Time `json:"watched_at"`
+	}
+	payload := struct {
+		Movies []*historyMovie `json:"movies"`
+		Shows  []*historyShow  `json:"shows"`
+	}{
+		Movies: make([]*historyMovie, 0, len(movies)),
+		Shows:  make([]*historyShow, 0),
+	}
+
+	for _, movie := range movies {
+		payload.Movies = append(payload.Movies, &historyMovie{IDs: ids{movie.TMDBId}, WatchedAt: movie.WatchedAt.UTC()})
+	}
+	shows := make(map[int]*historyShow)
+	seasons := make(map[string]*historySeason)
+	for _, episode := range episodes {
+		show, ok := shows[episode.TMDBId]
+		if !ok {
+			show = &historyShow{IDs: ids{episode.TMDBId}, Seasons: make([]*historySeason, 0)}
+			shows[episode.TMDBId] = show
+			payload.Shows = append(payload.Shows, show)
+		}
+		seasonKey := fmt.Sprintf("%d_%d", episode.TMDBId, episode.Season)
+		season, ok := seasons[seasonKey]
+		if !ok {
+			season = &historySeason{Number: episode.Season, Episodes: make([]*historyEpisode, 0)}
+			seasons[seasonKey] = season
+			show.Seasons = append(show.Seasons, season)
+		}
+		season.Episodes = append(season.Episodes, &historyEpisode{Number: episode.Episode, WatchedAt: episode.WatchedAt.UTC()})
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := Post(conf, "sync/history", bytes.NewBuffer(buf))
+	if err != nil {
+		return err
+	} else if resp.Status() != 201 {
+		return errors.New(fmt.Sprintf("Bad status adding to Trakt history: %d", resp.Status()))
+	}
+	return nil
+}
+
+// SetPlaybackProgress pauses a movie or episode at a point, which is how
+// Trakt learns about progress made elsewhere. Trakt takes 80% and over as
+// watched, so those are left alone.
+func SetPlaybackProgress(conf *config.Configuration, contentType string, tmdbId int, progress float64) error {
+	if err := Authorized(conf); err != nil {
+		return err
+	}
+	if progress >= 80 {
+		return errors.New(fmt.Sprintf("Progress of %s #%d too close to the end: %f%%", contentType, tmdbId, progress))
+	}
+
+	payload := fmt.Sprintf(`{"%s": {"ids": {"tmdb": %d}}, "progress": %f}`, contentType, tmdbId, progress)
+	resp, err := Post(conf, "scrobble/pause", bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	} else if resp.Status() != 201 {
+		return errors.New(fmt.Sprintf("Bad status pausing %s #%d at %f%%: %d", contentType, tmdbId, progress, resp.Status()))
+	}
+	return nil
+}
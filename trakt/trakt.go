@@ -38,7 +38,7 @@ var (
 	userlistExpiration      = 1 * time.Minute
 )
 
-var rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
+var rateLimiter = util.NewRateLimiter("trakt", burstRate, burstTime, simultaneousConnections)
 
 type Object struct {
 	Title string `json:"title"`
@@ -276,6 +276,15 @@ type CalendarMovie struct {
 	Movie      *Movie `json:"movie"`
 }
 
+// AccountKey makes the cache key of what depends on the Trakt account of a
+// profile.
+func AccountKey(conf *config.Configuration, key string) string {
+	if conf.Profile == config.DefaultProfile {
+		return key
+	}
+	return key + ".profile." + conf.Profile
+}
+
 func totalFromHeaders(headers http.Header) (total int, err error) {
 	if len(headers) > 0 {
 		if itemCount, exists := headers["X-Pagination-Item-Count"]; exists {
@@ -341,10 +350,10 @@ func Get(endPoint string, params url.Values) (resp *napping.Response, err error)
 	return
 }
 
-func GetWithAuth(endPoint string, params url.Values) (resp *napping.Response, err error) {
+func GetWithAuth(conf *config.Configuration, endPoint string, params url.Values) (resp *napping.Response, err error) {
 	header := http.Header{
 		"Content-type": []string{"application/json"},
-		"Authorization": []string{fmt.Sprintf("Bearer %s", config.Get().TraktToken)},
+		"Authorization":     []string{fmt.Sprintf("Bearer %s", conf.TraktToken)},
 		"trakt-api-key": []string{ClientId},
 		"trakt-api-version": []string{ApiVersion},
 		"User-Agent": []string{clearance.UserAgent},
@@ -368,17 +377,17 @@ func GetWithAuth(endPoint string, params url.Values) (resp *napping.Response, er
 		} else if resp.Status() == 403 && retriesLeft > 0 {
 			err = newClearance()
 			if err == nil {
-				resp, err = GetWithAuth(endPoint, params)
+				resp, err = GetWithAuth(conf, endPoint, params)
 			}
 		}
 	})
 	return
 }
 
-func Post(endPoint string, payload *bytes.Buffer) (resp *napping.Response, err error) {
+func Post(conf *config.Configuration, endPoint string, payload *bytes.Buffer) (resp *napping.Response, err error) {
 	header := http.Header{
 		"Content-type": []string{"application/json"},
-		"Authorization": []string{fmt.Sprintf("Bearer %s", config.Get().TraktToken)},
+		"Authorization":     []string{fmt.Sprintf("Bearer %s", conf.TraktToken)},
 		"trakt-api-key": []string{ClientId},
 		"trakt-api-version": []string{ApiVersion},
 		"User-Agent": []string{clearance.UserAgent},
@@ -403,7 +412,7 @@ func Post(endPoint string, payload *bytes.Buffer) (resp *napping.Response, err e
 		} else if resp.Status() == 403 && retriesLeft > 0 {
 			err = newClearance()
 			if err == nil {
-				resp, err = Post(endPoint, payload)
+				resp, err = Post(conf, endPoint, payload)
 			}
 		}
 	})
@@ -535,7 +544,7 @@ func PollToken(code *Code) (token *Token, err error) {
 	}
 }
 
-func RefreshToken() (resp *napping.Response, err error) {
+func RefreshToken(conf *config.Configuration) (resp *napping.Response, err error) {
 	endPoint := "oauth/token"
 	header := http.Header{
 		"Content-type": []string{"application/json"},
@@ -543,7 +552,7 @@ func RefreshToken() (resp *napping.Response, err error) {
 		"Cookie": []string{clearance.Cookies},
 	}
 	params := napping.Params{
-		"refresh_token": config.Get().TraktRefreshToken,
+		"refresh_token": conf.TraktRefreshToken,
 		"client_id": ClientId,
 		"client_secret": ClientSecret,
 		"redirect_uri": "urn:ietf:wg:oauth:2.0:oob",
@@ -564,52 +573,68 @@ func RefreshToken() (resp *napping.Response, err error) {
 	} else if resp.Status() == 403 && retriesLeft > 0 {
 		err = newClearance()
 		if err == nil {
-			resp, err = RefreshToken()
+			resp, err = RefreshToken(conf)
 		}
 	}
 	return
 }
 
+// TokenRefreshHandler refreshes the Trakt tokens of all profiles before
+// they expire.
 func TokenRefreshHandler() {
-	if config.Get().TraktToken == "" {
-		return
-	}
-
-	var token *Token
 	ticker := time.NewTicker(12 * time.Hour)
 	for {
 		select {
 		case <-ticker.C:
-			if time.Now().Unix() > int64(config.Get().TraktTokenExpiry) - int64(259200) {
-				resp, err := RefreshToken()
+			profiles := append([]string{config.DefaultProfile}, config.Profiles()...)
+			for _, profile := range profiles {
+				conf, err := config.GetProfile(profile)
 				if err != nil {
+					log.Error(err)
+					continue
+				}
+				if conf.TraktToken == "" || time.Now().Unix() <= int64(conf.TraktTokenExpiry)-int64(259200) {
+					continue
+				}
+				if err := refreshProfileToken(conf); err != nil {
 					xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
 					log.Error(err)
-					return
-				} else {
-					if resp.Status() == 200 {
-						if err := resp.Unmarshal(&token); err != nil {
-							xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
-							log.Error(err)
-						} else {
-							expiry := time.Now().Unix() + int64(token.ExpiresIn)
-							xbmc.SetSetting("trakt_token_expiry", strconv.Itoa(int(expiry)))
-							xbmc.SetSetting("trakt_token", token.AccessToken)
-							xbmc.SetSetting("trakt_refresh_token", token.RefreshToken)
-							log.Noticef("Token refreshed for Trakt authorization, next refresh in %s", time.Duration(token.ExpiresIn - 259200) * time.Second)
-						}
-					} else {
-						err = errors.New(fmt.Sprintf("Bad status while refreshing Trakt token: %d", resp.Status()))
-						xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
-						log.Error(err)
-					}
 				}
 			}
 		}
 	}
 }
 
-func Authorize(fromSettings bool) error {
+func refreshProfileToken(conf *config.Configuration) error {
+	var token *Token
+	resp, err := RefreshToken(conf)
+	if err != nil {
+		return err
+	}
+	if resp.Status() != 200 {
+		return errors.New(fmt.Sprintf("Bad status while refreshing Trakt token: %d", resp.Status()))
+	}
+	if err := resp.Unmarshal(&token); err != nil {
+		return err
+	}
+	if err := saveToken(conf, token); err != nil {
+		return err
+	}
+	log.Noticef("Token refreshed for Trakt authorization, next refresh in %s", time.Duration(token.ExpiresIn-259200)*time.Second)
+	return nil
+}
+
+// saveToken stores a token in the settings of the profile it's for.
+func saveToken(conf *config.Configuration, token *Token) error {
+	expiry := time.Now().Unix() + int64(token.ExpiresIn)
+	return config.SaveProfileSettings(conf.Profile, map[string]interface{}{
+		"trakt_token_expiry":  int(expiry),
+		"trakt_token":         token.AccessToken,
+		"trakt_refresh_token": token.RefreshToken,
+	})
+}
+
+func Authorize(conf *config.Configuration, fromSettings bool) error {
 	code, err := GetCode()
 
 	if err != nil {
@@ -634,18 +659,18 @@ func Authorize(fromSettings bool) error {
 		success += " (Save your settings!)"
 	}
 
-	expiry := time.Now().Unix() + int64(token.ExpiresIn)
-	xbmc.SetSetting("trakt_token_expiry", strconv.Itoa(int(expiry)))
-	xbmc.SetSetting("trakt_token", token.AccessToken)
-	xbmc.SetSetting("trakt_refresh_token", token.RefreshToken)
+	if err := saveToken(conf, token); err != nil {
+		xbmc.Notify("Quasar", err.Error(), config.AddonIcon())
+		return err
+	}
 
 	xbmc.Notify("Quasar", success, config.AddonIcon())
 	return nil
 }
 
-func Authorized() error {
-	if config.Get().TraktToken == "" {
-		err := Authorize(false)
+func Authorized(conf *config.Configuration) error {
+	if conf.TraktToken == "" {
+		err := Authorize(conf, false)
 		if err != nil {
 			return err
 		}
@@ -653,44 +678,44 @@ func Authorized() error {
 	return nil
 }
 
-func AddToWatchlist(itemType string, tmdbId string) (resp *napping.Response, err error) {
-	if err := Authorized(); err != nil {
+func AddToWatchlist(conf *config.Configuration, itemType string, tmdbId string) (resp *napping.Response, err error) {
+	if err := Authorized(conf); err != nil {
 		return nil, err
 	}
 
 	endPoint := "sync/watchlist"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
+	return Post(conf, endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
 }
 
-func RemoveFromWatchlist(itemType string, tmdbId string) (resp *napping.Response, err error) {
-	if err := Authorized(); err != nil {
+func RemoveFromWatchlist(conf *config.Configuration, itemType string, tmdbId string) (resp *napping.Response, err error) {
+	if err := Authorized(conf); err != nil {
 		return nil, err
 	}
 
 	endPoint := "sync/watchlist/remove"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
+	return Post(conf, endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
 }
 
-func AddToCollection(itemType string, tmdbId string) (resp *napping.Response, err error) {
-	if err := Authorized(); err != nil {
+func AddToCollection(conf *config.Configuration, itemType string, tmdbId string) (resp *napping.Response, err error) {
+	if err := Authorized(conf); err != nil {
 		return nil, err
 	}
 
 	endPoint := "sync/collection"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
+	return Post(conf, endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
 }
 
-func RemoveFromCollection(itemType string, tmdbId string) (resp *napping.Response, err error) {
-	if err := Authorized(); err != nil {
+func RemoveFromCollection(conf *config.Configuration, itemType string, tmdbId string) (resp *napping.Response, err error) {
+	if err := Authorized(conf); err != nil {
 		return nil, err
 	}
 
 	endPoint := "sync/collection/remove"
-	return Post(endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
+	return Post(conf, endPoint, bytes.NewBufferString(fmt.Sprintf(`{"%s": [{"ids": {"tmdb": %s}}]}`, itemType, tmdbId)))
 }
 
-func Scrobble(action string, contentType string, tmdbId int, watched float64, runtime float64) {
-	if err := Authorized(); err != nil {
+func Scrobble(conf *config.Configuration, action string, contentType string, tmdbId int, watched float64, runtime float64) {
+	if err := Authorized(conf); err != nil {
 		return
 	}
 
@@ -704,7 +729,7 @@ func Scrobble(action string, contentType string, tmdbId int, watched float64, ru
 	endPoint := fmt.Sprintf("scrobble/%s", action)
 	payload := fmt.Sprintf(`{"%s": {"ids": {"tmdb": %d}}, "progress": %f, "app_version": "%s"}`,
 	                       contentType, tmdbId, progress, util.Version[1:len(util.Version) - 1])
-	resp, err := Post(endPoint, bytes.NewBufferString(payload))
+	resp, err := Post(conf, endPoint, bytes.NewBufferString(payload))
 	if err != nil {
 		log.Error(err.Error())
 		xbmc.Notify("Quasar", "Scrobble failed, check your logs.", config.AddonIcon())
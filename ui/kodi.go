@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"github.com/scakemyer/quasar/config"
+	"github.com/scakemyer/quasar/xbmc"
+)
+
+// Kodi shows dialogs through the add-on.
+type Kodi struct{}
+
+func (k *Kodi) Confirm(title string, message string) bool {
+	return xbmc.DialogConfirm(title, message)
+}
+
+func (k *Kodi) Choose(title string, subject string, items ...string) int {
+	if subject != "" {
+		return xbmc.ListDialogLarge(title, subject, items...)
+	}
+	return xbmc.ListDialog(title, items...)
+}
+
+func (k *Kodi) Keyboard(defaultText string, heading string) string {
+	return xbmc.Keyboard(defaultText, heading)
+}
+
+func (k *Kodi) Notify(title string, message string) {
+	xbmc.Notify(title, message, config.AddonIcon())
+}
+
+func (k *Kodi) Progress(title string, lines ...string) Progress {
+	line1, line2, line3 := progressLines(lines)
+	return &kodiProgress{dialog: xbmc.NewDialogProgress(title, line1, line2, line3)}
+}
+
+// kodiProgress stands in for dialogs Kodi failed to open.
+type kodiProgress struct {
+	dialog *xbmc.DialogProgress
+}
+
+func (p *kodiProgress) Update(percent int, lines ...string) {
+	if p.dialog == nil {
+		return
+	}
+	line1, line2, line3 := progressLines(lines)
+	p.dialog.Update(percent, line1, line2, line3)
+}
+
+func (p *kodiProgress) IsCanceled() bool {
+	if p.dialog == nil {
+		return false
+	}
+	return p.dialog.IsCanceled()
+}
+
+func (p *kodiProgress) Close() {
+	if p.dialog != nil {
+		p.dialog.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rule answers the dialogs whose title, message, subject or items contain
+// Match, e.g. "LOCALIZE[30146]". Answers are "yes" or "no" to confirms,
+// "first", an index or part of an item's text to choices, and the text
+// itself to keyboards.
+type Rule struct {
+	Match  string `json:"match"`
+	Answer string `json:"answer"`
+}
+
+// DefaultRules pick the best link and the biggest file, so that plays work
+// without anyone to ask. Everything else gets canceled.
+var DefaultRules = []*Rule{
+	{Match: "LOCALIZE[30228]", Answer: "first"},
+	{Match: "LOCALIZE[30223]", Answer: "first"},
+}
+
+// Policy answers dialogs by its rules, the first matching one winning, for
+// running without anyone in front of a screen.
+type Policy struct {
+	Rules []*Rule
+}
+
+func NewPolicy(rules []*Rule) *Policy {
+	if rules == nil {
+		rules = DefaultRules
+	}
+	return &Policy{Rules: rules}
+}
+
+// LoadPolicy reads rules from a JSON file, the default ones being used
+// when there's none.
+func LoadPolicy(file string) (*Policy, error) {
+	if file == "" {
+		return NewPolicy(nil), nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return NewPolicy(nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+	rules := make([]*Rule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("Invalid rules in %s: %s", file, err)
+	}
+	return NewPolicy(rules), nil
+}
+
+func (p *Policy) answer(texts ...string) (string, bool) {
+	for _, rule := range p.Rules {
+		for _, text := range texts {
+			if rule.Match != "" && strings.Contains(text, rule.Match) {
+				return rule.Answer, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *Policy) Confirm(title string, message string) bool {
+	answer, ok := p.answer(title, message)
+	confirmed := ok && isYes(answer)
+	log.Noticef("Confirm %s %s: %t", title, message, confirmed)
+	return confirmed
+}
+
+func (p *Policy) Choose(title string, subject string, items ...string) int {
+	choice := -1
+	if answer, ok := p.answer(append([]string{title, subject}, items...)...); ok {
+		choice = chooseItem(answer, items)
+	}
+	log.Noticef("Choose %s %s: %d of %d", title, subject, choice, len(items))
+	return choice
+}
+
+func (p *Policy) Keyboard(defaultText string, heading string) string {
+	text, _ := p.answer(heading)
+	log.Noticef("Keyboard %s: %q", heading, text)
+	return text
+}
+
+func (p *Policy) Notify(title string, message string) {
+	log.Noticef("%s: %s", title, message)
+}
+
+// Progress dialogs can't be canceled by rules, so they only get logged.
+func (p *Policy) Progress(title string, lines ...string) Progress {
+	log.Noticef("%s %s", title, strings.Join(lines, " "))
+	return &logProgress{title: title}
+}
+
+type logProgress struct {
+	title string
+}
+
+func (p *logProgress) Update(percent int, lines ...string) {
+	log.Debugf("%s %d%% %s", p.title, percent, strings.Join(lines, " "))
+}
+
+func (p *logProgress) IsCanceled() bool {
+	return false
+}
+
+func (p *logProgress) Close() {
+	log.Debugf("%s closed", p.title)
+}
+
+func isYes(answer string) bool {
+	switch strings.ToLower(answer) {
+	case "yes", "y", "true", "1", "ok":
+		return true
+	}
+	return false
+}
+
+// chooseItem turns an answer into an item's index, -1 when none is meant.
+func chooseItem(answer string, items []string) int {
+	if len(items) == 0 {
+		return -1
+	}
+	if answer == "first" {
+		return 0
+	}
+	if index, err := strconv.Atoi(answer); err == nil {
+		if index < 0 || index >= len(items) {
+			return -1
+		}
+		return index
+	}
+	for i, item := range items {
+		if answer != "" && strings.Contains(strings.ToLower(item), strings.ToLower(answer)) {
+			return i
+		}
+	}
+	return -1
+}
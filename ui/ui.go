@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/config"
+)
+
+//
+// Dialogs and notifications, whoever shows and answers them: Kodi, rules
+// answering for a user who isn't there, or the web UI
+//
+
+var log = logging.MustGetLogger("ui")
+
+type UI interface {
+	// Confirm asks a yes or no question, false meaning no or canceled.
+	Confirm(title string, message string) bool
+	// Choose returns the index of the item chosen, -1 when canceled. The
+	// subject, when not empty, is shown along the items.
+	Choose(title string, subject string, items ...string) int
+	// Keyboard asks for some text, empty when canceled.
+	Keyboard(defaultText string, heading string) string
+	Notify(title string, message string)
+	// Progress opens a progress dialog, until closed.
+	Progress(title string, lines ...string) Progress
+}
+
+type Progress interface {
+	Update(percent int, lines ...string)
+	IsCanceled() bool
+	Close()
+}
+
+var (
+	current UI = &Kodi{}
+	mx      sync.RWMutex
+)
+
+// Get returns the UI of components given none.
+func Get() UI {
+	mx.RLock()
+	defer mx.RUnlock()
+	return current
+}
+
+func Set(ui UI) {
+	mx.Lock()
+	current = ui
+	mx.Unlock()
+}
+
+// ForConfig returns the UI the configuration asks for, Kodi's unless
+// headless.
+func ForConfig(conf *config.Configuration) (UI, error) {
+	switch conf.UI {
+	case "", config.UIKodi:
+		return &Kodi{}, nil
+	case config.UIPolicy:
+		return LoadPolicy(conf.UIPolicyFile)
+	case config.UIWeb:
+		policy, err := LoadPolicy(conf.UIPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewWeb(WebTimeout, policy), nil
+	}
+	return nil, fmt.Errorf("Unknown UI %s", conf.UI)
+}
+
+// Or returns ui, or the current UI when nil.
+func Or(ui UI) UI {
+	if ui == nil {
+		return Get()
+	}
+	return ui
+}
+
+// progressLines gives Kodi's three progress lines.
+func progressLines(lines []string) (string, string, string) {
+	padded := make([]string, 3)
+	copy(padded, lines)
+	return padded[0], padded[1], padded[2]
+}
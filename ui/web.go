@@ -0,0 +1,199 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	PromptConfirm  = "confirm"
+	PromptChoose   = "choose"
+	PromptKeyboard = "keyboard"
+	PromptProgress = "progress"
+
+	// How many notifications are kept for the web UI
+	noticesKept = 50
+	// How long dialogs wait in the web UI, playback waiting on some
+	WebTimeout = 5 * time.Minute
+)
+
+// Prompt is a dialog waiting in the web UI, progress dialogs staying there
+// until closed.
+type Prompt struct {
+	ID      int       `json:"id"`
+	Type    string    `json:"type"`
+	Title   string    `json:"title"`
+	Message string    `json:"message,omitempty"`
+	Items   []string  `json:"items,omitempty"`
+	Percent int       `json:"percent,omitempty"`
+	Lines   []string  `json:"lines,omitempty"`
+	Created time.Time `json:"created"`
+
+	answer   chan string
+	canceled bool
+}
+
+type Notice struct {
+	Time    time.Time `json:"time"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+}
+
+// Web shows dialogs in the web UI, and waits for them to be answered there.
+// Unanswered ones are left to the fallback after the timeout.
+type Web struct {
+	Timeout  time.Duration
+	Fallback UI
+
+	mx      sync.Mutex
+	lastId  int
+	prompts map[int]*Prompt
+	notices []*Notice
+}
+
+func NewWeb(timeout time.Duration, fallback UI) *Web {
+	if fallback == nil {
+		fallback = NewPolicy(nil)
+	}
+	return &Web{
+		Timeout:  timeout,
+		Fallback: fallback,
+		prompts:  make(map[int]*Prompt),
+		notices:  make([]*Notice, 0, noticesKept),
+	}
+}
+
+// Prompts returns the dialogs waiting, oldest first.
+func (w *Web) Prompts() []*Prompt {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	prompts := make([]*Prompt, 0, len(w.prompts))
+	for id := 1; id <= w.lastId; id++ {
+		if prompt, ok := w.prompts[id]; ok {
+			copied := *prompt
+			prompts = append(prompts, &copied)
+		}
+	}
+	return prompts
+}
+
+func (w *Web) Notices() []*Notice {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	return append([]*Notice{}, w.notices...)
+}
+
+// Answer answers a dialog, "cancel" canceling progress dialogs.
+func (w *Web) Answer(id int, answer string) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	prompt, ok := w.prompts[id]
+	if !ok {
+		return fmt.Errorf("No dialog #%d waiting", id)
+	}
+	if prompt.Type == PromptProgress {
+		if answer != "cancel" {
+			return errors.New("Progress dialogs can only be canceled")
+		}
+		prompt.canceled = true
+		return nil
+	}
+	delete(w.prompts, id)
+	prompt.answer <- answer
+	return nil
+}
+
+func (w *Web) add(prompt *Prompt) *Prompt {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	w.lastId++
+	prompt.ID = w.lastId
+	prompt.Created = time.Now()
+	prompt.answer = make(chan string, 1)
+	w.prompts[prompt.ID] = prompt
+	return prompt
+}
+
+// wait returns the answer to a dialog, false when the timeout came first.
+func (w *Web) wait(prompt *Prompt) (string, bool) {
+	select {
+	case answer := <-prompt.answer:
+		return answer, true
+	case <-time.After(w.Timeout):
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	// Answered just in time
+	select {
+	case answer := <-prompt.answer:
+		return answer, true
+	default:
+	}
+	delete(w.prompts, prompt.ID)
+	log.Infof("No answer to %s %q after %s", prompt.Type, prompt.Title, w.Timeout)
+	return "", false
+}
+
+func (w *Web) Confirm(title string, message string) bool {
+	answer, ok := w.wait(w.add(&Prompt{Type: PromptConfirm, Title: title, Message: message}))
+	if !ok {
+		return w.Fallback.Confirm(title, message)
+	}
+	return isYes(answer)
+}
+
+func (w *Web) Choose(title string, subject string, items ...string) int {
+	answer, ok := w.wait(w.add(&Prompt{Type: PromptChoose, Title: title, Message: subject, Items: items}))
+	if !ok {
+		return w.Fallback.Choose(title, subject, items...)
+	}
+	return chooseItem(answer, items)
+}
+
+func (w *Web) Keyboard(defaultText string, heading string) string {
+	answer, ok := w.wait(w.add(&Prompt{Type: PromptKeyboard, Title: heading, Message: defaultText}))
+	if !ok {
+		return w.Fallback.Keyboard(defaultText, heading)
+	}
+	return answer
+}
+
+func (w *Web) Notify(title string, message string) {
+	log.Noticef("%s: %s", title, message)
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if len(w.notices) == noticesKept {
+		w.notices = w.notices[1:]
+	}
+	w.notices = append(w.notices, &Notice{Time: time.Now(), Title: title, Message: message})
+}
+
+func (w *Web) Progress(title string, lines ...string) Progress {
+	return &webProgress{web: w, prompt: w.add(&Prompt{Type: PromptProgress, Title: title, Lines: lines})}
+}
+
+type webProgress struct {
+	web    *Web
+	prompt *Prompt
+}
+
+func (p *webProgress) Update(percent int, lines ...string) {
+	p.web.mx.Lock()
+	p.prompt.Percent = percent
+	p.prompt.Lines = lines
+	p.web.mx.Unlock()
+}
+
+func (p *webProgress) IsCanceled() bool {
+	p.web.mx.Lock()
+	defer p.web.mx.Unlock()
+	return p.prompt.canceled
+}
+
+func (p *webProgress) Close() {
+	p.web.mx.Lock()
+	delete(p.web.prompts, p.prompt.ID)
+	p.web.mx.Unlock()
+}
@@ -1,16 +1,24 @@
 package util
 
 import (
-	"time"
-	"strconv"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/op/go-logging"
+	"github.com/scakemyer/quasar/metrics"
 )
 
 var log = logging.MustGetLogger("ratelimiter")
 
+var (
+	apiRequests        = metrics.NewCounter("quasar_api_requests_total", "Requests made through a rate limiter.", "api")
+	apiCoolDowns       = metrics.NewCounter("quasar_api_cooldowns_total", "Times an API asked to slow down, with a 429.", "api")
+	apiCoolDownSeconds = metrics.NewGauge("quasar_api_cooldown_seconds", "How long requests to an API are held back.", "api")
+)
+
 type RateLimiter struct {
+	name         string
 	rateTicker   *time.Ticker
 	rateLimiter  chan bool
 	parallelChan chan bool
@@ -18,8 +26,11 @@ type RateLimiter struct {
 	coolDown     int
 }
 
-func NewRateLimiter(burstRate int, burstTimeSpan time.Duration, parallelCount int) *RateLimiter {
+// NewRateLimiter makes a rate limiter for the API of name, which is what
+// its metrics are labeled with.
+func NewRateLimiter(name string, burstRate int, burstTimeSpan time.Duration, parallelCount int) *RateLimiter {
 	limiter := &RateLimiter{
+		name:         name,
 		rateTicker:   time.NewTicker(burstTimeSpan),
 		rateLimiter:  make(chan bool, burstRate),
 		parallelChan: make(chan bool, parallelCount),
@@ -34,6 +45,7 @@ func NewRateLimiter(burstRate int, burstTimeSpan time.Duration, parallelCount in
 			} else {
 				time.Sleep(time.Duration(limiter.coolDown) * time.Second)
 				limiter.coolDown = 0
+				apiCoolDownSeconds.Set(0, name)
 				// log.Debugf("Cooldown tick after %ds (%d / %d)...", limiter.coolDown, burstRate, parallelCount)
 			}
 		}
@@ -53,6 +65,7 @@ func (rl *RateLimiter) Leave() {
 func (rl *RateLimiter) Call(f func()) {
 	rl.Enter()
 	defer rl.Leave()
+	apiRequests.Inc(rl.name)
 	if rl.coolDown > 0 {
 		// Already cooling down, wait up
 		time.Sleep(time.Duration(rl.coolDown) * time.Second)
@@ -72,6 +85,10 @@ outer:
 }
 
 func (rl *RateLimiter) CoolDown(headers http.Header) {
+	apiCoolDowns.Inc(rl.name)
+	defer func() {
+		apiCoolDownSeconds.Set(float64(rl.coolDown), rl.name)
+	}()
 	if len(headers) > 0 {
 		if retryAfter, exists := headers["Retry-After"]; exists {
 			if retryAfter != nil {
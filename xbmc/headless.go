@@ -0,0 +1,109 @@
+package xbmc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scakemyer/quasar/broadcast"
+)
+
+//
+// Headless mode, without Kodi around: dialogs and notifications go to the
+// log and to the event API, and get their default answer.
+//
+
+var Headless = false
+
+var errHeadless = errors.New("Kodi isn't available in headless mode")
+
+// SettingSaver stores settings changed by Quasar itself, e.g. Trakt tokens,
+// in place of Kodi.
+var SettingSaver func(id string, value interface{}) error
+
+// Event is a dialog or notification which would have been shown in Kodi.
+type Event struct {
+	ID     int           `json:"id"`
+	Time   time.Time     `json:"time"`
+	Method string        `json:"method"`
+	Args   []interface{} `json:"args"`
+}
+
+// How many events are kept for clients polling the event API
+const eventsKept = 200
+
+var (
+	Events       = broadcast.NewBroadcaster()
+	eventsMx     sync.Mutex
+	recentEvents = make([]*Event, 0, eventsKept)
+	lastEventId  = 0
+	lastHandle   = int64(0)
+)
+
+// RecentEvents returns the events kept which came after the one given.
+func RecentEvents(since int) []*Event {
+	eventsMx.Lock()
+	defer eventsMx.Unlock()
+	events := make([]*Event, 0)
+	for _, event := range recentEvents {
+		if event.ID > since {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func publishEvent(method string, args Args) {
+	eventsMx.Lock()
+	lastEventId++
+	event := &Event{
+		ID:     lastEventId,
+		Time:   time.Now(),
+		Method: method,
+		Args:   args,
+	}
+	if len(recentEvents) == eventsKept {
+		recentEvents = recentEvents[1:]
+	}
+	recentEvents = append(recentEvents, event)
+	eventsMx.Unlock()
+
+	Events.Broadcast(event)
+}
+
+func isUICall(method string) bool {
+	for _, prefix := range []string{"Notify", "Dialog", "Keyboard", "OverlayStatus"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// headlessCall stands for the add-on's side of a call. Return values keep
+// what callers initialized them to, which is the "cancel" answer, except
+// for windows, which get a handle.
+func headlessCall(method string, retVal interface{}, args Args) error {
+	if !isUICall(method) {
+		return errHeadless
+	}
+
+	if strings.HasSuffix(method, "_Update") || strings.HasSuffix(method, "_IsCanceled") || strings.HasSuffix(method, "_IsFinished") {
+		log.Debugf("%s %v", method, args)
+	} else {
+		log.Noticef("%s %s", method, strings.Trim(fmt.Sprint(args), "[]"))
+	}
+	publishEvent(method, args)
+
+	if strings.HasSuffix(method, "_Create") {
+		if handle, ok := retVal.(*int64); ok {
+			eventsMx.Lock()
+			lastHandle++
+			*handle = lastHandle
+			eventsMx.Unlock()
+		}
+	}
+	return nil
+}
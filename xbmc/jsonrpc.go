@@ -1,8 +1,12 @@
 package xbmc
 
 import (
-	"net"
+	"context"
 	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/scakemyer/quasar/jsonrpc"
 )
@@ -12,6 +16,13 @@ type Object map[string]interface{}
 
 var Results map[string]chan interface{}
 
+// Comma separated addresses of Kodi's JSON-RPC server and of the add-on's,
+// in place of the local ones
+const (
+	KodiJSONRPCEnv  = "QUASAR_KODI_JSONRPC"
+	AddonJSONRPCEnv = "QUASAR_ADDON_JSONRPC"
+)
+
 var (
 	XBMCJSONRPCHosts = []string{
 		net.JoinHostPort("127.0.0.1", "9090"),
@@ -19,13 +30,51 @@ var (
 	XBMCExJSONRPCHosts = []string{
 		net.JoinHostPort("127.0.0.1", "65252"),
 	}
+	hostsMx sync.RWMutex
 )
 
+func init() {
+	if hosts := os.Getenv(KodiJSONRPCEnv); hosts != "" {
+		XBMCJSONRPCHosts = strings.Split(hosts, ",")
+	}
+	if hosts := os.Getenv(AddonJSONRPCEnv); hosts != "" {
+		XBMCExJSONRPCHosts = strings.Split(hosts, ",")
+	}
+}
+
+// SetJSONRPCHosts points calls at other Kodi and add-on servers, e.g. fake
+// ones. Empty lists leave the current hosts.
+func SetJSONRPCHosts(kodiHosts []string, addonHosts []string) {
+	hostsMx.Lock()
+	defer hostsMx.Unlock()
+	if len(kodiHosts) > 0 {
+		XBMCJSONRPCHosts = kodiHosts
+	}
+	if len(addonHosts) > 0 {
+		XBMCExJSONRPCHosts = addonHosts
+	}
+	kodiPool.reset()
+	addonPool.reset()
+}
+
+func kodiHosts() []string {
+	hostsMx.RLock()
+	defer hostsMx.RUnlock()
+	return XBMCJSONRPCHosts
+}
+
+func addonHosts() []string {
+	hostsMx.RLock()
+	defer hostsMx.RUnlock()
+	return XBMCExJSONRPCHosts
+}
+
 func getConnection(hosts ...string) (net.Conn, error) {
-	var err error
+	err := errors.New("No JSON-RPC hosts")
 
 	for _, host := range hosts {
-		c, err := net.Dial("tcp", host)
+		var c net.Conn
+		c, err = dial(host)
 		if err == nil {
 			return c, nil
 		}
@@ -35,55 +84,52 @@ func getConnection(hosts ...string) (net.Conn, error) {
 }
 
 func executeJSONRPC(method string, retVal interface{}, args Args) error {
+	if Headless {
+		return errHeadless
+	}
 	if args == nil {
 		args = Args{}
 	}
-	conn, err := getConnection(XBMCJSONRPCHosts...)
-	if err != nil {
-		log.Error(err)
-		log.Critical("No available JSON-RPC connection to Kodi")
-		return err
-	}
-	if conn != nil {
-		defer conn.Close()
-		client := jsonrpc.NewClient(conn)
-		return client.Call(method, args, retVal)
-	}
-	return errors.New("No available JSON-RPC connection to Kodi")
+	return callKodi(method, retVal, args)
 }
 
 func executeJSONRPCO(method string, retVal interface{}, args Object) error {
+	if Headless {
+		return errHeadless
+	}
 	if args == nil {
 		args = Object{}
 	}
-	conn, err := getConnection(XBMCJSONRPCHosts...)
-	if err != nil {
-		log.Error(err)
-		log.Critical("No available JSON-RPC connection to Kodi")
-		return err
-	}
-	if conn != nil {
-		defer conn.Close()
-		client := jsonrpc.NewClient(conn)
-		return client.Call(method, args, retVal)
-	}
-	return errors.New("No available JSON-RPC connection to Kodi")
+	return callKodi(method, retVal, args)
 }
 
 func executeJSONRPCEx(method string, retVal interface{}, args Args) error {
 	if args == nil {
 		args = Args{}
 	}
-	conn, err := getConnection(XBMCExJSONRPCHosts...)
-	if err != nil {
-		log.Error(err)
-		log.Critical("No available JSON-RPC connection to the add-on")
-		return err
+	if Headless {
+		return headlessCall(method, retVal, args)
+	}
+	ctx, cancel := callContext(method)
+	defer cancel()
+	if isBlockingCall(method) {
+		return addonPool.dedicated(ctx, method, args, retVal)
 	}
-	if conn != nil {
-		defer conn.Close()
-		client := jsonrpc.NewClient(conn)
-		return client.Call(method, args, retVal)
+	return addonPool.call(ctx, method, args, retVal)
+}
+
+func callKodi(method string, retVal interface{}, args interface{}) error {
+	ctx, cancel := callContext(method)
+	defer cancel()
+	return kodiPool.call(ctx, method, args, retVal)
+}
+
+// executeJSONRPCBatch sends calls to Kodi at once.
+func executeJSONRPCBatch(calls []*jsonrpc.Call) error {
+	if Headless {
+		return errHeadless
 	}
-	return errors.New("No available JSON-RPC connection to the add-on")
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return kodiPool.batch(ctx, calls)
 }
@@ -0,0 +1,147 @@
+package xbmc
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/scakemyer/quasar/broadcast"
+)
+
+//
+// Kodi's notifications, decoded into PlayerEvent and LibraryEvent
+//
+
+const (
+	PlayerOnPlay           = "Player.OnPlay"
+	PlayerOnAVStart        = "Player.OnAVStart"
+	PlayerOnPause          = "Player.OnPause"
+	PlayerOnResume         = "Player.OnResume"
+	PlayerOnSeek           = "Player.OnSeek"
+	PlayerOnStop           = "Player.OnStop"
+	LibraryOnUpdate        = "VideoLibrary.OnUpdate"
+	LibraryOnRemove        = "VideoLibrary.OnRemove"
+	LibraryOnScanFinished  = "VideoLibrary.OnScanFinished"
+	LibraryOnCleanFinished = "VideoLibrary.OnCleanFinished"
+)
+
+// KodiEvents broadcasts Kodi's notifications, as a *PlayerEvent or
+// *LibraryEvent.
+var KodiEvents = broadcast.NewBroadcaster()
+
+// Notification is a notification as Kodi sends it.
+type Notification struct {
+	Method string          `json:"method"`
+	Sender string          `json:"sender"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// NotificationItem is the library item a notification is about, ID being
+// 0 for files outside the library.
+type NotificationItem struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title"`
+}
+
+type PlayerEvent struct {
+	Method   string
+	Item     NotificationItem
+	PlayerID int
+	Speed    int
+	// Position after seeking, in seconds
+	Time float64
+	// Whether playback stopped at the end
+	Ended bool
+}
+
+type LibraryEvent struct {
+	Method      string
+	Item        NotificationItem
+	PlayCount   int
+	Added       bool
+	Transaction bool
+}
+
+type notificationTime struct {
+	Hours        int `json:"hours"`
+	Minutes      int `json:"minutes"`
+	Seconds      int `json:"seconds"`
+	Milliseconds int `json:"milliseconds"`
+}
+
+func (t notificationTime) seconds() float64 {
+	return float64(t.Hours*3600+t.Minutes*60+t.Seconds) + float64(t.Milliseconds)/1000
+}
+
+// DecodeNotification turns a notification from Kodi into a *PlayerEvent or
+// *LibraryEvent, or nil for the ones Quasar doesn't act on.
+func DecodeNotification(n *Notification) (interface{}, error) {
+	if n.Sender != "" && n.Sender != "xbmc" {
+		return nil, nil
+	}
+
+	switch n.Method {
+	case PlayerOnPlay, PlayerOnAVStart, PlayerOnPause, PlayerOnResume, PlayerOnSeek, PlayerOnStop:
+		var data struct {
+			Item   NotificationItem `json:"item"`
+			Ended  bool             `json:"end"`
+			Player struct {
+				PlayerID int              `json:"playerid"`
+				Speed    float64          `json:"speed"`
+				Time     notificationTime `json:"time"`
+			} `json:"player"`
+		}
+		if len(n.Data) > 0 {
+			if err := json.Unmarshal(n.Data, &data); err != nil {
+				return nil, err
+			}
+		}
+		return &PlayerEvent{
+			Method:   n.Method,
+			Item:     data.Item,
+			PlayerID: data.Player.PlayerID,
+			Speed:    int(math.Floor(data.Player.Speed)),
+			Time:     data.Player.Time.seconds(),
+			Ended:    data.Ended,
+		}, nil
+
+	case LibraryOnUpdate, LibraryOnRemove, LibraryOnScanFinished, LibraryOnCleanFinished:
+		// Older Kodi versions nest the item, newer ones don't
+		var data struct {
+			NotificationItem
+			Item        *NotificationItem `json:"item"`
+			PlayCount   int               `json:"playcount"`
+			Added       bool              `json:"added"`
+			Transaction bool              `json:"transaction"`
+		}
+		if len(n.Data) > 0 && string(n.Data) != "null" {
+			if err := json.Unmarshal(n.Data, &data); err != nil {
+				return nil, err
+			}
+		}
+		event := &LibraryEvent{
+			Method:      n.Method,
+			Item:        data.NotificationItem,
+			PlayCount:   data.PlayCount,
+			Added:       data.Added,
+			Transaction: data.Transaction,
+		}
+		if data.Item != nil {
+			event.Item = *data.Item
+		}
+		return event, nil
+	}
+	return nil, nil
+}
+
+// PublishNotification broadcasts the event a notification is, if any.
+func PublishNotification(n *Notification) {
+	event, err := DecodeNotification(n)
+	if err != nil {
+		log.Warningf("Invalid notification %s: %s", n.Method, err)
+		return
+	}
+	if event != nil {
+		KodiEvents.Broadcast(event)
+	}
+}
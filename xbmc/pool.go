@@ -0,0 +1,237 @@
+package xbmc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scakemyer/quasar/jsonrpc"
+)
+
+//
+// Long-lived JSON-RPC connections, one per server, shared by all calls,
+// Kodi's also bringing its notifications. Dialogs waiting on the user get
+// connections of their own, not to hold up the add-on's other calls.
+//
+
+const (
+	dialTimeout = 5 * time.Second
+	callTimeout = 30 * time.Second
+	pingPeriod  = 30 * time.Second
+	minBackoff  = 100 * time.Millisecond
+	maxBackoff  = 10 * time.Second
+)
+
+var (
+	kodiPool  = &pool{name: "Kodi", hosts: kodiHosts, onNotification: publishNotification}
+	addonPool = &pool{name: "the add-on", hosts: addonHosts}
+	listening int32
+)
+
+type pool struct {
+	name           string
+	hosts          func() []string
+	onNotification func(*jsonrpc.Notification)
+
+	mx       sync.Mutex
+	conn     *jsonrpc.Conn
+	failures uint
+	retryAt  time.Time
+}
+
+// get returns the connection, dialing one unless it's too soon after
+// failing to.
+func (p *pool) get() (conn *jsonrpc.Conn, reused bool, err error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.conn != nil {
+		select {
+		case <-p.conn.Closed():
+			p.conn = nil
+		default:
+			return p.conn, true, nil
+		}
+	}
+	if wait := p.retryAt.Sub(time.Now()); wait > 0 {
+		return nil, false, fmt.Errorf("No JSON-RPC connection to %s, retrying in %s", p.name, wait)
+	}
+
+	c, err := getConnection(p.hosts()...)
+	if err != nil {
+		log.Error(err)
+		log.Criticalf("No available JSON-RPC connection to %s", p.name)
+		backoff := minBackoff << p.failures
+		if backoff >= maxBackoff {
+			backoff = maxBackoff
+		} else {
+			p.failures++
+		}
+		p.retryAt = time.Now().Add(backoff)
+		return nil, false, err
+	}
+	p.failures = 0
+	p.conn = jsonrpc.NewConn(c, p.onNotification)
+	return p.conn, false, nil
+}
+
+// drop closes conn, for the next call to dial again.
+func (p *pool) drop(conn *jsonrpc.Conn) {
+	p.mx.Lock()
+	if p.conn == conn {
+		p.conn = nil
+	}
+	p.mx.Unlock()
+	conn.Close()
+}
+
+// reset drops the connection, e.g. when hosts change.
+func (p *pool) reset() {
+	p.mx.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.failures = 0
+	p.retryAt = time.Time{}
+	p.mx.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// call calls method, once more on a new connection when a reused one
+// closed before answering, as servers answering only one call per
+// connection do.
+func (p *pool) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	for retried := false; ; retried = true {
+		conn, reused, err := p.get()
+		if err != nil {
+			return err
+		}
+		err = conn.Call(ctx, method, params, result)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-conn.Closed():
+			p.drop(conn)
+			if reused && !retried && ctx.Err() == nil {
+				continue
+			}
+		default:
+		}
+		return err
+	}
+}
+
+// dedicated calls method on a connection of its own, closed once answered.
+func (p *pool) dedicated(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c, err := getConnection(p.hosts()...)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	conn := jsonrpc.NewConn(c, nil)
+	defer conn.Close()
+	return conn.Call(ctx, method, params, result)
+}
+
+// batch sends calls at once, retried as call does.
+func (p *pool) batch(ctx context.Context, calls []*jsonrpc.Call) error {
+	for retried := false; ; retried = true {
+		conn, reused, err := p.get()
+		if err != nil {
+			return err
+		}
+		err = conn.Batch(ctx, calls)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-conn.Closed():
+			p.drop(conn)
+			if reused && !retried && ctx.Err() == nil {
+				continue
+			}
+		default:
+		}
+		return err
+	}
+}
+
+func publishNotification(n *jsonrpc.Notification) {
+	notification := &Notification{Method: n.Method}
+	if err := json.Unmarshal(n.Params, notification); err != nil {
+		log.Warningf("Invalid notification %s: %s", n.Method, err)
+		return
+	}
+	PublishNotification(notification)
+}
+
+// ListenNotifications keeps a connection to Kodi open, pinging it, for its
+// notifications to come in as KodiEvents until closing is closed.
+func ListenNotifications(closing <-chan struct{}) {
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	defer atomic.StoreInt32(&listening, 0)
+
+	for {
+		conn, _, err := kodiPool.get()
+		if err != nil {
+			atomic.StoreInt32(&listening, 0)
+			log.Debug(err)
+			select {
+			case <-closing:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		atomic.StoreInt32(&listening, 1)
+
+		select {
+		case <-closing:
+			return
+		case <-conn.Closed():
+			log.Warning("Lost JSON-RPC connection to Kodi, reconnecting")
+		case <-ping.C:
+			ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+			if err := conn.Call(ctx, "JSONRPC.Ping", Object{}, nil); err != nil {
+				log.Warningf("Kodi didn't answer ping: %s", err)
+				kodiPool.drop(conn)
+			}
+			cancel()
+		}
+	}
+}
+
+// ListeningNotifications tells whether Kodi's notifications are coming in.
+func ListeningNotifications() bool {
+	return atomic.LoadInt32(&listening) == 1
+}
+
+// isBlockingCall tells whether method waits on the user, for as long as
+// they take.
+func isBlockingCall(method string) bool {
+	switch method {
+	case "Dialog", "Dialog_Confirm", "Dialog_Select", "Dialog_Select_Large", "DialogInsert", "Keyboard":
+		return true
+	}
+	return false
+}
+
+// callContext bounds calls, but dialogs waiting on the user.
+func callContext(method string) (context.Context, context.CancelFunc) {
+	if isUICall(method) {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), callTimeout)
+}
+
+// dial is net.Dial, bounded.
+func dial(host string) (net.Conn, error) {
+	return net.DialTimeout("tcp", host, dialTimeout)
+}
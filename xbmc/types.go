@@ -131,6 +131,7 @@ type VideoLibraryMovieItem struct {
 	PlayCount  int    `json:"playcount"`
 	File       string `json:"file"`
 	Resume     *Resume
+	LastPlayed string `json:"lastplayed"`
 }
 
 type VideoLibraryShows struct {
@@ -160,6 +161,7 @@ type VideoLibraryEpisodeItem struct {
 	File       string    `json:"file"`
 	UniqueIDs  UniqueIDs `json:"uniqueid"`
 	Resume     *Resume
+	LastPlayed string `json:"lastplayed"`
 }
 
 type UniqueIDs struct {
@@ -1,6 +1,13 @@
 package xbmc
 
-import "time"
+import (
+	"time"
+
+	"github.com/scakemyer/quasar/jsonrpc"
+)
+
+// LastPlayedFormat is how Kodi stores last played dates, in local time
+const LastPlayedFormat = "2006-01-02 15:04:05"
 
 func UpdateAddonRepos() (retVal string) {
 	executeJSONRPCEx("UpdateAddonRepos", &retVal, nil)
@@ -33,6 +40,7 @@ func VideoLibraryGetMovies() (movies *VideoLibraryMovies) {
 		"playcount",
 		"file",
 		"resume",
+		"lastplayed",
 	}}
 	ret := executeJSONRPCO("VideoLibrary.GetMovies", &movies, params)
 	if ret != nil {
@@ -50,16 +58,19 @@ func VideoLibraryGetShows() (shows *VideoLibraryShows) {
 	return
 }
 
+var episodeProperties = []interface{}{
+	"tvshowid",
+	"uniqueid",
+	"season",
+	"episode",
+	"playcount",
+	"file",
+	"resume",
+	"lastplayed",
+}
+
 func VideoLibraryGetEpisodes(tvshowId int) (episodes *VideoLibraryEpisodes) {
-	params := map[string]interface{}{"tvshowid": tvshowId, "properties": []interface{}{
-		"tvshowid",
-		"uniqueid",
-		"season",
-		"episode",
-		"playcount",
-		"file",
-		"resume",
-	}}
+	params := map[string]interface{}{"tvshowid": tvshowId, "properties": episodeProperties}
 	err := executeJSONRPCO("VideoLibrary.GetEpisodes", &episodes, params)
 	if err != nil {
 		log.Error(err)
@@ -67,7 +78,40 @@ func VideoLibraryGetEpisodes(tvshowId int) (episodes *VideoLibraryEpisodes) {
 	return
 }
 
+// VideoLibraryGetShowsEpisodes gets the episodes of several shows in one
+// batch, by show id. Shows whose call failed are left out.
+func VideoLibraryGetShowsEpisodes(tvshowIds []int) map[int]*VideoLibraryEpisodes {
+	calls := make([]*jsonrpc.Call, len(tvshowIds))
+	results := make([]*VideoLibraryEpisodes, len(tvshowIds))
+	for i, tvshowId := range tvshowIds {
+		calls[i] = &jsonrpc.Call{
+			Method: "VideoLibrary.GetEpisodes",
+			Params: map[string]interface{}{"tvshowid": tvshowId, "properties": episodeProperties},
+			Result: &results[i],
+		}
+	}
+	episodes := make(map[int]*VideoLibraryEpisodes)
+	if err := executeJSONRPCBatch(calls); err != nil {
+		log.Error(err)
+		return episodes
+	}
+	for i, call := range calls {
+		if call.Error != nil {
+			log.Errorf("Episodes of show %d: %s", tvshowIds[i], call.Error)
+			continue
+		}
+		episodes[tvshowIds[i]] = results[i]
+	}
+	return episodes
+}
+
 func SetMovieWatched(movieId int, playcount int, position int, total int) (ret string) {
+	return SetMoviePlayState(movieId, playcount, position, total, time.Now())
+}
+
+// SetMoviePlayState is SetMovieWatched for a play which didn't happen now,
+// e.g. one synced from elsewhere.
+func SetMoviePlayState(movieId int, playcount int, position int, total int, lastPlayed time.Time) (ret string) {
 	params := map[string]interface{}{
 		"movieid": movieId,
 		"playcount": playcount,
@@ -75,13 +119,17 @@ func SetMovieWatched(movieId int, playcount int, position int, total int) (ret s
 			"position": position,
 			"total": total,
 		},
-		"lastplayed": time.Now().Format("2006-01-02 15:04:05"),
+		"lastplayed": lastPlayed.Local().Format(LastPlayedFormat),
 	}
 	executeJSONRPCO("VideoLibrary.SetMovieDetails", &ret, params)
 	return
 }
 
 func SetEpisodeWatched(episodeId int, playcount int, position int, total int) (ret string) {
+	return SetEpisodePlayState(episodeId, playcount, position, total, time.Now())
+}
+
+func SetEpisodePlayState(episodeId int, playcount int, position int, total int, lastPlayed time.Time) (ret string) {
 	params := map[string]interface{}{
 		"episodeid": episodeId,
 		"playcount": playcount,
@@ -89,7 +137,7 @@ func SetEpisodeWatched(episodeId int, playcount int, position int, total int) (r
 			"position": position,
 			"total": total,
 		},
-		"lastplayed": time.Now().Format("2006-01-02 15:04:05"),
+		"lastplayed": lastPlayed.Local().Format(LastPlayedFormat),
 	}
 	executeJSONRPCO("VideoLibrary.SetEpisodeDetails", &ret, params)
 	return
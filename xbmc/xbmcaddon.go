@@ -73,6 +73,14 @@ func GetSettingBool(id string) bool {
 }
 
 func SetSetting(id string, value interface{}) {
+	if Headless {
+		if SettingSaver != nil {
+			if err := SettingSaver(id, value); err != nil {
+				log.Error(err)
+			}
+		}
+		return
+	}
 	retVal := 0
 	executeJSONRPCEx("SetSetting", &retVal, Args{id, value})
 }